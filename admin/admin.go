@@ -0,0 +1,90 @@
+// Package admin serves the admin commands run over SSH, such as
+// `ssh ctf.example.com -p 2222 attempts list --email someone@example.com`.
+// Only clients authenticating with an allowlisted public key may run them.
+package admin
+
+import (
+	"bytes"
+	"fmt"
+	"log"
+	"time"
+
+	"github.com/charmbracelet/ssh"
+	"github.com/charmbracelet/wish"
+	"github.com/tomaspiaggio/autonoma-hiring-ctf/database"
+	gossh "golang.org/x/crypto/ssh"
+)
+
+// Store is the persistence the admin commands depend on
+type Store interface {
+	ListAttempts(email string, limit int) ([]database.Attempt, error)
+	GetUser(email string) (*database.User, error)
+	UnlinkPublicKey(email string) error
+	VoidAttempt(id int) error
+	GetStats() (*database.Stats, error)
+	Leaderboard(limit int) ([]database.LeaderboardEntry, error)
+	SetDisplayHandle(email string, handle string) error
+	Funnel(period database.FunnelPeriod, since time.Time) ([]database.FunnelStep, error)
+	ExportAttempts(filter database.ExportFilter, fn func(database.ExportRow) error) error
+	ListOutbox(status database.OutboxStatus, limit int) ([]database.OutboxEmail, error)
+	ResendEmail(id int) error
+}
+
+var _ Store = (*database.DB)(nil)
+
+// ParseKeys parses an allowlist in authorized_keys format, one key per line.
+// Blank lines and comments are skipped.
+func ParseKeys(data []byte) ([]ssh.PublicKey, error) {
+	var keys []ssh.PublicKey
+	for i, line := range bytes.Split(data, []byte("\n")) {
+		line = bytes.TrimSpace(line)
+		if len(line) == 0 || line[0] == '#' {
+			continue
+		}
+		key, _, _, _, err := ssh.ParseAuthorizedKey(line)
+		if err != nil {
+			return nil, fmt.Errorf("parsing admin key on line %d: %w", i+1, err)
+		}
+		keys = append(keys, key)
+	}
+	return keys, nil
+}
+
+// IsAdmin reports whether the key is in the allowlist
+func IsAdmin(keys []ssh.PublicKey, key ssh.PublicKey) bool {
+	if key == nil {
+		return false
+	}
+	for _, k := range keys {
+		if ssh.KeysEqual(k, key) {
+			return true
+		}
+	}
+	return false
+}
+
+// Middleware runs the command of sessions that have one as an admin command,
+// and hands sessions without a command to the next handler, the challenge TUI
+func Middleware(store Store, keys []ssh.PublicKey) wish.Middleware {
+	return func(next ssh.Handler) ssh.Handler {
+		return func(s ssh.Session) {
+			if len(s.Command()) == 0 {
+				next(s)
+				return
+			}
+
+			if !IsAdmin(keys, s.PublicKey()) {
+				log.Printf("Denied admin command %q from %s\n", s.RawCommand(), s.RemoteAddr())
+				wish.Fatalln(s, "Admin commands require an authorized SSH key.")
+				return
+			}
+
+			log.Printf("Admin %s ran %q\n", gossh.FingerprintSHA256(s.PublicKey()), s.RawCommand())
+			if err := Run(store, s.Command(), s); err != nil {
+				wish.Fatalln(s, err)
+				return
+			}
+			_ = s.Exit(0)
+		}
+	}
+}
@@ -0,0 +1,240 @@
+package admin
+
+import (
+	"bytes"
+	"crypto/ed25519"
+	"crypto/rand"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/charmbracelet/ssh"
+	"github.com/charmbracelet/wish"
+	"github.com/charmbracelet/wish/testsession"
+	"github.com/tomaspiaggio/autonoma-hiring-ctf/database"
+	"github.com/tomaspiaggio/autonoma-hiring-ctf/harness"
+	gossh "golang.org/x/crypto/ssh"
+)
+
+func TestRun(t *testing.T) {
+	tests := []struct {
+		name string
+		args string
+	}{
+		{name: "attempts_list", args: "attempts list"},
+		{name: "attempts_list_email", args: "attempts list --email ADA@example.com --limit 1"},
+		{name: "attempts_list_json", args: "attempts list --json"},
+		{name: "users_show", args: "users show ada@example.com"},
+		{name: "users_show_json", args: "users show --json grace@example.com"},
+		{name: "stats", args: "stats"},
+		{name: "leaderboard", args: "leaderboard"},
+		{name: "leaderboard_json", args: "leaderboard --json"},
+		{name: "users_handle", args: "users handle ada@example.com ada_l"},
+		{name: "report_funnel", args: "report funnel"},
+		{name: "report_funnel_since", args: "report funnel --by day --since 2025-03-31"},
+		{name: "report_funnel_json", args: "report funnel --json"},
+		{name: "outbox_list", args: "outbox list"},
+		{name: "outbox_list_json", args: "outbox list --json"},
+		{name: "outbox_list_dead", args: "outbox list --status dead --json"},
+		{name: "outbox_resend", args: "outbox resend 2"},
+		{name: "export_csv", args: "export"},
+		{name: "export_jsonl", args: "export --format jsonl"},
+		{name: "export_filtered", args: "export --outcome failed --from 2025-04-01 --to 2025-04-01"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var out bytes.Buffer
+			if err := Run(newStore(), strings.Fields(tt.args), &out); err != nil {
+				t.Fatal(err)
+			}
+			harness.Golden(t, tt.name, out.String())
+		})
+	}
+}
+
+func TestExportEscapesFormulas(t *testing.T) {
+	store := newStore()
+	attempt := func(id int, email string, msg string) database.Attempt {
+		return database.Attempt{ID: id, Email: email, Failed: true, Details: []byte(`{"step": 0, "msg": "` + msg + `"}`), SubmittedAt: at(0)}
+	}
+	store.Exports = []database.ExportRow{
+		{Attempt: attempt(1, "=HYPERLINK(\"http://evil\")@example.com", "+1 wrong"), UserCreatedAt: at(0), DisplayHandle: "-handle"},
+		{Attempt: attempt(2, "@admin@example.com", "\\tTab"), UserCreatedAt: at(0)},
+		{Attempt: attempt(3, "ada@example.com", "\\rReturn"), UserCreatedAt: at(0), DisplayHandle: "ada_l"},
+	}
+
+	var out bytes.Buffer
+	if err := Run(store, []string{"export"}, &out); err != nil {
+		t.Fatal(err)
+	}
+	harness.Golden(t, "export_csv_formulas", out.String())
+}
+
+func TestRunErrors(t *testing.T) {
+	tests := []struct {
+		args    string
+		wantErr string
+	}{
+		{args: "", wantErr: "Usage:"},
+		{args: "attempts delete 1", wantErr: `unknown command "attempts delete 1"`},
+		{args: "attempt void", wantErr: "usage: attempt void ID"},
+		{args: "attempt void abc", wantErr: `invalid attempt id "abc"`},
+		{args: "attempt void 99", wantErr: "attempt 99 not found"},
+		{args: "users show nobody@example.com", wantErr: "user nobody@example.com not found"},
+		{args: "users unlink nobody@example.com", wantErr: "user nobody@example.com not found"},
+		{args: "stats --verbose", wantErr: "flag provided but not defined: -verbose"},
+		{args: "users handle ada@example.com a", wantErr: "handles are 3 to 20 letters, digits, dashes or underscores"},
+		{args: "users handle ada@example.com Amazing_Grace", wantErr: "that handle is already taken"},
+		{args: "users handle nobody@example.com nobody", wantErr: "user nobody@example.com not found"},
+		{args: "outbox list --status lost", wantErr: `invalid status "lost", use pending, sent or dead`},
+		{args: "outbox resend", wantErr: "usage: outbox resend ID"},
+		{args: "outbox resend 99", wantErr: "email 99 not found"},
+		{args: "export --format xlsx", wantErr: `invalid format "xlsx", use csv or jsonl`},
+		{args: "export --to tomorrow", wantErr: `invalid date "tomorrow", use YYYY-MM-DD`},
+		{args: "report funnel --by month", wantErr: `invalid period "month", use day or week`},
+		{args: "report funnel --since yesterday", wantErr: `invalid date "yesterday", use YYYY-MM-DD`},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.args, func(t *testing.T) {
+			err := Run(newStore(), strings.Fields(tt.args), &bytes.Buffer{})
+			if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+				t.Fatalf("expected error containing %q, got %v", tt.wantErr, err)
+			}
+		})
+	}
+}
+
+func TestRunPassesArguments(t *testing.T) {
+	tests := []struct {
+		args     string
+		wantCall string
+	}{
+		{args: "attempts list", wantCall: "ListAttempts  20"},
+		{args: "attempts list --email ADA@example.com --limit 1", wantCall: "ListAttempts ADA@example.com 1"},
+		{args: "attempt void 2", wantCall: "VoidAttempt 2"},
+		{args: "users unlink ada@example.com", wantCall: "UnlinkPublicKey ada@example.com"},
+		{args: "users handle ada@example.com", wantCall: "SetDisplayHandle ada@example.com "},
+		{args: "leaderboard --limit 3", wantCall: "Leaderboard 3"},
+		{args: "report funnel", wantCall: "Funnel week 0001-01-01"},
+		{args: "report funnel --by day --since 2025-04-01", wantCall: "Funnel day 2025-04-01"},
+		{args: "outbox list --status dead --limit 0", wantCall: "ListOutbox dead 0"},
+		{args: "outbox resend 2", wantCall: "ResendEmail 2"},
+		{args: "export", wantCall: "ExportAttempts  0001-01-01 0001-01-01"},
+		// The end date is inclusive, so attempts are exported up to the next day
+		{args: "export --outcome failed --from 2025-04-01 --to 2025-04-01", wantCall: "ExportAttempts failed 2025-04-01 2025-04-02"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.args, func(t *testing.T) {
+			store := newStore()
+			if err := Run(store, strings.Fields(tt.args), &bytes.Buffer{}); err != nil {
+				t.Fatal(err)
+			}
+			if len(store.Calls) != 1 || store.Calls[0] != tt.wantCall {
+				t.Errorf("calls = %q, want [%q]", store.Calls, tt.wantCall)
+			}
+		})
+	}
+}
+
+func TestParseKeys(t *testing.T) {
+	signer := newSigner(t)
+	data := "# admins\n\n" + string(gossh.MarshalAuthorizedKey(signer.PublicKey())) + "\n"
+
+	keys, err := ParseKeys([]byte(data))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(keys) != 1 || !IsAdmin(keys, signer.PublicKey()) {
+		t.Fatalf("expected the key to be allowed, got %d keys", len(keys))
+	}
+
+	if _, err := ParseKeys([]byte("not a key")); err == nil {
+		t.Error("expected an error for an invalid key")
+	}
+}
+
+func TestMiddleware(t *testing.T) {
+	adminSigner := newSigner(t)
+	tests := []struct {
+		name       string
+		signer     gossh.Signer
+		command    string
+		wantOutput string
+		wantErr    bool
+	}{
+		{name: "admin runs a command", signer: adminSigner, command: "stats", wantOutput: "Attempts         4"},
+		{name: "other keys are denied", signer: newSigner(t), command: "stats", wantOutput: "Admin commands require an authorized SSH key.", wantErr: true},
+		{name: "command errors are reported", signer: adminSigner, command: "attempt void 99", wantOutput: "attempt 99 not found", wantErr: true},
+		{name: "no command goes to the challenge", signer: newSigner(t), wantOutput: "challenge"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			srv, err := wish.NewServer(
+				wish.WithHostKeyPath(filepath.Join(t.TempDir(), "id_ed25519")),
+				wish.WithPublicKeyAuth(func(ssh.Context, ssh.PublicKey) bool { return true }),
+				wish.WithMiddleware(
+					func(ssh.Handler) ssh.Handler {
+						return func(s ssh.Session) { wish.Println(s, "challenge") }
+					},
+					Middleware(newStore(), []ssh.PublicKey{adminSigner.PublicKey()}),
+				),
+			)
+			if err != nil {
+				t.Fatal(err)
+			}
+			sess := testsession.New(t, srv, &gossh.ClientConfig{
+				User: "admin",
+				Auth: []gossh.AuthMethod{gossh.PublicKeys(tt.signer)},
+			})
+
+			out, err := sess.CombinedOutput(tt.command)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("expected error=%v, got %v", tt.wantErr, err)
+			}
+			if !strings.Contains(string(out), tt.wantOutput) {
+				t.Errorf("expected output containing %q, got %q", tt.wantOutput, out)
+			}
+		})
+	}
+}
+
+func newSigner(t *testing.T) gossh.Signer {
+	t.Helper()
+	_, key, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	signer, err := gossh.NewSignerFromKey(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return signer
+}
+
+func TestExportRecordUnpacksDetails(t *testing.T) {
+	row := database.ExportRow{
+		Attempt: database.Attempt{
+			ID:      7,
+			Email:   "ada@example.com",
+			Failed:  true,
+			Details: []byte(`{"step": 2, "time": 754600000000, "msg": "Wrong answer", "session_id": "s-1", "seed": 9007199254740993}`),
+		},
+	}
+	got := newExportRecord(row)
+	if got.Step == nil || *got.Step != 3 {
+		t.Errorf("Step = %v, want 3", got.Step)
+	}
+	if got.Time != "12m35s" || got.TimeSeconds == nil || *got.TimeSeconds != 755 {
+		t.Errorf("Time = %q (%v seconds), want 12m35s (755 seconds)", got.Time, got.TimeSeconds)
+	}
+	if got.Message != "Wrong answer" || got.SessionID != "s-1" || got.Outcome != "failed" {
+		t.Errorf("unexpected record %+v", got)
+	}
+	if got.Seed == nil || *got.Seed != "9007199254740993" {
+		t.Errorf("Seed = %v, want 9007199254740993 without rounding", got.Seed)
+	}
+}
@@ -0,0 +1,437 @@
+package admin
+
+import (
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	"github.com/tomaspiaggio/autonoma-hiring-ctf/database"
+)
+
+// usage lists the available commands
+const usage = `Usage:
+  attempts list [--email EMAIL] [--limit N] [--json]   List the latest attempts
+  attempt void ID                                      Void an attempt, it stops counting as a win or failure
+  users show EMAIL [--json]                            Show a user and a summary of their attempts
+  users unlink EMAIL                                   Unbind the SSH key of a user, their next login binds a new one
+  users handle EMAIL [HANDLE]                          Set the leaderboard handle of a user, or clear it
+  outbox list [--status STATUS] [--limit N] [--json]   List the latest queued emails, pending, sent or dead
+  outbox resend ID                                     Queue an email to be sent again right away
+  leaderboard [--limit N] [--json]                     List the fastest completions of users with a handle
+  stats [--json]                                       Show totals over every user and attempt
+  report funnel [--by day|week] [--since DATE] [--json]
+                                                       Show how many attempts reached and cleared each step
+  export [--format csv|jsonl] [--from DATE] [--to DATE] [--outcome OUTCOME]
+                                                       Export every attempt along with its user`
+
+// timeFormat is how timestamps are printed in tables
+const timeFormat = "2006-01-02 15:04:05"
+
+// Run executes an admin command and writes its output to out
+func Run(store Store, args []string, out io.Writer) error {
+	if len(args) == 0 {
+		return errors.New(usage)
+	}
+
+	cmd := args[0]
+	if len(args) > 1 {
+		cmd += " " + args[1]
+	}
+	switch cmd {
+	case "attempts list", "attempt list":
+		return listAttempts(store, args[2:], out)
+	case "attempt void", "attempts void":
+		return voidAttempt(store, args[2:], out)
+	case "users show", "user show":
+		return showUser(store, args[2:], out)
+	case "users unlink", "user unlink":
+		return unlinkKey(store, args[2:], out)
+	case "users handle", "user handle":
+		return setHandle(store, args[2:], out)
+	case "outbox list":
+		return listOutbox(store, args[2:], out)
+	case "outbox resend":
+		return resendEmail(store, args[2:], out)
+	case "report funnel":
+		return showFunnel(store, args[2:], out)
+	}
+	if args[0] == "leaderboard" {
+		return showLeaderboard(store, args[1:], out)
+	}
+	if args[0] == "export" {
+		return exportAttempts(store, args[1:], out)
+	}
+	if args[0] == "stats" {
+		return showStats(store, args[1:], out)
+	}
+	if args[0] == "help" {
+		fmt.Fprintln(out, usage)
+		return nil
+	}
+	return fmt.Errorf("unknown command %q\n%s", strings.Join(args, " "), usage)
+}
+
+// newFlagSet returns a flag set that reports errors instead of exiting
+func newFlagSet(name string) *flag.FlagSet {
+	fs := flag.NewFlagSet(name, flag.ContinueOnError)
+	fs.SetOutput(io.Discard)
+	return fs
+}
+
+// parseArgs parses flags wherever they appear and returns the positional arguments
+func parseArgs(fs *flag.FlagSet, args []string) ([]string, error) {
+	var positional []string
+	for {
+		if err := fs.Parse(args); err != nil {
+			return nil, fmt.Errorf("%s: %w", fs.Name(), err)
+		}
+		args = fs.Args()
+		if len(args) == 0 {
+			return positional, nil
+		}
+		positional = append(positional, args[0])
+		args = args[1:]
+	}
+}
+
+func listAttempts(store Store, args []string, out io.Writer) error {
+	fs := newFlagSet("attempts list")
+	email := fs.String("email", "", "only list the attempts of this email")
+	limit := fs.Int("limit", 20, "maximum number of attempts, 0 for all")
+	asJSON := fs.Bool("json", false, "print JSON")
+	if _, err := parseArgs(fs, args); err != nil {
+		return err
+	}
+
+	attempts, err := store.ListAttempts(*email, *limit)
+	if err != nil {
+		return err
+	}
+	if *asJSON {
+		return writeJSON(out, attempts)
+	}
+
+	w := tabwriter.NewWriter(out, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(w, "ID\tEMAIL\tRESULT\tSTEP\tTIME\tSUBMITTED")
+	for _, a := range attempts {
+		step, took := summarizeDetails(a.Details)
+		fmt.Fprintf(w, "%d\t%s\t%s\t%s\t%s\t%s\n", a.ID, a.Email, result(a), step, took, a.SubmittedAt.UTC().Format(timeFormat))
+	}
+	return w.Flush()
+}
+
+// result describes the outcome of an attempt
+func result(a database.Attempt) string {
+	switch {
+	case a.Voided:
+		return "voided"
+	case a.Abandoned:
+		return "abandoned"
+	case a.Failed:
+		return "failed"
+	}
+	return "won"
+}
+
+// summarizeDetails returns the step reached and the time taken recorded in the attempt details
+func summarizeDetails(raw json.RawMessage) (string, string) {
+	var details struct {
+		Step *int           `json:"step"`
+		Time *time.Duration `json:"time"`
+	}
+	step, took := "-", "-"
+	if err := json.Unmarshal(raw, &details); err != nil {
+		return step, took
+	}
+	if details.Step != nil {
+		step = strconv.Itoa(*details.Step + 1)
+	}
+	if details.Time != nil {
+		took = details.Time.Round(time.Second).String()
+	}
+	return step, took
+}
+
+func voidAttempt(store Store, args []string, out io.Writer) error {
+	fs := newFlagSet("attempt void")
+	positional, err := parseArgs(fs, args)
+	if err != nil {
+		return err
+	}
+	if len(positional) != 1 {
+		return errors.New("usage: attempt void ID")
+	}
+	id, err := strconv.Atoi(positional[0])
+	if err != nil {
+		return fmt.Errorf("invalid attempt id %q", positional[0])
+	}
+
+	if err := store.VoidAttempt(id); err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return fmt.Errorf("attempt %d not found", id)
+		}
+		return err
+	}
+	fmt.Fprintf(out, "Voided attempt %d\n", id)
+	return nil
+}
+
+func showUser(store Store, args []string, out io.Writer) error {
+	fs := newFlagSet("users show")
+	asJSON := fs.Bool("json", false, "print JSON")
+	positional, err := parseArgs(fs, args)
+	if err != nil {
+		return err
+	}
+	if len(positional) != 1 {
+		return errors.New("usage: users show EMAIL [--json]")
+	}
+
+	user, err := store.GetUser(positional[0])
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return fmt.Errorf("user %s not found", positional[0])
+		}
+		return err
+	}
+	if *asJSON {
+		return writeJSON(out, user)
+	}
+
+	lastAttempt := "-"
+	if user.LastAttemptAt != nil {
+		lastAttempt = user.LastAttemptAt.UTC().Format(timeFormat)
+	}
+	w := tabwriter.NewWriter(out, 0, 0, 2, ' ', 0)
+	fmt.Fprintf(w, "ID\t%d\n", user.ID)
+	fmt.Fprintf(w, "Email\t%s\n", user.Email)
+	fmt.Fprintf(w, "Created\t%s\n", user.CreatedAt.UTC().Format(timeFormat))
+	fmt.Fprintf(w, "SSH key\t%s\n", orDash(user.PublicKey))
+	fmt.Fprintf(w, "Handle\t%s\n", orDash(user.DisplayHandle))
+	fmt.Fprintf(w, "Attempts\t%d\n", user.Attempts)
+	fmt.Fprintf(w, "Failures\t%d\n", user.Failures)
+	fmt.Fprintf(w, "Voided\t%d\n", user.Voided)
+	fmt.Fprintf(w, "Won\t%t\n", user.Won)
+	fmt.Fprintf(w, "Last attempt\t%s\n", lastAttempt)
+	return w.Flush()
+}
+
+func unlinkKey(store Store, args []string, out io.Writer) error {
+	fs := newFlagSet("users unlink")
+	positional, err := parseArgs(fs, args)
+	if err != nil {
+		return err
+	}
+	if len(positional) != 1 {
+		return errors.New("usage: users unlink EMAIL")
+	}
+
+	if err := store.UnlinkPublicKey(positional[0]); err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return fmt.Errorf("user %s not found", positional[0])
+		}
+		return err
+	}
+	fmt.Fprintf(out, "Unlinked the SSH key of %s, the next key they connect with will be bound\n", positional[0])
+	return nil
+}
+
+func setHandle(store Store, args []string, out io.Writer) error {
+	fs := newFlagSet("users handle")
+	positional, err := parseArgs(fs, args)
+	if err != nil {
+		return err
+	}
+	if len(positional) < 1 || len(positional) > 2 {
+		return errors.New("usage: users handle EMAIL [HANDLE]")
+	}
+	email, handle := positional[0], ""
+	if len(positional) == 2 {
+		handle = positional[1]
+	}
+
+	if err := store.SetDisplayHandle(email, handle); err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return fmt.Errorf("user %s not found", email)
+		}
+		return err
+	}
+	if handle == "" {
+		fmt.Fprintf(out, "Cleared the handle of %s, they are off the leaderboard\n", email)
+		return nil
+	}
+	fmt.Fprintf(out, "%s is on the leaderboard as %s\n", email, handle)
+	return nil
+}
+
+func listOutbox(store Store, args []string, out io.Writer) error {
+	fs := newFlagSet("outbox list")
+	status := fs.String("status", "", "only list pending, sent or dead emails")
+	limit := fs.Int("limit", 20, "maximum number of emails, 0 for all")
+	asJSON := fs.Bool("json", false, "print JSON")
+	if _, err := parseArgs(fs, args); err != nil {
+		return err
+	}
+	switch database.OutboxStatus(*status) {
+	case "", database.OutboxPending, database.OutboxSent, database.OutboxDead:
+	default:
+		return fmt.Errorf("invalid status %q, use pending, sent or dead", *status)
+	}
+
+	emails, err := store.ListOutbox(database.OutboxStatus(*status), *limit)
+	if err != nil {
+		return err
+	}
+	if *asJSON {
+		return writeJSON(out, emails)
+	}
+
+	w := tabwriter.NewWriter(out, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(w, "ID\tKIND\tTO\tSTATUS\tATTEMPTS\tNEXT ATTEMPT\tLAST ERROR")
+	for _, e := range emails {
+		next := "-"
+		if e.Status == database.OutboxPending {
+			next = e.NextAttemptAt.UTC().Format(timeFormat)
+		}
+		fmt.Fprintf(w, "%d\t%s\t%s\t%s\t%d\t%s\t%s\n", e.ID, e.Kind, e.Recipient, e.Status, e.Attempts, next, orDash(e.LastError))
+	}
+	return w.Flush()
+}
+
+func resendEmail(store Store, args []string, out io.Writer) error {
+	fs := newFlagSet("outbox resend")
+	positional, err := parseArgs(fs, args)
+	if err != nil {
+		return err
+	}
+	if len(positional) != 1 {
+		return errors.New("usage: outbox resend ID")
+	}
+	id, err := strconv.Atoi(positional[0])
+	if err != nil {
+		return fmt.Errorf("invalid email id %q", positional[0])
+	}
+
+	if err := store.ResendEmail(id); err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return fmt.Errorf("email %d not found", id)
+		}
+		return err
+	}
+	fmt.Fprintf(out, "Queued email %d to be sent again\n", id)
+	return nil
+}
+
+func showLeaderboard(store Store, args []string, out io.Writer) error {
+	fs := newFlagSet("leaderboard")
+	limit := fs.Int("limit", 10, "maximum number of entries, 0 for all")
+	asJSON := fs.Bool("json", false, "print JSON")
+	if _, err := parseArgs(fs, args); err != nil {
+		return err
+	}
+
+	entries, err := store.Leaderboard(*limit)
+	if err != nil {
+		return err
+	}
+	if *asJSON {
+		return writeJSON(out, entries)
+	}
+
+	w := tabwriter.NewWriter(out, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(w, "RANK\tHANDLE\tTIME\tCOMPLETED")
+	for _, e := range entries {
+		fmt.Fprintf(w, "%d\t%s\t%s\t%s\n", e.Rank, e.Handle, e.Duration.Round(time.Second), e.CompletedAt.UTC().Format(timeFormat))
+	}
+	return w.Flush()
+}
+
+// orDash returns s, or a dash when it is empty
+func orDash(s string) string {
+	if s == "" {
+		return "-"
+	}
+	return s
+}
+
+func showStats(store Store, args []string, out io.Writer) error {
+	fs := newFlagSet("stats")
+	asJSON := fs.Bool("json", false, "print JSON")
+	if _, err := parseArgs(fs, args); err != nil {
+		return err
+	}
+
+	stats, err := store.GetStats()
+	if err != nil {
+		return err
+	}
+	if *asJSON {
+		return writeJSON(out, stats)
+	}
+
+	winRate := 0.0
+	if decided := stats.Wins + stats.Failures; decided > 0 {
+		winRate = 100 * float64(stats.Wins) / float64(decided)
+	}
+	w := tabwriter.NewWriter(out, 0, 0, 2, ' ', 0)
+	fmt.Fprintf(w, "Users\t%d\n", stats.Users)
+	fmt.Fprintf(w, "Attempts\t%d\n", stats.Attempts)
+	fmt.Fprintf(w, "Wins\t%d\n", stats.Wins)
+	fmt.Fprintf(w, "Failures\t%d\n", stats.Failures)
+	fmt.Fprintf(w, "Voided\t%d\n", stats.Voided)
+	fmt.Fprintf(w, "Win rate\t%.1f%%\n", winRate)
+	fmt.Fprintf(w, "Active sessions\t%d\n", stats.ActiveSessions)
+	return w.Flush()
+}
+
+// dateFormat is how dates are passed to and printed by the reports
+const dateFormat = "2006-01-02"
+
+func showFunnel(store Store, args []string, out io.Writer) error {
+	fs := newFlagSet("report funnel")
+	by := fs.String("by", "week", "group attempts by day or week")
+	since := fs.String("since", "", "only count attempts submitted on or after this date, as YYYY-MM-DD")
+	asJSON := fs.Bool("json", false, "print JSON")
+	if _, err := parseArgs(fs, args); err != nil {
+		return err
+	}
+	period, err := database.ParseFunnelPeriod(*by)
+	if err != nil {
+		return err
+	}
+	var from time.Time
+	if *since != "" {
+		if from, err = time.Parse(dateFormat, *since); err != nil {
+			return fmt.Errorf("invalid date %q, use YYYY-MM-DD", *since)
+		}
+	}
+
+	funnel, err := store.Funnel(period, from)
+	if err != nil {
+		return err
+	}
+	if *asJSON {
+		return writeJSON(out, funnel)
+	}
+
+	w := tabwriter.NewWriter(out, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(w, "PERIOD\tSTEP\tTYPE\tREACHED\tCLEARED\tDROP-OFF")
+	for _, f := range funnel {
+		fmt.Fprintf(w, "%s\t%d\t%s\t%d\t%d\t%.1f%%\n", f.Period.Format(dateFormat), f.Step+1, orDash(f.StepType), f.Reached, f.Cleared, 100*f.DropOff())
+	}
+	return w.Flush()
+}
+
+// writeJSON prints v as indented JSON
+func writeJSON(out io.Writer, v interface{}) error {
+	enc := json.NewEncoder(out)
+	enc.SetIndent("", "  ")
+	return enc.Encode(v)
+}
@@ -0,0 +1,179 @@
+package admin
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/tomaspiaggio/autonoma-hiring-ctf/database"
+)
+
+// exportColumns are the CSV header, and the keys of every JSONL line
+var exportColumns = []string{
+	"attempt_id",
+	"email",
+	"display_handle",
+	"user_created_at",
+	"outcome",
+	"submitted_at",
+	"session_id",
+	"step",
+	"time",
+	"time_seconds",
+	"message",
+	"seed",
+}
+
+// exportRecord is an exported attempt with its details unpacked
+type exportRecord struct {
+	AttemptID     int     `json:"attempt_id"`
+	Email         string  `json:"email"`
+	DisplayHandle string  `json:"display_handle"`
+	UserCreatedAt string  `json:"user_created_at"`
+	Outcome       string  `json:"outcome"`
+	SubmittedAt   string  `json:"submitted_at"`
+	SessionID     string  `json:"session_id"`
+	Step          *int    `json:"step"`
+	Time          string  `json:"time"`
+	TimeSeconds   *int64  `json:"time_seconds"`
+	Message       string  `json:"message"`
+	Seed          *string `json:"seed"`
+}
+
+// newExportRecord unpacks the attempt details. Steps are counted from 1, like the challenge shows them,
+// and the time is written as a duration such as 18m12s instead of nanoseconds.
+func newExportRecord(row database.ExportRow) exportRecord {
+	record := exportRecord{
+		AttemptID:     row.ID,
+		Email:         row.Email,
+		DisplayHandle: row.DisplayHandle,
+		UserCreatedAt: row.UserCreatedAt.UTC().Format(time.RFC3339),
+		Outcome:       result(row.Attempt),
+		SubmittedAt:   row.SubmittedAt.UTC().Format(time.RFC3339),
+		SessionID:     row.SessionID,
+	}
+
+	var details struct {
+		Step      *int           `json:"step"`
+		Time      *time.Duration `json:"time"`
+		Msg       string         `json:"msg"`
+		SessionID string         `json:"session_id"`
+		Seed      *json.Number   `json:"seed"`
+	}
+	if err := json.Unmarshal(row.Details, &details); err != nil {
+		return record
+	}
+	if details.Step != nil {
+		step := *details.Step + 1
+		record.Step = &step
+	}
+	if details.Time != nil {
+		record.Time = details.Time.Round(time.Second).String()
+		seconds := int64(details.Time.Round(time.Second).Seconds())
+		record.TimeSeconds = &seconds
+	}
+	record.Message = details.Msg
+	if record.SessionID == "" {
+		record.SessionID = details.SessionID
+	}
+	if details.Seed != nil {
+		// Seeds are 64-bit, kept as strings so spreadsheets and JSON readers don't round them
+		seed := details.Seed.String()
+		record.Seed = &seed
+	}
+	return record
+}
+
+// csvFormulaPrefixes start cells that spreadsheets would run as a formula
+const csvFormulaPrefixes = "=+-@\t\r"
+
+// csvText escapes text the candidate controls, so opening the export in a spreadsheet
+// shows it as text instead of evaluating it
+func csvText(s string) string {
+	if s != "" && strings.ContainsRune(csvFormulaPrefixes, rune(s[0])) {
+		return "'" + s
+	}
+	return s
+}
+
+// csvFields returns the record in the order of exportColumns
+func (r exportRecord) csvFields() []string {
+	optional := func(v *int) string {
+		if v == nil {
+			return ""
+		}
+		return strconv.Itoa(*v)
+	}
+	seconds, seed := "", ""
+	if r.TimeSeconds != nil {
+		seconds = strconv.FormatInt(*r.TimeSeconds, 10)
+	}
+	if r.Seed != nil {
+		seed = *r.Seed
+	}
+	return []string{
+		strconv.Itoa(r.AttemptID),
+		csvText(r.Email),
+		csvText(r.DisplayHandle),
+		r.UserCreatedAt,
+		r.Outcome,
+		r.SubmittedAt,
+		r.SessionID,
+		optional(r.Step),
+		r.Time,
+		seconds,
+		csvText(r.Message),
+		seed,
+	}
+}
+
+func exportAttempts(store Store, args []string, out io.Writer) error {
+	fs := newFlagSet("export")
+	format := fs.String("format", "csv", "csv or jsonl")
+	from := fs.String("from", "", "only export attempts submitted on or after this date, as YYYY-MM-DD")
+	to := fs.String("to", "", "only export attempts submitted on or before this date, as YYYY-MM-DD")
+	outcome := fs.String("outcome", "", "only export won, failed, abandoned or voided attempts")
+	if _, err := parseArgs(fs, args); err != nil {
+		return err
+	}
+
+	filter := database.ExportFilter{Outcome: *outcome}
+	var err error
+	if *from != "" {
+		if filter.From, err = time.Parse(dateFormat, *from); err != nil {
+			return fmt.Errorf("invalid date %q, use YYYY-MM-DD", *from)
+		}
+	}
+	if *to != "" {
+		if filter.To, err = time.Parse(dateFormat, *to); err != nil {
+			return fmt.Errorf("invalid date %q, use YYYY-MM-DD", *to)
+		}
+		filter.To = filter.To.AddDate(0, 0, 1)
+	}
+
+	switch *format {
+	case "csv":
+		w := csv.NewWriter(out)
+		if err := w.Write(exportColumns); err != nil {
+			return err
+		}
+		err := store.ExportAttempts(filter, func(row database.ExportRow) error {
+			return w.Write(newExportRecord(row).csvFields())
+		})
+		if err != nil {
+			return err
+		}
+		w.Flush()
+		return w.Error()
+	case "jsonl":
+		enc := json.NewEncoder(out)
+		return store.ExportAttempts(filter, func(row database.ExportRow) error {
+			return enc.Encode(newExportRecord(row))
+		})
+	}
+	return fmt.Errorf("invalid format %q, use csv or jsonl", *format)
+}
@@ -0,0 +1,67 @@
+package admin
+
+import (
+	"time"
+
+	"github.com/tomaspiaggio/autonoma-hiring-ctf/database"
+	"github.com/tomaspiaggio/autonoma-hiring-ctf/harness"
+)
+
+var _ Store = (*harness.AdminStore)(nil)
+
+// at returns the time some hours after the first attempt
+func at(hours int) time.Time {
+	return time.Date(2025, 4, 1, 12+hours, 0, 0, 0, time.UTC)
+}
+
+// newStore returns a store with a win, a failure, a voided failure and an abandoned session
+func newStore() *harness.AdminStore {
+	attempt := func(id int, email string, failed bool, details string, hours int) database.Attempt {
+		return database.Attempt{ID: id, Email: email, Failed: failed, Details: []byte(details), SubmittedAt: at(hours)}
+	}
+	failed := attempt(1, "ada@example.com", true, `{"step": 2, "time": 1500000000000}`, 0)
+	won := attempt(2, "grace@example.com", false, `{"step": 5, "time": 1092000000000}`, 1)
+	voided := attempt(3, "ada@example.com", true, `{"step": 0, "time": 40000000000}`, 2)
+	voided.Voided = true
+	abandoned := attempt(4, "ada@example.com", true, `{"step": 3, "time": 1860000000000}`, 3)
+	abandoned.Abandoned = true
+
+	adaLast, graceLast := at(3), at(1)
+	// An older week reached by a single attempt, then the week of the attempts above
+	var funnel []database.FunnelStep
+	for week, counts := range [][][2]int{
+		{{1, 1}, {1, 0}, {0, 0}, {0, 0}, {0, 0}, {0, 0}},
+		{{3, 3}, {3, 3}, {3, 2}, {2, 1}, {1, 1}, {1, 1}},
+	} {
+		period := time.Date(2025, 3, 24+7*week, 0, 0, 0, 0, time.UTC)
+		for step, c := range counts {
+			funnel = append(funnel, database.FunnelStep{Period: period, Step: step, Reached: c[0], Cleared: c[1]})
+		}
+	}
+	sentAt := at(3)
+
+	return &harness.AdminStore{
+		Attempts: []database.Attempt{abandoned, voided, won, failed},
+		Users: []database.User{
+			{ID: 1, Email: "ada@example.com", CreatedAt: at(0), PublicKey: "SHA256:ada",
+				Attempts: 3, Failures: 2, Voided: 1, LastAttemptAt: &adaLast},
+			{ID: 2, Email: "grace@example.com", CreatedAt: at(1), DisplayHandle: "amazing_grace",
+				Attempts: 1, Won: true, LastAttemptAt: &graceLast},
+		},
+		Stats:       database.Stats{Users: 2, Attempts: 4, Wins: 1, Failures: 2, Voided: 1},
+		Entries:     []database.LeaderboardEntry{database.NewLeaderboardEntry(1, "amazing_grace", 18*time.Minute+12*time.Second, at(1))},
+		FunnelSteps: funnel,
+		Exports: []database.ExportRow{
+			{Attempt: failed, UserCreatedAt: at(0)},
+			{Attempt: won, UserCreatedAt: at(1), DisplayHandle: "amazing_grace"},
+			{Attempt: voided, UserCreatedAt: at(0)},
+			{Attempt: abandoned, UserCreatedAt: at(0), SessionID: "session-4"},
+		},
+		Emails: []database.OutboxEmail{
+			{ID: 2, IdempotencyKey: "end:ada@example.com", Kind: "end", Recipient: "ada@example.com", Payload: []byte(`{"token": "jwt"}`),
+				Status: database.OutboxDead, Attempts: 1, LastError: "emailer responded 502 Bad Gateway", NextAttemptAt: at(3).Add(time.Minute), CreatedAt: at(3)},
+			{ID: 1, IdempotencyKey: "end:grace@example.com", Kind: "end", Recipient: "grace@example.com", Payload: []byte(`{"token": "jwt"}`),
+				Status: database.OutboxSent, Attempts: 1, NextAttemptAt: at(3).Add(time.Minute), CreatedAt: at(3), SentAt: &sentAt},
+		},
+	}
+}
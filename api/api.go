@@ -0,0 +1,163 @@
+// Package api serves a read-only JSON API over users, attempts and step stats, so dashboards don't need
+// direct database access. Every request needs an `Authorization: Bearer <token>` header with an allowlisted token.
+package api
+
+import (
+	"crypto/subtle"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"log"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/tomaspiaggio/autonoma-hiring-ctf/database"
+)
+
+// Store is the persistence the API reads from
+type Store interface {
+	ListUsers(limit int) ([]database.User, error)
+	GetUser(email string) (*database.User, error)
+	ListAttempts(email string, limit int) ([]database.Attempt, error)
+	GetStats() (*database.Stats, error)
+	StepStats() ([]database.StepStat, error)
+	Funnel(period database.FunnelPeriod, since time.Time) ([]database.FunnelStep, error)
+}
+
+var _ Store = (*database.DB)(nil)
+
+// defaultLimit is how many users or attempts are listed when the request doesn't set a limit
+const defaultLimit = 20
+
+// ParseTokens parses a comma separated list of tokens, skipping blank ones
+func ParseTokens(value string) []string {
+	var tokens []string
+	for _, token := range strings.Split(value, ",") {
+		if token = strings.TrimSpace(token); token != "" {
+			tokens = append(tokens, token)
+		}
+	}
+	return tokens
+}
+
+// NewHandler returns the API handler. Requests without one of the tokens are rejected,
+// so an empty list rejects every request.
+func NewHandler(store Store, tokens []string) http.Handler {
+	mux := http.NewServeMux()
+	mux.HandleFunc("GET /api/users", func(w http.ResponseWriter, r *http.Request) {
+		limit, ok := parseLimit(w, r)
+		if !ok {
+			return
+		}
+		users, err := store.ListUsers(limit)
+		respond(w, users, err)
+	})
+	mux.HandleFunc("GET /api/users/{email}", func(w http.ResponseWriter, r *http.Request) {
+		user, err := store.GetUser(r.PathValue("email"))
+		respond(w, user, err)
+	})
+	mux.HandleFunc("GET /api/attempts", func(w http.ResponseWriter, r *http.Request) {
+		limit, ok := parseLimit(w, r)
+		if !ok {
+			return
+		}
+		attempts, err := store.ListAttempts(r.URL.Query().Get("email"), limit)
+		respond(w, attempts, err)
+	})
+	mux.HandleFunc("GET /api/stats", func(w http.ResponseWriter, r *http.Request) {
+		stats, err := store.GetStats()
+		respond(w, stats, err)
+	})
+	mux.HandleFunc("GET /api/stats/steps", func(w http.ResponseWriter, r *http.Request) {
+		stats, err := store.StepStats()
+		respond(w, stats, err)
+	})
+	mux.HandleFunc("GET /api/reports/funnel", func(w http.ResponseWriter, r *http.Request) {
+		query := r.URL.Query()
+		period := database.FunnelByWeek
+		if by := query.Get("by"); by != "" {
+			var err error
+			if period, err = database.ParseFunnelPeriod(by); err != nil {
+				writeError(w, http.StatusBadRequest, err.Error())
+				return
+			}
+		}
+		var since time.Time
+		if value := query.Get("since"); value != "" {
+			var err error
+			if since, err = time.Parse(time.DateOnly, value); err != nil {
+				writeError(w, http.StatusBadRequest, "since must be a date as YYYY-MM-DD")
+				return
+			}
+		}
+		funnel, err := store.Funnel(period, since)
+		respond(w, funnel, err)
+	})
+
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if !authorized(tokens, r) {
+			w.Header().Set("WWW-Authenticate", `Bearer realm="admin"`)
+			writeError(w, http.StatusUnauthorized, "missing or invalid token")
+			return
+		}
+		mux.ServeHTTP(w, r)
+	})
+}
+
+// authorized reports whether the request carries one of the tokens
+func authorized(tokens []string, r *http.Request) bool {
+	token, ok := strings.CutPrefix(r.Header.Get("Authorization"), "Bearer ")
+	if !ok || token == "" {
+		return false
+	}
+	match := 0
+	for _, t := range tokens {
+		match |= subtle.ConstantTimeCompare([]byte(t), []byte(token))
+	}
+	return match == 1
+}
+
+// parseLimit reads the limit query parameter, writing an error if it is invalid.
+// Zero lists everything.
+func parseLimit(w http.ResponseWriter, r *http.Request) (int, bool) {
+	value := r.URL.Query().Get("limit")
+	if value == "" {
+		return defaultLimit, true
+	}
+	limit, err := strconv.Atoi(value)
+	if err != nil || limit < 0 {
+		writeError(w, http.StatusBadRequest, "limit must be a non-negative number")
+		return 0, false
+	}
+	return limit, true
+}
+
+// respond writes v as JSON, or the error if the query failed
+func respond(w http.ResponseWriter, v interface{}, err error) {
+	if errors.Is(err, sql.ErrNoRows) {
+		writeError(w, http.StatusNotFound, "not found")
+		return
+	}
+	if err != nil {
+		log.Printf("Error serving admin API request: %v\n", err)
+		writeError(w, http.StatusInternalServerError, "internal error")
+		return
+	}
+	writeJSON(w, http.StatusOK, v)
+}
+
+func writeError(w http.ResponseWriter, status int, message string) {
+	writeJSON(w, status, map[string]string{"error": message})
+}
+
+func writeJSON(w http.ResponseWriter, status int, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "  ")
+	if err := enc.Encode(v); err != nil {
+		log.Printf("Error writing admin API response: %v\n", err)
+	}
+}
@@ -0,0 +1,133 @@
+package api
+
+import (
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/tomaspiaggio/autonoma-hiring-ctf/harness"
+)
+
+const token = "secret-token"
+
+func TestHandler(t *testing.T) {
+	tests := []struct {
+		name       string
+		path       string
+		wantStatus int
+	}{
+		{name: "users", path: "/api/users", wantStatus: http.StatusOK},
+		{name: "users_limit", path: "/api/users?limit=1", wantStatus: http.StatusOK},
+		{name: "user", path: "/api/users/GRACE@example.com", wantStatus: http.StatusOK},
+		{name: "user_missing", path: "/api/users/nobody@example.com", wantStatus: http.StatusNotFound},
+		{name: "attempts", path: "/api/attempts", wantStatus: http.StatusOK},
+		{name: "attempts_email", path: "/api/attempts?email=ada@example.com", wantStatus: http.StatusOK},
+		{name: "attempts_bad_limit", path: "/api/attempts?limit=-1", wantStatus: http.StatusBadRequest},
+		{name: "stats", path: "/api/stats", wantStatus: http.StatusOK},
+		{name: "stats_steps", path: "/api/stats/steps", wantStatus: http.StatusOK},
+		{name: "funnel", path: "/api/reports/funnel", wantStatus: http.StatusOK},
+		{name: "funnel_bad_period", path: "/api/reports/funnel?by=month", wantStatus: http.StatusBadRequest},
+		{name: "funnel_bad_since", path: "/api/reports/funnel?since=april", wantStatus: http.StatusBadRequest},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := httptest.NewRequest(http.MethodGet, tt.path, nil)
+			req.Header.Set("Authorization", "Bearer "+token)
+			rec := httptest.NewRecorder()
+			NewHandler(newStore(), []string{"other-token", token}).ServeHTTP(rec, req)
+
+			if rec.Code != tt.wantStatus {
+				t.Errorf("status = %d, want %d", rec.Code, tt.wantStatus)
+			}
+			if got := rec.Header().Get("Content-Type"); got != "application/json" {
+				t.Errorf("Content-Type = %q, want application/json", got)
+			}
+			harness.Golden(t, tt.name, rec.Body.String())
+		})
+	}
+}
+
+func TestHandlerPassesQuery(t *testing.T) {
+	tests := []struct {
+		path     string
+		wantCall string
+	}{
+		{path: "/api/users", wantCall: "ListUsers 20"},
+		{path: "/api/users?limit=1", wantCall: "ListUsers 1"},
+		{path: "/api/attempts?email=ada@example.com&limit=0", wantCall: "ListAttempts ada@example.com 0"},
+		{path: "/api/reports/funnel", wantCall: "Funnel week 0001-01-01"},
+		{path: "/api/reports/funnel?by=day&since=2025-04-01", wantCall: "Funnel day 2025-04-01"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.path, func(t *testing.T) {
+			store := newStore()
+			req := httptest.NewRequest(http.MethodGet, tt.path, nil)
+			req.Header.Set("Authorization", "Bearer "+token)
+			NewHandler(store, []string{token}).ServeHTTP(httptest.NewRecorder(), req)
+
+			if len(store.Calls) != 1 || store.Calls[0] != tt.wantCall {
+				t.Errorf("calls = %q, want [%q]", store.Calls, tt.wantCall)
+			}
+		})
+	}
+}
+
+func TestHandlerAuth(t *testing.T) {
+	tests := []struct {
+		name       string
+		header     string
+		method     string
+		tokens     []string
+		wantStatus int
+	}{
+		{name: "valid token", header: "Bearer " + token, method: http.MethodGet, tokens: []string{token}, wantStatus: http.StatusOK},
+		{name: "no header", method: http.MethodGet, tokens: []string{token}, wantStatus: http.StatusUnauthorized},
+		{name: "wrong token", header: "Bearer nope", method: http.MethodGet, tokens: []string{token}, wantStatus: http.StatusUnauthorized},
+		{name: "wrong scheme", header: "Basic " + token, method: http.MethodGet, tokens: []string{token}, wantStatus: http.StatusUnauthorized},
+		{name: "empty token", header: "Bearer ", method: http.MethodGet, tokens: []string{""}, wantStatus: http.StatusUnauthorized},
+		{name: "no tokens configured", header: "Bearer " + token, method: http.MethodGet, wantStatus: http.StatusUnauthorized},
+		{name: "read only", header: "Bearer " + token, method: http.MethodPost, tokens: []string{token}, wantStatus: http.StatusMethodNotAllowed},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := httptest.NewRequest(tt.method, "/api/stats", nil)
+			if tt.header != "" {
+				req.Header.Set("Authorization", tt.header)
+			}
+			rec := httptest.NewRecorder()
+			NewHandler(newStore(), tt.tokens).ServeHTTP(rec, req)
+
+			if rec.Code != tt.wantStatus {
+				t.Errorf("status = %d, want %d", rec.Code, tt.wantStatus)
+			}
+		})
+	}
+}
+
+func TestHandlerStoreError(t *testing.T) {
+	store := newStore()
+	store.Err = errors.New("connection refused")
+	req := httptest.NewRequest(http.MethodGet, "/api/users", nil)
+	req.Header.Set("Authorization", "Bearer "+token)
+	rec := httptest.NewRecorder()
+	NewHandler(store, []string{token}).ServeHTTP(rec, req)
+
+	if rec.Code != http.StatusInternalServerError {
+		t.Errorf("status = %d, want %d", rec.Code, http.StatusInternalServerError)
+	}
+	harness.Golden(t, "store_error", rec.Body.String())
+}
+
+func TestParseTokens(t *testing.T) {
+	got := ParseTokens(" one, ,two,")
+	if len(got) != 2 || got[0] != "one" || got[1] != "two" {
+		t.Errorf("ParseTokens = %q, want [one two]", got)
+	}
+	if got := ParseTokens(""); len(got) != 0 {
+		t.Errorf("ParseTokens(\"\") = %q, want none", got)
+	}
+}
@@ -0,0 +1,43 @@
+package api
+
+import (
+	"time"
+
+	"github.com/tomaspiaggio/autonoma-hiring-ctf/database"
+	"github.com/tomaspiaggio/autonoma-hiring-ctf/harness"
+)
+
+var _ Store = (*harness.AdminStore)(nil)
+
+// newStore returns a store with a win, a failure and the step stats of their sessions
+func newStore() *harness.AdminStore {
+	first, second := time.Date(2025, 4, 1, 12, 0, 0, 0, time.UTC), time.Date(2025, 4, 1, 13, 0, 0, 0, time.UTC)
+	funnel := []database.FunnelStep{
+		{Step: 0, StepType: "wordle", Reached: 2, Cleared: 2},
+		{Step: 1, StepType: "password", Reached: 2, Cleared: 1},
+		{Step: 2, Reached: 1, Cleared: 1},
+		{Step: 3, Reached: 1, Cleared: 1},
+		{Step: 4, Reached: 1, Cleared: 1},
+		{Step: 5, Reached: 1, Cleared: 1},
+	}
+	for i := range funnel {
+		funnel[i].Period = time.Date(2025, 4, 1, 0, 0, 0, 0, time.UTC)
+	}
+
+	return &harness.AdminStore{
+		Users: []database.User{
+			{ID: 2, Email: "grace@example.com", CreatedAt: second, Attempts: 1, Won: true, LastAttemptAt: &second},
+			{ID: 1, Email: "ada@example.com", CreatedAt: first, Attempts: 1, Failures: 1, LastAttemptAt: &first},
+		},
+		Attempts: []database.Attempt{
+			{ID: 2, Email: "grace@example.com", Details: []byte(`{"step": 5, "time": 1080000000000}`), SubmittedAt: second},
+			{ID: 1, Email: "ada@example.com", Failed: true, Details: []byte(`{"step": 1, "time": 540000000000}`), SubmittedAt: first},
+		},
+		Stats: database.Stats{Users: 2, Attempts: 2, Wins: 1, Failures: 1},
+		Steps: []database.StepStat{
+			{Step: 0, StepType: "wordle", Sessions: 2, Passed: 2, Submits: 1, AvgPassMs: 180_000, MedianPassMs: 180_000},
+			{Step: 1, StepType: "password", Sessions: 2, Passed: 1, Failed: 1, AvgPassMs: 180_000, MedianPassMs: 180_000},
+		},
+		FunnelSteps: funnel,
+	}
+}
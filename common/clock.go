@@ -0,0 +1,16 @@
+package common
+
+import "time"
+
+// Clock tells the current time. Tests swap the system clock for a fake one.
+type Clock interface {
+	Now() time.Time
+}
+
+// SystemClock is the wall clock
+type SystemClock struct{}
+
+// Now returns the current wall clock time
+func (SystemClock) Now() time.Time {
+	return time.Now()
+}
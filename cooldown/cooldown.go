@@ -0,0 +1,201 @@
+// Package cooldown decides when a candidate who failed the challenge can try again.
+// The same policy backs the check run when an email is entered and the countdown
+// shown to candidates who have to wait, so the two always agree.
+package cooldown
+
+import (
+	"fmt"
+	"strings"
+	"time"
+)
+
+// Policy decides when a candidate can try again after failing
+type Policy interface {
+	// NextAttempt returns when a candidate with the given failed attempts, oldest first,
+	// can play again. A time not after now means they can play right away.
+	NextAttempt(failures []time.Time, now time.Time) time.Time
+
+	// Lookback is how far back failures can still matter
+	Lookback() time.Duration
+
+	// Describe explains the policy to candidates
+	Describe() string
+}
+
+// Rolling makes candidates wait a fixed window after their last failure
+type Rolling struct {
+	// Window is how long candidates wait after failing
+	Window time.Duration
+}
+
+// NextAttempt returns the end of the window after the last failure
+func (p Rolling) NextAttempt(failures []time.Time, now time.Time) time.Time {
+	if len(failures) == 0 {
+		return now
+	}
+	return failures[len(failures)-1].Add(p.Window)
+}
+
+// Lookback returns the window, older failures have already been waited out
+func (p Rolling) Lookback() time.Duration {
+	return p.Window
+}
+
+// Describe tells candidates how long the window is
+func (p Rolling) Describe() string {
+	return "You can only do this test once every " + humanize(p.Window)
+}
+
+// CalendarDay makes candidates wait until the day after their last failure starts,
+// at midnight in the given location
+type CalendarDay struct {
+	// Location is where days start and end
+	Location *time.Location
+}
+
+// NextAttempt returns the midnight after the last failure
+func (p CalendarDay) NextAttempt(failures []time.Time, now time.Time) time.Time {
+	if len(failures) == 0 {
+		return now
+	}
+	last := failures[len(failures)-1].In(p.Location)
+	return time.Date(last.Year(), last.Month(), last.Day()+1, 0, 0, 0, 0, p.Location)
+}
+
+// Lookback returns the longest a day can last
+func (p CalendarDay) Lookback() time.Duration {
+	// A calendar day lasts at most 25 hours, when daylight saving time ends
+	return 25 * time.Hour
+}
+
+// Describe tells candidates in which timezone days start
+func (p CalendarDay) Describe() string {
+	return "You can only do this test once a day, days start at midnight " + p.Location.String() + " time"
+}
+
+// Backoff makes candidates wait longer after each failure: Base after the first one,
+// doubling up to Max. Failures older than Memory are forgotten, so Memory is never shorter than Max.
+type Backoff struct {
+	// Base is the wait after the first failure
+	Base time.Duration
+	// Max caps the wait, however many times candidates failed
+	Max time.Duration
+	// Memory is how long failures count towards the wait
+	Memory time.Duration
+}
+
+// NextAttempt returns the last failure plus the wait earned by every remembered failure
+func (p Backoff) NextAttempt(failures []time.Time, now time.Time) time.Time {
+	if len(failures) == 0 {
+		return now
+	}
+	wait := p.Base
+	for i := 1; i < len(failures) && wait < p.Max; i++ {
+		wait *= 2
+	}
+	if wait > p.Max {
+		wait = p.Max
+	}
+	return failures[len(failures)-1].Add(wait)
+}
+
+// Lookback returns how long failures are remembered
+func (p Backoff) Lookback() time.Duration {
+	return p.Memory
+}
+
+// Describe tells candidates how the wait grows
+func (p Backoff) Describe() string {
+	return fmt.Sprintf("After a failed attempt you wait %s, doubling with every failure up to %s", humanize(p.Base), humanize(p.Max))
+}
+
+// Default is the policy used when none is configured, one attempt every 24 hours
+var Default Policy = Rolling{Window: 24 * time.Hour}
+
+// Parse reads a policy from its configuration:
+//
+//	rolling[:WINDOW]            wait WINDOW after a failure, 24h by default
+//	calendar[:TIMEZONE]         wait until the next midnight in TIMEZONE, UTC by default
+//	backoff[:BASE[:MAX[:MEMORY]]] wait BASE, doubling per failure up to MAX,
+//	                            forgetting failures after MEMORY; 24h, 7 days and 30 days by default
+//
+// An empty configuration returns Default.
+func Parse(config string) (Policy, error) {
+	if config == "" {
+		return Default, nil
+	}
+	kind, args, _ := strings.Cut(config, ":")
+	var params []string
+	if args != "" {
+		params = strings.Split(args, ":")
+	}
+
+	switch kind {
+	case "rolling":
+		durations, err := parseDurations(params, 24*time.Hour)
+		if err != nil {
+			return nil, err
+		}
+		return Rolling{Window: durations[0]}, nil
+
+	case "calendar":
+		if len(params) > 1 {
+			return nil, fmt.Errorf("calendar cooldown takes a single timezone, got %q", args)
+		}
+		name := "UTC"
+		if len(params) == 1 {
+			name = params[0]
+		}
+		loc, err := time.LoadLocation(name)
+		if err != nil {
+			return nil, fmt.Errorf("calendar cooldown timezone: %w", err)
+		}
+		return CalendarDay{Location: loc}, nil
+
+	case "backoff":
+		durations, err := parseDurations(params, 24*time.Hour, 7*24*time.Hour, 30*24*time.Hour)
+		if err != nil {
+			return nil, err
+		}
+		if durations[1] < durations[0] {
+			return nil, fmt.Errorf("backoff cooldown max %s is shorter than its base %s", durations[1], durations[0])
+		}
+		if durations[2] < durations[1] {
+			// Failures would be forgotten before the wait they earned runs out
+			return nil, fmt.Errorf("backoff cooldown memory %s is shorter than its max %s", durations[2], durations[1])
+		}
+		return Backoff{Base: durations[0], Max: durations[1], Memory: durations[2]}, nil
+	}
+	return nil, fmt.Errorf("unknown cooldown policy %q, expected rolling, calendar or backoff", kind)
+}
+
+// parseDurations parses up to len(defaults) positive durations, using the defaults for missing ones
+func parseDurations(params []string, defaults ...time.Duration) ([]time.Duration, error) {
+	if len(params) > len(defaults) {
+		return nil, fmt.Errorf("expected at most %d durations, got %d", len(defaults), len(params))
+	}
+	durations := append([]time.Duration(nil), defaults...)
+	for i, param := range params {
+		d, err := time.ParseDuration(param)
+		if err != nil {
+			return nil, err
+		}
+		if d <= 0 {
+			return nil, fmt.Errorf("cooldown durations must be positive, got %s", param)
+		}
+		durations[i] = d
+	}
+	return durations, nil
+}
+
+// humanize writes whole days as days, anything else as a plain duration
+func humanize(d time.Duration) string {
+	const day = 24 * time.Hour
+	switch {
+	case d == day:
+		return "24 hours"
+	case d%day == 0:
+		return fmt.Sprintf("%d days", d/day)
+	}
+	return d.String()
+}
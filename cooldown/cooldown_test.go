@@ -0,0 +1,92 @@
+package cooldown
+
+import (
+	"strings"
+	"testing"
+	"time"
+)
+
+func at(t *testing.T, value string) time.Time {
+	t.Helper()
+	parsed, err := time.Parse(time.RFC3339, value)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return parsed
+}
+
+func TestNextAttempt(t *testing.T) {
+	madrid, err := time.LoadLocation("Europe/Madrid")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name     string
+		policy   Policy
+		failures []string
+		want     string
+	}{
+		{name: "rolling without failures", policy: Rolling{Window: 24 * time.Hour}, want: "2025-04-02T10:00:00Z"},
+		{name: "rolling", policy: Rolling{Window: 24 * time.Hour}, failures: []string{"2025-04-01T09:00:00Z", "2025-04-01T20:30:00Z"}, want: "2025-04-02T20:30:00Z"},
+		{name: "calendar in UTC", policy: CalendarDay{Location: time.UTC}, failures: []string{"2025-04-01T20:30:00Z"}, want: "2025-04-02T00:00:00Z"},
+		{name: "calendar in another timezone", policy: CalendarDay{Location: madrid}, failures: []string{"2025-04-01T22:30:00Z"}, want: "2025-04-02T22:00:00Z"},
+		{name: "backoff after one failure", policy: Backoff{Base: 24 * time.Hour, Max: 96 * time.Hour}, failures: []string{"2025-04-01T08:00:00Z"}, want: "2025-04-02T08:00:00Z"},
+		{name: "backoff doubles", policy: Backoff{Base: 24 * time.Hour, Max: 96 * time.Hour}, failures: []string{"2025-03-20T08:00:00Z", "2025-03-25T08:00:00Z", "2025-04-01T08:00:00Z"}, want: "2025-04-05T08:00:00Z"},
+		{name: "backoff is capped", policy: Backoff{Base: 24 * time.Hour, Max: 60 * time.Hour}, failures: []string{"2025-03-20T08:00:00Z", "2025-03-25T08:00:00Z", "2025-04-01T08:00:00Z"}, want: "2025-04-03T20:00:00Z"},
+	}
+
+	now := at(t, "2025-04-02T10:00:00Z")
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var failures []time.Time
+			for _, f := range tt.failures {
+				failures = append(failures, at(t, f))
+			}
+			got := tt.policy.NextAttempt(failures, now)
+			if want := at(t, tt.want); !got.Equal(want) {
+				t.Errorf("got %s, want %s", got.UTC().Format(time.RFC3339), tt.want)
+			}
+		})
+	}
+}
+
+func TestParse(t *testing.T) {
+	tests := []struct {
+		config  string
+		want    string
+		wantErr string
+	}{
+		{config: "", want: "You can only do this test once every 24 hours"},
+		{config: "rolling:12h", want: "You can only do this test once every 12h0m0s"},
+		{config: "calendar:America/Argentina/Buenos_Aires", want: "days start at midnight America/Argentina/Buenos_Aires time"},
+		{config: "calendar", want: "days start at midnight UTC time"},
+		{config: "backoff", want: "you wait 24 hours, doubling with every failure up to 7 days"},
+		{config: "backoff:48h:336h", want: "you wait 2 days, doubling with every failure up to 14 days"},
+		{config: "weekly", wantErr: `unknown cooldown policy "weekly"`},
+		{config: "rolling:soon", wantErr: "invalid duration"},
+		{config: "rolling:-1h", wantErr: "must be positive"},
+		{config: "calendar:Mars/Olympus", wantErr: "unknown time zone"},
+		{config: "backoff:48h:24h", wantErr: "shorter than its base"},
+		{config: "backoff:1h:96h:48h", wantErr: "memory 48h0m0s is shorter than its max 96h0m0s"},
+		{config: "backoff:1h:1000h", wantErr: "memory 720h0m0s is shorter than its max 1000h0m0s"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.config, func(t *testing.T) {
+			policy, err := Parse(tt.config)
+			if tt.wantErr != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+					t.Fatalf("expected error containing %q, got %v", tt.wantErr, err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got := policy.Describe(); !strings.Contains(got, tt.want) {
+				t.Errorf("got %q, want it to contain %q", got, tt.want)
+			}
+		})
+	}
+}
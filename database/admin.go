@@ -0,0 +1,227 @@
+package database
+
+import (
+	"database/sql"
+	"encoding/json"
+	"log"
+	"strings"
+	"time"
+)
+
+// Attempt is a finished challenge run as stored in the attempts table.
+type Attempt struct {
+	ID          int             `json:"id"`
+	Email       string          `json:"email"`
+	Failed      bool            `json:"failed"`
+	Voided      bool            `json:"voided"`
+	Abandoned   bool            `json:"abandoned"`
+	Details     json.RawMessage `json:"details"`
+	SubmittedAt time.Time       `json:"submitted_at"`
+}
+
+// User is a candidate along with a summary of their attempts.
+type User struct {
+	ID            int        `json:"id"`
+	Email         string     `json:"email"`
+	CreatedAt     time.Time  `json:"created_at"`
+	PublicKey     string     `json:"public_key_fingerprint"`
+	DisplayHandle string     `json:"display_handle"`
+	Attempts      int        `json:"attempts"`
+	Failures      int        `json:"failures"`
+	Voided        int        `json:"voided"`
+	Won           bool       `json:"won"`
+	LastAttemptAt *time.Time `json:"last_attempt_at"`
+}
+
+// Stats are totals over every user and attempt.
+type Stats struct {
+	Users          int `json:"users"`
+	Attempts       int `json:"attempts"`
+	Wins           int `json:"wins"`
+	Failures       int `json:"failures"`
+	Voided         int `json:"voided"`
+	ActiveSessions int `json:"active_sessions"`
+}
+
+// ListAttempts returns the most recent attempts first, optionally only those of one email.
+// A limit of zero or less returns every attempt.
+func (db *DB) ListAttempts(email string, limit int) ([]Attempt, error) {
+	query := `
+		SELECT a.id, u.email, a.failed, a.voided, a.abandoned, COALESCE(a.details, 'null'::jsonb), a.submitted_at
+		FROM attempts a
+		JOIN users u ON a.user_id = u.id
+		WHERE ($1 = '' OR u.email = $1)
+		ORDER BY a.submitted_at DESC, a.id DESC`
+	args := []interface{}{strings.ToLower(email)}
+	if limit > 0 {
+		query += " LIMIT $2"
+		args = append(args, limit)
+	}
+
+	rows, err := db.pool.QueryContext(db.ctx, query, args...)
+	if err != nil {
+		log.Printf("Error listing attempts: %v\n", err)
+		return nil, err
+	}
+	defer rows.Close()
+
+	attempts := []Attempt{}
+	for rows.Next() {
+		var a Attempt
+		var details []byte
+		if err := rows.Scan(&a.ID, &a.Email, &a.Failed, &a.Voided, &a.Abandoned, &details, &a.SubmittedAt); err != nil {
+			return nil, err
+		}
+		a.Details = details
+		attempts = append(attempts, a)
+	}
+	return attempts, rows.Err()
+}
+
+// userSummarySQL selects users along with a summary of their attempts, for scanUser.
+// Callers add their filter before the GROUP BY.
+const userSummarySQL = `
+		SELECT u.id, u.email, u.created_at, COALESCE(u.public_key_fingerprint, ''), COALESCE(u.display_handle, ''),
+		       COUNT(a.id),
+		       COUNT(a.id) FILTER (WHERE a.failed AND NOT a.voided),
+		       COUNT(a.id) FILTER (WHERE a.voided),
+		       COALESCE(BOOL_OR(NOT a.failed AND NOT a.voided), FALSE),
+		       MAX(a.submitted_at)
+		FROM users u
+		LEFT JOIN attempts a ON a.user_id = u.id`
+
+// rowScanner is a *sql.Row or *sql.Rows.
+type rowScanner interface {
+	Scan(dest ...interface{}) error
+}
+
+// scanUser scans a row selected by userSummarySQL.
+func scanUser(row rowScanner) (*User, error) {
+	var user User
+	var lastAttempt sql.NullTime
+	err := row.Scan(
+		&user.ID,
+		&user.Email,
+		&user.CreatedAt,
+		&user.PublicKey,
+		&user.DisplayHandle,
+		&user.Attempts,
+		&user.Failures,
+		&user.Voided,
+		&user.Won,
+		&lastAttempt,
+	)
+	if err != nil {
+		return nil, err
+	}
+	if lastAttempt.Valid {
+		user.LastAttemptAt = &lastAttempt.Time
+	}
+	return &user, nil
+}
+
+// GetUser returns the user with the given email and a summary of their attempts.
+// It returns sql.ErrNoRows if there is no such user.
+func (db *DB) GetUser(email string) (*User, error) {
+	query := userSummarySQL + `
+		WHERE u.email = $1
+		GROUP BY u.id`
+	return scanUser(db.pool.QueryRowContext(db.ctx, query, strings.ToLower(email)))
+}
+
+// ListUsers returns the most recently created users first, with a summary of their attempts.
+// A limit of zero or less returns every user.
+func (db *DB) ListUsers(limit int) ([]User, error) {
+	query := userSummarySQL + `
+		GROUP BY u.id
+		ORDER BY u.created_at DESC, u.id DESC`
+	var args []interface{}
+	if limit > 0 {
+		query += " LIMIT $1"
+		args = append(args, limit)
+	}
+
+	rows, err := db.pool.QueryContext(db.ctx, query, args...)
+	if err != nil {
+		log.Printf("Error listing users: %v\n", err)
+		return nil, err
+	}
+	defer rows.Close()
+
+	users := []User{}
+	for rows.Next() {
+		user, err := scanUser(rows)
+		if err != nil {
+			return nil, err
+		}
+		users = append(users, *user)
+	}
+	return users, rows.Err()
+}
+
+// VoidAttempt marks an attempt as voided, so it no longer counts as a win or towards the cooldown.
+// It returns sql.ErrNoRows if there is no such attempt.
+func (db *DB) VoidAttempt(id int) error {
+	result, err := db.pool.ExecContext(db.ctx, "UPDATE attempts SET voided = TRUE WHERE id = $1", id)
+	if err != nil {
+		log.Printf("Error voiding attempt %d: %v\n", id, err)
+		return err
+	}
+	affected, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if affected == 0 {
+		return sql.ErrNoRows
+	}
+	log.Printf("Voided attempt %d\n", id)
+	return nil
+}
+
+// UnlinkPublicKey removes the SSH key bound to the email, so the next key it plays with gets bound instead.
+// It returns sql.ErrNoRows if there is no such user.
+func (db *DB) UnlinkPublicKey(email string) error {
+	query := "UPDATE users SET public_key_fingerprint = NULL WHERE email = $1"
+	result, err := db.pool.ExecContext(db.ctx, query, strings.ToLower(email))
+	if err != nil {
+		log.Printf("Error unlinking public key of %s: %v\n", email, err)
+		return err
+	}
+	affected, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if affected == 0 {
+		return sql.ErrNoRows
+	}
+	log.Printf("Unlinked public key of %s\n", email)
+	return nil
+}
+
+// GetStats returns totals over every user and attempt.
+func (db *DB) GetStats() (*Stats, error) {
+	query := `
+		SELECT
+			(SELECT COUNT(*) FROM users),
+			COUNT(*),
+			COUNT(*) FILTER (WHERE NOT failed AND NOT voided),
+			COUNT(*) FILTER (WHERE failed AND NOT voided),
+			COUNT(*) FILTER (WHERE voided),
+			(SELECT COUNT(*) FROM sessions WHERE finished = FALSE)
+		FROM attempts`
+
+	var stats Stats
+	err := db.pool.QueryRowContext(db.ctx, query).Scan(
+		&stats.Users,
+		&stats.Attempts,
+		&stats.Wins,
+		&stats.Failures,
+		&stats.Voided,
+		&stats.ActiveSessions,
+	)
+	if err != nil {
+		log.Printf("Error computing stats: %v\n", err)
+		return nil, err
+	}
+	return &stats, nil
+}
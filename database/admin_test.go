@@ -0,0 +1,234 @@
+package database
+
+import (
+	"database/sql"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+func TestListAttemptsAndUsers(t *testing.T) {
+	db := newTestDB(t)
+	start := time.Date(2025, 4, 1, 12, 0, 0, 0, time.UTC)
+	adaFailed := createAttempt(t, db, "ada@example.com", true, map[string]interface{}{"step": 2})
+	submittedAt(t, db, adaFailed, start)
+	graceVoided := createAttempt(t, db, "grace@example.com", true, nil)
+	submittedAt(t, db, graceVoided, start.Add(time.Hour))
+	if err := db.VoidAttempt(graceVoided); err != nil {
+		t.Fatal(err)
+	}
+	adaWon := createAttempt(t, db, "ada@example.com", false, nil)
+	submittedAt(t, db, adaWon, start.Add(2*time.Hour))
+	if _, err := db.BindPublicKey("ada@example.com", "SHA256:ada"); err != nil {
+		t.Fatal(err)
+	}
+
+	attempts, err := db.ListAttempts("", 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(attempts) != 3 || attempts[0].ID != adaWon || attempts[1].ID != graceVoided || attempts[2].ID != adaFailed {
+		t.Fatalf("expected every attempt, latest first, got %+v", attempts)
+	}
+	if !attempts[1].Voided || attempts[1].Email != "grace@example.com" || string(attempts[1].Details) != "null" {
+		t.Errorf("unexpected attempt %+v", attempts[1])
+	}
+	attempts, err = db.ListAttempts("ADA@example.com", 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(attempts) != 1 || attempts[0].ID != adaWon {
+		t.Errorf("expected the latest attempt of ada, got %+v", attempts)
+	}
+
+	ada, err := db.GetUser("Ada@example.com")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if ada.Email != "ada@example.com" || ada.PublicKey != "SHA256:ada" || ada.Attempts != 2 || ada.Failures != 1 ||
+		ada.Voided != 0 || !ada.Won || ada.LastAttemptAt == nil || !ada.LastAttemptAt.Equal(start.Add(2*time.Hour)) {
+		t.Errorf("unexpected summary %+v", ada)
+	}
+	grace, err := db.GetUser("grace@example.com")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if grace.Attempts != 1 || grace.Failures != 0 || grace.Voided != 1 || grace.Won {
+		t.Errorf("unexpected summary %+v", grace)
+	}
+	if _, err := db.GetUser("nobody@example.com"); err != sql.ErrNoRows {
+		t.Errorf("expected sql.ErrNoRows for an unknown user, got %v", err)
+	}
+
+	// Users without attempts are listed too, the most recently created first
+	if err := db.CreateSession(uuid.NewString(), "linus@example.com", time.Now(), 1); err != nil {
+		t.Fatal(err)
+	}
+	users, err := db.ListUsers(0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(users) != 3 || users[0].Email != "linus@example.com" || users[1].Email != "grace@example.com" || users[2].Email != "ada@example.com" {
+		t.Fatalf("expected the users latest first, got %+v", users)
+	}
+	if users[0].Attempts != 0 || users[0].Won || users[0].LastAttemptAt != nil {
+		t.Errorf("expected an empty summary, got %+v", users[0])
+	}
+	if users, err := db.ListUsers(1); err != nil || len(users) != 1 {
+		t.Errorf("expected the limit to apply, got %+v %v", users, err)
+	}
+
+	stats, err := db.GetStats()
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := Stats{Users: 3, Attempts: 3, Wins: 1, Failures: 1, Voided: 1, ActiveSessions: 1}
+	if *stats != want {
+		t.Errorf("GetStats = %+v, want %+v", *stats, want)
+	}
+
+	if err := db.VoidAttempt(999); err != sql.ErrNoRows {
+		t.Errorf("expected sql.ErrNoRows for an unknown attempt, got %v", err)
+	}
+}
+
+func TestStepStats(t *testing.T) {
+	db := newTestDB(t)
+	first, second := uuid.NewString(), uuid.NewString()
+	record := func(session string, step int, stepType string, kind EventKind, took time.Duration) {
+		t.Helper()
+		event := StepEvent{SessionID: session, Step: step, StepType: stepType, Kind: kind, At: time.Now(), Duration: took}
+		if err := db.RecordStepEvent(event); err != nil {
+			t.Fatal(err)
+		}
+	}
+	record(first, 0, "wordle", EventEnter, 0)
+	record(first, 0, "wordle", EventSubmit, 30*time.Second)
+	record(first, 0, "wordle", EventPass, 2*time.Minute)
+	record(first, 1, "password", EventEnter, 0)
+	record(first, 1, "password", EventFail, 7*time.Minute)
+	record(second, 0, "wordle", EventEnter, 0)
+	record(second, 0, "wordle", EventEnter, 0) // resumed
+	record(second, 0, "wordle", EventPass, 4*time.Minute)
+	record(second, 1, "password", EventEnter, 0)
+	record(second, 1, "password", EventTimeout, 9*time.Minute)
+
+	stats, err := db.StepStats()
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []StepStat{
+		{Step: 0, StepType: "wordle", Sessions: 2, Passed: 2, Submits: 1, AvgPassMs: 180_000, MedianPassMs: 180_000},
+		{Step: 1, StepType: "password", Sessions: 2, Failed: 1, TimedOut: 1},
+	}
+	if len(stats) != len(want) {
+		t.Fatalf("StepStats = %+v, want %+v", stats, want)
+	}
+	for i := range want {
+		if stats[i] != want[i] {
+			t.Errorf("StepStats[%d] = %+v, want %+v", i, stats[i], want[i])
+		}
+	}
+}
+
+func TestExportAttempts(t *testing.T) {
+	db := newTestDB(t)
+	day := func(d int) time.Time { return time.Date(2025, 4, d, 12, 0, 0, 0, time.UTC) }
+
+	failed := createAttempt(t, db, "ada@example.com", true, nil)
+	submittedAt(t, db, failed, day(1))
+	won := createAttempt(t, db, "grace@example.com", false, nil)
+	submittedAt(t, db, won, day(2))
+	session := uuid.NewString()
+	abandoned, err := db.SaveAttempt(session, "ada@example.com", OutcomeAbandoned, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	submittedAt(t, db, abandoned, day(3))
+	voided := createAttempt(t, db, "ada@example.com", false, nil)
+	submittedAt(t, db, voided, day(4))
+	if err := db.VoidAttempt(voided); err != nil {
+		t.Fatal(err)
+	}
+	if err := db.SetDisplayHandle("grace@example.com", "amazing_grace"); err != nil {
+		t.Fatal(err)
+	}
+
+	export := func(t *testing.T, filter ExportFilter) []ExportRow {
+		t.Helper()
+		var rows []ExportRow
+		err := db.ExportAttempts(filter, func(row ExportRow) error {
+			rows = append(rows, row)
+			return nil
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+		return rows
+	}
+	ids := func(rows []ExportRow) []int {
+		var ids []int
+		for _, row := range rows {
+			ids = append(ids, row.ID)
+		}
+		return ids
+	}
+
+	tests := []struct {
+		name   string
+		filter ExportFilter
+		want   []int
+	}{
+		{name: "everything", want: []int{failed, won, abandoned, voided}},
+		{name: "won", filter: ExportFilter{Outcome: "won"}, want: []int{won}},
+		{name: "failed", filter: ExportFilter{Outcome: "Failed"}, want: []int{failed}},
+		{name: "abandoned", filter: ExportFilter{Outcome: "abandoned"}, want: []int{abandoned}},
+		{name: "voided", filter: ExportFilter{Outcome: "voided"}, want: []int{voided}},
+		{name: "from", filter: ExportFilter{From: day(3)}, want: []int{abandoned, voided}},
+		{name: "to", filter: ExportFilter{To: day(2)}, want: []int{failed}},
+		{name: "range", filter: ExportFilter{From: day(2), To: day(4)}, want: []int{won, abandoned}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := ids(export(t, tt.filter))
+			if len(got) != len(tt.want) {
+				t.Fatalf("exported %v, want %v", got, tt.want)
+			}
+			for i := range got {
+				if got[i] != tt.want[i] {
+					t.Fatalf("exported %v, want %v", got, tt.want)
+				}
+			}
+		})
+	}
+
+	rows := export(t, ExportFilter{})
+	if rows[1].DisplayHandle != "amazing_grace" || rows[1].Email != "grace@example.com" || rows[1].UserCreatedAt.IsZero() {
+		t.Errorf("expected the user to be exported with the attempt, got %+v", rows[1])
+	}
+	if rows[2].SessionID != session || !rows[2].Abandoned || rows[0].SessionID != "" {
+		t.Errorf("expected only the session attempt to have a session, got %q and %q", rows[2].SessionID, rows[0].SessionID)
+	}
+
+	// fn errors stop the export
+	stop := errors.New("disk full")
+	calls := 0
+	err = db.ExportAttempts(ExportFilter{}, func(ExportRow) error {
+		calls++
+		return stop
+	})
+	if err != stop || calls != 1 {
+		t.Errorf("expected the export to stop at the first error, got %v after %d rows", err, calls)
+	}
+}
+
+func TestExportAttemptsInvalidOutcome(t *testing.T) {
+	// The outcome is checked before querying, so no database is needed
+	err := (&DB{}).ExportAttempts(ExportFilter{Outcome: "lost"}, nil)
+	want := `invalid outcome "lost", use one of won, failed, abandoned, voided`
+	if err == nil || err.Error() != want {
+		t.Errorf("got %v, want %s", err, want)
+	}
+}
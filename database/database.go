@@ -5,6 +5,7 @@ import (
 	"database/sql"
 	"log"
 	"strings"
+	"time"
 
 	_ "github.com/jackc/pgx/v5/stdlib" // PostgreSQL driver
 )
@@ -15,28 +16,37 @@ type DB struct {
 	ctx  context.Context
 }
 
-// New connects to the database using the provided DSN and returns a DB instance.
+// New connects to the database using the provided DSN, applies pending migrations and returns a DB instance.
 func New(dsn string) (*DB, error) {
-	db, err := sql.Open("pgx", dsn)
+	db, err := Open(dsn)
 	if err != nil {
 		return nil, err
 	}
 
-	// Ping the database to verify the connection.
-	if err := db.Ping(); err != nil {
-		db.Close() // Close the connection if ping fails
+	if _, err := db.MigrateUp(); err != nil {
+		db.Close()
 		return nil, err
 	}
+	log.Println("Database schema is up to date.")
 
-	log.Println("Successfully connected to the database")
-	result := DB{pool: db, ctx: context.Background()}
+	return db, nil
+}
 
-	err = result.InitSchema()
+// Open connects to the database using the provided DSN without touching the schema.
+func Open(dsn string) (*DB, error) {
+	db, err := sql.Open("pgx", dsn)
 	if err != nil {
 		return nil, err
 	}
 
-	return &result, nil
+	// Ping the database to verify the connection.
+	if err := db.Ping(); err != nil {
+		db.Close() // Close the connection if ping fails
+		return nil, err
+	}
+
+	log.Println("Successfully connected to the database")
+	return &DB{pool: db, ctx: context.Background()}, nil
 }
 
 // Close closes the database connection pool.
@@ -44,73 +54,39 @@ func (db *DB) Close() error {
 	return db.pool.Close()
 }
 
-// InitSchema creates the necessary tables if they do not exist.
-func (db *DB) InitSchema() error {
-	// Create users table
-	usersTableSQL := `
-	CREATE TABLE IF NOT EXISTS users (
-		id SERIAL PRIMARY KEY,
-		email TEXT UNIQUE NOT NULL,
-		created_at TIMESTAMPTZ DEFAULT NOW()
-	);`
-
-	_, err := db.pool.ExecContext(db.ctx, usersTableSQL)
-	if err != nil {
-		log.Printf("Error creating users table: %v\n", err)
-		return err
-	}
-	log.Println("Users table checked/created successfully.")
-
-	// Create attempts table
-	attemptsTableSQL := `
-	CREATE TABLE IF NOT EXISTS attempts (
-		id SERIAL PRIMARY KEY,
-		user_id INTEGER NOT NULL REFERENCES users(id) ON DELETE CASCADE,
-		failed BOOLEAN DEFAULT TRUE,
-		details JSONB,
-		submitted_at TIMESTAMPTZ DEFAULT NOW()
-	);`
-
-	_, err = db.pool.ExecContext(db.ctx, attemptsTableSQL)
-	if err != nil {
-		log.Printf("Error creating attempts table: %v\n", err)
-		return err
-	}
-	log.Println("Attempts table checked/created successfully.")
-
-	return nil
-}
-
-func (db *DB) DoesUserHaveFailedAttemptsToday(email string) (bool, error) {
-	var exists int // Dummy variable to scan into
-	// Select 1 to just check for existence, also ensure we check for failed attempts
+// FailedAttemptTimes returns when the email failed attempts since the given time, oldest first.
+// The cooldown policy decides what they mean for the next attempt.
+func (db *DB) FailedAttemptTimes(email string, since time.Time) ([]time.Time, error) {
 	query := `
-		SELECT 1 
-		FROM attempts a 
-		JOIN users u ON a.user_id = u.id 
-		WHERE u.email = $1 
-		  AND a.failed = TRUE 
-		  AND a.submitted_at > NOW() - INTERVAL '24 hours' 
-		LIMIT 1`
-	lowerEmail := strings.ToLower(email)
-
-	// QueryRowContext returns sql.ErrNoRows if no row is found
-	err := db.pool.QueryRowContext(db.ctx, query, lowerEmail).Scan(&exists)
+		SELECT a.submitted_at
+		FROM attempts a
+		JOIN users u ON a.user_id = u.id
+		WHERE u.email = $1
+		  AND a.failed = TRUE
+		  AND a.voided = FALSE
+		  AND a.submitted_at > $2
+		ORDER BY a.submitted_at`
 
+	rows, err := db.pool.QueryContext(db.ctx, query, strings.ToLower(email), since)
 	if err != nil {
-		if err == sql.ErrNoRows {
-			log.Printf("No failed attempts found for %s in the last 24 hours\n", email)
-			// No failed attempts found in the last 24 hours
-			return false, nil
-		}
-		// Some other database error occurred
 		log.Printf("Error checking for failed attempts for %s: %v\n", email, err)
-		return false, err
+		return nil, err
 	}
+	defer rows.Close()
 
-	// A row was found, meaning a failed attempt exists
-	log.Printf("Found %d failed attempts for %s in the last 24 hours\n", exists, email)
-	return true, nil
+	var times []time.Time
+	for rows.Next() {
+		var at time.Time
+		if err := rows.Scan(&at); err != nil {
+			return nil, err
+		}
+		times = append(times, at)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	log.Printf("Found %d failed attempts for %s since %s\n", len(times), email, since.Format(time.RFC3339))
+	return times, nil
 }
 
 func (db *DB) HasUserWon(email string) (bool, error) {
@@ -122,6 +98,7 @@ func (db *DB) HasUserWon(email string) (bool, error) {
 		JOIN users u ON a.user_id = u.id
 		WHERE u.email = $1 
 		  AND a.failed = FALSE 
+		  AND a.voided = FALSE
 		LIMIT 1`
 	lowerEmail := strings.ToLower(email)
 	err := db.pool.QueryRowContext(db.ctx, query, lowerEmail).Scan(&exists)
@@ -156,20 +133,24 @@ func (db *DB) getUserIdByEmail(email string) (int, error) {
 	return id, err
 }
 
-func (db *DB) CreateAttempt(email string, failed bool, details map[string]interface{}) (int, error) {
+// getOrCreateUserId returns the id of the user with the given email, creating the user if needed
+func (db *DB) getOrCreateUserId(email string) (int, error) {
 	userId, err := db.getUserIdByEmail(email)
 	if err != nil {
 		if err == sql.ErrNoRows {
 			log.Printf("User %s not found, creating user\n", email)
-			userId, err = db.CreateUser(email)
-			if err != nil {
-				return -1, err
-			}
-		} else {
-			return -1, err
+			return db.CreateUser(email)
 		}
-	} else {
-		log.Printf("Found user %s with id %d\n", email, userId)
+		return -1, err
+	}
+	log.Printf("Found user %s with id %d\n", email, userId)
+	return userId, nil
+}
+
+func (db *DB) CreateAttempt(email string, failed bool, details map[string]interface{}) (int, error) {
+	userId, err := db.getOrCreateUserId(email)
+	if err != nil {
+		return -1, err
 	}
 	var id int
 	query := "INSERT INTO attempts (user_id, failed, details) VALUES ($1, $2, $3) RETURNING id"
@@ -177,3 +158,90 @@ func (db *DB) CreateAttempt(email string, failed bool, details map[string]interf
 	log.Printf("Created attempt for user %s with id %d\n", email, id)
 	return id, err
 }
+
+// Outcome is how a session ended.
+type Outcome string
+
+const (
+	// OutcomeWon is a session that reached the end of the challenge.
+	OutcomeWon Outcome = "won"
+
+	// OutcomeFailed is a session that failed a step or ran out of time.
+	OutcomeFailed Outcome = "failed"
+
+	// OutcomeAbandoned is a session the candidate left before finishing. It counts as a failure.
+	OutcomeAbandoned Outcome = "abandoned"
+)
+
+// SaveAttempt records how the session ended, creating the user if needed.
+// A session has a single attempt: an abandoned one is replaced when the session is resumed and ends again,
+// any other outcome is final. It returns -1 if the session already has a final attempt.
+func (db *DB) SaveAttempt(sessionID string, email string, outcome Outcome, details map[string]interface{}) (int, error) {
+	userId, err := db.getOrCreateUserId(email)
+	if err != nil {
+		return -1, err
+	}
+	var id int
+	query := `
+		INSERT INTO attempts (user_id, session_id, failed, abandoned, details)
+		VALUES ($1, $2, $3, $4, $5)
+		ON CONFLICT (session_id) DO UPDATE
+		SET failed = EXCLUDED.failed,
+		    abandoned = EXCLUDED.abandoned,
+		    details = EXCLUDED.details,
+		    submitted_at = NOW()
+		WHERE attempts.abandoned
+		RETURNING id`
+	err = db.pool.QueryRowContext(db.ctx, query, userId, sessionID, outcome != OutcomeWon, outcome == OutcomeAbandoned, details).Scan(&id)
+	if err == sql.ErrNoRows {
+		log.Printf("Attempt for session %s was already recorded\n", sessionID)
+		return -1, nil
+	}
+	if err != nil {
+		log.Printf("Error saving attempt for session %s: %v\n", sessionID, err)
+		return -1, err
+	}
+	log.Printf("Saved %s attempt for user %s with id %d\n", outcome, email, id)
+	return id, nil
+}
+
+// BoundPublicKey returns the fingerprint of the SSH key the email is bound to, or an empty string if there is none.
+// Unlike BindPublicKey it never creates the user, so it is safe to call before the email is verified.
+func (db *DB) BoundPublicKey(email string) (string, error) {
+	var bound sql.NullString
+	query := "SELECT public_key_fingerprint FROM users WHERE email = $1"
+	err := db.pool.QueryRowContext(db.ctx, query, strings.ToLower(email)).Scan(&bound)
+	if err == sql.ErrNoRows {
+		return "", nil
+	}
+	if err != nil {
+		log.Printf("Error reading the public key of %s: %v\n", email, err)
+		return "", err
+	}
+	return bound.String, nil
+}
+
+// BindPublicKey ties the email to the fingerprint of the SSH key it first plays with, creating the user if needed.
+// It reports whether the fingerprint matches the key the email is bound to.
+func (db *DB) BindPublicKey(email string, fingerprint string) (bool, error) {
+	userId, err := db.getOrCreateUserId(email)
+	if err != nil {
+		return false, err
+	}
+	var bound string
+	query := `
+		UPDATE users
+		SET public_key_fingerprint = COALESCE(public_key_fingerprint, $2)
+		WHERE id = $1
+		RETURNING public_key_fingerprint`
+	err = db.pool.QueryRowContext(db.ctx, query, userId, fingerprint).Scan(&bound)
+	if err != nil {
+		log.Printf("Error binding public key for %s: %v\n", email, err)
+		return false, err
+	}
+	if bound != fingerprint {
+		log.Printf("Public key %s does not match the one bound to %s\n", fingerprint, email)
+		return false, nil
+	}
+	return true, nil
+}
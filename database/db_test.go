@@ -0,0 +1,202 @@
+package database
+
+import (
+	"database/sql"
+	"fmt"
+	"os"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// testDSNEnv names the Postgres database the query tests run against. They are skipped when it is unset.
+const testDSNEnv = "TEST_DATABASE_URL"
+
+// newTestDB returns a migrated database in a schema of its own, dropped once the test ends
+func newTestDB(t *testing.T) *DB {
+	t.Helper()
+	dsn := os.Getenv(testDSNEnv)
+	if dsn == "" {
+		t.Skipf("set %s to run the queries against Postgres", testDSNEnv)
+	}
+
+	owner, err := Open(dsn)
+	if err != nil {
+		t.Fatal(err)
+	}
+	schema := fmt.Sprintf("test_%d", time.Now().UnixNano())
+	if _, err := owner.pool.ExecContext(owner.ctx, "CREATE SCHEMA "+schema); err != nil {
+		owner.Close()
+		t.Fatal(err)
+	}
+	t.Cleanup(func() {
+		if _, err := owner.pool.ExecContext(owner.ctx, "DROP SCHEMA "+schema+" CASCADE"); err != nil {
+			t.Errorf("dropping schema %s: %v", schema, err)
+		}
+		owner.Close()
+	})
+
+	db, err := New(withSearchPath(dsn, schema))
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { db.Close() })
+	return db
+}
+
+// withSearchPath adds the schema as the search path of every connection, for both URL and keyword/value DSNs
+func withSearchPath(dsn string, schema string) string {
+	if !strings.Contains(dsn, "://") {
+		return dsn + " search_path=" + schema
+	}
+	if strings.Contains(dsn, "?") {
+		return dsn + "&search_path=" + schema
+	}
+	return dsn + "?search_path=" + schema
+}
+
+// exec runs a statement the tests need to arrange rows, such as moving timestamps back
+func exec(t *testing.T, db *DB, query string, args ...interface{}) {
+	t.Helper()
+	if _, err := db.pool.ExecContext(db.ctx, query, args...); err != nil {
+		t.Fatal(err)
+	}
+}
+
+// submittedAt moves an attempt to the given time
+func submittedAt(t *testing.T, db *DB, id int, at time.Time) {
+	t.Helper()
+	exec(t, db, "UPDATE attempts SET submitted_at = $2 WHERE id = $1", id, at)
+}
+
+// createAttempt stores an attempt and fails the test on error
+func createAttempt(t *testing.T, db *DB, email string, failed bool, details map[string]interface{}) int {
+	t.Helper()
+	id, err := db.CreateAttempt(email, failed, details)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return id
+}
+
+func TestSaveAttempt(t *testing.T) {
+	db := newTestDB(t)
+	session := uuid.NewString()
+
+	abandoned, err := db.SaveAttempt(session, "Ada@Example.com", OutcomeAbandoned, map[string]interface{}{"step": 1})
+	if err != nil || abandoned == -1 {
+		t.Fatalf("expected the abandoned attempt to be saved, got %d %v", abandoned, err)
+	}
+
+	// The resumed session replaces the abandoned attempt, and its outcome is final
+	failed, err := db.SaveAttempt(session, "ada@example.com", OutcomeFailed, map[string]interface{}{"step": 2})
+	if err != nil || failed != abandoned {
+		t.Fatalf("expected attempt %d to be replaced, got %d %v", abandoned, failed, err)
+	}
+	won, err := db.SaveAttempt(session, "ada@example.com", OutcomeWon, map[string]interface{}{"step": 5})
+	if err != nil || won != -1 {
+		t.Fatalf("expected the final attempt to be kept, got %d %v", won, err)
+	}
+
+	attempts, err := db.ListAttempts("ada@example.com", 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(attempts) != 1 || !attempts[0].Failed || attempts[0].Abandoned {
+		t.Fatalf("expected a single failed attempt, got %+v", attempts)
+	}
+	if string(attempts[0].Details) != `{"step": 2}` {
+		t.Errorf("expected the details of the failure, got %s", attempts[0].Details)
+	}
+}
+
+func TestFailedAttemptTimesAndWins(t *testing.T) {
+	db := newTestDB(t)
+	now := time.Now()
+
+	old := createAttempt(t, db, "ada@example.com", true, nil)
+	submittedAt(t, db, old, now.Add(-48*time.Hour))
+	recent := createAttempt(t, db, "ada@example.com", true, nil)
+	submittedAt(t, db, recent, now.Add(-time.Hour))
+	voided := createAttempt(t, db, "ada@example.com", true, nil)
+	if err := db.VoidAttempt(voided); err != nil {
+		t.Fatal(err)
+	}
+	createAttempt(t, db, "grace@example.com", true, nil)
+
+	times, err := db.FailedAttemptTimes("ADA@example.com", now.Add(-24*time.Hour))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(times) != 1 || !times[0].Equal(now.Add(-time.Hour).Truncate(time.Microsecond)) {
+		t.Errorf("expected only the recent failure, got %v", times)
+	}
+
+	if won, err := db.HasUserWon("ada@example.com"); err != nil || won {
+		t.Fatalf("expected no win yet, got %v %v", won, err)
+	}
+	win := createAttempt(t, db, "ada@example.com", false, nil)
+	if won, err := db.HasUserWon("ada@example.com"); err != nil || !won {
+		t.Fatalf("expected the win to count, got %v %v", won, err)
+	}
+	if err := db.VoidAttempt(win); err != nil {
+		t.Fatal(err)
+	}
+	if won, err := db.HasUserWon("ada@example.com"); err != nil || won {
+		t.Errorf("expected the voided win to be ignored, got %v %v", won, err)
+	}
+}
+
+func TestBindPublicKey(t *testing.T) {
+	db := newTestDB(t)
+	bound := func(email string) string {
+		t.Helper()
+		fingerprint, err := db.BoundPublicKey(email)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return fingerprint
+	}
+
+	// Reading the key doesn't create the user
+	if fingerprint := bound("ada@example.com"); fingerprint != "" {
+		t.Fatalf("expected no key for an unknown user, got %s", fingerprint)
+	}
+	if users, err := db.ListUsers(0); err != nil || len(users) != 0 {
+		t.Fatalf("expected no users, got %+v %v", users, err)
+	}
+
+	tests := []struct {
+		fingerprint string
+		want        bool
+	}{
+		{fingerprint: "SHA256:first", want: true},
+		{fingerprint: "SHA256:first", want: true},
+		{fingerprint: "SHA256:other", want: false},
+	}
+	for _, tt := range tests {
+		ok, err := db.BindPublicKey("Ada@example.com", tt.fingerprint)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if ok != tt.want {
+			t.Errorf("BindPublicKey(%s) = %v, want %v", tt.fingerprint, ok, tt.want)
+		}
+	}
+	if fingerprint := bound("ADA@example.com"); fingerprint != "SHA256:first" {
+		t.Errorf("BoundPublicKey = %q, want SHA256:first", fingerprint)
+	}
+
+	// Once unlinked, the next key gets bound
+	if err := db.UnlinkPublicKey("ada@example.com"); err != nil {
+		t.Fatal(err)
+	}
+	if ok, err := db.BindPublicKey("ada@example.com", "SHA256:other"); err != nil || !ok {
+		t.Errorf("expected the new key to be bound, got %v %v", ok, err)
+	}
+	if err := db.UnlinkPublicKey("nobody@example.com"); err != sql.ErrNoRows {
+		t.Errorf("expected sql.ErrNoRows for an unknown user, got %v", err)
+	}
+}
@@ -0,0 +1,105 @@
+package database
+
+import (
+	"log"
+	"time"
+)
+
+// EventKind is what happened on a step.
+type EventKind string
+
+const (
+	// EventEnter is emitted when a step becomes the current one, including when a session resumes on it.
+	EventEnter EventKind = "enter"
+
+	// EventSubmit is emitted when the candidate submits something the step checks, like a Wordle guess or code.
+	EventSubmit EventKind = "submit"
+
+	// EventPass is emitted when the step is completed.
+	EventPass EventKind = "pass"
+
+	// EventFail is emitted when the step fails the session.
+	EventFail EventKind = "fail"
+
+	// EventTimeout is emitted when the session fails because the step or the challenge ran out of time.
+	EventTimeout EventKind = "timeout"
+)
+
+// StepEvent is something that happened on a step of a session.
+// Duration is the time since the step was entered, and Payload holds step specific details.
+type StepEvent struct {
+	SessionID string
+	Step      int
+	StepType  string
+	Kind      EventKind
+	At        time.Time
+	Duration  time.Duration
+	Payload   map[string]interface{}
+}
+
+// RecordStepEvent stores a step event.
+func (db *DB) RecordStepEvent(event StepEvent) error {
+	query := `
+		INSERT INTO step_events (session_id, step, step_type, kind, duration_ms, payload, created_at)
+		VALUES ($1, $2, $3, $4, $5, $6, $7)`
+	_, err := db.pool.ExecContext(db.ctx, query,
+		event.SessionID,
+		event.Step,
+		event.StepType,
+		string(event.Kind),
+		event.Duration.Milliseconds(),
+		event.Payload,
+		event.At,
+	)
+	if err != nil {
+		log.Printf("Error recording %s event of session %s: %v\n", event.Kind, event.SessionID, err)
+	}
+	return err
+}
+
+// StepStat summarizes the events of one step over every session.
+type StepStat struct {
+	Step         int    `json:"step"`
+	StepType     string `json:"step_type"`
+	Sessions     int    `json:"sessions"`
+	Passed       int    `json:"passed"`
+	Failed       int    `json:"failed"`
+	TimedOut     int    `json:"timed_out"`
+	Submits      int    `json:"submits"`
+	AvgPassMs    int64  `json:"avg_pass_ms"`
+	MedianPassMs int64  `json:"median_pass_ms"`
+}
+
+// StepStats returns how many sessions reached, passed, failed and timed out on each step, and how long
+// passing took, ordered by step.
+func (db *DB) StepStats() ([]StepStat, error) {
+	query := `
+		SELECT step, step_type,
+		       COUNT(DISTINCT session_id) FILTER (WHERE kind = 'enter'),
+		       COUNT(*) FILTER (WHERE kind = 'pass'),
+		       COUNT(*) FILTER (WHERE kind = 'fail'),
+		       COUNT(*) FILTER (WHERE kind = 'timeout'),
+		       COUNT(*) FILTER (WHERE kind = 'submit'),
+		       COALESCE(AVG(duration_ms) FILTER (WHERE kind = 'pass'), 0)::BIGINT,
+		       COALESCE(PERCENTILE_CONT(0.5) WITHIN GROUP (ORDER BY duration_ms) FILTER (WHERE kind = 'pass'), 0)::BIGINT
+		FROM step_events
+		GROUP BY step, step_type
+		ORDER BY step, step_type`
+
+	rows, err := db.pool.QueryContext(db.ctx, query)
+	if err != nil {
+		log.Printf("Error computing step stats: %v\n", err)
+		return nil, err
+	}
+	defer rows.Close()
+
+	stats := []StepStat{}
+	for rows.Next() {
+		var s StepStat
+		if err := rows.Scan(&s.Step, &s.StepType, &s.Sessions, &s.Passed, &s.Failed, &s.TimedOut, &s.Submits, &s.AvgPassMs, &s.MedianPassMs); err != nil {
+			return nil, err
+		}
+		stats = append(stats, s)
+	}
+	return stats, rows.Err()
+}
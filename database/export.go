@@ -0,0 +1,95 @@
+package database
+
+import (
+	"fmt"
+	"log"
+	"strings"
+	"time"
+)
+
+// ExportFilter selects the attempts to export. Zero values don't filter.
+type ExportFilter struct {
+	// From and To bound when the attempts were submitted, From inclusive and To exclusive.
+	From time.Time
+	To   time.Time
+
+	// Outcome is won, failed, abandoned or voided. Voided attempts only match voided.
+	Outcome string
+}
+
+// ExportOutcomes are the outcomes an export can be filtered by.
+var ExportOutcomes = []string{"won", "failed", "abandoned", "voided"}
+
+// ExportRow is an attempt along with the user who made it.
+type ExportRow struct {
+	Attempt
+	SessionID     string
+	UserCreatedAt time.Time
+	DisplayHandle string
+}
+
+// exportOutcomeSQL is the condition matching each outcome
+var exportOutcomeSQL = map[string]string{
+	"":          "TRUE",
+	"won":       "NOT a.failed AND NOT a.voided",
+	"failed":    "a.failed AND NOT a.abandoned AND NOT a.voided",
+	"abandoned": "a.abandoned AND NOT a.voided",
+	"voided":    "a.voided",
+}
+
+// ExportAttempts calls fn with every attempt matching the filter, oldest first, as they are read.
+// It stops at the first error fn returns.
+func (db *DB) ExportAttempts(filter ExportFilter, fn func(ExportRow) error) error {
+	outcome, ok := exportOutcomeSQL[strings.ToLower(filter.Outcome)]
+	if !ok {
+		return fmt.Errorf("invalid outcome %q, use one of %s", filter.Outcome, strings.Join(ExportOutcomes, ", "))
+	}
+
+	query := `
+		SELECT a.id, u.email, a.failed, a.voided, a.abandoned, COALESCE(a.details, 'null'::jsonb), a.submitted_at,
+		       COALESCE(a.session_id::TEXT, ''), u.created_at, COALESCE(u.display_handle, '')
+		FROM attempts a
+		JOIN users u ON a.user_id = u.id
+		WHERE ($1::TIMESTAMPTZ IS NULL OR a.submitted_at >= $1)
+		  AND ($2::TIMESTAMPTZ IS NULL OR a.submitted_at < $2)
+		  AND ` + outcome + `
+		ORDER BY a.submitted_at, a.id`
+
+	rows, err := db.pool.QueryContext(db.ctx, query, nullTime(filter.From), nullTime(filter.To))
+	if err != nil {
+		log.Printf("Error exporting attempts: %v\n", err)
+		return err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var row ExportRow
+		err := rows.Scan(
+			&row.ID,
+			&row.Email,
+			&row.Failed,
+			&row.Voided,
+			&row.Abandoned,
+			&row.Details,
+			&row.SubmittedAt,
+			&row.SessionID,
+			&row.UserCreatedAt,
+			&row.DisplayHandle,
+		)
+		if err != nil {
+			return err
+		}
+		if err := fn(row); err != nil {
+			return err
+		}
+	}
+	return rows.Err()
+}
+
+// nullTime returns nil for the zero time, so it is passed as NULL
+func nullTime(t time.Time) interface{} {
+	if t.IsZero() {
+		return nil
+	}
+	return t
+}
@@ -0,0 +1,133 @@
+package database
+
+import (
+	"database/sql"
+	"errors"
+	"log"
+	"regexp"
+	"strings"
+	"time"
+
+	"github.com/jackc/pgx/v5/pgconn"
+)
+
+var (
+	// ErrInvalidHandle is returned for handles that are not 3 to 20 letters, digits, dashes or underscores.
+	ErrInvalidHandle = errors.New("handles are 3 to 20 letters, digits, dashes or underscores")
+
+	// ErrHandleTaken is returned when another user already has the handle, ignoring case.
+	ErrHandleTaken = errors.New("that handle is already taken")
+)
+
+var handlePattern = regexp.MustCompile(`^[A-Za-z0-9_-]{3,20}$`)
+
+// ValidateHandle checks the handle can be shown on the leaderboard.
+func ValidateHandle(handle string) error {
+	if !handlePattern.MatchString(handle) {
+		return ErrInvalidHandle
+	}
+	return nil
+}
+
+// LeaderboardEntry is the fastest win of a user who opted into the leaderboard.
+type LeaderboardEntry struct {
+	Rank        int           `json:"rank"`
+	Handle      string        `json:"handle"`
+	Duration    time.Duration `json:"-"`
+	DurationMs  int64         `json:"duration_ms"`
+	CompletedAt time.Time     `json:"completed_at"`
+}
+
+// NewLeaderboardEntry returns an entry for a win that took the given time.
+func NewLeaderboardEntry(rank int, handle string, took time.Duration, completedAt time.Time) LeaderboardEntry {
+	return LeaderboardEntry{
+		Rank:        rank,
+		Handle:      handle,
+		Duration:    took,
+		DurationMs:  took.Milliseconds(),
+		CompletedAt: completedAt,
+	}
+}
+
+// Leaderboard returns the fastest wins, one per user with a display handle, fastest first.
+// Voided wins don't count. A limit of zero or less returns every entry.
+func (db *DB) Leaderboard(limit int) ([]LeaderboardEntry, error) {
+	query := `
+		SELECT handle, took, submitted_at
+		FROM (
+			SELECT DISTINCT ON (u.id) u.display_handle AS handle, (a.details->>'time')::BIGINT AS took, a.submitted_at
+			FROM attempts a
+			JOIN users u ON a.user_id = u.id
+			WHERE a.failed = FALSE
+			  AND a.voided = FALSE
+			  AND u.display_handle IS NOT NULL
+			  AND a.details ? 'time'
+			ORDER BY u.id, took, a.submitted_at
+		) best
+		ORDER BY took, submitted_at`
+	var args []interface{}
+	if limit > 0 {
+		query += " LIMIT $1"
+		args = append(args, limit)
+	}
+
+	rows, err := db.pool.QueryContext(db.ctx, query, args...)
+	if err != nil {
+		log.Printf("Error loading the leaderboard: %v\n", err)
+		return nil, err
+	}
+	defer rows.Close()
+
+	entries := []LeaderboardEntry{}
+	for rows.Next() {
+		var handle string
+		var took int64
+		var completedAt time.Time
+		if err := rows.Scan(&handle, &took, &completedAt); err != nil {
+			return nil, err
+		}
+		entries = append(entries, NewLeaderboardEntry(len(entries)+1, handle, time.Duration(took), completedAt))
+	}
+	return entries, rows.Err()
+}
+
+// DisplayHandle returns the leaderboard handle of the email, empty if they have none.
+func (db *DB) DisplayHandle(email string) (string, error) {
+	var handle string
+	query := "SELECT COALESCE(display_handle, '') FROM users WHERE email = $1"
+	err := db.pool.QueryRowContext(db.ctx, query, strings.ToLower(email)).Scan(&handle)
+	if err == sql.ErrNoRows {
+		return "", nil
+	}
+	return handle, err
+}
+
+// SetDisplayHandle sets the leaderboard handle of the email, an empty handle takes them off the leaderboard.
+// It returns ErrInvalidHandle, ErrHandleTaken, or sql.ErrNoRows if there is no such user.
+func (db *DB) SetDisplayHandle(email string, handle string) error {
+	if handle != "" {
+		if err := ValidateHandle(handle); err != nil {
+			return err
+		}
+	}
+
+	query := "UPDATE users SET display_handle = NULLIF($2, '') WHERE email = $1"
+	result, err := db.pool.ExecContext(db.ctx, query, strings.ToLower(email), handle)
+	if err != nil {
+		var pgErr *pgconn.PgError
+		if errors.As(err, &pgErr) && pgErr.Code == "23505" {
+			return ErrHandleTaken
+		}
+		log.Printf("Error setting the display handle of %s: %v\n", email, err)
+		return err
+	}
+	affected, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if affected == 0 {
+		return sql.ErrNoRows
+	}
+	log.Printf("Set the display handle of %s to %q\n", email, handle)
+	return nil
+}
@@ -0,0 +1,98 @@
+package database
+
+import (
+	"database/sql"
+	"testing"
+	"time"
+)
+
+func TestLeaderboard(t *testing.T) {
+	db := newTestDB(t)
+	win := func(email string, took time.Duration) int {
+		return createAttempt(t, db, email, false, map[string]interface{}{"time": took})
+	}
+	win("ada@example.com", 20*time.Minute)
+	fastest := win("ada@example.com", 15*time.Minute)
+	win("grace@example.com", 18*time.Minute)
+	voided := win("grace@example.com", 5*time.Minute)
+	if err := db.VoidAttempt(voided); err != nil {
+		t.Fatal(err)
+	}
+	createAttempt(t, db, "grace@example.com", true, map[string]interface{}{"time": time.Minute})
+	win("linus@example.com", 10*time.Minute) // no handle, so not listed
+
+	if err := db.SetDisplayHandle("ADA@example.com", "ada"); err != nil {
+		t.Fatal(err)
+	}
+	if err := db.SetDisplayHandle("grace@example.com", "amazing_grace"); err != nil {
+		t.Fatal(err)
+	}
+
+	entries, err := db.Leaderboard(0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(entries) != 2 {
+		t.Fatalf("expected one entry per user with a handle, got %+v", entries)
+	}
+	want := []struct {
+		handle string
+		took   time.Duration
+	}{{"ada", 15 * time.Minute}, {"amazing_grace", 18 * time.Minute}}
+	for i, w := range want {
+		if entries[i].Rank != i+1 || entries[i].Handle != w.handle || entries[i].Duration != w.took {
+			t.Errorf("entry %d = %+v, want %s in %s", i, entries[i], w.handle, w.took)
+		}
+	}
+	var completedAt time.Time
+	if err := db.pool.QueryRowContext(db.ctx, "SELECT submitted_at FROM attempts WHERE id = $1", fastest).Scan(&completedAt); err != nil {
+		t.Fatal(err)
+	}
+	if !entries[0].CompletedAt.Equal(completedAt) {
+		t.Errorf("expected the fastest win to be shown, completed at %v, got %v", completedAt, entries[0].CompletedAt)
+	}
+
+	if entries, err := db.Leaderboard(1); err != nil || len(entries) != 1 {
+		t.Errorf("expected the limit to apply, got %+v %v", entries, err)
+	}
+
+	// Clearing the handle takes the user off the leaderboard
+	if err := db.SetDisplayHandle("ada@example.com", ""); err != nil {
+		t.Fatal(err)
+	}
+	if entries, err := db.Leaderboard(0); err != nil || len(entries) != 1 || entries[0].Handle != "amazing_grace" {
+		t.Errorf("expected only grace to be left, got %+v %v", entries, err)
+	}
+}
+
+func TestDisplayHandle(t *testing.T) {
+	db := newTestDB(t)
+	createAttempt(t, db, "ada@example.com", false, nil)
+	createAttempt(t, db, "grace@example.com", false, nil)
+
+	if err := db.SetDisplayHandle("grace@example.com", "Amazing_Grace"); err != nil {
+		t.Fatal(err)
+	}
+	if handle, err := db.DisplayHandle("GRACE@example.com"); err != nil || handle != "Amazing_Grace" {
+		t.Errorf("DisplayHandle = %q %v, want Amazing_Grace", handle, err)
+	}
+	if handle, err := db.DisplayHandle("nobody@example.com"); err != nil || handle != "" {
+		t.Errorf("DisplayHandle of an unknown user = %q %v, want none", handle, err)
+	}
+
+	tests := []struct {
+		email   string
+		handle  string
+		wantErr error
+	}{
+		{email: "ada@example.com", handle: "amazing_grace", wantErr: ErrHandleTaken},
+		{email: "ada@example.com", handle: "a", wantErr: ErrInvalidHandle},
+		{email: "nobody@example.com", handle: "nobody", wantErr: sql.ErrNoRows},
+		{email: "grace@example.com", handle: "amazing_grace"},
+	}
+	for _, tt := range tests {
+		if err := db.SetDisplayHandle(tt.email, tt.handle); err != tt.wantErr {
+			t.Errorf("SetDisplayHandle(%s, %s) = %v, want %v", tt.email, tt.handle, err, tt.wantErr)
+		}
+	}
+}
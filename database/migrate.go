@@ -0,0 +1,243 @@
+package database
+
+import (
+	"database/sql"
+	"embed"
+	"fmt"
+	"io/fs"
+	"log"
+	"regexp"
+	"sort"
+	"strconv"
+	"time"
+)
+
+// migrationFiles holds the schema migrations, a NNNN_name.up.sql and NNNN_name.down.sql pair per version.
+//
+//go:embed migrations/*.sql
+var migrationFiles embed.FS
+
+// migrationLockID is the advisory lock held while migrating, so servers starting together don't race.
+const migrationLockID = 7_140_301
+
+var migrationFileName = regexp.MustCompile(`^(\d+)_(\w+)\.(up|down)\.sql$`)
+
+// Migration is a versioned change to the schema.
+type Migration struct {
+	Version int
+	Name    string
+	Up      string
+	Down    string
+}
+
+// MigrationStatus is a migration along with when it was applied, nil if it is pending.
+type MigrationStatus struct {
+	Migration
+	AppliedAt *time.Time
+}
+
+// Migrations returns the embedded migrations ordered by version.
+// Versions must start at 1 and have no gaps, and every migration needs both an up and a down file.
+func Migrations() ([]Migration, error) {
+	return loadMigrations(migrationFiles, "migrations")
+}
+
+func loadMigrations(fsys fs.FS, dir string) ([]Migration, error) {
+	entries, err := fs.ReadDir(fsys, dir)
+	if err != nil {
+		return nil, err
+	}
+
+	byVersion := map[int]*Migration{}
+	for _, entry := range entries {
+		match := migrationFileName.FindStringSubmatch(entry.Name())
+		if match == nil {
+			return nil, fmt.Errorf("invalid migration file name %q", entry.Name())
+		}
+		version, _ := strconv.Atoi(match[1])
+		contents, err := fs.ReadFile(fsys, dir+"/"+entry.Name())
+		if err != nil {
+			return nil, err
+		}
+
+		m, ok := byVersion[version]
+		if !ok {
+			m = &Migration{Version: version, Name: match[2]}
+			byVersion[version] = m
+		}
+		if m.Name != match[2] {
+			return nil, fmt.Errorf("migration %d is named both %q and %q", version, m.Name, match[2])
+		}
+		if match[3] == "up" {
+			m.Up = string(contents)
+		} else {
+			m.Down = string(contents)
+		}
+	}
+
+	migrations := make([]Migration, 0, len(byVersion))
+	for _, m := range byVersion {
+		migrations = append(migrations, *m)
+	}
+	sort.Slice(migrations, func(i, j int) bool {
+		return migrations[i].Version < migrations[j].Version
+	})
+	for i, m := range migrations {
+		if m.Version != i+1 {
+			return nil, fmt.Errorf("migration %d is missing", i+1)
+		}
+		if m.Up == "" || m.Down == "" {
+			return nil, fmt.Errorf("migration %d_%s needs both an up and a down file", m.Version, m.Name)
+		}
+	}
+	return migrations, nil
+}
+
+// MigrateUp applies every pending migration in order, each in its own transaction, and returns the ones applied.
+func (db *DB) MigrateUp() ([]Migration, error) {
+	var applied []Migration
+	err := db.withMigrationLock(func(conn *sql.Conn) error {
+		statuses, err := db.migrationStatus(conn)
+		if err != nil {
+			return err
+		}
+		for _, status := range statuses {
+			if status.AppliedAt != nil {
+				continue
+			}
+			m := status.Migration
+			err := db.runMigration(conn, m.Up, "INSERT INTO schema_migrations (version, name) VALUES ($1, $2)", m.Version, m.Name)
+			if err != nil {
+				return fmt.Errorf("applying migration %d_%s: %w", m.Version, m.Name, err)
+			}
+			log.Printf("Applied migration %d_%s\n", m.Version, m.Name)
+			applied = append(applied, m)
+		}
+		return nil
+	})
+	return applied, err
+}
+
+// MigrateDown reverts the latest applied migration and returns it, or nil if none is applied.
+func (db *DB) MigrateDown() (*Migration, error) {
+	var reverted *Migration
+	err := db.withMigrationLock(func(conn *sql.Conn) error {
+		statuses, err := db.migrationStatus(conn)
+		if err != nil {
+			return err
+		}
+		for i := len(statuses) - 1; i >= 0; i-- {
+			if statuses[i].AppliedAt == nil {
+				continue
+			}
+			m := statuses[i].Migration
+			err := db.runMigration(conn, m.Down, "DELETE FROM schema_migrations WHERE version = $1", m.Version)
+			if err != nil {
+				return fmt.Errorf("reverting migration %d_%s: %w", m.Version, m.Name, err)
+			}
+			log.Printf("Reverted migration %d_%s\n", m.Version, m.Name)
+			reverted = &m
+			return nil
+		}
+		return nil
+	})
+	return reverted, err
+}
+
+// MigrationStatus returns every migration and whether it was applied.
+func (db *DB) MigrationStatus() ([]MigrationStatus, error) {
+	var statuses []MigrationStatus
+	err := db.withMigrationLock(func(conn *sql.Conn) error {
+		var err error
+		statuses, err = db.migrationStatus(conn)
+		return err
+	})
+	return statuses, err
+}
+
+// withMigrationLock runs fn on a single connection holding the migration lock,
+// creating the schema_migrations table first if needed
+func (db *DB) withMigrationLock(fn func(conn *sql.Conn) error) error {
+	conn, err := db.pool.Conn(db.ctx)
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+
+	if _, err := conn.ExecContext(db.ctx, "SELECT pg_advisory_lock($1)", migrationLockID); err != nil {
+		return fmt.Errorf("taking the migration lock: %w", err)
+	}
+	defer func() {
+		if _, err := conn.ExecContext(db.ctx, "SELECT pg_advisory_unlock($1)", migrationLockID); err != nil {
+			log.Printf("Error releasing the migration lock: %v\n", err)
+		}
+	}()
+
+	migrationsTableSQL := `
+	CREATE TABLE IF NOT EXISTS schema_migrations (
+		version INTEGER PRIMARY KEY,
+		name TEXT NOT NULL,
+		applied_at TIMESTAMPTZ NOT NULL DEFAULT NOW()
+	);`
+	if _, err := conn.ExecContext(db.ctx, migrationsTableSQL); err != nil {
+		log.Printf("Error creating schema migrations table: %v\n", err)
+		return err
+	}
+	return fn(conn)
+}
+
+// migrationStatus matches the embedded migrations with the applied ones
+func (db *DB) migrationStatus(conn *sql.Conn) ([]MigrationStatus, error) {
+	migrations, err := Migrations()
+	if err != nil {
+		return nil, err
+	}
+
+	rows, err := conn.QueryContext(db.ctx, "SELECT version, applied_at FROM schema_migrations")
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	applied := map[int]time.Time{}
+	for rows.Next() {
+		var version int
+		var at time.Time
+		if err := rows.Scan(&version, &at); err != nil {
+			return nil, err
+		}
+		applied[version] = at
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	statuses := make([]MigrationStatus, len(migrations))
+	for i, m := range migrations {
+		statuses[i].Migration = m
+		if at, ok := applied[m.Version]; ok {
+			statuses[i].AppliedAt = &at
+		}
+		delete(applied, m.Version)
+	}
+	for version := range applied {
+		return nil, fmt.Errorf("database has migration %d applied, which this build doesn't know about", version)
+	}
+	return statuses, nil
+}
+
+// runMigration runs the migration SQL and records it in schema_migrations in a single transaction
+func (db *DB) runMigration(conn *sql.Conn, script string, record string, args ...interface{}) error {
+	tx, err := conn.BeginTx(db.ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	if _, err := tx.ExecContext(db.ctx, script); err != nil {
+		return err
+	}
+	if _, err := tx.ExecContext(db.ctx, record, args...); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
@@ -0,0 +1,104 @@
+package database
+
+import (
+	"strings"
+	"testing"
+	"testing/fstest"
+)
+
+func TestMigrations(t *testing.T) {
+	migrations, err := Migrations()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(migrations) == 0 || migrations[0].Name != "users_and_attempts" {
+		t.Fatalf("expected the users and attempts tables to be migration 1, got %+v", migrations)
+	}
+	for _, m := range migrations {
+		if !strings.Contains(m.Up, "IF NOT EXISTS") {
+			t.Errorf("migration %d_%s must be idempotent, databases created before migrations already have it", m.Version, m.Name)
+		}
+	}
+}
+
+func TestLoadMigrationsErrors(t *testing.T) {
+	file := func(contents string) *fstest.MapFile {
+		return &fstest.MapFile{Data: []byte(contents)}
+	}
+	tests := []struct {
+		name    string
+		files   fstest.MapFS
+		wantErr string
+	}{
+		{
+			name: "gap",
+			files: fstest.MapFS{
+				"m/0001_a.up.sql": file("up"), "m/0001_a.down.sql": file("down"),
+				"m/0003_c.up.sql": file("up"), "m/0003_c.down.sql": file("down"),
+			},
+			wantErr: "migration 2 is missing",
+		},
+		{
+			name:    "missing down",
+			files:   fstest.MapFS{"m/0001_a.up.sql": file("up")},
+			wantErr: "migration 1_a needs both an up and a down file",
+		},
+		{
+			name:    "bad name",
+			files:   fstest.MapFS{"m/first.sql": file("up")},
+			wantErr: `invalid migration file name "first.sql"`,
+		},
+		{
+			name:    "name mismatch",
+			files:   fstest.MapFS{"m/0001_a.up.sql": file("up"), "m/0001_b.down.sql": file("down")},
+			wantErr: `migration 1 is named both "a" and "b"`,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := loadMigrations(tt.files, "m")
+			if err == nil || err.Error() != tt.wantErr {
+				t.Fatalf("expected error %q, got %v", tt.wantErr, err)
+			}
+		})
+	}
+}
+
+func TestMigrateDownAndUp(t *testing.T) {
+	db := newTestDB(t)
+	migrations, err := Migrations()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for i := len(migrations) - 1; i >= 0; i-- {
+		reverted, err := db.MigrateDown()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if reverted == nil || reverted.Version != migrations[i].Version {
+			t.Fatalf("expected migration %d to be reverted, got %+v", migrations[i].Version, reverted)
+		}
+	}
+	if reverted, err := db.MigrateDown(); err != nil || reverted != nil {
+		t.Fatalf("expected nothing left to revert, got %+v %v", reverted, err)
+	}
+
+	applied, err := db.MigrateUp()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(applied) != len(migrations) {
+		t.Errorf("expected every migration to be applied again, got %d of %d", len(applied), len(migrations))
+	}
+	statuses, err := db.MigrationStatus()
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, status := range statuses {
+		if status.AppliedAt == nil {
+			t.Errorf("migration %d_%s is pending", status.Version, status.Name)
+		}
+	}
+}
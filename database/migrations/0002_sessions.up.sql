@@ -0,0 +1,12 @@
+CREATE TABLE IF NOT EXISTS sessions (
+	id UUID PRIMARY KEY,
+	user_id INTEGER NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+	started_at TIMESTAMPTZ NOT NULL,
+	current_step INTEGER NOT NULL DEFAULT 0,
+	state JSONB,
+	finished BOOLEAN DEFAULT FALSE,
+	updated_at TIMESTAMPTZ DEFAULT NOW()
+);
+
+-- Sessions created before seeding existed have no seed
+ALTER TABLE sessions ADD COLUMN IF NOT EXISTS seed BIGINT;
@@ -0,0 +1,2 @@
+-- Voided attempts are kept for the record but ignored by every check
+ALTER TABLE attempts ADD COLUMN IF NOT EXISTS voided BOOLEAN NOT NULL DEFAULT FALSE;
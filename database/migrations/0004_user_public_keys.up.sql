@@ -0,0 +1,2 @@
+-- Users are bound to the SSH key they first play with
+ALTER TABLE users ADD COLUMN IF NOT EXISTS public_key_fingerprint TEXT;
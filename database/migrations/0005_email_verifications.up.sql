@@ -0,0 +1,12 @@
+-- The codes sent to check candidates own their email
+CREATE TABLE IF NOT EXISTS email_verifications (
+	id SERIAL PRIMARY KEY,
+	email TEXT NOT NULL,
+	code_hash TEXT NOT NULL,
+	attempts INTEGER NOT NULL DEFAULT 0,
+	expires_at TIMESTAMPTZ NOT NULL,
+	verified_at TIMESTAMPTZ,
+	created_at TIMESTAMPTZ DEFAULT NOW()
+);
+
+CREATE INDEX IF NOT EXISTS email_verifications_email_idx ON email_verifications (email);
@@ -0,0 +1,2 @@
+ALTER TABLE attempts DROP COLUMN IF EXISTS abandoned;
+ALTER TABLE attempts DROP COLUMN IF EXISTS session_id;
@@ -0,0 +1,3 @@
+-- Attempts are tied to their session, and sessions the candidate walked away from are recorded as abandoned
+ALTER TABLE attempts ADD COLUMN IF NOT EXISTS session_id UUID UNIQUE;
+ALTER TABLE attempts ADD COLUMN IF NOT EXISTS abandoned BOOLEAN NOT NULL DEFAULT FALSE;
@@ -0,0 +1,13 @@
+-- What candidates did on each step and when
+CREATE TABLE IF NOT EXISTS step_events (
+	id BIGSERIAL PRIMARY KEY,
+	session_id UUID NOT NULL,
+	step INTEGER NOT NULL,
+	step_type TEXT NOT NULL,
+	kind TEXT NOT NULL,
+	duration_ms BIGINT NOT NULL,
+	payload JSONB,
+	created_at TIMESTAMPTZ NOT NULL
+);
+
+CREATE INDEX IF NOT EXISTS step_events_session_idx ON step_events (session_id, created_at);
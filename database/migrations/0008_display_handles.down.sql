@@ -0,0 +1,2 @@
+DROP INDEX IF EXISTS users_display_handle_idx;
+ALTER TABLE users DROP COLUMN IF EXISTS display_handle;
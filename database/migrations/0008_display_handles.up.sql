@@ -0,0 +1,4 @@
+-- Winners opt into the leaderboard with a public handle, so their email is never shown
+ALTER TABLE users ADD COLUMN IF NOT EXISTS display_handle TEXT;
+
+CREATE UNIQUE INDEX IF NOT EXISTS users_display_handle_idx ON users (LOWER(display_handle));
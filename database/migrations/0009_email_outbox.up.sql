@@ -0,0 +1,16 @@
+-- Emails waiting to be delivered by the outbox worker, retried with backoff until sent or dead
+CREATE TABLE IF NOT EXISTS email_outbox (
+	id SERIAL PRIMARY KEY,
+	idempotency_key TEXT NOT NULL UNIQUE,
+	kind TEXT NOT NULL,
+	recipient TEXT NOT NULL,
+	payload JSONB NOT NULL DEFAULT '{}'::jsonb,
+	status TEXT NOT NULL DEFAULT 'pending',
+	attempts INTEGER NOT NULL DEFAULT 0,
+	last_error TEXT,
+	next_attempt_at TIMESTAMPTZ NOT NULL DEFAULT NOW(),
+	created_at TIMESTAMPTZ NOT NULL DEFAULT NOW(),
+	sent_at TIMESTAMPTZ
+);
+
+CREATE INDEX IF NOT EXISTS email_outbox_due_idx ON email_outbox (next_attempt_at) WHERE status = 'pending';
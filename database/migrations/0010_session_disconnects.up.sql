@@ -0,0 +1,2 @@
+-- Sessions are only resumed once their connection is gone, so a live session can't be joined from a second terminal
+ALTER TABLE sessions ADD COLUMN IF NOT EXISTS disconnected_at TIMESTAMPTZ;
@@ -0,0 +1,2 @@
+DROP INDEX IF EXISTS email_verifications_email_created_at_idx;
+ALTER TABLE email_verifications DROP COLUMN IF EXISTS superseded_at;
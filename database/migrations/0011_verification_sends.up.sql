@@ -0,0 +1,3 @@
+-- Resent codes supersede the pending one instead of deleting it, so every code sent to an email counts towards the send limit
+ALTER TABLE email_verifications ADD COLUMN IF NOT EXISTS superseded_at TIMESTAMPTZ;
+CREATE INDEX IF NOT EXISTS email_verifications_email_created_at_idx ON email_verifications (email, created_at);
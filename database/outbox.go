@@ -0,0 +1,190 @@
+package database
+
+import (
+	"database/sql"
+	"encoding/json"
+	"log"
+	"time"
+)
+
+// OutboxStatus is where an outbox email is in its delivery.
+type OutboxStatus string
+
+const (
+	// OutboxPending emails are waiting for their next delivery attempt.
+	OutboxPending OutboxStatus = "pending"
+
+	// OutboxSent emails were delivered.
+	OutboxSent OutboxStatus = "sent"
+
+	// OutboxDead emails ran out of attempts, or can't be delivered at all, until an admin resends them.
+	OutboxDead OutboxStatus = "dead"
+)
+
+// OutboxEmail is an email in the outbox. The worker renders it from its kind and payload.
+type OutboxEmail struct {
+	ID             int             `json:"id"`
+	IdempotencyKey string          `json:"idempotency_key"`
+	Kind           string          `json:"kind"`
+	Recipient      string          `json:"recipient"`
+	Payload        json.RawMessage `json:"payload"`
+	Status         OutboxStatus    `json:"status"`
+	Attempts       int             `json:"attempts"`
+	LastError      string          `json:"last_error"`
+	NextAttemptAt  time.Time       `json:"next_attempt_at"`
+	CreatedAt      time.Time       `json:"created_at"`
+	SentAt         *time.Time      `json:"sent_at"`
+}
+
+// outboxColumns are the columns scanned by scanOutboxEmail
+const outboxColumns = `id, idempotency_key, kind, recipient, payload, status, attempts, COALESCE(last_error, ''),
+	next_attempt_at, created_at, sent_at`
+
+// scanOutboxEmail scans a row selected with outboxColumns.
+func scanOutboxEmail(row rowScanner) (*OutboxEmail, error) {
+	var e OutboxEmail
+	var sentAt sql.NullTime
+	err := row.Scan(&e.ID, &e.IdempotencyKey, &e.Kind, &e.Recipient, &e.Payload, &e.Status, &e.Attempts, &e.LastError,
+		&e.NextAttemptAt, &e.CreatedAt, &sentAt)
+	if err != nil {
+		return nil, err
+	}
+	if sentAt.Valid {
+		e.SentAt = &sentAt.Time
+	}
+	return &e, nil
+}
+
+// EnqueueEmail adds an email to the outbox, to be delivered right away. The idempotency key identifies it,
+// such as the session it is about, so enqueueing it again does nothing. It reports whether it was added.
+func (db *DB) EnqueueEmail(key string, kind string, recipient string, payload interface{}) (bool, error) {
+	query := `
+		INSERT INTO email_outbox (idempotency_key, kind, recipient, payload)
+		VALUES ($1, $2, $3, $4)
+		ON CONFLICT (idempotency_key) DO NOTHING`
+	data, err := json.Marshal(payload)
+	if err != nil {
+		return false, err
+	}
+	result, err := db.pool.ExecContext(db.ctx, query, key, kind, recipient, string(data))
+	if err != nil {
+		log.Printf("Error enqueueing %s email to %s: %v\n", kind, recipient, err)
+		return false, err
+	}
+	affected, err := result.RowsAffected()
+	return affected == 1, err
+}
+
+// ClaimEmails returns up to limit pending emails that are due, counting a delivery attempt for each.
+// They are leased: no other worker claims them until the lease runs out, after which an email whose
+// worker died mid-delivery is claimed again.
+func (db *DB) ClaimEmails(limit int, lease time.Duration) ([]OutboxEmail, error) {
+	query := `
+		UPDATE email_outbox
+		SET attempts = attempts + 1,
+		    next_attempt_at = NOW() + make_interval(secs => $2)
+		WHERE id IN (
+			SELECT id FROM email_outbox
+			WHERE status = 'pending' AND next_attempt_at <= NOW()
+			ORDER BY next_attempt_at, id
+			LIMIT $1
+			FOR UPDATE SKIP LOCKED
+		)
+		RETURNING ` + outboxColumns
+
+	rows, err := db.pool.QueryContext(db.ctx, query, limit, lease.Seconds())
+	if err != nil {
+		log.Printf("Error claiming outbox emails: %v\n", err)
+		return nil, err
+	}
+	defer rows.Close()
+
+	emails := []OutboxEmail{}
+	for rows.Next() {
+		e, err := scanOutboxEmail(rows)
+		if err != nil {
+			return nil, err
+		}
+		emails = append(emails, *e)
+	}
+	return emails, rows.Err()
+}
+
+// MarkEmailSent records that the email was delivered.
+func (db *DB) MarkEmailSent(id int) error {
+	query := "UPDATE email_outbox SET status = 'sent', sent_at = NOW(), last_error = NULL WHERE id = $1"
+	_, err := db.pool.ExecContext(db.ctx, query, id)
+	return err
+}
+
+// RetryEmail records why a delivery failed and schedules the next attempt after the given wait.
+func (db *DB) RetryEmail(id int, reason string, wait time.Duration) error {
+	query := `
+		UPDATE email_outbox
+		SET last_error = $2, next_attempt_at = NOW() + make_interval(secs => $3)
+		WHERE id = $1`
+	_, err := db.pool.ExecContext(db.ctx, query, id, reason, wait.Seconds())
+	return err
+}
+
+// DeadLetterEmail records why the email can't be delivered and stops retrying it.
+func (db *DB) DeadLetterEmail(id int, reason string) error {
+	query := "UPDATE email_outbox SET status = 'dead', last_error = $2 WHERE id = $1"
+	_, err := db.pool.ExecContext(db.ctx, query, id, reason)
+	return err
+}
+
+// ListOutbox returns the latest outbox emails first, optionally only those with the given status.
+// A limit of zero or less returns every email.
+func (db *DB) ListOutbox(status OutboxStatus, limit int) ([]OutboxEmail, error) {
+	query := `
+		SELECT ` + outboxColumns + `
+		FROM email_outbox
+		WHERE ($1 = '' OR status = $1)
+		ORDER BY created_at DESC, id DESC`
+	args := []interface{}{string(status)}
+	if limit > 0 {
+		query += " LIMIT $2"
+		args = append(args, limit)
+	}
+
+	rows, err := db.pool.QueryContext(db.ctx, query, args...)
+	if err != nil {
+		log.Printf("Error listing outbox emails: %v\n", err)
+		return nil, err
+	}
+	defer rows.Close()
+
+	emails := []OutboxEmail{}
+	for rows.Next() {
+		e, err := scanOutboxEmail(rows)
+		if err != nil {
+			return nil, err
+		}
+		emails = append(emails, *e)
+	}
+	return emails, rows.Err()
+}
+
+// ResendEmail puts an email back in the outbox to be delivered right away with a fresh set of attempts,
+// whatever its status. It returns sql.ErrNoRows if there is no such email.
+func (db *DB) ResendEmail(id int) error {
+	query := `
+		UPDATE email_outbox
+		SET status = 'pending', attempts = 0, next_attempt_at = NOW(), sent_at = NULL
+		WHERE id = $1`
+	result, err := db.pool.ExecContext(db.ctx, query, id)
+	if err != nil {
+		log.Printf("Error requeueing outbox email %d: %v\n", id, err)
+		return err
+	}
+	affected, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if affected == 0 {
+		return sql.ErrNoRows
+	}
+	log.Printf("Requeued outbox email %d\n", id)
+	return nil
+}
@@ -0,0 +1,95 @@
+package database
+
+import (
+	"database/sql"
+	"encoding/json"
+	"testing"
+	"time"
+)
+
+func TestOutbox(t *testing.T) {
+	db := newTestDB(t)
+	enqueue := func(key string, to string) bool {
+		t.Helper()
+		added, err := db.EnqueueEmail(key, "end", to, map[string]string{"token": "jwt"})
+		if err != nil {
+			t.Fatal(err)
+		}
+		return added
+	}
+	claim := func(limit int) []OutboxEmail {
+		t.Helper()
+		emails, err := db.ClaimEmails(limit, time.Minute)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return emails
+	}
+
+	if !enqueue("end:1", "ada@example.com") || enqueue("end:1", "ada@example.com") || !enqueue("end:2", "grace@example.com") {
+		t.Fatal("expected each key to be enqueued once")
+	}
+
+	// Claimed emails are leased, so they aren't claimed again until the lease runs out
+	first := claim(1)
+	if len(first) != 1 || first[0].IdempotencyKey != "end:1" || first[0].Attempts != 1 || first[0].Status != OutboxPending {
+		t.Fatalf("expected the oldest email to be claimed, got %+v", first)
+	}
+	var payload map[string]string
+	if err := json.Unmarshal(first[0].Payload, &payload); err != nil || payload["token"] != "jwt" {
+		t.Errorf("expected the payload to be kept, got %s (%v)", first[0].Payload, err)
+	}
+	second := claim(10)
+	if len(second) != 1 || second[0].IdempotencyKey != "end:2" {
+		t.Fatalf("expected only the unleased email to be claimed, got %+v", second)
+	}
+	if emails := claim(10); len(emails) != 0 {
+		t.Fatalf("expected leased emails not to be claimed, got %+v", emails)
+	}
+
+	if err := db.MarkEmailSent(first[0].ID); err != nil {
+		t.Fatal(err)
+	}
+	if err := db.RetryEmail(second[0].ID, "resend is down", 0); err != nil {
+		t.Fatal(err)
+	}
+	retried := claim(10)
+	if len(retried) != 1 || retried[0].ID != second[0].ID || retried[0].Attempts != 2 || retried[0].LastError != "resend is down" {
+		t.Fatalf("expected the failed email to be retried, got %+v", retried)
+	}
+	if err := db.DeadLetterEmail(second[0].ID, "invalid payload"); err != nil {
+		t.Fatal(err)
+	}
+
+	list := func(status OutboxStatus, limit int) []OutboxEmail {
+		t.Helper()
+		emails, err := db.ListOutbox(status, limit)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return emails
+	}
+	if sent := list(OutboxSent, 0); len(sent) != 1 || sent[0].ID != first[0].ID || sent[0].SentAt == nil || sent[0].LastError != "" {
+		t.Errorf("expected the first email to be sent, got %+v", sent)
+	}
+	if dead := list(OutboxDead, 0); len(dead) != 1 || dead[0].ID != second[0].ID || dead[0].LastError != "invalid payload" {
+		t.Errorf("expected the second email to be dead, got %+v", dead)
+	}
+	if latest := list("", 1); len(latest) != 1 || latest[0].ID != second[0].ID {
+		t.Errorf("expected the latest email first, got %+v", latest)
+	}
+
+	// Resending starts over, whatever the status
+	for _, e := range []OutboxEmail{first[0], second[0]} {
+		if err := db.ResendEmail(e.ID); err != nil {
+			t.Fatal(err)
+		}
+	}
+	resent := claim(10)
+	if len(resent) != 2 || resent[0].Attempts != 1 || resent[1].Attempts != 1 || resent[0].SentAt != nil {
+		t.Errorf("expected both emails to be delivered again, got %+v", resent)
+	}
+	if err := db.ResendEmail(999); err != sql.ErrNoRows {
+		t.Errorf("expected sql.ErrNoRows for an unknown email, got %v", err)
+	}
+}
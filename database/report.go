@@ -0,0 +1,102 @@
+package database
+
+import (
+	"fmt"
+	"log"
+	"time"
+)
+
+// FunnelPeriod is how the funnel report groups attempts over time.
+type FunnelPeriod string
+
+const (
+	// FunnelByDay groups attempts by the UTC day they were submitted.
+	FunnelByDay FunnelPeriod = "day"
+
+	// FunnelByWeek groups attempts by the UTC week they were submitted, weeks start on Monday.
+	FunnelByWeek FunnelPeriod = "week"
+)
+
+// ParseFunnelPeriod parses "day" or "week".
+func ParseFunnelPeriod(s string) (FunnelPeriod, error) {
+	switch FunnelPeriod(s) {
+	case FunnelByDay, FunnelByWeek:
+		return FunnelPeriod(s), nil
+	}
+	return "", fmt.Errorf("invalid period %q, use day or week", s)
+}
+
+// Truncate returns the start of the period t falls in, in UTC.
+func (p FunnelPeriod) Truncate(t time.Time) time.Time {
+	t = t.UTC()
+	day := time.Date(t.Year(), t.Month(), t.Day(), 0, 0, 0, 0, time.UTC)
+	if p == FunnelByWeek {
+		return day.AddDate(0, 0, -(int(day.Weekday())+6)%7)
+	}
+	return day
+}
+
+// FunnelStep is how many attempts of a period reached and cleared a step.
+// Attempts clear a step by reaching the next one, winners clear every step up to the end.
+type FunnelStep struct {
+	Period   time.Time `json:"period"`
+	Step     int       `json:"step"`
+	StepType string    `json:"step_type"`
+	Reached  int       `json:"reached"`
+	Cleared  int       `json:"cleared"`
+}
+
+// DropOff returns the share of the attempts reaching the step that didn't clear it, from 0 to 1.
+func (f FunnelStep) DropOff() float64 {
+	if f.Reached == 0 {
+		return 0
+	}
+	return float64(f.Reached-f.Cleared) / float64(f.Reached)
+}
+
+// Funnel returns how many attempts reached and cleared each step, per period, using the step reached
+// recorded in the attempt details. Voided attempts are left out. Steps are labeled with their type from
+// the latest step event recorded for them, if any. A zero since covers every attempt.
+func (db *DB) Funnel(period FunnelPeriod, since time.Time) ([]FunnelStep, error) {
+	query := `
+		WITH runs AS (
+			SELECT date_trunc($1, a.submitted_at, 'UTC') AS period,
+			       (a.details->>'step')::INTEGER AS step,
+			       NOT a.failed AS won
+			FROM attempts a
+			WHERE NOT a.voided
+			  AND a.details ? 'step'
+			  AND a.submitted_at >= $2
+		),
+		labels AS (
+			SELECT DISTINCT ON (step) step, step_type
+			FROM step_events
+			ORDER BY step, created_at DESC, id DESC
+		)
+		SELECT r.period, s.step, COALESCE(l.step_type, ''),
+		       COUNT(*) FILTER (WHERE r.step >= s.step),
+		       COUNT(*) FILTER (WHERE r.step > s.step OR (r.won AND r.step = s.step))
+		FROM runs r
+		CROSS JOIN generate_series(0, (SELECT MAX(step) FROM runs)) AS s(step)
+		LEFT JOIN labels l ON l.step = s.step
+		GROUP BY r.period, s.step, l.step_type
+		ORDER BY r.period, s.step`
+
+	rows, err := db.pool.QueryContext(db.ctx, query, string(period), since)
+	if err != nil {
+		log.Printf("Error computing the funnel: %v\n", err)
+		return nil, err
+	}
+	defer rows.Close()
+
+	funnel := []FunnelStep{}
+	for rows.Next() {
+		var f FunnelStep
+		if err := rows.Scan(&f.Period, &f.Step, &f.StepType, &f.Reached, &f.Cleared); err != nil {
+			return nil, err
+		}
+		f.Period = f.Period.UTC()
+		funnel = append(funnel, f)
+	}
+	return funnel, rows.Err()
+}
@@ -0,0 +1,103 @@
+package database
+
+import (
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+func TestFunnel(t *testing.T) {
+	db := newTestDB(t)
+	monday := time.Date(2025, 3, 31, 12, 0, 0, 0, time.UTC)
+	attempt := func(email string, failed bool, step int, at time.Time) int {
+		t.Helper()
+		id := createAttempt(t, db, email, failed, map[string]interface{}{"step": step})
+		submittedAt(t, db, id, at)
+		return id
+	}
+	attempt("ada@example.com", true, 2, monday)
+	attempt("grace@example.com", false, 2, monday.Add(time.Hour))
+	attempt("linus@example.com", true, 0, monday.AddDate(0, 0, 1))
+	attempt("old@example.com", true, 1, monday.AddDate(0, 0, -30))
+	voided := attempt("ada@example.com", true, 0, monday.Add(2*time.Hour))
+	if err := db.VoidAttempt(voided); err != nil {
+		t.Fatal(err)
+	}
+
+	// Steps are labeled by their latest event, whatever order the events were stored in
+	record := func(step int, stepType string, at time.Time) {
+		t.Helper()
+		event := StepEvent{SessionID: uuid.NewString(), Step: step, StepType: stepType, Kind: EventEnter, At: at}
+		if err := db.RecordStepEvent(event); err != nil {
+			t.Fatal(err)
+		}
+	}
+	record(0, "anagram", monday.Add(time.Hour))
+	record(0, "wordle", monday)
+	record(1, "password", monday)
+
+	funnel := func(period FunnelPeriod, since time.Time) []FunnelStep {
+		t.Helper()
+		steps, err := db.Funnel(period, since)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return steps
+	}
+	step := func(period time.Time, step int, stepType string, reached int, cleared int) FunnelStep {
+		return FunnelStep{Period: period, Step: step, StepType: stepType, Reached: reached, Cleared: cleared}
+	}
+	day, nextDay := FunnelByDay.Truncate(monday), FunnelByDay.Truncate(monday.AddDate(0, 0, 1))
+	oldWeek := FunnelByWeek.Truncate(monday.AddDate(0, 0, -30))
+
+	tests := []struct {
+		name   string
+		period FunnelPeriod
+		since  time.Time
+		want   []FunnelStep
+	}{
+		{
+			name:   "by day",
+			period: FunnelByDay,
+			since:  day,
+			want: []FunnelStep{
+				step(day, 0, "anagram", 2, 2), step(day, 1, "password", 2, 2), step(day, 2, "", 2, 1),
+				step(nextDay, 0, "anagram", 1, 0), step(nextDay, 1, "password", 0, 0), step(nextDay, 2, "", 0, 0),
+			},
+		},
+		{
+			name:   "by week",
+			period: FunnelByWeek,
+			since:  day,
+			want:   []FunnelStep{step(day, 0, "anagram", 3, 2), step(day, 1, "password", 2, 2), step(day, 2, "", 2, 1)},
+		},
+		{
+			name:   "every attempt",
+			period: FunnelByWeek,
+			want: []FunnelStep{
+				step(oldWeek, 0, "anagram", 1, 1), step(oldWeek, 1, "password", 1, 0), step(oldWeek, 2, "", 0, 0),
+				step(day, 0, "anagram", 3, 2), step(day, 1, "password", 2, 2), step(day, 2, "", 2, 1),
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := funnel(tt.period, tt.since)
+			if len(got) != len(tt.want) {
+				t.Fatalf("Funnel = %+v, want %+v", got, tt.want)
+			}
+			for i := range tt.want {
+				if !got[i].Period.Equal(tt.want[i].Period) || got[i].Step != tt.want[i].Step || got[i].StepType != tt.want[i].StepType ||
+					got[i].Reached != tt.want[i].Reached || got[i].Cleared != tt.want[i].Cleared {
+					t.Errorf("Funnel[%d] = %+v, want %+v", i, got[i], tt.want[i])
+				}
+			}
+		})
+	}
+
+	if steps := funnel(FunnelByDay, monday.AddDate(0, 0, 7)); len(steps) != 0 {
+		t.Errorf("expected no steps without attempts, got %+v", steps)
+	}
+}
@@ -0,0 +1,127 @@
+package database
+
+import (
+	"database/sql"
+	"log"
+	"strings"
+	"time"
+)
+
+// Session is a challenge run whose progress is stored so it can be resumed after a disconnect.
+type Session struct {
+	ID          string
+	Email       string
+	StartedAt   time.Time
+	CurrentStep int
+	State       []byte
+	Seed        int64
+	Finished    bool
+	UpdatedAt   time.Time
+}
+
+// CreateSession stores a new unfinished session for the given email, with the seed its content was generated from.
+func (db *DB) CreateSession(id string, email string, startedAt time.Time, seed int64) error {
+	userId, err := db.getOrCreateUserId(email)
+	if err != nil {
+		return err
+	}
+	query := "INSERT INTO sessions (id, user_id, started_at, seed) VALUES ($1, $2, $3, $4)"
+	_, err = db.pool.ExecContext(db.ctx, query, id, userId, startedAt, seed)
+	if err != nil {
+		log.Printf("Error creating session %s for %s: %v\n", id, email, err)
+		return err
+	}
+	log.Printf("Created session %s for %s\n", id, email)
+	return nil
+}
+
+// SaveSession stores the latest progress of a session and refreshes its last seen time.
+func (db *DB) SaveSession(id string, currentStep int, state []byte) error {
+	query := `
+		UPDATE sessions
+		SET current_step = $2, state = $3, updated_at = NOW()
+		WHERE id = $1 AND finished = FALSE`
+	_, err := db.pool.ExecContext(db.ctx, query, id, currentStep, string(state))
+	return err
+}
+
+// FinishSession marks a session as finished so it can no longer be resumed.
+func (db *DB) FinishSession(id string) error {
+	query := "UPDATE sessions SET finished = TRUE, updated_at = NOW() WHERE id = $1"
+	_, err := db.pool.ExecContext(db.ctx, query, id)
+	return err
+}
+
+// sessionColumns are the columns scanned by scanSession
+const sessionColumns = "s.id, u.email, s.started_at, s.current_step, s.state, COALESCE(s.seed, 0), COALESCE(s.finished, FALSE), s.updated_at"
+
+func scanSession(row *sql.Row) (*Session, error) {
+	var session Session
+	err := row.Scan(
+		&session.ID,
+		&session.Email,
+		&session.StartedAt,
+		&session.CurrentStep,
+		&session.State,
+		&session.Seed,
+		&session.Finished,
+		&session.UpdatedAt,
+	)
+	if err != nil {
+		return nil, err
+	}
+	return &session, nil
+}
+
+// DisconnectSession marks an unfinished session whose connection closed, so it can be resumed.
+func (db *DB) DisconnectSession(id string) error {
+	query := "UPDATE sessions SET disconnected_at = NOW() WHERE id = $1 AND finished = FALSE"
+	_, err := db.pool.ExecContext(db.ctx, query, id)
+	return err
+}
+
+// ClaimResumableSession returns the latest unfinished session for the email that disconnected within
+// the grace window, or nil if there is none. The session is claimed by clearing its disconnect, so it
+// can't be resumed by a second connection until this one closes too.
+func (db *DB) ClaimResumableSession(email string, grace time.Duration) (*Session, error) {
+	// A concurrent claim of the same session waits for the row and then no longer matches disconnected_at
+	query := `
+		UPDATE sessions s
+		SET disconnected_at = NULL, updated_at = NOW()
+		FROM users u
+		WHERE s.user_id = u.id
+		  AND s.disconnected_at IS NOT NULL
+		  AND s.id = (
+			SELECT ls.id
+			FROM sessions ls
+			JOIN users lu ON ls.user_id = lu.id
+			WHERE lu.email = $1
+			  AND ls.finished = FALSE
+			  AND ls.state IS NOT NULL
+			  AND ls.disconnected_at > NOW() - make_interval(secs => $2)
+			ORDER BY ls.disconnected_at DESC
+			LIMIT 1
+		  )
+		RETURNING ` + sessionColumns
+
+	session, err := scanSession(db.pool.QueryRowContext(db.ctx, query, strings.ToLower(email), grace.Seconds()))
+	if err != nil {
+		if err == sql.ErrNoRows {
+			return nil, nil
+		}
+		log.Printf("Error claiming resumable session for %s: %v\n", email, err)
+		return nil, err
+	}
+	log.Printf("Claimed session %s for %s\n", session.ID, email)
+	return session, nil
+}
+
+// GetSession returns the session with the given id, finished or not.
+func (db *DB) GetSession(id string) (*Session, error) {
+	query := `
+		SELECT ` + sessionColumns + `
+		FROM sessions s
+		JOIN users u ON s.user_id = u.id
+		WHERE s.id = $1`
+	return scanSession(db.pool.QueryRowContext(db.ctx, query, id))
+}
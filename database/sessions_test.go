@@ -0,0 +1,125 @@
+package database
+
+import (
+	"database/sql"
+	"encoding/json"
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+func TestClaimResumableSession(t *testing.T) {
+	db := newTestDB(t)
+	id := uuid.NewString()
+	startedAt := time.Now().Add(-5 * time.Minute).Truncate(time.Microsecond)
+	if err := db.CreateSession(id, "Ada@example.com", startedAt, 42); err != nil {
+		t.Fatal(err)
+	}
+
+	claim := func(grace time.Duration) *Session {
+		t.Helper()
+		session, err := db.ClaimResumableSession("ada@example.com", grace)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return session
+	}
+
+	// Sessions without a checkpoint, or still being played, can't be resumed
+	if session := claim(time.Hour); session != nil {
+		t.Fatalf("session %s was resumable before its first checkpoint", session.ID)
+	}
+	if err := db.SaveSession(id, 1, []byte(`{"current_step":1}`)); err != nil {
+		t.Fatal(err)
+	}
+	if session := claim(time.Hour); session != nil {
+		t.Fatalf("live session %s was resumable", session.ID)
+	}
+
+	if err := db.DisconnectSession(id); err != nil {
+		t.Fatal(err)
+	}
+	session := claim(time.Hour)
+	if session == nil {
+		t.Fatal("expected the disconnected session to be resumable")
+	}
+	var state struct {
+		CurrentStep int `json:"current_step"`
+	}
+	if err := json.Unmarshal(session.State, &state); err != nil || state.CurrentStep != 1 {
+		t.Errorf("expected the checkpoint to be returned, got %s (%v)", session.State, err)
+	}
+	if session.ID != id || session.Email != "ada@example.com" || session.CurrentStep != 1 || session.Seed != 42 ||
+		!session.StartedAt.Equal(startedAt) || session.Finished {
+		t.Errorf("unexpected session %+v", session)
+	}
+
+	// A second connection can't claim it too
+	if session := claim(time.Hour); session != nil {
+		t.Fatalf("session %s was claimed twice", session.ID)
+	}
+
+	// Past the grace window it is gone
+	if err := db.DisconnectSession(id); err != nil {
+		t.Fatal(err)
+	}
+	exec(t, db, "UPDATE sessions SET disconnected_at = NOW() - INTERVAL '1 hour' WHERE id = $1", id)
+	if session := claim(10 * time.Minute); session != nil {
+		t.Fatalf("session %s was resumable past the grace window", session.ID)
+	}
+
+	// Finished sessions are never resumed
+	if err := db.FinishSession(id); err != nil {
+		t.Fatal(err)
+	}
+	if err := db.DisconnectSession(id); err != nil {
+		t.Fatal(err)
+	}
+	if session := claim(2 * time.Hour); session != nil {
+		t.Fatalf("finished session %s was resumable", session.ID)
+	}
+	if err := db.SaveSession(id, 2, []byte(`{}`)); err != nil {
+		t.Fatal(err)
+	}
+	finished, err := db.GetSession(id)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !finished.Finished || finished.CurrentStep != 1 {
+		t.Errorf("expected the finished session to keep its last checkpoint, got %+v", finished)
+	}
+}
+
+func TestClaimLatestSession(t *testing.T) {
+	db := newTestDB(t)
+	var ids []string
+	for i := 0; i < 2; i++ {
+		id := uuid.NewString()
+		if err := db.CreateSession(id, "ada@example.com", time.Now(), int64(i)); err != nil {
+			t.Fatal(err)
+		}
+		if err := db.SaveSession(id, 0, []byte(`{}`)); err != nil {
+			t.Fatal(err)
+		}
+		if err := db.DisconnectSession(id); err != nil {
+			t.Fatal(err)
+		}
+		ids = append(ids, id)
+	}
+
+	session, err := db.ClaimResumableSession("ada@example.com", time.Hour)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if session == nil || session.ID != ids[1] {
+		t.Errorf("expected the latest disconnected session %s, got %+v", ids[1], session)
+	}
+}
+
+func TestGetSessionMissing(t *testing.T) {
+	db := newTestDB(t)
+	if _, err := db.GetSession(uuid.NewString()); err != sql.ErrNoRows {
+		t.Errorf("expected sql.ErrNoRows, got %v", err)
+	}
+}
@@ -0,0 +1,29 @@
+package database
+
+import "time"
+
+// Store is the persistence the challenge TUI depends on.
+// DB implements it against Postgres and tests use an in-memory fake.
+type Store interface {
+	FailedAttemptTimes(email string, since time.Time) ([]time.Time, error)
+	HasUserWon(email string) (bool, error)
+	SaveAttempt(sessionID string, email string, outcome Outcome, details map[string]interface{}) (int, error)
+	BoundPublicKey(email string) (string, error)
+	BindPublicKey(email string, fingerprint string) (bool, error)
+	Leaderboard(limit int) ([]LeaderboardEntry, error)
+	DisplayHandle(email string) (string, error)
+	SetDisplayHandle(email string, handle string) error
+
+	CreateSession(id string, email string, startedAt time.Time, seed int64) error
+	SaveSession(id string, currentStep int, state []byte) error
+	FinishSession(id string) error
+	DisconnectSession(id string) error
+	ClaimResumableSession(email string, grace time.Duration) (*Session, error)
+	GetSession(id string) (*Session, error)
+
+	RecordStepEvent(event StepEvent) error
+
+	EnqueueEmail(key string, kind string, recipient string, payload interface{}) (bool, error)
+}
+
+var _ Store = (*DB)(nil)
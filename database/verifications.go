@@ -0,0 +1,131 @@
+package database
+
+import (
+	"crypto/subtle"
+	"database/sql"
+	"errors"
+	"log"
+	"strings"
+	"time"
+)
+
+// VerificationStatus is the outcome of checking an email verification code.
+type VerificationStatus int
+
+const (
+	// VerificationOK means the code matched and the email is verified
+	VerificationOK VerificationStatus = iota
+	// VerificationWrongCode means the code didn't match, but there are attempts left
+	VerificationWrongCode
+	// VerificationExpired means the pending code expired and a new one is needed
+	VerificationExpired
+	// VerificationLocked means the attempt limit was reached and a new code is needed
+	VerificationLocked
+	// VerificationMissing means no code is pending for the email
+	VerificationMissing
+)
+
+// ErrTooManyVerifications is returned when too many codes were sent to the email recently.
+var ErrTooManyVerifications = errors.New("too many verification codes sent")
+
+// CreateEmailVerification stores the hash of a code sent to the email, superseding any pending one.
+// It returns ErrTooManyVerifications when maxSends codes were already sent to the email since the given time.
+func (db *DB) CreateEmailVerification(email string, codeHash string, expiresAt time.Time, maxSends int, since time.Time) error {
+	tx, err := db.pool.BeginTx(db.ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	email = strings.ToLower(email)
+	// Sends to the same email wait for each other, so sessions racing each other can't all get under the limit
+	if _, err := tx.ExecContext(db.ctx, "SELECT pg_advisory_xact_lock(hashtext($1))", email); err != nil {
+		return err
+	}
+	var sent int
+	query := "SELECT COUNT(*) FROM email_verifications WHERE email = $1 AND created_at > $2"
+	if err := tx.QueryRowContext(db.ctx, query, email, since).Scan(&sent); err != nil {
+		log.Printf("Error counting verifications for %s: %v\n", email, err)
+		return err
+	}
+	if sent >= maxSends {
+		log.Printf("Refusing to send another verification code to %s, %d sent already\n", email, sent)
+		return ErrTooManyVerifications
+	}
+
+	query = "UPDATE email_verifications SET superseded_at = NOW() WHERE email = $1 AND verified_at IS NULL AND superseded_at IS NULL"
+	if _, err := tx.ExecContext(db.ctx, query, email); err != nil {
+		log.Printf("Error superseding pending verifications for %s: %v\n", email, err)
+		return err
+	}
+	query = "INSERT INTO email_verifications (email, code_hash, expires_at) VALUES ($1, $2, $3)"
+	_, err = tx.ExecContext(db.ctx, query, email, codeHash, expiresAt)
+	if err != nil {
+		log.Printf("Error creating verification for %s: %v\n", email, err)
+		return err
+	}
+	return tx.Commit()
+}
+
+// CheckEmailVerification compares the hash of a typed code with the pending one for the email.
+// Every wrong code counts towards maxAttempts, after which the pending code is locked.
+func (db *DB) CheckEmailVerification(email string, codeHash string, maxAttempts int) (VerificationStatus, error) {
+	tx, err := db.pool.BeginTx(db.ctx, nil)
+	if err != nil {
+		return VerificationMissing, err
+	}
+	defer tx.Rollback()
+
+	var (
+		id       int
+		hash     string
+		attempts int
+		expired  bool
+	)
+	query := `
+		SELECT id, code_hash, attempts, expires_at <= NOW()
+		FROM email_verifications
+		WHERE email = $1 AND verified_at IS NULL AND superseded_at IS NULL
+		ORDER BY created_at DESC, id DESC
+		LIMIT 1
+		FOR UPDATE`
+	err = tx.QueryRowContext(db.ctx, query, strings.ToLower(email)).Scan(&id, &hash, &attempts, &expired)
+	if err != nil {
+		if err == sql.ErrNoRows {
+			return VerificationMissing, nil
+		}
+		log.Printf("Error checking verification for %s: %v\n", email, err)
+		return VerificationMissing, err
+	}
+
+	switch {
+	case expired:
+		return VerificationExpired, nil
+	case attempts >= maxAttempts:
+		return VerificationLocked, nil
+	}
+
+	if subtle.ConstantTimeCompare([]byte(hash), []byte(codeHash)) != 1 {
+		_, err = tx.ExecContext(db.ctx, "UPDATE email_verifications SET attempts = attempts + 1 WHERE id = $1", id)
+		if err != nil {
+			return VerificationMissing, err
+		}
+		if err := tx.Commit(); err != nil {
+			return VerificationMissing, err
+		}
+		if attempts+1 >= maxAttempts {
+			return VerificationLocked, nil
+		}
+		return VerificationWrongCode, nil
+	}
+
+	_, err = tx.ExecContext(db.ctx, "UPDATE email_verifications SET verified_at = NOW() WHERE id = $1", id)
+	if err != nil {
+		return VerificationMissing, err
+	}
+	if err := tx.Commit(); err != nil {
+		return VerificationMissing, err
+	}
+	log.Printf("Verified email %s\n", email)
+	return VerificationOK, nil
+}
@@ -0,0 +1,78 @@
+package database
+
+import (
+	"errors"
+	"testing"
+	"time"
+)
+
+func TestEmailVerification(t *testing.T) {
+	db := newTestDB(t)
+	expiresAt, since := time.Now().Add(10*time.Minute), time.Now().Add(-time.Hour)
+	if err := db.CreateEmailVerification("Ada@example.com", "first", expiresAt, 5, since); err != nil {
+		t.Fatal(err)
+	}
+
+	check := func(hash string, want VerificationStatus) {
+		t.Helper()
+		status, err := db.CheckEmailVerification("ada@example.com", hash, 3)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if status != want {
+			t.Errorf("checking %q: got status %d, want %d", hash, status, want)
+		}
+	}
+
+	// Wrong codes count towards the limit, which locks the pending code
+	check("wrong", VerificationWrongCode)
+	check("wrong", VerificationWrongCode)
+	check("wrong", VerificationLocked)
+	check("first", VerificationLocked)
+
+	// A new code replaces the locked one, and verifies once
+	if err := db.CreateEmailVerification("ada@example.com", "second", expiresAt, 5, since); err != nil {
+		t.Fatal(err)
+	}
+	check("second", VerificationOK)
+	check("second", VerificationMissing)
+
+	if err := db.CreateEmailVerification("ada@example.com", "third", time.Now().Add(-time.Second), 5, since); err != nil {
+		t.Fatal(err)
+	}
+	check("third", VerificationExpired)
+}
+
+func TestEmailVerificationSendLimit(t *testing.T) {
+	db := newTestDB(t)
+	create := func(email string, hash string, since time.Time) error {
+		t.Helper()
+		return db.CreateEmailVerification(email, hash, time.Now().Add(10*time.Minute), 2, since)
+	}
+	since := time.Now().Add(-time.Hour)
+
+	for _, hash := range []string{"first", "second"} {
+		if err := create("ada@example.com", hash, since); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := create("ADA@example.com", "third", since); !errors.Is(err, ErrTooManyVerifications) {
+		t.Fatalf("expected ErrTooManyVerifications, got %v", err)
+	}
+	if err := create("grace@example.com", "first", since); err != nil {
+		t.Errorf("expected the limit to be per email, got %v", err)
+	}
+
+	// The refused code wasn't stored, and the superseded one can't be used
+	for hash, want := range map[string]VerificationStatus{"third": VerificationWrongCode, "first": VerificationWrongCode, "second": VerificationOK} {
+		if status, err := db.CheckEmailVerification("ada@example.com", hash, 5); err != nil || status != want {
+			t.Errorf("checking %q: got status %d %v, want %d", hash, status, err, want)
+		}
+	}
+
+	// Codes sent before the window don't count
+	exec(t, db, "UPDATE email_verifications SET created_at = NOW() - INTERVAL '2 hours'")
+	if err := create("ada@example.com", "fourth", since); err != nil {
+		t.Errorf("expected old codes not to count, got %v", err)
+	}
+}
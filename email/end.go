@@ -0,0 +1,25 @@
+package email
+
+import "fmt"
+
+// EndEmailKind is the outbox kind, and the template, of the email with the last challenge
+const EndEmailKind = "end"
+
+// EndEmail is the outbox payload of the email with the last challenge
+type EndEmail struct {
+	Token string `json:"token"`
+
+	// Campaign is the challenge pack the winner played, which can override the templates
+	Campaign string `json:"campaign,omitempty"`
+}
+
+// SendEndEmail sends the last challenge to a winner, rendered from the end templates.
+// Sends with the same key are only delivered once by mailers that support it.
+func SendEndEmail(m Mailer, t *Templates, key string, to string, end EndEmail) error {
+	msg, err := t.Render(end.Campaign, EndEmailKind, EndData{To: to, Token: end.Token})
+	if err != nil {
+		return fmt.Errorf("rendering end email: %w", err)
+	}
+	msg.To, msg.IdempotencyKey = to, key
+	return m.Send(msg)
+}
@@ -0,0 +1,169 @@
+package email
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/resend/resend-go/v2"
+)
+
+// Message is an email to send
+type Message struct {
+	To      string
+	Subject string
+	Text    string
+	HTML    string
+
+	// IdempotencyKey, when set, makes mailers that support it deliver the message once,
+	// however many times it is sent with the same key
+	IdempotencyKey string
+}
+
+// Mailer sends emails
+type Mailer interface {
+	Send(msg Message) error
+}
+
+// ResendMailer sends emails through the Resend API, passing idempotency keys as the Idempotency-Key header
+type ResendMailer struct {
+	client *resend.Client
+	from   string
+}
+
+// NewResendMailer returns a mailer sending from the given address
+func NewResendMailer(apiKey string, from string) *ResendMailer {
+	httpClient := &http.Client{Timeout: time.Minute, Transport: idempotencyTransport{http.DefaultTransport}}
+	return &ResendMailer{client: resend.NewCustomClient(httpClient, strings.Trim(strings.TrimSpace(apiKey), "'")), from: from}
+}
+
+func (m *ResendMailer) Send(msg Message) error {
+	ctx := context.WithValue(context.Background(), idempotencyKey{}, msg.IdempotencyKey)
+	_, err := m.client.Emails.SendWithContext(ctx, &resend.SendEmailRequest{
+		From:    m.from,
+		To:      []string{msg.To},
+		Subject: msg.Subject,
+		Text:    msg.Text,
+		Html:    msg.HTML,
+	})
+	return err
+}
+
+// idempotencyKey is the context key of the idempotency key of a Resend request
+type idempotencyKey struct{}
+
+// idempotencyTransport sets the Idempotency-Key header from the request context,
+// as the Resend client doesn't take per-request headers
+type idempotencyTransport struct {
+	base http.RoundTripper
+}
+
+func (t idempotencyTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	if key, _ := req.Context().Value(idempotencyKey{}).(string); key != "" {
+		req = req.Clone(req.Context())
+		req.Header.Set("Idempotency-Key", key)
+	}
+	return t.base.RoundTrip(req)
+}
+
+// LogMailer writes emails to the log instead of sending them, for local runs
+type LogMailer struct{}
+
+func (LogMailer) Send(msg Message) error {
+	log.Printf("Email to %s: %s\n%s\n", msg.To, msg.Subject, msg.Text)
+	return nil
+}
+
+// StreamMailer writes emails to a stream, such as stdout, instead of sending them, for development
+type StreamMailer struct {
+	W io.Writer
+
+	mu sync.Mutex
+}
+
+func (m *StreamMailer) Send(msg Message) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return writeMessage(m.W, msg)
+}
+
+// FileMailer appends emails to a file instead of sending them, for offline tests
+type FileMailer struct {
+	Path string
+
+	mu sync.Mutex
+}
+
+func (m *FileMailer) Send(msg Message) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	f, err := os.OpenFile(m.Path, os.O_CREATE|os.O_APPEND|os.O_WRONLY, 0600)
+	if err != nil {
+		return err
+	}
+	err = writeMessage(f, msg)
+	if closeErr := f.Close(); err == nil {
+		err = closeErr
+	}
+	return err
+}
+
+// writeMessage writes the headers and text of an email, or its HTML when it has no text
+func writeMessage(w io.Writer, msg Message) error {
+	body := msg.Text
+	if body == "" {
+		body = msg.HTML
+	}
+	_, err := fmt.Fprintf(w, "Date: %s\nTo: %s\nSubject: %s\n\n%s\n\n", time.Now().UTC().Format(time.RFC1123Z), msg.To, msg.Subject, body)
+	return err
+}
+
+// DefaultFrom is the address emails are sent from unless MAILER_FROM is set
+const DefaultFrom = "ctf@autonoma.app"
+
+// FromEnv returns the mailer selected by MAILER:
+//   - resend (the default) sends through Resend with RESEND_API_KEY
+//   - smtp sends through the SMTP server at SMTP_ADDR (host:port), authenticating with
+//     SMTP_USERNAME and SMTP_PASSWORD when they are set
+//   - log writes them to the log
+//   - stdout writes them to stdout
+//   - file appends them to MAILER_FILE, or emails.log
+//
+// Emails are sent from MAILER_FROM, or DefaultFrom.
+func FromEnv() (Mailer, error) {
+	from := os.Getenv("MAILER_FROM")
+	if from == "" {
+		from = DefaultFrom
+	}
+
+	switch kind := os.Getenv("MAILER"); kind {
+	case "", "resend":
+		return NewResendMailer(os.Getenv("RESEND_API_KEY"), from), nil
+	case "smtp":
+		addr := os.Getenv("SMTP_ADDR")
+		if addr == "" {
+			return nil, errors.New("SMTP_ADDR is required by the smtp mailer")
+		}
+		return NewSMTPMailer(addr, from, os.Getenv("SMTP_USERNAME"), os.Getenv("SMTP_PASSWORD"))
+	case "log":
+		return LogMailer{}, nil
+	case "stdout":
+		return &StreamMailer{W: os.Stdout}, nil
+	case "file":
+		path := os.Getenv("MAILER_FILE")
+		if path == "" {
+			path = "emails.log"
+		}
+		return &FileMailer{Path: path}, nil
+	default:
+		return nil, fmt.Errorf("unknown MAILER %q, expected resend, smtp, log, stdout or file", kind)
+	}
+}
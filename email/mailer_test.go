@@ -0,0 +1,104 @@
+package email
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestFileMailerAppends(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "emails.log")
+	m := &FileMailer{Path: path}
+
+	for _, to := range []string{"a@example.com", "b@example.com"} {
+		if err := m.Send(Message{To: to, Subject: "Hi", Text: "Hello " + to}); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	data, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, want := range []string{"To: a@example.com", "Hello a@example.com", "To: b@example.com", "Hello b@example.com"} {
+		if !strings.Contains(string(data), want) {
+			t.Errorf("expected %q in\n%s", want, data)
+		}
+	}
+}
+
+func TestStreamMailerWritesHTMLWithoutText(t *testing.T) {
+	var out strings.Builder
+	m := &StreamMailer{W: &out}
+	if err := m.Send(Message{To: "a@example.com", Subject: "Hi", HTML: "<p>Hello</p>"}); err != nil {
+		t.Fatal(err)
+	}
+	for _, want := range []string{"To: a@example.com", "Subject: Hi", "<p>Hello</p>"} {
+		if !strings.Contains(out.String(), want) {
+			t.Errorf("expected %q in\n%s", want, out.String())
+		}
+	}
+}
+
+func TestResendMailerSendsIdempotencyKey(t *testing.T) {
+	var keys []string
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		keys = append(keys, r.Header.Get("Idempotency-Key"))
+		w.Header().Set("Content-Type", "application/json")
+		fmt.Fprint(w, `{"id": "email-1"}`)
+	}))
+	defer srv.Close()
+
+	m := NewResendMailer("re_key", "ctf@example.com")
+	base, err := url.Parse(srv.URL + "/")
+	if err != nil {
+		t.Fatal(err)
+	}
+	m.client.BaseURL = base
+
+	for _, key := range []string{"end:session-1", ""} {
+		if err := m.Send(Message{To: "a@example.com", Subject: "Hi", Text: "Hello", IdempotencyKey: key}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if len(keys) != 2 || keys[0] != "end:session-1" || keys[1] != "" {
+		t.Errorf("expected the key to be sent only when set, got %q", keys)
+	}
+}
+
+func TestFromEnv(t *testing.T) {
+	tests := []struct {
+		mailer   string
+		smtpAddr string
+		want     string
+		wantErr  bool
+	}{
+		{mailer: "", want: "*email.ResendMailer"},
+		{mailer: "smtp", smtpAddr: "localhost:1025", want: "*email.SMTPMailer"},
+		{mailer: "smtp", wantErr: true},
+		{mailer: "smtp", smtpAddr: "localhost", wantErr: true},
+		{mailer: "log", want: "email.LogMailer"},
+		{mailer: "stdout", want: "*email.StreamMailer"},
+		{mailer: "file", want: "*email.FileMailer"},
+		{mailer: "pigeon", wantErr: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.mailer+" "+tt.smtpAddr, func(t *testing.T) {
+			t.Setenv("MAILER", tt.mailer)
+			t.Setenv("SMTP_ADDR", tt.smtpAddr)
+			m, err := FromEnv()
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("expected error=%v, got %v", tt.wantErr, err)
+			}
+			if got := fmt.Sprintf("%T", m); !tt.wantErr && got != tt.want {
+				t.Errorf("got %s, want %s", got, tt.want)
+			}
+		})
+	}
+}
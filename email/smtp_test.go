@@ -0,0 +1,152 @@
+package email
+
+import (
+	"bufio"
+	"io"
+	"mime"
+	"mime/multipart"
+	"net"
+	"net/mail"
+	"strings"
+	"testing"
+)
+
+// smtpSink is a minimal SMTP server that accepts every email and hands over what it received
+type smtpSink struct {
+	addr     string
+	received chan sinkEmail
+}
+
+type sinkEmail struct {
+	from, to string
+	data     string
+}
+
+func newSMTPSink(t *testing.T) *smtpSink {
+	t.Helper()
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { l.Close() })
+
+	sink := &smtpSink{addr: l.Addr().String(), received: make(chan sinkEmail, 1)}
+	go func() {
+		conn, err := l.Accept()
+		if err != nil {
+			return
+		}
+		defer conn.Close()
+		r := bufio.NewReader(conn)
+		reply := func(line string) { io.WriteString(conn, line+"\r\n") }
+
+		var email sinkEmail
+		reply("220 sink ready")
+		for {
+			line, err := r.ReadString('\n')
+			if err != nil {
+				return
+			}
+			cmd := strings.TrimSpace(line)
+			switch upper := strings.ToUpper(cmd); {
+			case strings.HasPrefix(upper, "EHLO"), strings.HasPrefix(upper, "HELO"):
+				reply("250 sink")
+			case strings.HasPrefix(upper, "MAIL FROM:"):
+				email.from = strings.Trim(cmd[len("MAIL FROM:"):], "<> ")
+				reply("250 ok")
+			case strings.HasPrefix(upper, "RCPT TO:"):
+				email.to = strings.Trim(cmd[len("RCPT TO:"):], "<> ")
+				reply("250 ok")
+			case upper == "DATA":
+				reply("354 go ahead")
+				var data strings.Builder
+				for {
+					line, err := r.ReadString('\n')
+					if err != nil {
+						return
+					}
+					if line == ".\r\n" {
+						break
+					}
+					data.WriteString(line)
+				}
+				email.data = data.String()
+				sink.received <- email
+				reply("250 queued")
+			case upper == "QUIT":
+				reply("221 bye")
+				return
+			default:
+				reply("250 ok")
+			}
+		}
+	}()
+	return sink
+}
+
+func TestSMTPMailerSends(t *testing.T) {
+	sink := newSMTPSink(t)
+	m, err := NewSMTPMailer(sink.addr, "ctf@example.com", "", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	err = m.Send(Message{
+		To:      "ada@example.com",
+		Subject: "Your code: 123456 ✓",
+		Text:    "Your code is 123456",
+		HTML:    "<p>Your code is <strong>123456</strong></p>",
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	got := <-sink.received
+	if got.from != "ctf@example.com" || got.to != "ada@example.com" {
+		t.Errorf("got envelope from %q to %q", got.from, got.to)
+	}
+	msg, err := mail.ReadMessage(strings.NewReader(got.data))
+	if err != nil {
+		t.Fatal(err)
+	}
+	subject, err := new(mime.WordDecoder).DecodeHeader(msg.Header.Get("Subject"))
+	if err != nil || subject != "Your code: 123456 ✓" {
+		t.Errorf("Subject = %q (%v)", subject, err)
+	}
+
+	mediaType, params, err := mime.ParseMediaType(msg.Header.Get("Content-Type"))
+	if err != nil || mediaType != "multipart/alternative" {
+		t.Fatalf("Content-Type = %q (%v)", msg.Header.Get("Content-Type"), err)
+	}
+	parts := multipart.NewReader(msg.Body, params["boundary"])
+	var bodies []string
+	for {
+		part, err := parts.NextPart()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			t.Fatal(err)
+		}
+		body, _ := io.ReadAll(part)
+		bodies = append(bodies, part.Header.Get("Content-Type")+": "+string(body))
+	}
+	want := []string{
+		"text/plain; charset=UTF-8: Your code is 123456",
+		"text/html; charset=UTF-8: <p>Your code is <strong>123456</strong></p>",
+	}
+	if strings.Join(bodies, "\n") != strings.Join(want, "\n") {
+		t.Errorf("got parts\n%s\nwant\n%s", strings.Join(bodies, "\n"), strings.Join(want, "\n"))
+	}
+}
+
+func TestSMTPMailerRejectsHeaderInjection(t *testing.T) {
+	m, err := NewSMTPMailer("127.0.0.1:1", "ctf@example.com", "", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = m.Send(Message{To: "ada@example.com\r\nBcc: everyone@example.com", Subject: "Hi", Text: "Hi"})
+	if err == nil || !strings.Contains(err.Error(), "line breaks") {
+		t.Fatalf("expected the line break to be rejected, got %v", err)
+	}
+}
@@ -0,0 +1,135 @@
+package email
+
+import (
+	"bytes"
+	"embed"
+	"errors"
+	"fmt"
+	htmltemplate "html/template"
+	"io/fs"
+	"os"
+	"path"
+	"strings"
+	texttemplate "text/template"
+	"time"
+)
+
+// VerificationEmail is the template of the email with a verification code
+const VerificationEmail = "verification"
+
+//go:embed templates/*
+var embedded embed.FS
+
+// EndData is what the end email templates are rendered with
+type EndData struct {
+	To    string
+	Token string
+}
+
+// VerificationData is what the verification email templates are rendered with
+type VerificationData struct {
+	To   string
+	Code string
+	TTL  time.Duration
+}
+
+// Templates renders emails from the templates embedded in the binary. Every email has a text template,
+// NAME.txt, which also defines its "subject", and an HTML template, NAME.html.
+//
+// Files in Dir override the embedded ones, and files in a subdirectory of Dir named after a campaign
+// (the challenge pack) override both for the emails of that campaign. A nil *Templates only uses the
+// embedded ones.
+type Templates struct {
+	Dir string
+}
+
+// NewTemplates returns templates overridden by the files in dir, which may be empty
+func NewTemplates(dir string) *Templates {
+	return &Templates{Dir: dir}
+}
+
+// Render renders the named email of the campaign with the given data. The caller sets who it goes to.
+func (t *Templates) Render(campaign string, name string, data interface{}) (Message, error) {
+	source, err := t.read(campaign, name+".txt")
+	if err != nil {
+		return Message{}, err
+	}
+	text, err := texttemplate.New(name + ".txt").Parse(string(source))
+	if err != nil {
+		return Message{}, err
+	}
+	if text.Lookup("subject") == nil {
+		return Message{}, fmt.Errorf("%s.txt doesn't define a subject", name)
+	}
+
+	source, err = t.read(campaign, name+".html")
+	if err != nil {
+		return Message{}, err
+	}
+	html, err := htmltemplate.New(name + ".html").Parse(string(source))
+	if err != nil {
+		return Message{}, err
+	}
+
+	var subject, body, htmlBody bytes.Buffer
+	if err := text.ExecuteTemplate(&subject, "subject", data); err != nil {
+		return Message{}, err
+	}
+	if err := text.Execute(&body, data); err != nil {
+		return Message{}, err
+	}
+	if err := html.Execute(&htmlBody, data); err != nil {
+		return Message{}, err
+	}
+	return Message{
+		Subject: strings.TrimSpace(subject.String()),
+		Text:    strings.TrimSpace(body.String()),
+		HTML:    htmlBody.String(),
+	}, nil
+}
+
+// Preview renders the named email of the campaign with sample data
+func (t *Templates) Preview(campaign string, name string) (Message, error) {
+	var data interface{}
+	switch name {
+	case EndEmailKind:
+		data = EndData{To: "candidate@example.com", Token: previewToken}
+	case VerificationEmail:
+		data = VerificationData{To: "candidate@example.com", Code: "123456", TTL: 10 * time.Minute}
+	default:
+		return Message{}, fmt.Errorf("unknown email %q, expected %s or %s", name, EndEmailKind, VerificationEmail)
+	}
+	msg, err := t.Render(campaign, name, data)
+	msg.To = "candidate@example.com"
+	return msg, err
+}
+
+// previewToken stands in for a completion token in previews
+const previewToken = "eyJhbGciOiJIUzI1NiIsInR5cCI6IkpXVCJ9.eyJnb1RvVGhpc0xpbmsiOiJodHRwczovL2V4YW1wbGUuY29tIn0.preview"
+
+// read returns the most specific version of a template file: the campaign's, the override or the embedded one
+func (t *Templates) read(campaign string, file string) ([]byte, error) {
+	if t != nil && t.Dir != "" {
+		dir := os.DirFS(t.Dir)
+		paths := []string{file}
+		if campaign != "" {
+			paths = []string{path.Join(campaign, file), file}
+		}
+		for _, p := range paths {
+			// Campaign names that aren't valid paths, like "../x", are skipped by fs.ReadFile
+			data, err := fs.ReadFile(dir, p)
+			if err == nil {
+				return data, nil
+			}
+			if !errors.Is(err, fs.ErrNotExist) && !errors.Is(err, fs.ErrInvalid) {
+				return nil, err
+			}
+		}
+	}
+
+	data, err := embedded.ReadFile("templates/" + file)
+	if errors.Is(err, fs.ErrNotExist) {
+		return nil, fmt.Errorf("unknown email template %q", file)
+	}
+	return data, err
+}
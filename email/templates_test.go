@@ -0,0 +1,126 @@
+package email
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestRenderEmbeddedTemplates(t *testing.T) {
+	var templates *Templates
+
+	msg, err := templates.Render("", EndEmailKind, EndData{To: "ada@example.com", Token: "a.b.c"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if msg.Subject != "Autonoma CTF: one last challenge" {
+		t.Errorf("Subject = %q", msg.Subject)
+	}
+	if !strings.Contains(msg.Text, "a.b.c") || !strings.Contains(msg.HTML, "<pre") || !strings.Contains(msg.HTML, "a.b.c") {
+		t.Errorf("expected the token in both bodies, got\n%s\n%s", msg.Text, msg.HTML)
+	}
+
+	msg, err = templates.Render("", VerificationEmail, VerificationData{Code: "123456", TTL: 10 * time.Minute})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if msg.Subject != "Your Autonoma CTF code: 123456" || !strings.Contains(msg.Text, "expires in 10m0s") {
+		t.Errorf("unexpected email %+v", msg)
+	}
+}
+
+func TestRenderEscapesHTML(t *testing.T) {
+	msg, err := NewTemplates("").Render("", EndEmailKind, EndData{Token: "<script>alert(1)</script>"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if strings.Contains(msg.HTML, "<script>") {
+		t.Errorf("expected the token to be escaped in\n%s", msg.HTML)
+	}
+}
+
+func writeTemplate(t *testing.T, path string, content string) {
+	t.Helper()
+	if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(path, []byte(content), 0o644); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestRenderOverrides(t *testing.T) {
+	dir := t.TempDir()
+	writeTemplate(t, filepath.Join(dir, "end.txt"), `{{define "subject"}}Override{{end}}Override {{.Token}}`)
+	writeTemplate(t, filepath.Join(dir, "spring", "end.html"), `<p>Spring {{.Token}}</p>`)
+	templates := NewTemplates(dir)
+
+	tests := []struct {
+		campaign string
+		subject  string
+		text     string
+		html     string
+	}{
+		{campaign: "", subject: "Override", text: "Override t", html: "<pre"},
+		{campaign: "default", subject: "Override", text: "Override t", html: "<pre"},
+		{campaign: "spring", subject: "Override", text: "Override t", html: "<p>Spring t</p>"},
+		{campaign: "../spring", subject: "Override", text: "Override t", html: "<pre"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.campaign, func(t *testing.T) {
+			msg, err := templates.Render(tt.campaign, EndEmailKind, EndData{Token: "t"})
+			if err != nil {
+				t.Fatal(err)
+			}
+			if msg.Subject != tt.subject || msg.Text != tt.text || !strings.Contains(msg.HTML, tt.html) {
+				t.Errorf("got %+v, want subject %q, text %q and HTML with %q", msg, tt.subject, tt.text, tt.html)
+			}
+		})
+	}
+}
+
+func TestRenderErrors(t *testing.T) {
+	dir := t.TempDir()
+	writeTemplate(t, filepath.Join(dir, "nosubject", "end.txt"), `{{.Token}}`)
+	writeTemplate(t, filepath.Join(dir, "broken", "end.html"), `<p>{{if .Token}}</p>`)
+	writeTemplate(t, filepath.Join(dir, "typo", "end.txt"), `{{define "subject"}}Hi{{end}}{{.Tokn}}`)
+	templates := NewTemplates(dir)
+
+	tests := []struct {
+		campaign string
+		name     string
+		wantErr  string
+	}{
+		{campaign: "nosubject", name: EndEmailKind, wantErr: "doesn't define a subject"},
+		{campaign: "broken", name: EndEmailKind, wantErr: "end.html"},
+		{campaign: "typo", name: EndEmailKind, wantErr: "can't evaluate field Tokn"},
+		{name: "newsletter", wantErr: `unknown email template "newsletter.txt"`},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.campaign+tt.name, func(t *testing.T) {
+			_, err := templates.Render(tt.campaign, tt.name, EndData{})
+			if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+				t.Errorf("expected an error with %q, got %v", tt.wantErr, err)
+			}
+		})
+	}
+}
+
+func TestPreview(t *testing.T) {
+	for _, name := range []string{EndEmailKind, VerificationEmail} {
+		msg, err := NewTemplates("").Preview("", name)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if msg.To == "" || msg.Subject == "" || msg.Text == "" || msg.HTML == "" {
+			t.Errorf("expected a complete %s email, got %+v", name, msg)
+		}
+	}
+	if _, err := NewTemplates("").Preview("", "newsletter"); err == nil {
+		t.Error("expected an error for an unknown email")
+	}
+}
@@ -0,0 +1,16 @@
+package email
+
+import (
+	"fmt"
+	"time"
+)
+
+// SendVerificationCode emails the one-time code a candidate types to prove they own the address
+func SendVerificationCode(m Mailer, t *Templates, to string, code string, ttl time.Duration) error {
+	msg, err := t.Render("", VerificationEmail, VerificationData{To: to, Code: code, TTL: ttl})
+	if err != nil {
+		return fmt.Errorf("rendering verification email: %w", err)
+	}
+	msg.To = to
+	return m.Send(msg)
+}
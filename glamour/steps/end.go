@@ -2,14 +2,12 @@ package steps
 
 import (
 	"fmt"
-	"log"
 	"time"
 
 	tea "github.com/charmbracelet/bubbletea"
 	"github.com/charmbracelet/lipgloss"
 	"github.com/golang-jwt/jwt/v5"
 	"github.com/google/uuid"
-	"github.com/tomaspiaggio/autonoma-hiring-ctf/email"
 )
 
 // EndStep is the final step shown upon successful completion.
@@ -22,6 +20,7 @@ type EndStep struct {
 	congratsStyle lipgloss.Style
 	infoStyle     lipgloss.Style
 	tokenStyle    lipgloss.Style
+	endsAt        time.Time // When the session is done, set once the win is recorded
 }
 
 // JWTCustomClaims defines the payload for the JWT token.
@@ -33,15 +32,27 @@ type JWTCustomClaims struct {
 	jwt.RegisteredClaims
 }
 
-// TODO: Move this secret to a more secure location (e.g., environment variable)
-var jwtSecretKey = []byte("a_very_secret_key_for_autonoma_ctf_shhh")
+// EndConfig holds the details embedded in the completion token.
+type EndConfig struct {
+	CalLink      string `json:"cal_link"`
+	Instructions string `json:"instructions"`
+	FollowMe     string `json:"follow_me"`
+}
+
+func init() {
+	RegisterType("end", func(sm *StepManager, cfg EndConfig) (Step, error) {
+		return NewEndStep(sm, cfg), nil
+	})
+}
 
 // NewEndStep creates a new EndStep instance.
-func NewEndStep(sm *StepManager) *EndStep {
+func NewEndStep(sm *StepManager, cfg EndConfig) *EndStep {
 	generatedKey := uuid.NewString() // Generate a unique key
-	calLink := "https://cal.com/tom-piaggio-autonoma/15min"
-	instructions := "In the meeting description, please write the key provided below and briefly share your thoughts on the CTF."
-	followMe := "@tomaspiaggio"
+	calLink := cfg.CalLink
+	instructions := cfg.Instructions
+	followMe := cfg.FollowMe
+
+	now := sm.Now()
 
 	// Create the claims
 	claims := JWTCustomClaims{
@@ -50,24 +61,22 @@ func NewEndStep(sm *StepManager) *EndStep {
 		followMe,
 		generatedKey,
 		jwt.RegisteredClaims{
-			ExpiresAt: jwt.NewNumericDate(time.Now().Add(24 * time.Hour)), // Token expires in 24 hours
-			IssuedAt:  jwt.NewNumericDate(time.Now()),
-			NotBefore: jwt.NewNumericDate(time.Now()),
+			ExpiresAt: jwt.NewNumericDate(now.Add(24 * time.Hour)), // Token expires in 24 hours
+			IssuedAt:  jwt.NewNumericDate(now),
+			NotBefore: jwt.NewNumericDate(now),
 			Issuer:    "AutonomaCTF",
 			Subject:   "CandidateCompletion",
 			ID:        uuid.NewString(),
 		},
 	}
 
-	// Create token
-	token := jwt.NewWithClaims(jwt.SigningMethodHS256, claims)
-
-	// Sign token
-	signedToken, err := token.SignedString(jwtSecretKey)
+	// Sign token with the current key of the manager's keyring
+	signedToken, err := sm.Keys.Sign(claims)
 	if err != nil {
 		// In a real app, handle this error more gracefully
 		signedToken = fmt.Sprintf("Error generating token: %v", err)
 	}
+	sm.endToken = signedToken
 
 	congratsStyle := lipgloss.NewStyle().
 		Bold(true).
@@ -109,31 +118,21 @@ func (s *EndStep) Update(msg tea.Msg) (Step, tea.Cmd) {
 		return s, nil
 	}
 
-	if !s.sm.EmailSent {
+	if !s.sm.EmailSent && s.sm.db != nil {
 		s.sm.EmailSent = true
-		go func() {
-			_, err := email.SendEndEmail(s.sm.Email, "Tom Piaggio", "tom@autonoma.app", s.jwtToken)
-			if err != nil {
-				log.Printf("Error sending end email for %s: %v\n", s.sm.Email, err)
-			}
-		}()
-		go func() {
-			_, err := s.sm.db.CreateAttempt(s.sm.Email, false, map[string]interface{}{
-				"step": s.sm.CurrentStep,
-				"time": time.Since(s.sm.startTime),
-				"msg":  s.sm.FailureMsg,
-			})
-			if err != nil {
-				log.Printf("Error creating attempt for %s: %v\n", s.sm.Email, err)
-			}
-			time.Sleep(5 * time.Second)
-			s.sm.StepFailed = true
-		}()
+		s.sm.recordWin(s.jwtToken)
+		s.endsAt = s.sm.Now().Add(endShownFor)
+	}
+	if !s.endsAt.IsZero() && !s.sm.Now().Before(s.endsAt) {
+		s.sm.Done = true
 	}
 
 	return s, nil
 }
 
+// endShownFor is how long the end screen stays up once the win is recorded
+const endShownFor = 5 * time.Second
+
 // View renders the final success message, JWT token, and instructions.
 func (s *EndStep) View() string {
 	return fmt.Sprintf(
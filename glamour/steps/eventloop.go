@@ -0,0 +1,143 @@
+package steps
+
+import (
+	"errors"
+	"sort"
+	"time"
+
+	"github.com/dop251/goja"
+)
+
+// maxTimerCallbacks bounds how many timer callbacks one evaluation may run
+const maxTimerCallbacks = 100000
+
+// errTooManyTimers is returned when candidate code keeps scheduling timers forever
+var errTooManyTimers = errors.New("too many timer callbacks, is a setInterval never cleared?")
+
+// eventLoop gives a runtime the browser timer APIs and runs them on a virtual clock.
+// Timers fire in order of due time without waiting in real time, and goja drains
+// the promise job queue after every callback, so an evaluation runs until nothing
+// is left to do. Wall-clock limits still come from runSandboxed.
+type eventLoop struct {
+	vm     *goja.Runtime
+	now    time.Duration
+	nextID int64
+	timers map[int64]*timer
+}
+
+// timer is a pending setTimeout, setInterval or setImmediate
+type timer struct {
+	id       int64
+	due      time.Duration
+	interval time.Duration
+	repeat   bool
+	fn       goja.Callable
+	args     []goja.Value
+}
+
+// newEventLoop installs setTimeout, setInterval, setImmediate, their clear
+// counterparts and queueMicrotask on the runtime
+func newEventLoop(vm *goja.Runtime) *eventLoop {
+	l := &eventLoop{vm: vm, timers: map[int64]*timer{}}
+
+	vm.Set("setTimeout", func(call goja.FunctionCall) goja.Value {
+		return l.schedule(call, false)
+	})
+	vm.Set("setInterval", func(call goja.FunctionCall) goja.Value {
+		return l.schedule(call, true)
+	})
+	vm.Set("setImmediate", func(call goja.FunctionCall) goja.Value {
+		fn, ok := goja.AssertFunction(call.Argument(0))
+		if !ok {
+			panic(vm.NewTypeError("setImmediate callback must be a function"))
+		}
+		return l.add(&timer{due: l.now, fn: fn, args: argsFrom(call.Arguments, 1)})
+	})
+	clear := func(call goja.FunctionCall) goja.Value {
+		delete(l.timers, call.Argument(0).ToInteger())
+		return goja.Undefined()
+	}
+	vm.Set("clearTimeout", clear)
+	vm.Set("clearInterval", clear)
+	vm.Set("clearImmediate", clear)
+
+	// queueMicrotask schedules on the promise job queue
+	if _, err := vm.RunString(`globalThis.queueMicrotask = function (fn) { Promise.resolve().then(fn); };`); err != nil {
+		panic(err)
+	}
+	return l
+}
+
+func (l *eventLoop) schedule(call goja.FunctionCall, repeat bool) goja.Value {
+	fn, ok := goja.AssertFunction(call.Argument(0))
+	if !ok {
+		panic(l.vm.NewTypeError("timer callback must be a function"))
+	}
+	delay := time.Duration(call.Argument(1).ToInteger()) * time.Millisecond
+	if delay < 0 {
+		delay = 0
+	}
+	// Like browsers, an interval can't spin faster than once per millisecond
+	if repeat && delay < time.Millisecond {
+		delay = time.Millisecond
+	}
+	return l.add(&timer{
+		due:      l.now + delay,
+		interval: delay,
+		repeat:   repeat,
+		fn:       fn,
+		args:     argsFrom(call.Arguments, 2),
+	})
+}
+
+func (l *eventLoop) add(t *timer) goja.Value {
+	l.nextID++
+	t.id = l.nextID
+	l.timers[t.id] = t
+	return l.vm.ToValue(t.id)
+}
+
+// argsFrom returns the arguments from index i on
+func argsFrom(args []goja.Value, i int) []goja.Value {
+	if len(args) <= i {
+		return nil
+	}
+	return args[i:]
+}
+
+// run fires timers in due order until none are left
+func (l *eventLoop) run() error {
+	for fired := 0; len(l.timers) > 0; fired++ {
+		if fired >= maxTimerCallbacks {
+			return errTooManyTimers
+		}
+
+		next := l.nextTimer()
+		l.now = next.due
+		if next.repeat {
+			next.due += next.interval
+		} else {
+			delete(l.timers, next.id)
+		}
+
+		if _, err := next.fn(goja.Undefined(), next.args...); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// nextTimer returns the timer due first, ties going to the one scheduled first
+func (l *eventLoop) nextTimer() *timer {
+	pending := make([]*timer, 0, len(l.timers))
+	for _, t := range l.timers {
+		pending = append(pending, t)
+	}
+	sort.Slice(pending, func(i, j int) bool {
+		if pending[i].due != pending[j].due {
+			return pending[i].due < pending[j].due
+		}
+		return pending[i].id < pending[j].id
+	})
+	return pending[0]
+}
@@ -0,0 +1,86 @@
+package steps
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/dop251/goja"
+)
+
+func TestEventLoop(t *testing.T) {
+	tests := []struct {
+		name string
+		code string
+		want string
+	}{
+		{
+			name: "timers fire in due order after microtasks",
+			code: `
+				setTimeout(() => log.push("b"), 20);
+				setTimeout(() => log.push("a"), 10);
+				setImmediate(() => log.push("immediate"));
+				Promise.resolve().then(() => log.push("micro"));
+				queueMicrotask(() => log.push("queued"));`,
+			want: "micro,queued,immediate,a,b",
+		},
+		{
+			name: "cleared timers never fire",
+			code: `
+				const id = setTimeout(() => log.push("cleared"), 10);
+				setTimeout(() => log.push("kept"), 20);
+				clearTimeout(id);`,
+			want: "kept",
+		},
+		{
+			name: "intervals repeat until cleared",
+			code: `
+				let n = 0;
+				const id = setInterval(() => { log.push(String(++n)); if (n === 3) clearInterval(id); }, 5);`,
+			want: "1,2,3",
+		},
+		{
+			name: "awaiting timers inside async functions",
+			code: `
+				const sleep = ms => new Promise(r => setTimeout(r, ms));
+				(async () => { await sleep(50); log.push("slow"); })();
+				(async () => { await sleep(10); log.push("fast"); })();`,
+			want: "fast,slow",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var got string
+			err := runSandboxed(func(vm *goja.Runtime) error {
+				loop := newEventLoop(vm)
+				if _, err := vm.RunString("var log = [];" + tt.code); err != nil {
+					return err
+				}
+				if err := loop.run(); err != nil {
+					return err
+				}
+				got = vm.Get("log").String()
+				return nil
+			})
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got != tt.want {
+				t.Errorf("got %s, want %s", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestEventLoopStopsRunawayIntervals(t *testing.T) {
+	err := runSandboxed(func(vm *goja.Runtime) error {
+		loop := newEventLoop(vm)
+		if _, err := vm.RunString(`setInterval(() => {}, 0)`); err != nil {
+			return err
+		}
+		return loop.run()
+	})
+	if err == nil || !strings.Contains(err.Error(), "too many timer callbacks") {
+		t.Fatalf("expected the loop to give up, got %v", err)
+	}
+}
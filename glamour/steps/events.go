@@ -0,0 +1,47 @@
+package steps
+
+import (
+	"log"
+
+	"github.com/tomaspiaggio/autonoma-hiring-ctf/database"
+)
+
+// RecordEvent stores an event on the current step. The manager emits enter, pass, fail and
+// timeout events itself; steps record submits with the details of what was submitted.
+func (sm *StepManager) RecordEvent(kind database.EventKind, payload map[string]interface{}) {
+	if sm.db == nil || sm.SessionID == "" || sm.StepFailed {
+		return
+	}
+
+	now := sm.Now()
+	event := database.StepEvent{
+		SessionID: sm.SessionID,
+		Step:      sm.CurrentStep,
+		StepType:  sm.stepType(sm.CurrentStep),
+		Kind:      kind,
+		At:        now,
+		Duration:  now.Sub(sm.stepEnteredAt),
+		Payload:   payload,
+	}
+	sm.recording.Add(1)
+	go func() {
+		defer sm.recording.Done()
+		if err := sm.db.RecordStepEvent(event); err != nil {
+			log.Printf("Error recording %s event for %s: %v\n", kind, sm.Email, err)
+		}
+	}()
+}
+
+// enterStep starts timing the current step and records that it was entered
+func (sm *StepManager) enterStep(payload map[string]interface{}) {
+	sm.stepEnteredAt = sm.Now()
+	sm.RecordEvent(database.EventEnter, payload)
+}
+
+// stepType returns the pack type of the step at index i, empty for flows not built from a pack
+func (sm *StepManager) stepType(i int) string {
+	if sm.pack == nil || i < 0 || i >= len(sm.pack.Steps) {
+		return ""
+	}
+	return sm.pack.Steps[i].Type
+}
@@ -0,0 +1,98 @@
+package steps
+
+import (
+	"testing"
+	"time"
+
+	"github.com/tomaspiaggio/autonoma-hiring-ctf/database"
+	"github.com/tomaspiaggio/autonoma-hiring-ctf/harness"
+)
+
+func TestStepEvents(t *testing.T) {
+	sm, clock, store := newTestManager(t)
+	if err := sm.StartSession(DefaultPack()); err != nil {
+		t.Fatal(err)
+	}
+
+	wordle := sm.Steps[0].(*Step1)
+	clock.Advance(30 * time.Second)
+	for _, msg := range harness.Type("zzzzz") {
+		sm.UpdateCurrentStep(msg)
+	}
+	clock.Advance(time.Minute)
+	for _, msg := range harness.Type(wordle.answer) {
+		sm.UpdateCurrentStep(msg)
+	}
+	clock.Advance(2 * time.Minute)
+	sm.SetTimedOutStep("Time's up.")
+
+	// Nothing is recorded once the session failed
+	sm.RecordEvent(database.EventSubmit, nil)
+	sm.Wait()
+
+	want := []struct {
+		kind     database.EventKind
+		step     int
+		stepType string
+		duration time.Duration
+		payload  map[string]interface{}
+	}{
+		{kind: database.EventEnter, step: 0, stepType: "wordle"},
+		{kind: database.EventSubmit, step: 0, stepType: "wordle", duration: 30 * time.Second, payload: map[string]interface{}{"guess": "zzzzz", "row": 0}},
+		{kind: database.EventSubmit, step: 0, stepType: "wordle", duration: 90 * time.Second, payload: map[string]interface{}{"guess": wordle.answer, "row": 1}},
+		{kind: database.EventPass, step: 0, stepType: "wordle", duration: 90 * time.Second},
+		{kind: database.EventEnter, step: 1, stepType: "password"},
+		{kind: database.EventTimeout, step: 1, stepType: "password", duration: 2 * time.Minute, payload: map[string]interface{}{"msg": "Time's up."}},
+	}
+
+	events := store.Events()
+	if len(events) != len(want) {
+		t.Fatalf("expected %d events, got %+v", len(want), events)
+	}
+	// Events are written concurrently, so match them up by kind, step and duration
+	for _, w := range want {
+		found := false
+		for _, e := range events {
+			if e.Kind != w.kind || e.Step != w.step || e.Duration != w.duration {
+				continue
+			}
+			found = true
+			if e.SessionID != sm.SessionID || e.StepType != w.stepType {
+				t.Errorf("%s event of step %d: got session %s and type %q", w.kind, w.step+1, e.SessionID, e.StepType)
+			}
+			for key, value := range w.payload {
+				if e.Payload[key] != value {
+					t.Errorf("%s event of step %d: expected %s=%v, got %v", w.kind, w.step+1, key, value, e.Payload[key])
+				}
+			}
+		}
+		if !found {
+			t.Errorf("missing %s event of step %d after %s in %+v", w.kind, w.step+1, w.duration, events)
+		}
+	}
+}
+
+func TestRestoreRecordsResumedEnter(t *testing.T) {
+	sm, _, store := newTestManager(t)
+	if err := sm.StartSession(DefaultPack()); err != nil {
+		t.Fatal(err)
+	}
+	sm.Checkpoint()
+	harness.Eventually(t, func() bool {
+		return store.Session(sm.SessionID).State != nil
+	}, "checkpoint saved")
+	sm.Wait()
+
+	restored, _, _ := newTestManager(t)
+	restored.db = store
+	if err := restored.Restore(store.Session(sm.SessionID)); err != nil {
+		t.Fatal(err)
+	}
+	restored.Wait()
+
+	events := store.Events()
+	last := events[len(events)-1]
+	if last.Kind != database.EventEnter || last.Payload["resumed"] != true {
+		t.Errorf("expected a resumed enter event, got %+v", last)
+	}
+}
@@ -0,0 +1,130 @@
+package steps
+
+import (
+	"strings"
+)
+
+// forAwaitHelper collects the values of a for await loop. goja parses async
+// functions but not for await, so rewriteForAwait turns each loop into a plain
+// for...of over the awaited values. Unlike a real for await, every value is
+// awaited, in order, before the body first runs.
+const forAwaitHelper = `
+var __forAwait = async function (iterable) {
+	const values = [];
+	for (const value of iterable) {
+		values.push(await value);
+	}
+	return values;
+};
+`
+
+// rewriteForAwait replaces every `for await (HEAD of EXPR)` in code with
+// `for (HEAD of await __forAwait(EXPR))`, leaving strings and comments alone.
+// Loops it can't make sense of are kept, so goja reports the syntax error.
+func rewriteForAwait(code string) string {
+	var out strings.Builder
+	for i := 0; i < len(code); {
+		if end := skipLiteral(code, i); end > i {
+			out.WriteString(code[i:end])
+			i = end
+			continue
+		}
+		if head, expr, end, ok := matchForAwait(code, i); ok {
+			out.WriteString("for (" + head + " of await __forAwait(" + expr + "))")
+			i = end
+			continue
+		}
+		out.WriteByte(code[i])
+		i++
+	}
+	return out.String()
+}
+
+// matchForAwait matches a for await loop header at i, returning what comes
+// before and after its `of` and where the header ends
+func matchForAwait(code string, i int) (head string, expr string, end int, ok bool) {
+	if !wordAt(code, i, "for") {
+		return "", "", 0, false
+	}
+	j := skipSpace(code, i+len("for"))
+	if !wordAt(code, j, "await") {
+		return "", "", 0, false
+	}
+	j = skipSpace(code, j+len("await"))
+	if j >= len(code) || code[j] != '(' {
+		return "", "", 0, false
+	}
+
+	start, of, depth := j+1, -1, 0
+	for k := start; k < len(code); {
+		if next := skipLiteral(code, k); next > k {
+			k = next
+			continue
+		}
+		switch c := code[k]; {
+		case c == '(' || c == '[' || c == '{':
+			depth++
+		case c == ')' && depth == 0:
+			if of < 0 {
+				return "", "", 0, false
+			}
+			return code[start:of], strings.TrimSpace(code[of+len("of") : k]), k + 1, true
+		case c == ')' || c == ']' || c == '}':
+			depth--
+		case depth == 0 && of < 0 && wordAt(code, k, "of"):
+			of = k
+		}
+		k++
+	}
+	return "", "", 0, false
+}
+
+// skipLiteral returns where the string, template or comment starting at i ends, or i if there is none
+func skipLiteral(code string, i int) int {
+	switch {
+	case strings.HasPrefix(code[i:], "//"):
+		if end := strings.IndexByte(code[i:], '\n'); end >= 0 {
+			return i + end
+		}
+		return len(code)
+	case strings.HasPrefix(code[i:], "/*"):
+		if end := strings.Index(code[i+2:], "*/"); end >= 0 {
+			return i + 2 + end + 2
+		}
+		return len(code)
+	case code[i] == '"' || code[i] == '\'' || code[i] == '`':
+		for k := i + 1; k < len(code); k++ {
+			switch code[k] {
+			case '\\':
+				k++
+			case code[i]:
+				return k + 1
+			}
+		}
+		return len(code)
+	}
+	return i
+}
+
+// wordAt reports whether the identifier at i is word
+func wordAt(code string, i int, word string) bool {
+	if !strings.HasPrefix(code[i:], word) {
+		return false
+	}
+	if i > 0 && isIdentByte(code[i-1]) {
+		return false
+	}
+	end := i + len(word)
+	return end == len(code) || !isIdentByte(code[end])
+}
+
+func isIdentByte(c byte) bool {
+	return c == '_' || c == '$' || c >= 'a' && c <= 'z' || c >= 'A' && c <= 'Z' || c >= '0' && c <= '9'
+}
+
+func skipSpace(code string, i int) int {
+	for i < len(code) && strings.ContainsRune(" \t\r\n", rune(code[i])) {
+		i++
+	}
+	return i
+}
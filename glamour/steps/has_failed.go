@@ -9,28 +9,25 @@ import (
 	"github.com/tomaspiaggio/autonoma-hiring-ctf/common"
 )
 
-// HasFailedStep informs the user they have already failed today.
+// HasFailedStep informs the user they have to wait before trying again.
 type HasFailedStep struct {
 	BaseStep
-	targetTime     time.Time // Time when the user can try again (midnight)
+	targetTime     time.Time // Time when the user can try again, set by the cooldown policy
 	ticker         *time.Ticker
 	errorStyle     lipgloss.Style
 	infoStyle      lipgloss.Style
 	countdownStyle lipgloss.Style
 }
 
-// NewHasFailedStep creates a new HasFailedStep instance.
-func NewHasFailedStep(sm *StepManager) *HasFailedStep {
-	now := time.Now()
-	// Calculate the next midnight
-	midnight := time.Date(now.Year(), now.Month(), now.Day()+1, 0, 0, 0, 0, now.Location())
-	// Add a small buffer to avoid edge cases right at midnight
-	targetTime := midnight.Add(1 * time.Second)
+// NewHasFailedStep creates a new HasFailedStep instance counting down to the given time.
+func NewHasFailedStep(sm *StepManager, nextAttempt time.Time) *HasFailedStep {
+	// Add a small buffer to avoid edge cases right at the reset
+	targetTime := nextAttempt.Add(1 * time.Second)
 
 	ticker := time.NewTicker(1 * time.Second)
 
 	return &HasFailedStep{
-		BaseStep:   NewBaseStep("Try Again Tomorrow", sm),
+		BaseStep:   NewBaseStep("Try Again Later", sm),
 		targetTime: targetTime,
 		ticker:     ticker,
 		errorStyle: lipgloss.NewStyle().
@@ -55,7 +52,7 @@ func (s *HasFailedStep) Update(msg tea.Msg) (Step, tea.Cmd) {
 	switch msg.(type) {
 	case common.TickMsg:
 		// Check if the target time has been reached
-		if time.Now().After(s.targetTime) {
+		if s.sm.Now().After(s.targetTime) {
 			s.ticker.Stop()
 			return s, tea.Quit // Quit when countdown time is reached
 		}
@@ -69,28 +66,54 @@ func (s *HasFailedStep) Update(msg tea.Msg) (Step, tea.Cmd) {
 	return s, nil
 }
 
-// View displays the message and countdown.
-func (s *HasFailedStep) View() string {
-	// Calculate remaining time dynamically
-	remaining := time.Until(s.targetTime)
-	if remaining < 0 {
-		remaining = 0 // Ensure we don't show negative time
+// Title names the step after the time left, as the cooldown policy may make it hours or days.
+func (s *HasFailedStep) Title() string {
+	remaining := s.remaining()
+	switch {
+	case remaining > 48*time.Hour:
+		return fmt.Sprintf("Try Again in %d Days", units(remaining, 24*time.Hour))
+	case remaining >= 90*time.Minute:
+		return fmt.Sprintf("Try Again in %d Hours", units(remaining, time.Hour))
+	case remaining >= 90*time.Second:
+		return fmt.Sprintf("Try Again in %d Minutes", units(remaining, time.Minute))
 	}
+	return "Try Again in a Minute"
+}
 
-	hours := int(remaining.Hours())
+// View displays the message and countdown.
+func (s *HasFailedStep) View() string {
+	remaining := s.remaining()
+	days := int(remaining / (24 * time.Hour))
+	hours := int(remaining.Hours()) % 24
 	minutes := int(remaining.Minutes()) % 60
 	seconds := int(remaining.Seconds()) % 60
 	timeLeftStr := fmt.Sprintf("%02d:%02d:%02d", hours, minutes, seconds)
+	if days > 0 {
+		timeLeftStr = fmt.Sprintf("%dd %s", days, timeLeftStr)
+	}
 
 	msg := s.errorStyle.Render("Access Denied!") + "\n\n"
-	msg += s.infoStyle.Render("You have already attempted the challenge today and did not succeed.") + "\n"
-	msg += s.infoStyle.Render("Please try again tomorrow.") + "\n\n"
+	msg += s.infoStyle.Render("You have recently attempted the challenge and did not succeed.") + "\n"
+	msg += s.infoStyle.Render("Please try again once the countdown is over.") + "\n\n"
 	msg += s.infoStyle.Render("Time until next attempt: ") + s.countdownStyle.Render(timeLeftStr) + "\n\n"
 	msg += s.infoStyle.Render("Press any key to exit.")
 
 	return "\n" + msg // Add some top padding
 }
 
+// remaining returns the time left until the user can try again, never negative
+func (s *HasFailedStep) remaining() time.Duration {
+	if remaining := s.targetTime.Sub(s.sm.Now()); remaining > 0 {
+		return remaining
+	}
+	return 0
+}
+
+// units returns how many units d lasts, to the nearest one
+func units(d time.Duration, unit time.Duration) int {
+	return int(d.Round(unit) / unit)
+}
+
 // IsCompleted indicates this is the final state for this session.
 func (s *HasFailedStep) IsCompleted() bool {
 	return true
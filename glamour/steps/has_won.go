@@ -23,7 +23,7 @@ type HasWonStep struct {
 func NewHasWonStep(sm *StepManager) *HasWonStep {
 	ticker := time.NewTicker(1 * time.Second)
 
-	now := time.Now()
+	now := sm.Now()
 	targetTime := now.Add(10 * time.Second)
 
 	return &HasWonStep{
@@ -51,10 +51,10 @@ func (s *HasWonStep) Init() tea.Cmd {
 
 // Update handles the countdown timer and key presses.
 func (s *HasWonStep) Update(msg tea.Msg) (Step, tea.Cmd) {
-	switch msg.(type) {
+	switch msg := msg.(type) {
 	case common.TickMsg:
 		// Check if the target time has been reached
-		if time.Now().After(s.targetTime) {
+		if s.sm.Now().After(s.targetTime) {
 			s.ticker.Stop()
 			return s, tea.Quit // Quit when countdown time is reached
 		}
@@ -62,7 +62,12 @@ func (s *HasWonStep) Update(msg tea.Msg) (Step, tea.Cmd) {
 		return s, nil
 	case tea.KeyMsg:
 		s.ticker.Stop()
-		return s, tea.Quit // Quit on any key press
+		if msg.String() == "l" {
+			// Winners can see how they rank and pick their leaderboard handle
+			leaderboard := NewLeaderboardStep(s.sm)
+			return leaderboard, leaderboard.Init()
+		}
+		return s, tea.Quit // Quit on any other key press
 	}
 	// Ignore other messages
 	return s, nil
@@ -70,7 +75,7 @@ func (s *HasWonStep) Update(msg tea.Msg) (Step, tea.Cmd) {
 
 // View displays the congratulatory message.
 func (s *HasWonStep) View() string {
-	remaining := time.Until(s.targetTime)
+	remaining := s.targetTime.Sub(s.sm.Now())
 	if remaining < 0 {
 		remaining = 0 // Ensure we don't show negative time
 	}
@@ -79,7 +84,7 @@ func (s *HasWonStep) View() string {
 	msg += s.infoStyle.Render("Our records show you have already successfully completed the CTF challenge.") + "\n"
 	msg += s.infoStyle.Render("There's no need to attempt it again. We hope to speak with you soon!") + "\n\n"
 	msg += s.countdownStyle.Render(fmt.Sprintf("Exiting in %d seconds...", countdown)) + "\n"
-	msg += s.infoStyle.Render("(Press any key to exit now)")
+	msg += s.infoStyle.Render("(Press l to see the leaderboard, or any other key to exit now)")
 
 	return "\n" + msg // Add some top padding
 }
@@ -0,0 +1,69 @@
+package steps
+
+import (
+	tea "github.com/charmbracelet/bubbletea"
+	"github.com/charmbracelet/lipgloss"
+)
+
+// KeyMismatchStep informs the user their email is bound to another SSH key,
+// or that they need to connect with a key at all.
+type KeyMismatchStep struct {
+	BaseStep
+	fingerprint string
+	errorStyle  lipgloss.Style
+	infoStyle   lipgloss.Style
+	keyStyle    lipgloss.Style
+}
+
+// NewKeyMismatchStep creates a new KeyMismatchStep instance.
+// An empty fingerprint means the user connected without a public key.
+func NewKeyMismatchStep(sm *StepManager, fingerprint string) *KeyMismatchStep {
+	return &KeyMismatchStep{
+		BaseStep:    NewBaseStep("SSH Key Required", sm),
+		fingerprint: fingerprint,
+		errorStyle: lipgloss.NewStyle().
+			Foreground(lipgloss.Color("#FF5F87")). // Red/Pink
+			Bold(true),
+		infoStyle: lipgloss.NewStyle().
+			Foreground(lipgloss.Color("#FAFAFA")), // White
+		keyStyle: lipgloss.NewStyle().
+			Foreground(lipgloss.Color("#FFFF00")), // Yellow
+	}
+}
+
+// Init marks the step as completed, it is the only step of the session.
+func (s *KeyMismatchStep) Init() tea.Cmd {
+	s.completed = true
+	return nil
+}
+
+// Update quits on any key press.
+func (s *KeyMismatchStep) Update(msg tea.Msg) (Step, tea.Cmd) {
+	if _, ok := msg.(tea.KeyMsg); ok {
+		return s, tea.Quit
+	}
+	return s, nil
+}
+
+// View explains why the user can't play with this key.
+func (s *KeyMismatchStep) View() string {
+	msg := s.errorStyle.Render("Access Denied!") + "\n\n"
+	if s.fingerprint == "" {
+		msg += s.infoStyle.Render("You connected without an SSH key. Each email is tied to the key it first played with,") + "\n"
+		msg += s.infoStyle.Render("so we need one to know it's you. Create one with ") + s.keyStyle.Render("ssh-keygen -t ed25519") + "\n"
+		msg += s.infoStyle.Render("and connect again.") + "\n\n"
+	} else {
+		msg += s.infoStyle.Render("This email is tied to a different SSH key than the one you connected with:") + "\n"
+		msg += s.keyStyle.Render(s.fingerprint) + "\n\n"
+		msg += s.infoStyle.Render("Connect with the key you used the first time. If you lost it, email us from this") + "\n"
+		msg += s.infoStyle.Render("address and we'll re-link it to your new key.") + "\n\n"
+	}
+	msg += s.infoStyle.Render("Press any key to exit.")
+
+	return "\n" + msg
+}
+
+// IsCompleted indicates this is the final state for this session.
+func (s *KeyMismatchStep) IsCompleted() bool {
+	return true
+}
@@ -0,0 +1,184 @@
+package steps
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"strings"
+	"time"
+
+	"github.com/charmbracelet/bubbles/textinput"
+	tea "github.com/charmbracelet/bubbletea"
+	"github.com/charmbracelet/lipgloss"
+	"github.com/tomaspiaggio/autonoma-hiring-ctf/database"
+)
+
+// LeaderboardSize is how many entries the TUI shows
+const LeaderboardSize = 10
+
+var (
+	leaderboardHeaderStyle = lipgloss.NewStyle().Foreground(lipgloss.Color("#7D56F4")).Bold(true)
+	leaderboardRowStyle    = lipgloss.NewStyle().Foreground(lipgloss.Color("#FAFAFA"))
+	leaderboardOwnStyle    = lipgloss.NewStyle().Foreground(lipgloss.Color("#FFD700")).Bold(true)
+	leaderboardEmptyStyle  = lipgloss.NewStyle().Foreground(lipgloss.Color("#626262")).Italic(true)
+)
+
+// RenderLeaderboard renders the entries as a table, highlighting the row of the given handle
+func RenderLeaderboard(entries []database.LeaderboardEntry, highlight string) string {
+	if len(entries) == 0 {
+		return leaderboardEmptyStyle.Render("No one made it to the leaderboard yet. Will you be the first?")
+	}
+
+	width := len("HANDLE")
+	for _, e := range entries {
+		width = max(width, len(e.Handle))
+	}
+
+	rows := []string{leaderboardHeaderStyle.Render(fmt.Sprintf("%-4s %-*s  %s", "#", width, "HANDLE", "TIME"))}
+	for _, e := range entries {
+		style := leaderboardRowStyle
+		if highlight != "" && strings.EqualFold(e.Handle, highlight) {
+			style = leaderboardOwnStyle
+		}
+		rows = append(rows, style.Render(fmt.Sprintf("%-4d %-*s  %s", e.Rank, width, e.Handle, e.Duration.Round(time.Second))))
+	}
+	return strings.Join(rows, "\n")
+}
+
+// LeaderboardStep shows the fastest winners and lets a winner pick the public handle
+// they are listed with. Emails are never shown.
+type LeaderboardStep struct {
+	BaseStep
+	entries    []database.LeaderboardEntry
+	handle     string
+	input      textinput.Model
+	message    string
+	errorMsg   string
+	titleStyle lipgloss.Style
+	infoStyle  lipgloss.Style
+	errorStyle lipgloss.Style
+}
+
+// NewLeaderboardStep creates a new LeaderboardStep instance for the winner with the manager's email
+func NewLeaderboardStep(sm *StepManager) *LeaderboardStep {
+	ti := textinput.New()
+	ti.Placeholder = "your-handle"
+	ti.CharLimit = 20
+	ti.Width = 24
+	ti.Prompt = "Handle: "
+	ti.Focus()
+
+	s := &LeaderboardStep{
+		BaseStep: NewBaseStep("Leaderboard", sm),
+		input:    ti,
+		titleStyle: lipgloss.NewStyle().
+			Bold(true).
+			Foreground(lipgloss.Color("#4CAF50")). // Green
+			Padding(1, 0),
+		infoStyle: lipgloss.NewStyle().
+			Foreground(lipgloss.Color("#FAFAFA")), // White
+		errorStyle: lipgloss.NewStyle().
+			Foreground(lipgloss.Color("#FF5F87")), // Red/Pink
+	}
+
+	if sm.db != nil {
+		handle, err := sm.db.DisplayHandle(sm.Email)
+		if err != nil {
+			log.Printf("Error loading the display handle of %s: %v\n", sm.Email, err)
+		}
+		s.handle = handle
+		s.input.SetValue(handle)
+	}
+	s.load()
+	return s
+}
+
+// load refreshes the leaderboard entries
+func (s *LeaderboardStep) load() {
+	if s.sm.db == nil {
+		return
+	}
+	entries, err := s.sm.db.Leaderboard(LeaderboardSize)
+	if err != nil {
+		log.Printf("Error loading the leaderboard: %v\n", err)
+		s.errorMsg = "The leaderboard is not available right now."
+		return
+	}
+	s.entries = entries
+}
+
+// Init marks the step as completed, it is the only step of the session.
+func (s *LeaderboardStep) Init() tea.Cmd {
+	s.completed = true
+	return textinput.Blink
+}
+
+// Update saves the handle on enter and quits on esc.
+func (s *LeaderboardStep) Update(msg tea.Msg) (Step, tea.Cmd) {
+	if msg, ok := msg.(tea.KeyMsg); ok {
+		switch msg.String() {
+		case "esc":
+			return s, tea.Quit
+		case "enter":
+			s.save(strings.TrimSpace(s.input.Value()))
+			return s, nil
+		}
+	}
+
+	var cmd tea.Cmd
+	s.input, cmd = s.input.Update(msg)
+	return s, cmd
+}
+
+// save stores the handle, an empty one takes the winner off the leaderboard
+func (s *LeaderboardStep) save(handle string) {
+	s.message, s.errorMsg = "", ""
+	if s.sm.db == nil {
+		return
+	}
+
+	err := s.sm.db.SetDisplayHandle(s.sm.Email, handle)
+	switch {
+	case errors.Is(err, database.ErrInvalidHandle):
+		s.errorMsg = "Handles are 3 to 20 letters, digits, dashes or underscores."
+		return
+	case errors.Is(err, database.ErrHandleTaken):
+		s.errorMsg = "That handle is already taken."
+		return
+	case err != nil:
+		log.Printf("Error saving the display handle of %s: %v\n", s.sm.Email, err)
+		s.errorMsg = "We couldn't save your handle. Please try again."
+		return
+	}
+
+	s.handle = handle
+	if handle == "" {
+		s.message = "You're off the leaderboard."
+	} else {
+		s.message = fmt.Sprintf("Saved! You're on the leaderboard as %s.", handle)
+	}
+	s.load()
+}
+
+// View renders the leaderboard and the handle prompt.
+func (s *LeaderboardStep) View() string {
+	msg := s.titleStyle.Render("🏆 Fastest Completions 🏆") + "\n\n"
+	msg += RenderLeaderboard(s.entries, s.handle) + "\n\n"
+	msg += s.infoStyle.Render("Pick a public handle to be listed with, your email is never shown.") + "\n"
+	msg += s.infoStyle.Render("Leave it empty to stay off the leaderboard.") + "\n\n"
+	msg += s.input.View() + "\n"
+	if s.errorMsg != "" {
+		msg += s.errorStyle.Render(s.errorMsg) + "\n"
+	}
+	if s.message != "" {
+		msg += s.infoStyle.Render(s.message) + "\n"
+	}
+	msg += "\n" + s.infoStyle.Render("Press enter to save, esc to exit.")
+
+	return "\n" + msg
+}
+
+// IsCompleted indicates this is the final state for this session.
+func (s *LeaderboardStep) IsCompleted() bool {
+	return true
+}
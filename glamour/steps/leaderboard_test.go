@@ -0,0 +1,69 @@
+package steps
+
+import (
+	"strings"
+	"testing"
+	"time"
+
+	tea "github.com/charmbracelet/bubbletea"
+	"github.com/tomaspiaggio/autonoma-hiring-ctf/harness"
+)
+
+func TestLeaderboardStep(t *testing.T) {
+	sm, _, store := newTestManager(t)
+	win := func(email string, took time.Duration, handle string) {
+		t.Helper()
+		if _, err := store.CreateAttempt(email, false, map[string]interface{}{"time": took}); err != nil {
+			t.Fatal(err)
+		}
+		if handle == "" {
+			return
+		}
+		if err := store.SetDisplayHandle(email, handle); err != nil {
+			t.Fatal(err)
+		}
+	}
+	win("ada@example.com", 25*time.Minute+3*time.Second, "ada")
+	win("linus@example.com", 12*time.Minute, "")
+	win(testEmail, 18*time.Minute+40*time.Second, "")
+
+	s := NewLeaderboardStep(sm)
+	s.Init()
+	if strings.Contains(s.View(), "linus") || strings.Contains(s.View(), "candidate") {
+		t.Fatal("only winners with a handle should be listed")
+	}
+
+	tests := []struct {
+		handle  string
+		wantMsg string
+	}{
+		{handle: "a!", wantMsg: "Handles are 3 to 20 letters, digits, dashes or underscores."},
+		{handle: "ADA", wantMsg: "That handle is already taken."},
+		{handle: "speedy", wantMsg: "Saved! You're on the leaderboard as speedy."},
+	}
+	var step Step = s
+	for _, tt := range tests {
+		s.input.SetValue("")
+		step, _ = harness.Run(step, harness.Type(tt.handle+"\n")...)
+		if !strings.Contains(step.View(), tt.wantMsg) {
+			t.Errorf("saving %q: expected %q in\n%s", tt.handle, tt.wantMsg, step.View())
+		}
+	}
+	harness.Golden(t, "leaderboard", step.View())
+
+	_, cmds := harness.Run(step, harness.Key(tea.KeyEsc))
+	if !harness.Quits(cmds...) {
+		t.Fatal("expected esc to quit")
+	}
+}
+
+func TestHasWonStepOpensLeaderboard(t *testing.T) {
+	sm, _, _ := newTestManager(t)
+	step, cmds := harness.Run(Step(NewHasWonStep(sm)), harness.Type("l")...)
+	if harness.Quits(cmds...) {
+		t.Fatal("expected l to open the leaderboard instead of quitting")
+	}
+	if _, ok := step.(*LeaderboardStep); !ok {
+		t.Fatalf("expected the leaderboard, got %T", step)
+	}
+}
@@ -0,0 +1,112 @@
+package steps
+
+import (
+	"bytes"
+	_ "embed"
+	"encoding/json"
+	"fmt"
+	"os"
+	"time"
+)
+
+//go:embed packs/default.json
+var defaultPackJSON []byte
+
+// Pack describes an ordered challenge flow and the content of each step
+type Pack struct {
+	Name  string     `json:"name"`
+	Steps []StepSpec `json:"steps"`
+}
+
+// StepSpec describes a single step of a pack
+type StepSpec struct {
+	// Type is the ID the step kind was registered under (see Register)
+	Type string `json:"type"`
+
+	// Title optionally overrides the default tab title of the step
+	Title string `json:"title,omitempty"`
+
+	// Params holds the step specific configuration
+	Params json.RawMessage `json:"params,omitempty"`
+}
+
+// Duration is a time.Duration that reads from JSON strings like "1m30s"
+type Duration time.Duration
+
+// UnmarshalJSON parses a duration string
+func (d *Duration) UnmarshalJSON(data []byte) error {
+	var s string
+	if err := json.Unmarshal(data, &s); err != nil {
+		return err
+	}
+	parsed, err := time.ParseDuration(s)
+	if err != nil {
+		return err
+	}
+	*d = Duration(parsed)
+	return nil
+}
+
+// MarshalJSON writes the duration as a string
+func (d Duration) MarshalJSON() ([]byte, error) {
+	return json.Marshal(time.Duration(d).String())
+}
+
+// DefaultPack returns the pack that ships embedded in the binary
+func DefaultPack() *Pack {
+	pack, err := ParsePack(defaultPackJSON)
+	if err != nil {
+		panic(fmt.Sprintf("invalid embedded challenge pack: %v", err))
+	}
+	return pack
+}
+
+// LoadPack reads a pack from the given path. An empty path returns the default pack.
+func LoadPack(path string) (*Pack, error) {
+	if path == "" {
+		return DefaultPack(), nil
+	}
+
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("reading challenge pack: %w", err)
+	}
+
+	pack, err := ParsePack(data)
+	if err != nil {
+		return nil, fmt.Errorf("parsing challenge pack %s: %w", path, err)
+	}
+	return pack, nil
+}
+
+// ParsePack decodes and validates a pack
+func ParsePack(data []byte) (*Pack, error) {
+	var pack Pack
+	if err := json.Unmarshal(data, &pack); err != nil {
+		return nil, err
+	}
+	if len(pack.Steps) == 0 {
+		return nil, fmt.Errorf("pack %q has no steps", pack.Name)
+	}
+
+	// Build once against a throwaway manager so content errors surface at load time
+	if _, err := pack.Build(NewStepManager(nil, time.Now(), nil)); err != nil {
+		return nil, err
+	}
+	return &pack, nil
+}
+
+// Build creates the steps described by the pack
+func (p *Pack) Build(sm *StepManager) ([]Step, error) {
+	return sm.BuildFlow(p.Steps)
+}
+
+// decodeParams unmarshals step params, rejecting unknown fields to catch typos in packs
+func decodeParams(params json.RawMessage, v interface{}) error {
+	if len(params) == 0 {
+		return nil
+	}
+	dec := json.NewDecoder(bytes.NewReader(params))
+	dec.DisallowUnknownFields()
+	return dec.Decode(v)
+}
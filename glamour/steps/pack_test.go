@@ -0,0 +1,86 @@
+package steps
+
+import (
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestDefaultPackBuilds(t *testing.T) {
+	sm, _, _ := newTestManager(t)
+	allSteps, err := GenerateSteps(sm, DefaultPack())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	titles := []string{}
+	for _, step := range allSteps {
+		titles = append(titles, step.Title())
+	}
+	want := "Wordle Challenge|Password Game|Math Challenge|Async JavaScript Debugging|Grid Navigation Challenge|Challenge Completed!"
+	if got := strings.Join(titles, "|"); got != want {
+		t.Errorf("unexpected flow:\n got %s\nwant %s", got, want)
+	}
+}
+
+func TestParsePack(t *testing.T) {
+	tests := []struct {
+		name    string
+		pack    string
+		wantErr string
+	}{
+		{
+			name: "title override",
+			pack: `{"name": "s2", "steps": [{"type": "end", "title": "Done"}]}`,
+		},
+		{
+			name:    "no steps",
+			pack:    `{"name": "empty", "steps": []}`,
+			wantErr: "has no steps",
+		},
+		{
+			name:    "unknown type",
+			pack:    `{"name": "s2", "steps": [{"type": "sudoku"}]}`,
+			wantErr: `unknown step type "sudoku"`,
+		},
+		{
+			name:    "unknown param",
+			pack:    `{"name": "s2", "steps": [{"type": "wordle", "params": {"wrods": []}}]}`,
+			wantErr: "unknown field",
+		},
+		{
+			name:    "invalid content",
+			pack:    `{"name": "s2", "steps": [{"type": "wordle", "params": {"words": [{"word": "tango!"}]}}]}`,
+			wantErr: "must be 5 lowercase letters",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			pack, err := ParsePack([]byte(tt.pack))
+			if tt.wantErr == "" {
+				if err != nil {
+					t.Fatal(err)
+				}
+				allSteps, err := pack.Build(NewStepManager(nil, time.Now(), nil))
+				if err != nil {
+					t.Fatal(err)
+				}
+				if allSteps[0].Title() != "Done" {
+					t.Errorf("expected title override, got %q", allSteps[0].Title())
+				}
+				return
+			}
+			if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+				t.Fatalf("expected error containing %q, got %v", tt.wantErr, err)
+			}
+		})
+	}
+}
+
+func TestRegisteredTypes(t *testing.T) {
+	want := "async,end,grid,math,password,wordle"
+	if got := strings.Join(Types(), ","); got != want {
+		t.Errorf("got %s, want %s", got, want)
+	}
+}
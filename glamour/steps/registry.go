@@ -0,0 +1,94 @@
+package steps
+
+import (
+	"encoding/json"
+	"fmt"
+	"sort"
+	"sync"
+)
+
+// Factory builds a step from the raw params of a pack entry
+type Factory func(sm *StepManager, params json.RawMessage) (Step, error)
+
+var (
+	registryMu sync.RWMutex
+	registry   = map[string]Factory{}
+)
+
+// Register makes a step type available to packs under the given ID.
+// Step types living in other packages call this from an init function,
+// and get linked in with a blank import from main.
+// It panics if the ID is empty or already registered.
+func Register(id string, factory Factory) {
+	registryMu.Lock()
+	defer registryMu.Unlock()
+
+	if id == "" || factory == nil {
+		panic("steps: Register called with an empty id or nil factory")
+	}
+	if _, dup := registry[id]; dup {
+		panic("steps: Register called twice for step type " + id)
+	}
+	registry[id] = factory
+}
+
+// RegisterType registers a constructor that takes a typed config.
+// The pack params are decoded into C, rejecting unknown fields.
+func RegisterType[C any](id string, build func(sm *StepManager, cfg C) (Step, error)) {
+	Register(id, func(sm *StepManager, params json.RawMessage) (Step, error) {
+		var cfg C
+		if err := decodeParams(params, &cfg); err != nil {
+			return nil, err
+		}
+		return build(sm, cfg)
+	})
+}
+
+// lookup returns the factory registered under the given ID
+func lookup(id string) (Factory, bool) {
+	registryMu.RLock()
+	defer registryMu.RUnlock()
+	factory, ok := registry[id]
+	return factory, ok
+}
+
+// Types returns the sorted IDs of all registered step types
+func Types() []string {
+	registryMu.RLock()
+	defer registryMu.RUnlock()
+
+	ids := make([]string, 0, len(registry))
+	for id := range registry {
+		ids = append(ids, id)
+	}
+	sort.Strings(ids)
+	return ids
+}
+
+// Build creates a single step of the given registered type
+func (sm *StepManager) Build(id string, params json.RawMessage) (Step, error) {
+	factory, ok := lookup(id)
+	if !ok {
+		return nil, fmt.Errorf("unknown step type %q", id)
+	}
+	return factory(sm, params)
+}
+
+// BuildFlow creates the steps described by the specs, in order
+func (sm *StepManager) BuildFlow(specs []StepSpec) ([]Step, error) {
+	result := make([]Step, 0, len(specs))
+	for i, spec := range specs {
+		sm.buildIndex = i
+		step, err := sm.Build(spec.Type, spec.Params)
+		if err != nil {
+			return nil, fmt.Errorf("step %d (%s): %w", i+1, spec.Type, err)
+		}
+		if spec.Title != "" {
+			if t, ok := step.(interface{ setTitle(string) }); ok {
+				t.setTitle(spec.Title)
+			}
+		}
+		result = append(result, step)
+	}
+	return result, nil
+}
@@ -0,0 +1,205 @@
+package steps
+
+import (
+	"errors"
+	"fmt"
+	"math"
+	"runtime"
+	"runtime/metrics"
+	"sync"
+	"time"
+
+	"github.com/dop251/goja"
+)
+
+// Limits applied to candidate code. They are variables so tests can tighten them.
+var (
+	// evalTimeout is the wall-clock budget of a single evaluation
+	evalTimeout = 2 * time.Second
+
+	// evalMaxCallStack is the maximum depth of nested JavaScript calls
+	evalMaxCallStack = 1024
+
+	// evalMaxAlloc is how many bytes the allocating builtins may hand out during an evaluation
+	evalMaxAlloc int64 = 32 << 20
+
+	// evalMaxHeapGrowth is how far the process heap may grow during an evaluation. It is a
+	// backstop for allocations the builtins don't see, such as concatenating strings in a loop.
+	// The ssh app is limited to 128M, and the growth is measured on the whole process, so
+	// concurrent evaluations share this ceiling instead of adding up to more than it.
+	evalMaxHeapGrowth uint64 = 16 << 20
+
+	// evalPollInterval is how often the watchdog samples the heap. Doubling a string takes a
+	// few milliseconds, so a slower poll would let it overshoot the ceiling several times over.
+	evalPollInterval = time.Millisecond
+)
+
+var (
+	// ErrEvalTimeout is returned when candidate code runs past evalTimeout
+	ErrEvalTimeout = errors.New("evaluation timed out")
+
+	// ErrEvalMemory is returned when candidate code allocates past evalMaxAlloc or grows the heap past evalMaxHeapGrowth
+	ErrEvalMemory = errors.New("evaluation used too much memory")
+)
+
+// heapMetric is the heap in use, which runtime/metrics reads without stopping the world
+const heapMetric = "/memory/classes/heap/objects:bytes"
+
+// heapInUse returns the bytes taken by live and not yet swept heap objects
+func heapInUse() uint64 {
+	samples := []metrics.Sample{{Name: heapMetric}}
+	metrics.Read(samples)
+	return samples[0].Value.Uint64()
+}
+
+// runSandboxed runs fn against a fresh runtime and interrupts it when it hits the
+// deadline or a memory limit. The call stack limit makes runaway recursion throw a
+// RangeError instead of growing the Go stack.
+//
+// Builtins that allocate in proportion to an argument, such as String.prototype.repeat,
+// the Array constructor and typed arrays, are charged against a per-evaluation budget
+// before they run, so a single huge allocation is refused deterministically. The heap
+// watchdog only catches what slips past them, and is shared by concurrent evaluations.
+func runSandboxed(fn func(vm *goja.Runtime) error) error {
+	return runSandboxedUntil(time.Now().Add(evalTimeout), fn)
+}
+
+// watchdogProcs makes sure the watchdog can run while candidate code keeps a processor busy.
+// With a single one, it only gets to sample the heap when the evaluation is preempted, every
+// 10ms or so, which is enough for a doubling string to get past the ceiling several times over.
+var watchdogProcs sync.Once
+
+// runSandboxedUntil is runSandboxed with a deadline shared by several evaluations
+func runSandboxedUntil(deadline time.Time, fn func(vm *goja.Runtime) error) error {
+	watchdogProcs.Do(func() {
+		if runtime.GOMAXPROCS(0) < 2 {
+			runtime.GOMAXPROCS(2)
+		}
+	})
+
+	vm := goja.New()
+	vm.SetMaxCallStackSize(evalMaxCallStack)
+	if err := meterAllocations(vm, evalMaxAlloc); err != nil {
+		return err
+	}
+
+	baseline := heapInUse()
+	done := make(chan struct{})
+	watchdogDone := make(chan struct{})
+	go func() {
+		defer close(watchdogDone)
+
+		timeout := time.NewTimer(time.Until(deadline))
+		defer timeout.Stop()
+		poll := time.NewTicker(evalPollInterval)
+		defer poll.Stop()
+
+		for {
+			select {
+			case <-done:
+				return
+			case <-timeout.C:
+				vm.Interrupt(ErrEvalTimeout)
+				return
+			case <-poll.C:
+				if heap := heapInUse(); heap > baseline && heap-baseline > evalMaxHeapGrowth {
+					vm.Interrupt(ErrEvalMemory)
+					return
+				}
+			}
+		}
+	}()
+
+	err := fn(vm)
+	close(done)
+	<-watchdogDone
+
+	var interrupted *goja.InterruptedError
+	if errors.As(err, &interrupted) {
+		if reason, ok := interrupted.Value().(error); ok {
+			return reason
+		}
+	}
+	return err
+}
+
+// meteredBuiltins wraps the builtins that allocate in proportion to an argument so they
+// charge the bytes they are about to take first. Sizes are rough: a character is a byte
+// and an array element 16. The originals stay private to the closure.
+const meteredBuiltins = `
+(function (charge) {
+	const define = (obj, name, value) => Object.defineProperty(obj, name, { value, writable: true, configurable: true });
+	const meter = (obj, name, size) => {
+		const original = obj[name];
+		define(obj, name, function (...args) {
+			charge(size(this, args));
+			return original.apply(this, args);
+		});
+	};
+	const guard = (name, size) => {
+		const original = globalThis[name];
+		const guarded = new Proxy(original, {
+			apply: (target, self, args) => { charge(size(args, original)); return Reflect.apply(target, self, args); },
+			construct: (target, args, newTarget) => { charge(size(args, original)); return Reflect.construct(target, args, newTarget); },
+		});
+		define(original.prototype, "constructor", guarded);
+		define(globalThis, name, guarded);
+	};
+
+	meter(String.prototype, "repeat", (s, [count]) => String(s).length * count);
+	meter(String.prototype, "padStart", (s, [length]) => length);
+	meter(String.prototype, "padEnd", (s, [length]) => length);
+	meter(Array.prototype, "fill", (a) => a.length * 16);
+	meter(Array.prototype, "join", (a, [sep]) => a.length * (sep === undefined ? 1 : String(sep).length));
+
+	guard("Array", (args) => args.length === 1 && typeof args[0] === "number" ? args[0] * 16 : 0);
+	guard("ArrayBuffer", ([length]) => length);
+	for (const name of ["Int8Array", "Uint8Array", "Uint8ClampedArray", "Int16Array", "Uint16Array", "Int32Array",
+		"Uint32Array", "Float32Array", "Float64Array", "BigInt64Array", "BigUint64Array"]) {
+		guard(name, ([length], original) => typeof length === "number" ? length * original.BYTES_PER_ELEMENT : 0);
+	}
+})
+`
+
+// meterAllocations installs meteredBuiltins with a budget of the given bytes. Going over it
+// interrupts the runtime, so candidate code can't catch the error and carry on.
+func meterAllocations(vm *goja.Runtime, budget int64) error {
+	install, err := vm.RunString(meteredBuiltins)
+	if err != nil {
+		return err
+	}
+	fn, ok := goja.AssertFunction(install)
+	if !ok {
+		return errors.New("metered builtins must evaluate to a function")
+	}
+
+	left := float64(budget)
+	charge := func(call goja.FunctionCall) goja.Value {
+		// Sizes that aren't finite and positive make the original throw or allocate nothing
+		bytes := call.Argument(0).ToFloat()
+		if math.IsNaN(bytes) || math.IsInf(bytes, 0) || bytes <= 0 {
+			return goja.Undefined()
+		}
+		if left -= bytes; left < 0 {
+			vm.Interrupt(ErrEvalMemory)
+			panic(vm.NewGoError(ErrEvalMemory))
+		}
+		return goja.Undefined()
+	}
+	_, err = fn(goja.Undefined(), vm.ToValue(charge))
+	return err
+}
+
+// describeEvalError turns an evaluation error into a message for the candidate
+func describeEvalError(err error) string {
+	var overflow *goja.StackOverflowError
+	switch {
+	case errors.As(err, &overflow):
+		return fmt.Sprintf("Your code went deeper than %d nested calls. Look for recursion without a base case.", evalMaxCallStack)
+	case errors.Is(err, ErrEvalTimeout):
+		return fmt.Sprintf("Your code timed out after %s. Look for infinite loops or promises that never settle.", evalTimeout)
+	case errors.Is(err, ErrEvalMemory):
+		return "Your code used too much memory and was stopped."
+	}
+	return "JavaScript error: " + err.Error()
+}
@@ -0,0 +1,144 @@
+package steps
+
+import (
+	"errors"
+	"runtime"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/dop251/goja"
+)
+
+// tightLimits shrinks the sandbox limits for the duration of a test
+func tightLimits(t *testing.T) {
+	t.Helper()
+	timeout, alloc := evalTimeout, evalMaxAlloc
+	evalTimeout, evalMaxAlloc = 200*time.Millisecond, 256<<10
+	t.Cleanup(func() {
+		evalTimeout, evalMaxAlloc = timeout, alloc
+	})
+}
+
+func TestRunSandboxedLimits(t *testing.T) {
+	tightLimits(t)
+
+	tests := []struct {
+		name    string
+		code    string
+		wantMsg string
+	}{
+		{name: "infinite loop", code: `while (true) {}`, wantMsg: "Your code timed out after 200ms"},
+		{name: "recursion", code: `function f() { return f() } f()`, wantMsg: "deeper than 1024 nested calls"},
+		{name: "memory", code: `const a = []; while (true) { a.push("x".repeat(1 << 16) + a.length) }`, wantMsg: "too much memory"},
+		{name: "huge string", code: `"x".repeat(1e8)`, wantMsg: "too much memory"},
+		{name: "huge array", code: `new Array(1e8).fill(0)`, wantMsg: "too much memory"},
+		{name: "sparse array", code: `const a = []; a.length = 1e8; a.fill(0)`, wantMsg: "too much memory"},
+		{name: "huge buffer", code: `new Uint8Array(1e9)`, wantMsg: "too much memory"},
+		{name: "caught", code: `try { "x".repeat(1e8) } catch (e) {} while (true) {}`, wantMsg: "too much memory"},
+		{name: "throws", code: `throw new Error("boom")`, wantMsg: "JavaScript error: Error: boom"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			start := time.Now()
+			err := runSandboxed(func(vm *goja.Runtime) error {
+				_, err := vm.RunString(tt.code)
+				return err
+			})
+			if err == nil {
+				t.Fatal("expected an error")
+			}
+			if msg := describeEvalError(err); !strings.Contains(msg, tt.wantMsg) {
+				t.Errorf("got %q, want it to contain %q", msg, tt.wantMsg)
+			}
+			if elapsed := time.Since(start); elapsed > 2*time.Second {
+				t.Errorf("evaluation took %v", elapsed)
+			}
+		})
+	}
+}
+
+func TestRunSandboxedStopsUnmeteredGrowth(t *testing.T) {
+	// Production limits: these allocations aren't metered, only the heap watchdog sees them
+	tests := []struct {
+		name string
+		code string
+	}{
+		{name: "doubling string", code: `let s = "x"; for (;;) s += s`},
+		{name: "array from length", code: `Array.from({ length: 1e8 })`},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			runtime.GC()
+			baseline := heapInUse()
+			var peak uint64
+			err := runSandboxed(func(vm *goja.Runtime) error {
+				stop := make(chan struct{})
+				sampled := make(chan struct{})
+				go func() {
+					defer close(sampled)
+					for {
+						if heap := heapInUse(); heap > peak {
+							peak = heap
+						}
+						select {
+						case <-stop:
+							return
+						case <-time.After(time.Millisecond):
+						}
+					}
+				}()
+				_, err := vm.RunString(tt.code)
+				close(stop)
+				<-sampled
+				return err
+			})
+			if !errors.Is(err, ErrEvalMemory) {
+				t.Fatalf("expected ErrEvalMemory, got %v", err)
+			}
+			// Short of the 128M the ssh app gets, garbage included
+			if growth := peak - baseline; peak > baseline && growth >= 128<<20 {
+				t.Errorf("the heap grew by %dM before the evaluation stopped", growth>>20)
+			}
+		})
+	}
+}
+
+func TestRunSandboxedAllowsSmallAllocations(t *testing.T) {
+	tightLimits(t)
+	code := `
+		class Grid extends Array {}
+		const rows = Array.from({ length: 3 }, (_, i) => new Array(3).fill(i));
+		const typed = new Uint8Array(4);
+		[rows.join(";"), "ab".repeat(2).padStart(6, "-"), new Grid(2) instanceof Grid, typed.length, [].constructor === Array].join(" ")
+	`
+	var got string
+	err := runSandboxed(func(vm *goja.Runtime) error {
+		v, err := vm.RunString(code)
+		if err == nil {
+			got = v.String()
+		}
+		return err
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := "0,0,0;1,1,1;2,2,2 --abab true 4 true"; got != want {
+		t.Errorf("got %q, want %q", got, want)
+	}
+}
+
+func TestStep4InfiniteLoopTimesOut(t *testing.T) {
+	tightLimits(t)
+	sm, _, _ := newTestManager(t)
+	s := newAsyncChallenge(t, sm)
+	s.textarea.SetValue(`async function fetchUserData(users) { while (true) {} }`)
+
+	step := submitAsync(t, s)
+
+	if step.IsCompleted() || !strings.Contains(s.errorMsg, "timed out") {
+		t.Fatalf("expected a timeout, got %q", s.errorMsg)
+	}
+}
@@ -0,0 +1,200 @@
+package steps
+
+import (
+	"encoding/json"
+	"errors"
+	"strings"
+	"testing"
+	"time"
+
+	tea "github.com/charmbracelet/bubbletea"
+	"github.com/golang-jwt/jwt/v5"
+	"github.com/tomaspiaggio/autonoma-hiring-ctf/email"
+	"github.com/tomaspiaggio/autonoma-hiring-ctf/harness"
+)
+
+func TestFailedStepCountsDownAndQuits(t *testing.T) {
+	sm, clock, _ := newTestManager(t)
+	s := NewFailedStep(2, 7*time.Minute+5*time.Second, "You've run out of guesses!", sm)
+	s.Init()
+	harness.Golden(t, "failed", s.View())
+
+	script := []tea.Msg{}
+	for i := 0; i < 9; i++ {
+		script = append(script, clock.Tick(time.Second))
+	}
+	step, _ := harness.Run(Step(s), script...)
+	_, cmds := harness.Run(step, clock.Tick(time.Second))
+	if !harness.Quits(cmds...) {
+		t.Fatal("expected the failed screen to quit after its countdown")
+	}
+}
+
+func TestHasFailedStep(t *testing.T) {
+	sm, clock, _ := newTestManager(t)
+	s := NewHasFailedStep(sm, clock.Now().Add(20*time.Hour))
+	s.Init()
+	harness.Golden(t, "has_failed", s.View())
+	if got := s.Title(); got != "Try Again in 20 Hours" {
+		t.Errorf("got title %q for a 20 hour wait", got)
+	}
+
+	step, cmds := harness.Run(Step(s), clock.Tick(time.Hour))
+	if harness.Quits(cmds...) {
+		t.Fatal("quit before the cooldown was over")
+	}
+	_, cmds = harness.Run(step, harness.Type("q")...)
+	if !harness.Quits(cmds...) {
+		t.Fatal("expected any key to quit")
+	}
+}
+
+func TestHasFailedStepShowsTheWait(t *testing.T) {
+	tests := []struct {
+		wait      time.Duration
+		title     string
+		countdown string
+	}{
+		{wait: 7 * 24 * time.Hour, title: "Try Again in 7 Days", countdown: "7d 00:00:01"},
+		{wait: 36 * time.Hour, title: "Try Again in 36 Hours", countdown: "1d 12:00:01"},
+		{wait: time.Hour, title: "Try Again in 60 Minutes", countdown: "01:00:01"},
+		{wait: 30 * time.Minute, title: "Try Again in 30 Minutes", countdown: "00:30:01"},
+		{wait: 0, title: "Try Again in a Minute", countdown: "00:00:01"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.wait.String(), func(t *testing.T) {
+			sm, clock, _ := newTestManager(t)
+			s := NewHasFailedStep(sm, clock.Now().Add(tt.wait))
+			if got := s.Title(); got != tt.title {
+				t.Errorf("got title %q, want %q", got, tt.title)
+			}
+			if view := s.View(); !strings.Contains(view, "Time until next attempt: "+tt.countdown) {
+				t.Errorf("expected a countdown of %s, got\n%s", tt.countdown, view)
+			}
+		})
+	}
+}
+
+func TestHasWonStep(t *testing.T) {
+	sm, clock, _ := newTestManager(t)
+	s := NewHasWonStep(sm)
+	s.Init()
+	harness.Golden(t, "has_won", s.View())
+
+	_, cmds := harness.Run(Step(s), clock.Tick(11*time.Second))
+	if !harness.Quits(cmds...) {
+		t.Fatal("expected the screen to quit after 10 seconds")
+	}
+}
+
+func TestKeyMismatchStep(t *testing.T) {
+	sm, _, _ := newTestManager(t)
+	s := NewKeyMismatchStep(sm, "SHA256:uZ0cvNwLk2z8Sx0b0Jv4b1a8tWc2I7nP0mJx9Q7yQ5k")
+	s.Init()
+	harness.Golden(t, "key_mismatch", s.View())
+
+	harness.Golden(t, "key_missing", NewKeyMismatchStep(sm, "").View())
+
+	_, cmds := harness.Run(Step(s), harness.Type("q")...)
+	if !harness.Quits(cmds...) {
+		t.Fatal("expected any key to quit")
+	}
+}
+
+func TestEndStepView(t *testing.T) {
+	sm, _, _ := newTestManager(t)
+	s := NewEndStep(sm, EndConfig{})
+	s.Init()
+	if !s.IsCompleted() {
+		t.Fatal("expected the end step to complete on init")
+	}
+	harness.Golden(t, "end", s.View())
+}
+
+func TestEndStepSignsToken(t *testing.T) {
+	sm, clock, _ := newTestManager(t)
+	s := NewEndStep(sm, EndConfig{CalLink: "https://cal.example.com/tom", FollowMe: "@tom"})
+
+	var claims JWTCustomClaims
+	if err := sm.Keys.Verify(s.jwtToken, &claims, jwt.WithTimeFunc(clock.Now)); err != nil {
+		t.Fatalf("expected the token to verify with the manager's keys, got %v", err)
+	}
+	if claims.GoToThisLink != "https://cal.example.com/tom" || claims.FollowMe != "@tom" || claims.Key != s.generatedKey {
+		t.Errorf("unexpected claims %+v", claims)
+	}
+
+	// Without keys the token can't be signed
+	sm.Keys = nil
+	if s := NewEndStep(sm, EndConfig{}); !strings.HasPrefix(s.jwtToken, "Error generating token") {
+		t.Errorf("expected an error instead of a token, got %q", s.jwtToken)
+	}
+}
+
+func TestEndStepQueuesEmail(t *testing.T) {
+	sm, _, store := newTestManager(t)
+	if err := sm.StartSession(DefaultPack()); err != nil {
+		t.Fatal(err)
+	}
+	s := NewEndStep(sm, EndConfig{})
+	s.Init()
+
+	// Only the first key press sends it, and a session never queues it twice
+	harness.Run(Step(s), harness.Type("abc")...)
+	sm.EmailSent = false
+	harness.Run(Step(s), harness.Type("d")...)
+	sm.Wait()
+
+	outbox := store.Outbox()
+	if len(outbox) != 1 {
+		t.Fatalf("expected a single queued email, got %+v", outbox)
+	}
+	e := outbox[0]
+	if e.Kind != email.EndEmailKind || e.Recipient != testEmail || e.IdempotencyKey != "end:"+sm.SessionID {
+		t.Errorf("unexpected email %+v", e)
+	}
+	var payload email.EndEmail
+	if err := json.Unmarshal(e.Payload, &payload); err != nil || payload.Token != s.jwtToken || payload.Campaign != "default" {
+		t.Errorf("expected the payload to carry the token and the pack, got %s (%v)", e.Payload, err)
+	}
+}
+
+func TestEndStepEndsTheSession(t *testing.T) {
+	sm, clock, _ := newTestManager(t)
+	if err := sm.StartSession(DefaultPack()); err != nil {
+		t.Fatal(err)
+	}
+	s := NewEndStep(sm, EndConfig{})
+	s.Init()
+
+	step, _ := harness.Run(Step(s), harness.Type("a")...)
+	step, _ = harness.Run(step, clock.Tick(endShownFor-time.Second))
+	if sm.Done {
+		t.Fatal("expected the end screen to stay up")
+	}
+	harness.Run(step, clock.Tick(time.Second))
+	if !sm.Done || sm.StepFailed {
+		t.Errorf("expected the session to be done without failing, got done=%v failed=%v", sm.Done, sm.StepFailed)
+	}
+	sm.Wait()
+}
+
+func TestEndStepSendsEmailWhenQueueingFails(t *testing.T) {
+	sm, _, store := newTestManager(t)
+	if err := sm.StartSession(DefaultPack()); err != nil {
+		t.Fatal(err)
+	}
+	mailer := &harness.FakeMailer{}
+	sm.Mailer = mailer
+	s := NewEndStep(sm, EndConfig{})
+	s.Init()
+
+	store.SetErr(errors.New("database is down"))
+	harness.Run(Step(s), harness.Type("a")...)
+	sm.Wait()
+
+	sent := mailer.Sent()
+	if len(sent) != 1 || sent[0].To != testEmail || !strings.Contains(sent[0].HTML, s.jwtToken) {
+		t.Errorf("expected the email to be sent through the mailer, got %+v", sent)
+	}
+}
@@ -0,0 +1,29 @@
+package steps
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/binary"
+	"strings"
+)
+
+// DeriveSeed returns the session seed for a candidate's attempt.
+// It is an HMAC of the email and attempt ID keyed with a server secret, so candidates
+// can't predict their content while admins can regenerate it from the stored seed.
+func DeriveSeed(secret []byte, email string, attemptID string) int64 {
+	mac := hmac.New(sha256.New, secret)
+	mac.Write([]byte(strings.ToLower(email)))
+	mac.Write([]byte{0})
+	mac.Write([]byte(attemptID))
+	return int64(binary.BigEndian.Uint64(mac.Sum(nil)[:8]))
+}
+
+// StepSeed returns the seed for the step currently being built.
+// Each position in the flow gets its own seed so steps don't share a random sequence.
+func (sm *StepManager) StepSeed() int64 {
+	// splitmix64 finalizer, spreads consecutive indexes over the whole range
+	z := uint64(sm.Seed) + uint64(sm.buildIndex+1)*0x9e3779b97f4a7c15
+	z = (z ^ (z >> 30)) * 0xbf58476d1ce4e5b9
+	z = (z ^ (z >> 27)) * 0x94d049bb133111eb
+	return int64(z ^ (z >> 31))
+}
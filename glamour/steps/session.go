@@ -0,0 +1,343 @@
+package steps
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"log"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/tomaspiaggio/autonoma-hiring-ctf/database"
+	"github.com/tomaspiaggio/autonoma-hiring-ctf/email"
+	"github.com/tomaspiaggio/autonoma-hiring-ctf/webhook"
+)
+
+// checkpointInterval is how often an unchanged session is still saved, to keep it resumable
+const checkpointInterval = 10 * time.Second
+
+// Stateful is implemented by steps whose progress survives a reconnect
+type Stateful interface {
+	// SaveState returns the progress of the step
+	SaveState() (json.RawMessage, error)
+
+	// RestoreState restores progress previously returned by SaveState
+	RestoreState(state json.RawMessage) error
+}
+
+// Snapshot is the persisted progress of a session. The pack is stored with it
+// so a resumed session keeps its content even if a new pack was shipped meanwhile.
+type Snapshot struct {
+	Pack        *Pack             `json:"pack"`
+	CurrentStep int               `json:"current_step"`
+	Steps       []json.RawMessage `json:"steps"`
+}
+
+// StartSession builds the flow from the pack and stores a new session for it.
+// The session ID doubles as the attempt ID the content seed is derived from.
+// The challenge clock starts now, and the start time is stored so the deadline survives reconnects.
+func (sm *StepManager) StartSession(pack *Pack) error {
+	sessionID := uuid.NewString()
+	sm.Seed = DeriveSeed(sm.SeedSecret, sm.Email, sessionID)
+
+	allSteps, err := GenerateSteps(sm, pack)
+	if err != nil {
+		return err
+	}
+
+	sm.Steps = allSteps
+	sm.CurrentStep = 0
+	sm.pack = pack
+	sm.SessionID = sessionID
+	sm.startTime = sm.Now()
+
+	if sm.db != nil {
+		if err := sm.db.CreateSession(sm.SessionID, sm.Email, sm.startTime, sm.Seed); err != nil {
+			// The candidate can still play, they just won't be able to resume
+			log.Printf("Error creating session for %s: %v\n", sm.Email, err)
+		}
+	}
+	sm.enterStep(nil)
+	return nil
+}
+
+// Restore rebuilds the flow and progress of a stored session
+func (sm *StepManager) Restore(session *database.Session) error {
+	var snap Snapshot
+	if err := json.Unmarshal(session.State, &snap); err != nil {
+		return fmt.Errorf("decoding session state: %w", err)
+	}
+	if snap.Pack == nil {
+		return fmt.Errorf("session %s has no pack", session.ID)
+	}
+
+	sm.Seed = session.Seed
+	allSteps, err := GenerateSteps(sm, snap.Pack)
+	if err != nil {
+		return err
+	}
+	if snap.CurrentStep < 0 || snap.CurrentStep >= len(allSteps) || len(snap.Steps) > len(allSteps) {
+		return fmt.Errorf("session %s does not match its pack", session.ID)
+	}
+
+	for i, state := range snap.Steps {
+		if i < snap.CurrentStep {
+			if c, ok := allSteps[i].(interface{ MarkCompleted() }); ok {
+				c.MarkCompleted()
+			}
+		}
+		stateful, ok := allSteps[i].(Stateful)
+		if !ok || len(state) == 0 {
+			continue
+		}
+		if err := stateful.RestoreState(state); err != nil {
+			return fmt.Errorf("restoring step %d: %w", i+1, err)
+		}
+	}
+
+	sm.Steps = allSteps
+	sm.CurrentStep = snap.CurrentStep
+	sm.pack = snap.Pack
+	sm.SessionID = session.ID
+	sm.startTime = session.StartedAt
+	sm.enterStep(map[string]interface{}{"resumed": true})
+	return nil
+}
+
+// Replay rebuilds the content a session started with, from its seed and stored pack.
+// The fallback pack is used for sessions that never checkpointed. Progress is not restored.
+func (sm *StepManager) Replay(session *database.Session, fallback *Pack) error {
+	pack := fallback
+	if len(session.State) > 0 {
+		var snap Snapshot
+		if err := json.Unmarshal(session.State, &snap); err != nil {
+			return fmt.Errorf("decoding session state: %w", err)
+		}
+		if snap.Pack != nil {
+			pack = snap.Pack
+		}
+	}
+
+	sm.Email = session.Email
+	sm.Seed = session.Seed
+	allSteps, err := GenerateSteps(sm, pack)
+	if err != nil {
+		return err
+	}
+	sm.Steps = allSteps
+	sm.CurrentStep = 0
+	return nil
+}
+
+// snapshot captures the progress of every step
+func (sm *StepManager) snapshot() (Snapshot, error) {
+	snap := Snapshot{
+		Pack:        sm.pack,
+		CurrentStep: sm.CurrentStep,
+		Steps:       make([]json.RawMessage, len(sm.Steps)),
+	}
+	for i, step := range sm.Steps {
+		stateful, ok := step.(Stateful)
+		if !ok {
+			continue
+		}
+		state, err := stateful.SaveState()
+		if err != nil {
+			return snap, fmt.Errorf("saving step %d: %w", i+1, err)
+		}
+		snap.Steps[i] = state
+	}
+	return snap, nil
+}
+
+// Checkpoint stores the session progress when it changed, and at least every
+// checkpointInterval so the session stays within the resume grace window
+func (sm *StepManager) Checkpoint() {
+	if sm.db == nil || sm.SessionID == "" || sm.pack == nil || sm.StepFailed || sm.Done {
+		return
+	}
+
+	snap, err := sm.snapshot()
+	if err != nil {
+		log.Printf("Error taking snapshot of session %s: %v\n", sm.SessionID, err)
+		return
+	}
+	data, err := json.Marshal(snap)
+	if err != nil {
+		log.Printf("Error encoding session %s: %v\n", sm.SessionID, err)
+		return
+	}
+	if bytes.Equal(data, sm.lastSaved) && sm.Now().Sub(sm.savedAt) < checkpointInterval {
+		return
+	}
+
+	sm.lastSaved = data
+	sm.savedAt = sm.Now()
+	sm.saveSeq++
+	seq, id, currentStep := sm.saveSeq, sm.SessionID, sm.CurrentStep
+
+	go func() {
+		sm.persistMu.Lock()
+		defer sm.persistMu.Unlock()
+
+		// A newer checkpoint already made it to the database
+		if seq < sm.persistedSeq {
+			return
+		}
+		if err := sm.db.SaveSession(id, currentStep, data); err != nil {
+			log.Printf("Error saving session %s: %v\n", id, err)
+			return
+		}
+		sm.persistedSeq = seq
+	}()
+}
+
+// FinishSession marks the session as over so it can't be resumed
+func (sm *StepManager) FinishSession() {
+	if sm.db == nil || sm.SessionID == "" {
+		return
+	}
+	id := sm.SessionID
+	go func() {
+		if err := sm.db.FinishSession(id); err != nil {
+			log.Printf("Error finishing session %s: %v\n", id, err)
+		}
+	}()
+}
+
+// RecordAttempt stores how the session ended as its attempt. Only the first outcome counts, so a
+// winner leaving or the deadline passing afterwards can't overwrite it. Won and failed sessions are
+// finished; abandoned ones are marked as disconnected so they can be resumed, and their attempt is
+// replaced when the resumed session ends. Sessions that never started, like the pre-check screens
+// and replays, record nothing.
+func (sm *StepManager) RecordAttempt(outcome database.Outcome) {
+	sm.recordAttempt(outcome, nil)
+}
+
+// recordWin records the session as won and queues the end email with the completion token
+func (sm *StepManager) recordWin(token string) {
+	sm.recordAttempt(database.OutcomeWon, sm.endEmail(token))
+}
+
+// recordAttempt records the outcome and, once it is stored, dispatches its webhook and runs onRecorded.
+// Nothing is sent when the session already had a final attempt, which happens when another connection
+// ended it first.
+func (sm *StepManager) recordAttempt(outcome database.Outcome, onRecorded func()) {
+	sm.recordMu.Lock()
+	defer sm.recordMu.Unlock()
+	if sm.db == nil || sm.SessionID == "" || sm.recorded {
+		return
+	}
+	sm.recorded = true
+
+	// the json has the last step that was reached, the time it took, the failure message and the seed
+	id, email, details, at, hooks := sm.SessionID, sm.Email, sm.AttemptDetails(), sm.Now(), sm.Webhooks
+	sm.recording.Add(1)
+	go func() {
+		defer sm.recording.Done()
+		attemptID, err := sm.db.SaveAttempt(id, email, outcome, details)
+		if err != nil {
+			// Still notified, a database outage shouldn't cost the candidate their email
+			log.Printf("Error saving %s attempt for %s: %v\n", outcome, email, err)
+		} else if attemptID == -1 {
+			return
+		}
+		if outcome == database.OutcomeAbandoned {
+			// Only resumable once the abandoned attempt is stored, so the resumed outcome replaces it
+			if err := sm.db.DisconnectSession(id); err != nil {
+				log.Printf("Error marking session %s as disconnected: %v\n", id, err)
+			}
+		}
+		hooks.Dispatch(attemptEvent(outcome, email, at, details))
+		if onRecorded != nil {
+			onRecorded()
+		}
+	}()
+	if outcome != database.OutcomeAbandoned {
+		sm.FinishSession()
+	}
+}
+
+// attemptEventTypes are the webhook events sent for each outcome
+var attemptEventTypes = map[database.Outcome]webhook.EventType{
+	database.OutcomeWon:       webhook.AttemptWon,
+	database.OutcomeFailed:    webhook.AttemptFailed,
+	database.OutcomeAbandoned: webhook.AttemptAbandoned,
+}
+
+// attemptEvent returns the webhook event of an attempt from its details
+func attemptEvent(outcome database.Outcome, email string, at time.Time, details map[string]interface{}) webhook.Event {
+	step, _ := details["step"].(int)
+	took, _ := details["time"].(time.Duration)
+	msg, _ := details["msg"].(string)
+	sessionID, _ := details["session_id"].(string)
+	return webhook.NewEvent(attemptEventTypes[outcome], at, webhook.AttemptData{
+		Email:      email,
+		SessionID:  sessionID,
+		Step:       step + 1,
+		Duration:   took.Round(time.Second).String(),
+		DurationMs: took.Milliseconds(),
+		Message:    msg,
+	})
+}
+
+// endEmail returns a function queueing the email with the last challenge for the outbox worker to deliver.
+// It is keyed by the session, so a session never sends it twice. If it can't be queued,
+// it is sent right away through the manager's mailer instead.
+func (sm *StepManager) endEmail(token string) func() {
+	key, to, mailer, templates := "end:"+sm.SessionID, sm.Email, sm.Mailer, sm.EmailTemplates
+	end := email.EndEmail{Token: token}
+	if sm.pack != nil {
+		end.Campaign = sm.pack.Name
+	}
+	return func() {
+		_, err := sm.db.EnqueueEmail(key, email.EndEmailKind, to, end)
+		if err == nil {
+			return
+		}
+		log.Printf("Error enqueueing end email for %s: %v\n", to, err)
+		if mailer == nil {
+			return
+		}
+		if err := email.SendEndEmail(mailer, templates, key, to, end); err != nil {
+			log.Printf("Error sending end email for %s: %v\n", to, err)
+		}
+	}
+}
+
+// Abandon is called once the connection is gone. A session that hasn't recorded its outcome yet
+// is recorded as abandoned, or as won if the candidate left on the final screen, in which case
+// the end email is queued as the end step would have.
+func (sm *StepManager) Abandon() {
+	if sm.Finished() {
+		sm.recordWin(sm.endToken)
+		return
+	}
+	sm.RecordAttempt(database.OutcomeAbandoned)
+}
+
+// Wait blocks until the recorded attempt, step events and queued emails reached the database
+func (sm *StepManager) Wait() {
+	sm.recording.Wait()
+}
+
+// AttemptDetails returns the details stored with an attempt: the last step reached, the time it
+// took, the failure message, and the session and seed needed to regenerate its content
+func (sm *StepManager) AttemptDetails() map[string]interface{} {
+	step := sm.CurrentStep
+	if sm.StepFailed {
+		step = sm.stepReached
+	}
+	return map[string]interface{}{
+		"step":       step,
+		"time":       sm.Now().Sub(sm.startTime),
+		"msg":        sm.FailureMsg,
+		"session_id": sm.SessionID,
+		"seed":       sm.Seed,
+	}
+}
+
+// StartTime returns when the session started
+func (sm *StepManager) StartTime() time.Time {
+	return sm.startTime
+}
@@ -0,0 +1,253 @@
+package steps
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/tomaspiaggio/autonoma-hiring-ctf/database"
+	"github.com/tomaspiaggio/autonoma-hiring-ctf/email"
+	"github.com/tomaspiaggio/autonoma-hiring-ctf/harness"
+	"github.com/tomaspiaggio/autonoma-hiring-ctf/webhook"
+)
+
+func TestCheckpointAndRestore(t *testing.T) {
+	sm, clock, store := newTestManager(t)
+	if err := sm.StartSession(DefaultPack()); err != nil {
+		t.Fatal(err)
+	}
+
+	// Solve the wordle and type part of a password
+	wordle := sm.Steps[0].(*Step1)
+	for _, msg := range harness.Type(wordle.answer + "abc") {
+		sm.UpdateCurrentStep(msg)
+	}
+	clock.Advance(2 * time.Minute)
+	sm.Checkpoint()
+
+	harness.Eventually(t, func() bool {
+		session := store.Session(sm.SessionID)
+		return session != nil && session.CurrentStep == 1
+	}, "checkpoint saved")
+
+	// Sessions are only resumable once their connection is gone
+	if session, _ := store.ClaimResumableSession(testEmail, 10*time.Minute); session != nil {
+		t.Fatalf("live session %s was resumable", session.ID)
+	}
+	sm.Abandon()
+	sm.Wait()
+
+	session, err := store.ClaimResumableSession(testEmail, 10*time.Minute)
+	if err != nil || session == nil {
+		t.Fatalf("expected a resumable session, got %v %v", session, err)
+	}
+	if again, _ := store.ClaimResumableSession(testEmail, 10*time.Minute); again != nil {
+		t.Fatalf("session %s was resumed by two connections", again.ID)
+	}
+
+	restored, _, _ := newTestManager(t)
+	if err := restored.Restore(session); err != nil {
+		t.Fatal(err)
+	}
+	if restored.CurrentStep != 1 || restored.SessionID != sm.SessionID {
+		t.Fatalf("restored to step %d of session %s", restored.CurrentStep+1, restored.SessionID)
+	}
+	if !restored.StartTime().Equal(sm.StartTime()) {
+		t.Errorf("start time changed from %v to %v", sm.StartTime(), restored.StartTime())
+	}
+	if got := restored.Steps[1].(*Step2).input.Value(); got != "abc" {
+		t.Errorf("expected the password to be restored, got %q", got)
+	}
+	if !restored.Steps[0].IsCompleted() {
+		t.Error("expected the wordle to be marked completed")
+	}
+}
+
+func TestFinishedSessionIsNotResumable(t *testing.T) {
+	sm, _, store := newTestManager(t)
+	if err := sm.StartSession(DefaultPack()); err != nil {
+		t.Fatal(err)
+	}
+	sm.Checkpoint()
+	harness.Eventually(t, func() bool {
+		return store.Session(sm.SessionID).State != nil
+	}, "checkpoint saved")
+
+	sm.FinishSession()
+	harness.Eventually(t, func() bool {
+		return store.Session(sm.SessionID).Finished
+	}, "session finished")
+
+	if err := store.DisconnectSession(sm.SessionID); err != nil {
+		t.Fatal(err)
+	}
+	if session, _ := store.ClaimResumableSession(testEmail, time.Hour); session != nil {
+		t.Fatalf("finished session %s was resumable", session.ID)
+	}
+}
+
+func TestRecordAttemptKeepsFirstOutcome(t *testing.T) {
+	sm, _, store := newTestManager(t)
+	if err := sm.StartSession(DefaultPack()); err != nil {
+		t.Fatal(err)
+	}
+
+	// Failing or leaving afterwards must not turn the win into a failure
+	sm.RecordAttempt(database.OutcomeWon)
+	sm.SetFailedStep("")
+	sm.RecordAttempt(database.OutcomeFailed)
+	sm.Abandon()
+	sm.Wait()
+
+	attempts := store.Attempts()
+	if len(attempts) != 1 || attempts[0].Failed {
+		t.Fatalf("expected a single win, got %+v", attempts)
+	}
+	harness.Eventually(t, func() bool {
+		return store.Session(sm.SessionID).Finished
+	}, "session finished")
+}
+
+func TestDisconnectOnEndScreenSendsTheEndEmail(t *testing.T) {
+	sm, _, store := newTestManager(t)
+	if err := sm.StartSession(DefaultPack()); err != nil {
+		t.Fatal(err)
+	}
+	sm.CurrentStep = len(sm.Steps) - 1
+	sm.Init()
+	end := sm.Steps[sm.CurrentStep].(*EndStep)
+
+	// The candidate leaves before the end screen got a message
+	sm.Abandon()
+	sm.Wait()
+
+	if attempts := store.Attempts(); len(attempts) != 1 || attempts[0].Failed || attempts[0].Abandoned {
+		t.Fatalf("expected a single win, got %+v", attempts)
+	}
+	outbox := store.Outbox()
+	if len(outbox) != 1 || outbox[0].IdempotencyKey != "end:"+sm.SessionID {
+		t.Fatalf("expected the end email to be queued, got %+v", outbox)
+	}
+	var payload email.EndEmail
+	if err := json.Unmarshal(outbox[0].Payload, &payload); err != nil || payload.Token != end.jwtToken {
+		t.Errorf("expected the end step's token, got %s (%v)", outbox[0].Payload, err)
+	}
+}
+
+func TestRecordedSessionSendsNothingAgain(t *testing.T) {
+	events := make(chan webhook.Event, 10)
+	receiver := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		events <- webhook.Event{}
+	}))
+	defer receiver.Close()
+
+	// Another connection to the same session failed it first
+	sm, _, store := newTestManager(t)
+	if err := sm.StartSession(DefaultPack()); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := store.SaveAttempt(sm.SessionID, testEmail, database.OutcomeFailed, nil); err != nil {
+		t.Fatal(err)
+	}
+
+	sm.Webhooks = webhook.New([]string{receiver.URL}, []byte("shh"))
+	sm.recordWin("jwt")
+	sm.Wait()
+	sm.Webhooks.Wait()
+
+	if len(events) != 0 {
+		t.Errorf("expected no webhook for the ignored win, got %d", len(events))
+	}
+	if outbox := store.Outbox(); len(outbox) != 0 {
+		t.Errorf("expected no end email for the ignored win, got %+v", outbox)
+	}
+	if attempts := store.Attempts(); len(attempts) != 1 || !attempts[0].Failed {
+		t.Errorf("expected the failure to stand, got %+v", attempts)
+	}
+}
+
+func TestRecordAttemptDispatchesWebhook(t *testing.T) {
+	tests := []struct {
+		name     string
+		end      func(sm *StepManager)
+		wantType webhook.EventType
+		wantMsg  string
+	}{
+		{
+			name:     "won",
+			end:      func(sm *StepManager) { sm.RecordAttempt(database.OutcomeWon) },
+			wantType: webhook.AttemptWon,
+		},
+		{
+			name: "failed",
+			end: func(sm *StepManager) {
+				sm.SetFailedStep("Wrong answer")
+				sm.RecordAttempt(database.OutcomeFailed)
+			},
+			wantType: webhook.AttemptFailed,
+			wantMsg:  "Wrong answer",
+		},
+		{
+			name:     "abandoned",
+			end:      func(sm *StepManager) { sm.Abandon() },
+			wantType: webhook.AttemptAbandoned,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			events := make(chan webhook.Event, 10)
+			receiver := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				var event webhook.Event
+				if err := json.NewDecoder(r.Body).Decode(&event); err != nil {
+					t.Error(err)
+				}
+				events <- event
+			}))
+			defer receiver.Close()
+
+			sm, clock, _ := newTestManager(t)
+			sm.Webhooks = webhook.New([]string{receiver.URL}, []byte("shh"))
+			if err := sm.StartSession(DefaultPack()); err != nil {
+				t.Fatal(err)
+			}
+			clock.Advance(3 * time.Minute)
+			tt.end(sm)
+			sm.Abandon()
+			sm.Wait()
+			sm.Webhooks.Wait()
+
+			if len(events) != 1 {
+				t.Fatalf("got %d events, want 1", len(events))
+			}
+			event := <-events
+			want := webhook.AttemptData{
+				Email:      sm.Email,
+				SessionID:  sm.SessionID,
+				Step:       1,
+				Duration:   "3m0s",
+				DurationMs: 180_000,
+				Message:    tt.wantMsg,
+			}
+			if event.Type != tt.wantType || event.Data != want {
+				t.Errorf("got %s %+v, want %s %+v", event.Type, event.Data, tt.wantType, want)
+			}
+		})
+	}
+}
+
+func TestDeriveSeed(t *testing.T) {
+	secret := []byte("secret")
+	a := DeriveSeed(secret, "Candidate@Example.com", "attempt-1")
+	if a != DeriveSeed(secret, "candidate@example.com", "attempt-1") {
+		t.Error("seed should not depend on email case")
+	}
+	if a == DeriveSeed(secret, "candidate@example.com", "attempt-2") {
+		t.Error("seed should depend on the attempt")
+	}
+	if a == DeriveSeed([]byte("other"), "candidate@example.com", "attempt-1") {
+		t.Error("seed should depend on the secret")
+	}
+}
@@ -1,10 +1,15 @@
 package steps
 
 import (
+	"sync"
 	"time"
 
 	tea "github.com/charmbracelet/bubbletea"
+	"github.com/tomaspiaggio/autonoma-hiring-ctf/common"
 	"github.com/tomaspiaggio/autonoma-hiring-ctf/database"
+	"github.com/tomaspiaggio/autonoma-hiring-ctf/email"
+	"github.com/tomaspiaggio/autonoma-hiring-ctf/tokens"
+	"github.com/tomaspiaggio/autonoma-hiring-ctf/webhook"
 )
 
 // Step represents a challenge step in the CTF
@@ -56,30 +61,71 @@ func (b *BaseStep) MarkCompleted() {
 	b.completed = true
 }
 
+// setTitle overrides the step title, used by packs
+func (b *BaseStep) setTitle(title string) {
+	b.title = title
+}
+
 // StepManager handles progression between steps
 type StepManager struct {
-	Steps       []Step
-	CurrentStep int
-	startTime   time.Time
-	StepFailed  bool
-	Email       string
-	EmailSent   bool
-	db          *database.DB
-	FailureMsg  string
-}
-
-// NewStepManager creates a new step manager with the given steps
-func NewStepManager(steps []Step, startTime time.Time, db *database.DB) *StepManager {
+	Steps          []Step
+	CurrentStep    int
+	startTime      time.Time
+	TimeLimit      time.Duration
+	StepFailed     bool
+	Done           bool // Set once a won session showed its end screen, the TUI quits
+	Email          string
+	EmailSent      bool
+	Clock          common.Clock
+	db             database.Store
+	FailureMsg     string
+	SessionID      string
+	Seed           int64
+	SeedSecret     []byte
+	Webhooks       *webhook.Dispatcher
+	Mailer         email.Mailer
+	EmailTemplates *email.Templates
+	Keys           *tokens.Keyring
+	pack           *Pack
+	endToken       string // Signed by the end step, for a winner who disconnects before it records the win
+	buildIndex     int
+	stepReached    int
+
+	// Checkpoint bookkeeping
+	lastSaved    []byte
+	savedAt      time.Time
+	saveSeq      int
+	persistedSeq int
+	persistMu    sync.Mutex
+
+	// Attempt bookkeeping, a session records a single outcome
+	recordMu  sync.Mutex
+	recorded  bool
+	recording sync.WaitGroup
+
+	// stepEnteredAt is when the current step became current, to time its events
+	stepEnteredAt time.Time
+}
+
+// NewStepManager creates a new step manager with the given steps.
+// With a nil db nothing is recorded or sent, which is how replays run.
+func NewStepManager(steps []Step, startTime time.Time, db database.Store) *StepManager {
 	return &StepManager{
 		Steps:       steps,
 		CurrentStep: 0,
 		startTime:   startTime,
 		StepFailed:  false,
 		EmailSent:   false,
+		Clock:       common.SystemClock{},
 		db:          db,
 	}
 }
 
+// Now returns the current time according to the manager's clock
+func (sm *StepManager) Now() time.Time {
+	return sm.Clock.Now()
+}
+
 // CurrentStepView returns the view of the current step
 func (sm *StepManager) CurrentStepView() string {
 	if sm.CurrentStep < len(sm.Steps) {
@@ -100,7 +146,9 @@ func (sm *StepManager) UpdateCurrentStep(msg tea.Msg) tea.Cmd {
 
 		// Check if the current step is completed
 		if sm.Steps[sm.CurrentStep].IsCompleted() && sm.CurrentStep < len(sm.Steps)-1 {
+			sm.RecordEvent(database.EventPass, nil)
 			sm.CurrentStep++
+			sm.enterStep(nil)
 			// Initialize the next step
 			return tea.Batch(cmd, sm.Steps[sm.CurrentStep].Init())
 		}
@@ -115,35 +163,52 @@ func (sm *StepManager) SetCurrentStep(step int) {
 }
 
 func (sm *StepManager) SetFailedStep(failureMsg string) {
+	sm.failStep(database.EventFail, failureMsg)
+}
+
+// SetTimedOutStep fails the session because the step or the whole challenge ran out of time
+func (sm *StepManager) SetTimedOutStep(failureMsg string) {
+	sm.failStep(database.EventTimeout, failureMsg)
+}
+
+// failStep replaces the flow with the failure screen, recording why the current step failed
+func (sm *StepManager) failStep(kind database.EventKind, failureMsg string) {
+	sm.RecordEvent(kind, map[string]interface{}{"msg": failureMsg})
 	stepReached := sm.CurrentStep
-	timeTaken := time.Since(sm.startTime)
+	timeTaken := sm.Now().Sub(sm.startTime)
 	sm.Steps = []Step{
 		NewFailedStep(stepReached, timeTaken, failureMsg, sm),
 	}
+	// Kept for the attempt details, the flow is gone
+	sm.stepReached = stepReached
 	sm.CurrentStep = 0
 	sm.StepFailed = true
 	sm.FailureMsg = failureMsg
 }
 
+// Deadline returns when the challenge time runs out, counted from the persisted start time
+func (sm *StepManager) Deadline() time.Time {
+	return sm.startTime.Add(sm.TimeLimit)
+}
+
+// Finished reports whether the last step of the flow was reached and completed
+func (sm *StepManager) Finished() bool {
+	return len(sm.Steps) > 0 && sm.CurrentStep == len(sm.Steps)-1 && sm.Steps[sm.CurrentStep].IsCompleted()
+}
+
 func (sm *StepManager) GetCompletedSteps() int {
 	return sm.CurrentStep
 }
 
-// Init initializes the step manager
+// Init initializes the current step
 func (sm *StepManager) Init() tea.Cmd {
-	if len(sm.Steps) > 0 {
-		return sm.Steps[0].Init()
+	if sm.CurrentStep < len(sm.Steps) {
+		return sm.Steps[sm.CurrentStep].Init()
 	}
 	return nil
 }
 
-func GenerateSteps(sm *StepManager) []Step {
-	return []Step{
-		NewStep1(sm),
-		NewStep2(sm),
-		NewStep3(sm),
-		NewStep4(sm),
-		NewStep5(sm),
-		NewEndStep(sm),
-	}
+// GenerateSteps builds the challenge flow described by the given pack
+func GenerateSteps(sm *StepManager, pack *Pack) ([]Step, error) {
+	return pack.Build(sm)
 }
@@ -1,6 +1,7 @@
 package steps
 
 import (
+	"encoding/json"
 	"fmt"
 	"math/rand"
 	"strings"
@@ -8,6 +9,7 @@ import (
 
 	tea "github.com/charmbracelet/bubbletea"
 	"github.com/charmbracelet/lipgloss"
+	"github.com/tomaspiaggio/autonoma-hiring-ctf/database"
 )
 
 // Step1 is the first challenge step
@@ -26,12 +28,49 @@ type Step1 struct {
 	activeStyle    lipgloss.Style
 	highLight      lipgloss.Style
 	completed      bool
-	argentineWords []string
+	words          []string
 	hints          map[string]string
 }
 
+// Step1Config holds the word list for the Wordle challenge
+type Step1Config struct {
+	Words []WordleWord `json:"words"`
+}
+
+// WordleWord is a candidate answer and the acrostic hint shown for it
+type WordleWord struct {
+	Word string `json:"word"`
+	Hint string `json:"hint"`
+}
+
+func init() {
+	RegisterType("wordle", func(sm *StepManager, cfg Step1Config) (Step, error) {
+		step, err := NewStep1(sm, cfg)
+		if err != nil {
+			return nil, err
+		}
+		return step, nil
+	})
+}
+
 // NewStep1 creates a new Step1 instance
-func NewStep1(sm *StepManager) *Step1 {
+func NewStep1(sm *StepManager, cfg Step1Config) (*Step1, error) {
+	if len(cfg.Words) == 0 {
+		return nil, fmt.Errorf("wordle needs at least one word")
+	}
+
+	words := make([]string, 0, len(cfg.Words))
+	hints := make(map[string]string, len(cfg.Words))
+	for _, w := range cfg.Words {
+		if len(w.Word) != 5 || strings.IndexFunc(w.Word, func(r rune) bool { return r < 'a' || r > 'z' }) != -1 {
+			return nil, fmt.Errorf("wordle word %q must be 5 lowercase letters", w.Word)
+		}
+		words = append(words, w.Word)
+		if w.Hint != "" {
+			hints[w.Word] = w.Hint
+		}
+	}
+
 	correctStyle := lipgloss.NewStyle().
 		Bold(true).
 		Foreground(lipgloss.Color("#ffffff")).
@@ -78,36 +117,9 @@ func NewStep1(sm *StepManager) *Step1 {
 		Background(lipgloss.Color("#7D56F4")).
 		Padding(0, 1)
 
-	argentineWords := []string{
-		"asado", "birra", "morfi", "guita", "pibes",
-		"chori", "locro", "garca", "mango", "piola",
-		"yerba", "flaco", "posta", "amigo", "cheto",
-		"mates",
-	}
-
-	// Create hints map with Argentinian Spanish phrases
-	hints := map[string]string{
-		"asado": "Ahora salimos a disfrutar olores",
-		"birra": "Bajá inmediatamente Ricardo! Retrasas amigos",
-		"morfi": "Mirá, Oscar recién freía ingredientes",
-		"guita": "Gastamos últimamente ingresos tantos, amigo",
-		"pibes": "Papá invita bebidas esta semana",
-		"chori": "Compramos hamburguesas ¡o rica inquisición!",
-		"locro": "Llevamos ollas con rica ofrenda",
-		"garca": "Gastón ahora reclama comida ajena",
-		"mango": "Mamá anduvo negociando ganancias obvias",
-		"piola": "Pablo invita otra linda aventura",
-		"yerba": "Ya estamos reuniendo bebidas argentinas",
-		"flaco": "Fernando llegó a comprar ovejas",
-		"posta": "Pablo ordena sequía, tormenta aparece",
-		"amigo": "Alguien mencionó interesantes grandes obstáculos",
-		"cheto": "Cada hermano evita tomar ómnibus",
-		"mates": "Muchos argentinos toman esta semana",
-	}
-
-	// Seed random number generator
-	randInt := rand.New(rand.NewSource(time.Now().UnixNano()))
-	selectedWord := argentineWords[randInt.Intn(len(argentineWords))]
+	// Pick the word from the session seed so the attempt can be reproduced
+	randInt := rand.New(rand.NewSource(sm.StepSeed()))
+	selectedWord := words[randInt.Intn(len(words))]
 
 	return &Step1{
 		BaseStep:       NewBaseStep("Wordle Challenge", sm),
@@ -121,11 +133,11 @@ func NewStep1(sm *StepManager) *Step1 {
 		incorrectStyle: incorrectStyle,
 		emptyStyle:     emptyStyle,
 		activeStyle:    activeStyle,
-		argentineWords: argentineWords,
+		words:          words,
 		highLight:      highLight,
 		hints:          hints,
 		errorMsg:       "",
-	}
+	}, nil
 }
 
 // Init initializes the step
@@ -137,6 +149,10 @@ func (s *Step1) Init() tea.Cmd {
 func (s *Step1) submitGuess() {
 	// Store the guess
 	s.guesses[s.currentRow] = s.currentGuess
+	s.sm.RecordEvent(database.EventSubmit, map[string]interface{}{
+		"guess": s.currentGuess,
+		"row":   s.currentRow,
+	})
 
 	// Check if the guess is correct
 	if s.currentGuess == s.answer {
@@ -200,6 +216,39 @@ func (s *Step1) Update(msg tea.Msg) (Step, tea.Cmd) {
 	return s, nil
 }
 
+type step1State struct {
+	Answer       string   `json:"answer"`
+	Guesses      []string `json:"guesses"`
+	CurrentGuess string   `json:"current_guess"`
+	CurrentRow   int      `json:"current_row"`
+}
+
+// SaveState returns the answer and the guesses made so far
+func (s *Step1) SaveState() (json.RawMessage, error) {
+	return json.Marshal(step1State{
+		Answer:       s.answer,
+		Guesses:      s.guesses,
+		CurrentGuess: s.currentGuess,
+		CurrentRow:   s.currentRow,
+	})
+}
+
+// RestoreState restores the answer and the guesses made so far
+func (s *Step1) RestoreState(data json.RawMessage) error {
+	var state step1State
+	if err := json.Unmarshal(data, &state); err != nil {
+		return err
+	}
+	if len(state.Guesses) != s.maxGuesses || state.CurrentRow < 0 || state.CurrentRow >= s.maxGuesses {
+		return fmt.Errorf("invalid wordle state")
+	}
+	s.answer = state.Answer
+	s.guesses = state.Guesses
+	s.currentGuess = state.CurrentGuess
+	s.currentRow = state.CurrentRow
+	return nil
+}
+
 // View returns the view for this step
 func (s *Step1) View() string {
 	var sb strings.Builder
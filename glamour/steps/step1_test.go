@@ -0,0 +1,60 @@
+package steps
+
+import (
+	"testing"
+
+	tea "github.com/charmbracelet/bubbletea"
+	"github.com/tomaspiaggio/autonoma-hiring-ctf/harness"
+)
+
+func newWordle(t *testing.T, sm *StepManager, words ...string) *Step1 {
+	t.Helper()
+	cfg := Step1Config{}
+	for _, w := range words {
+		cfg.Words = append(cfg.Words, WordleWord{Word: w, Hint: "Hint for " + w})
+	}
+	s, err := NewStep1(sm, cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return s
+}
+
+func TestStep1Guesses(t *testing.T) {
+	sm, _, _ := newTestManager(t)
+	s := newWordle(t, sm, "asado")
+
+	step, _ := harness.Run(Step(s), harness.Type("BIRRA")...)
+	if s.currentRow != 1 || s.guesses[0] != "birra" {
+		t.Fatalf("expected birra as first guess, got row %d guesses %v", s.currentRow, s.guesses)
+	}
+	harness.Golden(t, "step1_one_guess", step.View())
+
+	step, _ = harness.Run(step, harness.Type("asa")...)
+	step, _ = harness.Run(step, harness.Key(tea.KeyBackspace))
+	step, _ = harness.Run(step, harness.Type("ado")...)
+	if !step.IsCompleted() {
+		t.Fatalf("expected the step to be completed, guesses %v", s.guesses)
+	}
+}
+
+func TestStep1WordComesFromSeed(t *testing.T) {
+	words := []string{"asado", "birra", "morfi", "guita", "pibes", "chori", "locro", "garca"}
+
+	sm, _, _ := newTestManager(t)
+	first := newWordle(t, sm, words...).answer
+	for i := 0; i < 5; i++ {
+		if got := newWordle(t, sm, words...).answer; got != first {
+			t.Fatalf("same seed picked %s and %s", first, got)
+		}
+	}
+
+	seen := map[string]bool{}
+	for seed := int64(0); seed < 20; seed++ {
+		sm.Seed = seed
+		seen[newWordle(t, sm, words...).answer] = true
+	}
+	if len(seen) < 2 {
+		t.Errorf("20 seeds always picked the same word")
+	}
+}
@@ -1,6 +1,7 @@
 package steps
 
 import (
+	"encoding/json"
 	"fmt"
 	"strconv"
 	"strings"
@@ -8,6 +9,7 @@ import (
 
 	"github.com/charmbracelet/bubbles/textinput"
 	tea "github.com/charmbracelet/bubbletea"
+	"github.com/tomaspiaggio/autonoma-hiring-ctf/database"
 )
 
 // Step2 is the password game challenge
@@ -25,8 +27,48 @@ type constraint struct {
 	validate    func(string) (bool, string)
 }
 
+// Step2Config holds the ordered password constraints
+type Step2Config struct {
+	Constraints []ConstraintSpec `json:"constraints"`
+}
+
+// ConstraintSpec declares a password rule. Kind selects the check:
+//
+//   - min_length: at least Min characters
+//   - contains_digit: at least one digit
+//   - contains_char: at least one rune from Chars
+//   - digit_sum: the digits add up to exactly Value
+//   - roman_sum: the Roman numeral letters add up to more than Min and less than Max
+//   - contains_any: contains one of Values, optionally ignoring case
+type ConstraintSpec struct {
+	Kind        string   `json:"kind"`
+	Description string   `json:"description"`
+	Error       string   `json:"error,omitempty"`
+	Min         int      `json:"min,omitempty"`
+	Max         int      `json:"max,omitempty"`
+	Value       int      `json:"value,omitempty"`
+	Chars       string   `json:"chars,omitempty"`
+	Values      []string `json:"values,omitempty"`
+	IgnoreCase  bool     `json:"ignore_case,omitempty"`
+}
+
+func init() {
+	RegisterType("password", func(sm *StepManager, cfg Step2Config) (Step, error) {
+		step, err := NewStep2(sm, cfg)
+		if err != nil {
+			return nil, err
+		}
+		return step, nil
+	})
+}
+
 // NewStep2 creates a new Step2 instance
-func NewStep2(sm *StepManager) *Step2 {
+func NewStep2(sm *StepManager, cfg Step2Config) (*Step2, error) {
+	constraints, err := buildConstraints(cfg.Constraints)
+	if err != nil {
+		return nil, err
+	}
+
 	input := textinput.New()
 	input.Placeholder = "Enter your password"
 	input.Focus()
@@ -35,130 +77,114 @@ func NewStep2(sm *StepManager) *Step2 {
 	return &Step2{
 		BaseStep:    NewBaseStep("Password Game", sm),
 		input:       input,
-		constraints: buildConstraints(),
+		constraints: constraints,
 		revealed:    1,
 		errorMsg:    "",
+	}, nil
+}
+
+func buildConstraints(specs []ConstraintSpec) ([]constraint, error) {
+	if len(specs) == 0 {
+		return nil, fmt.Errorf("password game needs at least one constraint")
+	}
+
+	constraints := make([]constraint, 0, len(specs))
+	for _, spec := range specs {
+		validate, err := buildValidator(spec)
+		if err != nil {
+			return nil, err
+		}
+		constraints = append(constraints, constraint{
+			description: spec.Description,
+			validate:    validate,
+		})
 	}
+	return constraints, nil
 }
 
-func buildConstraints() []constraint {
-	return []constraint{
-		{
-			description: "Password must be at least 8 characters long",
-			validate: func(s string) (bool, string) {
-				if len(s) < 8 {
-					return false, fmt.Sprintf("Too short: %d/8 characters", len(s))
-				}
-				return true, ""
-			},
-		},
-		{
-			description: "Password must contain at least 1 number",
-			validate: func(s string) (bool, string) {
-				for _, char := range s {
-					if unicode.IsDigit(char) {
-						return true, ""
-					}
-				}
-				return false, "No numbers found"
-			},
-		},
-		{
-			description: "Password must contain at least 1 special character",
-			validate: func(s string) (bool, string) {
-				specialChars := `!@#$%^&*()-_=+[]{};:'",.<>/?`
-				for _, char := range s {
-					if strings.ContainsRune(specialChars, char) {
-						return true, ""
-					}
-				}
-				return false, "No special characters found"
-			},
-		},
-		{
-			description: "The sum of all numbers must be 35",
-			validate: func(s string) (bool, string) {
-				sum := 0
-				for _, char := range s {
-					if unicode.IsDigit(char) {
-						num, _ := strconv.Atoi(string(char))
-						sum += num
-					}
-				}
-				if sum != 35 {
-					return false, fmt.Sprintf("Sum is %d, not 35", sum)
-				}
-				return true, ""
-			},
-		},
-		{
-			description: "Password must contain Roman numerals",
-			validate: func(s string) (bool, string) {
-				romanNumerals := "IVXLCDM"
-				found := false
-				for _, char := range s {
-					if strings.ContainsRune(romanNumerals, char) {
-						found = true
-						break
-					}
-				}
-				if !found {
-					return false, "No Roman numerals found"
+func buildValidator(spec ConstraintSpec) (func(string) (bool, string), error) {
+	switch spec.Kind {
+	case "min_length":
+		return func(s string) (bool, string) {
+			if len(s) < spec.Min {
+				return false, fmt.Sprintf("Too short: %d/%d characters", len(s), spec.Min)
+			}
+			return true, ""
+		}, nil
+	case "contains_digit":
+		return func(s string) (bool, string) {
+			for _, char := range s {
+				if unicode.IsDigit(char) {
+					return true, ""
 				}
-				return true, ""
-			},
-		},
-		{
-			description: "The Roman numerals must sum to less than 100 and more than 10",
-			validate: func(s string) (bool, string) {
-				values := map[rune]int{'I': 1, 'V': 5, 'X': 10, 'L': 50, 'C': 100, 'D': 500, 'M': 1000}
-				sum := 0
-				for _, char := range s {
-					if val, ok := values[char]; ok {
-						sum += val
-					}
+			}
+			return false, orDefault(spec.Error, "No numbers found")
+		}, nil
+	case "contains_char":
+		if spec.Chars == "" {
+			return nil, fmt.Errorf("constraint %q needs chars", spec.Description)
+		}
+		return func(s string) (bool, string) {
+			for _, char := range s {
+				if strings.ContainsRune(spec.Chars, char) {
+					return true, ""
 				}
-				if sum >= 100 || sum <= 10 {
-					return false, fmt.Sprintf("Roman numeral sum is %d, must be < 100 and > 10", sum)
+			}
+			return false, orDefault(spec.Error, "Missing a required character")
+		}, nil
+	case "digit_sum":
+		return func(s string) (bool, string) {
+			sum := 0
+			for _, char := range s {
+				if unicode.IsDigit(char) {
+					num, _ := strconv.Atoi(string(char))
+					sum += num
 				}
-				return true, ""
-			},
-		},
-		{
-			description: "Password must contain one of Autonoma's founders name in uppercase",
-			validate: func(s string) (bool, string) {
-				founders := []string{"SIMON", "TOMAS", "NICOLAS", "EUGENIO"}
-				for _, name := range founders {
-					if strings.Contains(s, name) {
-						return true, ""
-					}
+			}
+			if sum != spec.Value {
+				return false, fmt.Sprintf("Sum is %d, not %d", sum, spec.Value)
+			}
+			return true, ""
+		}, nil
+	case "roman_sum":
+		return func(s string) (bool, string) {
+			values := map[rune]int{'I': 1, 'V': 5, 'X': 10, 'L': 50, 'C': 100, 'D': 500, 'M': 1000}
+			sum := 0
+			for _, char := range s {
+				if val, ok := values[char]; ok {
+					sum += val
 				}
-				return false, "No founder name found"
-			},
-		},
-		{
-			description: "Password must contain a popular programming language",
-			validate: func(s string) (bool, string) {
-				languages := []string{"PYTHON", "JAVA", "JAVASCRIPT", "C", "CPP", "CSHARP", "PHP", "RUBY", "GO", "SWIFT", "KOTLIN", "RUST", "SCALA", "PERL", "TYPESCRIPT"}
-				for _, lang := range languages {
-					if strings.Contains(strings.ToUpper(s), lang) {
-						return true, ""
-					}
+			}
+			if sum >= spec.Max || sum <= spec.Min {
+				return false, fmt.Sprintf("Roman numeral sum is %d, must be < %d and > %d", sum, spec.Max, spec.Min)
+			}
+			return true, ""
+		}, nil
+	case "contains_any":
+		if len(spec.Values) == 0 {
+			return nil, fmt.Errorf("constraint %q needs values", spec.Description)
+		}
+		return func(s string) (bool, string) {
+			for _, value := range spec.Values {
+				if spec.IgnoreCase && strings.Contains(strings.ToLower(s), strings.ToLower(value)) {
+					return true, ""
 				}
-				return false, "No programming language found"
-			},
-		},
-		{
-			description: "Password must contain the area of a triangle with height 10 and base 4 (lowercase text)",
-			validate: func(s string) (bool, string) {
-				// Area = (base * height) / 2 = (4 * 10) / 2 = 20
-				if strings.Contains(strings.ToLower(s), "twenty") {
+				if !spec.IgnoreCase && strings.Contains(s, value) {
 					return true, ""
 				}
-				return false, "Missing the area of the triangle"
-			},
-		},
+			}
+			return false, orDefault(spec.Error, "Missing a required word")
+		}, nil
+	}
+	return nil, fmt.Errorf("unknown constraint kind %q", spec.Kind)
+}
+
+// orDefault returns s, or fallback when s is empty
+func orDefault(s, fallback string) string {
+	if s == "" {
+		return fallback
 	}
+	return s
 }
 
 // Init initializes the step
@@ -196,6 +222,7 @@ func (s *Step2) Update(msg tea.Msg) (Step, tea.Cmd) {
 
 	if allValid {
 		if s.revealed < len(s.constraints) {
+			s.sm.RecordEvent(database.EventSubmit, map[string]interface{}{"constraints_met": s.revealed})
 			s.revealed++
 			s.errorMsg = "New constraint revealed!"
 		} else {
@@ -206,6 +233,37 @@ func (s *Step2) Update(msg tea.Msg) (Step, tea.Cmd) {
 	return s, cmd
 }
 
+type step2State struct {
+	Password string `json:"password"`
+	Revealed int    `json:"revealed"`
+	ErrorMsg string `json:"error_msg"`
+}
+
+// SaveState returns the password typed so far and the revealed constraints
+func (s *Step2) SaveState() (json.RawMessage, error) {
+	return json.Marshal(step2State{
+		Password: s.input.Value(),
+		Revealed: s.revealed,
+		ErrorMsg: s.errorMsg,
+	})
+}
+
+// RestoreState restores the password typed so far and the revealed constraints
+func (s *Step2) RestoreState(data json.RawMessage) error {
+	var state step2State
+	if err := json.Unmarshal(data, &state); err != nil {
+		return err
+	}
+	if state.Revealed < 1 || state.Revealed > len(s.constraints) {
+		return fmt.Errorf("invalid password game state")
+	}
+	s.input.SetValue(state.Password)
+	s.password = state.Password
+	s.revealed = state.Revealed
+	s.errorMsg = state.ErrorMsg
+	return nil
+}
+
 // View returns the view for this step
 func (s *Step2) View() string {
 	var sb strings.Builder
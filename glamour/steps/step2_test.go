@@ -0,0 +1,48 @@
+package steps
+
+import (
+	"testing"
+
+	tea "github.com/charmbracelet/bubbletea"
+	"github.com/tomaspiaggio/autonoma-hiring-ctf/harness"
+)
+
+func newPasswordGame(t *testing.T, sm *StepManager) *Step2 {
+	t.Helper()
+	step, err := sm.Build("password", defaultParams(t, "password"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return step.(*Step2)
+}
+
+func TestStep2RevealsConstraintsInOrder(t *testing.T) {
+	sm, _, _ := newTestManager(t)
+	s := newPasswordGame(t, sm)
+
+	step, _ := harness.Run(Step(s), harness.Type("abc")...)
+	if s.revealed != 1 || s.errorMsg != "Too short: 3/8 characters" {
+		t.Fatalf("unexpected state: revealed %d, error %q", s.revealed, s.errorMsg)
+	}
+
+	step, _ = harness.Run(step, harness.Type("defgh")...)
+	if s.revealed != 2 {
+		t.Fatalf("expected the second constraint after 8 characters, revealed %d", s.revealed)
+	}
+	harness.Golden(t, "step2_second_constraint", step.View())
+}
+
+func TestStep2Completes(t *testing.T) {
+	sm, _, _ := newTestManager(t)
+	s := newPasswordGame(t, sm)
+
+	// Constraints reveal one per keystroke, keep nudging the cursor until all are met
+	step, _ := harness.Run(Step(s), harness.Type("EUGENIOXV9998!twentyGO")...)
+	for i := 0; i < len(s.constraints) && !step.IsCompleted(); i++ {
+		step, _ = harness.Run(step, harness.Key(tea.KeyLeft))
+	}
+
+	if !step.IsCompleted() {
+		t.Fatalf("expected the password to pass, revealed %d, error %q", s.revealed, s.errorMsg)
+	}
+}
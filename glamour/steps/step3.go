@@ -1,13 +1,16 @@
 package steps
 
 import (
+	"encoding/json"
 	"fmt"
+	"math/rand"
 	"strings"
 	"time"
 
 	tea "github.com/charmbracelet/bubbletea"
 	"github.com/charmbracelet/lipgloss"
 	"github.com/tomaspiaggio/autonoma-hiring-ctf/common"
+	"github.com/tomaspiaggio/autonoma-hiring-ctf/database"
 )
 
 var (
@@ -21,7 +24,7 @@ var (
 			Padding(0, 1)
 )
 
-// Step3 is a math challenge with 10 questions
+// Step3 is a timed multiple choice math challenge
 type Step3 struct {
 	BaseStep
 	questions     []string
@@ -32,40 +35,77 @@ type Step3 struct {
 	cursor        int
 	errorMsg      string
 	timerStart    time.Time
+	timeLimit     time.Duration
 	timeRemaining time.Duration
+	passMark      int
+	seed          int64
 	finished      bool
 }
 
+// Step3Config holds the questions and scoring rules for the math challenge
+type Step3Config struct {
+	Questions []MathQuestion `json:"questions"`
+	TimeLimit Duration       `json:"time_limit"`
+	PassMark  int            `json:"pass_mark"`
+}
+
+// MathQuestion is a question and its correct integer answer
+type MathQuestion struct {
+	Text   string `json:"text"`
+	Answer int    `json:"answer"`
+}
+
+func init() {
+	RegisterType("math", func(sm *StepManager, cfg Step3Config) (Step, error) {
+		step, err := NewStep3(sm, cfg)
+		if err != nil {
+			return nil, err
+		}
+		return step, nil
+	})
+}
+
 // NewStep3 creates a new Step3 instance
-func NewStep3(sm *StepManager) *Step3 {
+func NewStep3(sm *StepManager, cfg Step3Config) (*Step3, error) {
+	if len(cfg.Questions) == 0 {
+		return nil, fmt.Errorf("math challenge needs at least one question")
+	}
+	if cfg.PassMark <= 0 || cfg.PassMark > len(cfg.Questions) {
+		return nil, fmt.Errorf("pass_mark must be between 1 and %d", len(cfg.Questions))
+	}
+	timeLimit := time.Duration(cfg.TimeLimit)
+	if timeLimit <= 0 {
+		timeLimit = time.Minute
+	}
+
+	questions := make([]string, len(cfg.Questions))
+	answers := make([]int, len(cfg.Questions))
+	for i, q := range cfg.Questions {
+		questions[i] = q.Text
+		answers[i] = q.Answer
+	}
+
 	return &Step3{
-		BaseStep: NewBaseStep("Math Challenge", sm),
-		questions: []string{
-			"What is 7 + 12?",
-			"What is 15 + 4?",
-			"What is 8 + 9?",
-			"What is 3 + 5 - 2?",
-			"What is 12 - 4 + 7?",
-			"What is 3 × 5 + 2?",
-			"What is 8 + 2 × 6?",
-			"What is 4 × 3 - 7?",
-			"What is 18 - 6 × 2?",
-			"What is 3 × (4 + 2)?",
-		},
-		answers:       []int{19, 19, 17, 6, 15, 17, 20, 5, 6, 18},
-		userAnswers:   make([]int, 10),
+		BaseStep:      NewBaseStep("Math Challenge", sm),
+		questions:     questions,
+		answers:       answers,
+		userAnswers:   make([]int, len(questions)),
 		currentQ:      0,
 		choices:       []int{},
 		cursor:        0,
 		errorMsg:      "",
-		timeRemaining: time.Minute,
-		timerStart:    time.Now(),
-	}
+		timeLimit:     timeLimit,
+		timeRemaining: timeLimit,
+		passMark:      cfg.PassMark,
+		seed:          sm.StepSeed(),
+	}, nil
 }
 
-// Init initializes the step
+// Init initializes the step. A restored step keeps its original timer start.
 func (s *Step3) Init() tea.Cmd {
-	s.timerStart = time.Now()
+	if s.timerStart.IsZero() {
+		s.timerStart = s.sm.Now()
+	}
 	return tea.Tick(time.Second, func(t time.Time) tea.Msg {
 		return common.TickMsg(t)
 	})
@@ -75,8 +115,8 @@ func (s *Step3) Init() tea.Cmd {
 func (s *Step3) Update(msg tea.Msg) (Step, tea.Cmd) {
 	switch msg := msg.(type) {
 	case common.TickMsg:
-		elapsed := time.Since(s.timerStart)
-		s.timeRemaining = time.Minute - elapsed
+		elapsed := s.sm.Now().Sub(s.timerStart)
+		s.timeRemaining = s.timeLimit - elapsed
 
 		if s.timeRemaining <= 0 && !s.finished {
 			s.finished = true
@@ -86,10 +126,10 @@ func (s *Step3) Update(msg tea.Msg) (Step, tea.Cmd) {
 					correct++
 				}
 			}
-			if correct >= 7 {
+			if correct >= s.passMark {
 				s.MarkCompleted()
 			} else {
-				s.fail("Time's up! You got %d out of 10 correct. Need at least 7 to pass.")
+				s.fail(fmt.Sprintf("Time's up! You got %d out of %d correct. Need at least %d to pass.", correct, len(s.questions), s.passMark), true)
 			}
 			return s, nil
 		}
@@ -115,11 +155,16 @@ func (s *Step3) Update(msg tea.Msg) (Step, tea.Cmd) {
 			}
 		case "enter", " ":
 			s.userAnswers[s.currentQ] = s.choices[s.cursor]
+			s.sm.RecordEvent(database.EventSubmit, map[string]interface{}{
+				"question": s.currentQ,
+				"answer":   s.choices[s.cursor],
+				"correct":  s.choices[s.cursor] == s.answers[s.currentQ],
+			})
 			s.currentQ++
 			s.cursor = 0
 
 			// Generate choices for the next question
-			if s.currentQ < 10 {
+			if s.currentQ < len(s.questions) {
 				s.generateChoices()
 			} else {
 				s.finished = true
@@ -129,10 +174,10 @@ func (s *Step3) Update(msg tea.Msg) (Step, tea.Cmd) {
 						correct++
 					}
 				}
-				if correct >= 7 {
+				if correct >= s.passMark {
 					s.MarkCompleted()
 				} else {
-					s.fail(fmt.Sprintf("You got %d out of 10 correct. Need at least 7 to pass.", correct))
+					s.fail(fmt.Sprintf("You got %d out of %d correct. Need at least %d to pass.", correct, len(s.questions), s.passMark), false)
 				}
 			}
 		}
@@ -141,10 +186,14 @@ func (s *Step3) Update(msg tea.Msg) (Step, tea.Cmd) {
 	return s, nil
 }
 
-func (s *Step3) fail(errorMsg string) {
+func (s *Step3) fail(errorMsg string, timedOut bool) {
 	s.errorMsg = errorMsg
 	go func() {
 		time.Sleep(1 * time.Second)
+		if timedOut {
+			s.sm.SetTimedOutStep(errorMsg)
+			return
+		}
 		s.sm.SetFailedStep(errorMsg)
 	}()
 }
@@ -170,14 +219,12 @@ func (s *Step3) generateChoices() {
 		}
 	}
 
-	// Shuffle the choices
-	for i := range s.choices {
-		j := i + (s.currentQ % (len(s.choices) - i))
-		if j >= len(s.choices) {
-			j = i
-		}
+	// Shuffle the choices. Each question gets its own source so the order
+	// is the same for a given seed no matter when the choices are generated.
+	rng := rand.New(rand.NewSource(s.seed + int64(s.currentQ)))
+	rng.Shuffle(len(s.choices), func(i, j int) {
 		s.choices[i], s.choices[j] = s.choices[j], s.choices[i]
-	}
+	})
 }
 
 // contains checks if a slice contains a value
@@ -190,6 +237,37 @@ func contains(slice []int, val int) bool {
 	return false
 }
 
+type step3State struct {
+	UserAnswers []int     `json:"user_answers"`
+	CurrentQ    int       `json:"current_q"`
+	TimerStart  time.Time `json:"timer_start"`
+}
+
+// SaveState returns the answers given so far and when the timer started
+func (s *Step3) SaveState() (json.RawMessage, error) {
+	return json.Marshal(step3State{
+		UserAnswers: s.userAnswers,
+		CurrentQ:    s.currentQ,
+		TimerStart:  s.timerStart,
+	})
+}
+
+// RestoreState restores the answers given so far. The timer keeps counting from its original start.
+func (s *Step3) RestoreState(data json.RawMessage) error {
+	var state step3State
+	if err := json.Unmarshal(data, &state); err != nil {
+		return err
+	}
+	if len(state.UserAnswers) != len(s.questions) || state.CurrentQ < 0 || state.CurrentQ > len(s.questions) {
+		return fmt.Errorf("invalid math challenge state")
+	}
+	s.userAnswers = state.UserAnswers
+	s.currentQ = state.CurrentQ
+	s.timerStart = state.TimerStart
+	s.choices = []int{}
+	return nil
+}
+
 // View returns the view for this step
 func (s *Step3) View() string {
 	var sb strings.Builder
@@ -198,9 +276,9 @@ func (s *Step3) View() string {
 	seconds := int(s.timeRemaining.Seconds()) % 60
 
 	sb.WriteString(fmt.Sprintf("\n  Time remaining: %02d:%02d", minutes, seconds))
-	sb.WriteString(fmt.Sprintf("\n  Question %d of 10\n\n", s.currentQ+1))
+	sb.WriteString(fmt.Sprintf("\n  Question %d of %d\n\n", s.currentQ+1, len(s.questions)))
 
-	if s.currentQ < 10 && !s.finished {
+	if s.currentQ < len(s.questions) && !s.finished {
 		sb.WriteString("  " + s.questions[s.currentQ] + "\n\n")
 
 		if len(s.choices) == 0 {
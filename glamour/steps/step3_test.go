@@ -0,0 +1,100 @@
+package steps
+
+import (
+	"strings"
+	"testing"
+	"time"
+
+	tea "github.com/charmbracelet/bubbletea"
+	"github.com/tomaspiaggio/autonoma-hiring-ctf/harness"
+)
+
+func newMathChallenge(t *testing.T, sm *StepManager) *Step3 {
+	t.Helper()
+	step, err := sm.Build("math", defaultParams(t, "math"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	s := step.(*Step3)
+	s.Init()
+	return s
+}
+
+// answer picks the choice at the given offset from the correct one, 0 being correct
+func answer(s *Step3, wrong bool) []tea.Msg {
+	// Choices are generated on first render
+	s.View()
+
+	target := 0
+	for i, choice := range s.choices {
+		if (choice == s.answers[s.currentQ]) != wrong {
+			target = i
+			break
+		}
+	}
+	script := []tea.Msg{}
+	for i := 0; i < target; i++ {
+		script = append(script, harness.Key(tea.KeyDown))
+	}
+	return append(script, harness.Key(tea.KeyEnter))
+}
+
+func TestStep3PassMark(t *testing.T) {
+	sm, _, _ := newTestManager(t)
+	s := newMathChallenge(t, sm)
+	harness.Golden(t, "step3_first_question", s.View())
+
+	var step Step = s
+	for i := 0; i < len(s.questions); i++ {
+		// Three wrong answers still pass
+		step, _ = harness.Run(step, answer(s, i < 3)...)
+	}
+
+	if !step.IsCompleted() {
+		t.Fatalf("expected a pass, error %q", s.errorMsg)
+	}
+}
+
+func TestStep3FailsBelowPassMark(t *testing.T) {
+	sm, _, _ := newTestManager(t)
+	s := newMathChallenge(t, sm)
+
+	var step Step = s
+	for i := 0; i < len(s.questions); i++ {
+		step, _ = harness.Run(step, answer(s, i < 4)...)
+	}
+
+	if step.IsCompleted() || !strings.Contains(s.errorMsg, "You got 6 out of 10 correct") {
+		t.Fatalf("expected a failure, got completed=%v error %q", step.IsCompleted(), s.errorMsg)
+	}
+}
+
+func TestStep3TimesOut(t *testing.T) {
+	sm, clock, _ := newTestManager(t)
+	s := newMathChallenge(t, sm)
+
+	step, _ := harness.Run(Step(s), clock.Tick(30*time.Second))
+	if !strings.Contains(step.View(), "Time remaining: 00:30") {
+		t.Fatalf("expected 30 seconds left:\n%s", step.View())
+	}
+
+	step, _ = harness.Run(step, clock.Tick(31*time.Second))
+	if !s.finished || !strings.Contains(s.errorMsg, "Time's up! You got 0 out of 10 correct") {
+		t.Fatalf("expected the timer to end the step, error %q", s.errorMsg)
+	}
+}
+
+func TestStep3ChoiceOrderComesFromSeed(t *testing.T) {
+	sm, _, _ := newTestManager(t)
+	a, b := newMathChallenge(t, sm), newMathChallenge(t, sm)
+	for i := 0; i < len(a.questions); i++ {
+		a.currentQ, b.currentQ = i, i
+		a.generateChoices()
+		b.generateChoices()
+		for j := range a.choices {
+			if a.choices[j] != b.choices[j] {
+				t.Fatalf("question %d: same seed gave %v and %v", i+1, a.choices, b.choices)
+			}
+		}
+	}
+}
@@ -1,12 +1,15 @@
 package steps
 
 import (
+	"encoding/json"
+	"fmt"
 	"strings"
 
 	"github.com/charmbracelet/bubbles/textarea"
 	tea "github.com/charmbracelet/bubbletea"
 	"github.com/charmbracelet/lipgloss"
 	"github.com/dop251/goja"
+	"github.com/tomaspiaggio/autonoma-hiring-ctf/database"
 )
 
 var (
@@ -17,6 +20,12 @@ var (
 		Padding(1)
 )
 
+// editorState is the saved state of the code editor steps
+type editorState struct {
+	Code     string `json:"code"`
+	ErrorMsg string `json:"error_msg"`
+}
+
 // Step4 is the fourth challenge step - fix async code
 type Step4 struct {
 	BaseStep
@@ -24,31 +33,36 @@ type Step4 struct {
 	question string
 	errorMsg string
 	code     string
+
+	// evaluating is set while a submission runs in a command
+	evaluating bool
 }
 
-// NewStep4 creates a new Step4 instance
-func NewStep4(sm *StepManager) *Step4 {
-	// Broken JavaScript code with a promise-related bug
-	brokenCode := `async function fetchUserData(users) {
-  const userData = [];
-  
-  for (const user of users) {
-    fetchUser(user).then(data => {
-      userData.push(data);
-    });
-  }
-  
-  return userData;
+// Step4Config holds the broken code shown to the candidate
+type Step4Config struct {
+	Question string `json:"question"`
+	Template string `json:"template"`
 }
 
-// Mock function (don't modify)
-function fetchUser(user) {
-  return Promise.resolve({ id: user, name: 'User ' + user });
-}`
+func init() {
+	RegisterType("async", func(sm *StepManager, cfg Step4Config) (Step, error) {
+		step, err := NewStep4(sm, cfg)
+		if err != nil {
+			return nil, err
+		}
+		return step, nil
+	})
+}
+
+// NewStep4 creates a new Step4 instance
+func NewStep4(sm *StepManager, cfg Step4Config) (*Step4, error) {
+	if cfg.Template == "" {
+		return nil, fmt.Errorf("async challenge needs a template")
+	}
 
 	// Create a textarea for the code
 	ta := textarea.New()
-	ta.SetValue(brokenCode)
+	ta.SetValue(cfg.Template)
 	ta.Focus()
 	ta.ShowLineNumbers = true
 	ta.Placeholder = "Fix the code here"
@@ -58,10 +72,10 @@ function fetchUser(user) {
 	return &Step4{
 		BaseStep: NewBaseStep("Async JavaScript Debugging", sm),
 		textarea: ta,
-		question: "Fix the JavaScript function that fetches user data.\nThe current implementation has a bug where the returned list is always empty.",
+		question: cfg.Question,
 		errorMsg: "",
-		code:     brokenCode,
-	}
+		code:     cfg.Template,
+	}, nil
 }
 
 // Init initializes the step
@@ -69,20 +83,39 @@ func (s *Step4) Init() tea.Cmd {
 	return textarea.Blink
 }
 
+// asyncResultMsg carries the outcome of a submission evaluated in a command
+type asyncResultMsg struct {
+	passed bool
+	report string
+}
+
 // Update handles user input
 func (s *Step4) Update(msg tea.Msg) (Step, tea.Cmd) {
 	switch msg := msg.(type) {
 	case tea.KeyMsg:
 		if msg.String() == "ctrl+s" || msg.String() == "ctrl+d" {
-			// Check the solution
-			code := s.textarea.Value()
-			if s.evaluateCode(code) {
-				s.MarkCompleted()
-				s.errorMsg = "Well done! The async code is fixed."
+			if s.evaluating {
 				return s, nil
 			}
+			// Run the solution off the update loop, it can take up to the evaluation timeout
+			s.evaluating = true
+			s.errorMsg = "Running your solution..."
+			code := s.textarea.Value()
+			return s, func() tea.Msg {
+				return evaluateCode(code)
+			}
+		}
+	case asyncResultMsg:
+		s.evaluating = false
+		if msg.passed {
+			s.sm.RecordEvent(database.EventSubmit, map[string]interface{}{"passed": true})
+			s.MarkCompleted()
+			s.errorMsg = "Well done! The async code is fixed."
 			return s, nil
 		}
+		s.errorMsg = msg.report
+		s.sm.RecordEvent(database.EventSubmit, map[string]interface{}{"passed": false, "error": s.errorMsg})
+		return s, nil
 	}
 
 	var cmd tea.Cmd
@@ -90,53 +123,115 @@ func (s *Step4) Update(msg tea.Msg) (Step, tea.Cmd) {
 	return s, cmd
 }
 
-// evaluateCode runs the user's JavaScript solution and checks if it works
-func (s *Step4) evaluateCode(code string) bool {
-	vm := goja.New()
+// asyncTestHarness runs after the candidate code. It swaps the mock for one that
+// resolves on timers in reverse order and records who it was called for, so results
+// only come back right if the code actually fetches every user and waits for every
+// promise, then records the outcome in __result.
+const asyncTestHarness = `
+var __result = { done: false, error: null };
+(function () {
+	const fetched = [];
+	try {
+		fetchUser = function (user) {
+			fetched.push(user);
+			return new Promise(resolve => setTimeout(() => resolve({ id: user, name: 'User ' + user }), (4 - user) * 10));
+		};
+	} catch (e) {
+		__result.done = true;
+		__result.error = "Don't modify the fetchUser mock";
+		return;
+	}
 
-	testCode := code + `
-	// Test the function
-	async function testFetchUserData() {
+	(async function () {
 		const users = [1, 2, 3];
 		const result = await fetchUserData(users);
-		
+
+		if (!Array.isArray(result)) {
+			throw new Error("Expected an array of users, got " + typeof result);
+		}
+
 		// Check if we have all users
 		if (result.length !== 3) {
 			throw new Error("Expected 3 users, got " + result.length);
 		}
-		
-		// Check if all users are present
+
+		// Check every user was fetched, and every result came from fetchUser
 		for (let i = 0; i < users.length; i++) {
-			const user = result.find(u => u.id === users[i]);
+			if (!fetched.includes(users[i])) {
+				throw new Error("User " + users[i] + " was never fetched with fetchUser");
+			}
+			const user = result.find(u => u && u.id === users[i]);
 			if (!user) {
 				throw new Error("User " + users[i] + " not found in results");
 			}
+			if (user.name !== 'User ' + users[i]) {
+				throw new Error("User " + users[i] + " doesn't have the name fetchUser returned");
+			}
 		}
-		
-		return true;
-	}
-	
-	// Run the test
-	let success = false;
-	testFetchUserData().then(result => {
-		success = result;
-	});
-	`
-
-	_, err := vm.RunString(testCode)
+	})().then(
+		() => { __result.done = true; },
+		e => { __result.done = true; __result.error = String(e && e.message ? e.message : e); }
+	);
+})();
+`
+
+// evaluateCode runs the user's JavaScript solution against the test and
+// decides from its behavior alone, after every timer and promise has settled
+func evaluateCode(code string) asyncResultMsg {
+	var done bool
+	var testErr string
+	err := runSandboxed(func(vm *goja.Runtime) error {
+		loop := newEventLoop(vm)
+		if _, err := vm.RunString(forAwaitHelper); err != nil {
+			return err
+		}
+		if _, err := vm.RunString(rewriteForAwait(code)); err != nil {
+			return err
+		}
+		if _, err := vm.RunString(asyncTestHarness); err != nil {
+			return err
+		}
+		if err := loop.run(); err != nil {
+			return err
+		}
+
+		result := vm.Get("__result").ToObject(vm)
+		done = result.Get("done").ToBoolean()
+		if errValue := result.Get("error"); !goja.IsNull(errValue) && !goja.IsUndefined(errValue) {
+			testErr = errValue.String()
+		}
+		return nil
+	})
 	if err != nil {
-		s.errorMsg = "JavaScript error: " + err.Error()
-		return false
+		return asyncResultMsg{report: describeEvalError(err)}
 	}
 
-	// Check if the solution contains either Promise.all or await in the loop
-	if !strings.Contains(code, "Promise.all") && !strings.Contains(code, "await fetchUser") {
-		s.errorMsg = "Your solution should handle promises correctly. Think about how to wait for all promises to resolve."
-		return false
+	if !done {
+		return asyncResultMsg{report: "fetchUserData never resolved. Make sure every promise eventually settles."}
+	}
+	if testErr != "" {
+		return asyncResultMsg{report: "Test failed: " + testErr}
 	}
+	return asyncResultMsg{passed: true}
+}
+
+// SaveState returns the contents of the editor
+func (s *Step4) SaveState() (json.RawMessage, error) {
+	return json.Marshal(editorState{
+		Code:     s.textarea.Value(),
+		ErrorMsg: s.errorMsg,
+	})
+}
 
-	s.errorMsg = "Your solution handles the promises correctly!"
-	return true
+// RestoreState restores the contents of the editor
+func (s *Step4) RestoreState(data json.RawMessage) error {
+	var state editorState
+	if err := json.Unmarshal(data, &state); err != nil {
+		return err
+	}
+	s.textarea.SetValue(state.Code)
+	s.errorMsg = state.ErrorMsg
+	return nil
 }
 
 // View returns the view for this step
@@ -0,0 +1,187 @@
+package steps
+
+import (
+	"testing"
+
+	tea "github.com/charmbracelet/bubbletea"
+	"github.com/tomaspiaggio/autonoma-hiring-ctf/harness"
+)
+
+const fixedFetchUserData = `async function fetchUserData(users) {
+  return Promise.all(users.map(user => fetchUser(user)));
+}
+
+function fetchUser(user) {
+  return Promise.resolve({ id: user, name: 'User ' + user });
+}`
+
+func newAsyncChallenge(t *testing.T, sm *StepManager) *Step4 {
+	t.Helper()
+	step, err := sm.Build("async", defaultParams(t, "async"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return step.(*Step4)
+}
+
+// submitAsync submits the solution and delivers the result of the evaluation command
+func submitAsync(t *testing.T, s *Step4) Step {
+	t.Helper()
+	step, cmds := harness.Run(Step(s), harness.Key(tea.KeyCtrlS))
+	if len(cmds) != 1 {
+		t.Fatalf("expected the evaluation to run in a command, got %d commands", len(cmds))
+	}
+	step, _ = harness.Run(step, cmds[0]())
+	return step
+}
+
+func TestStep4(t *testing.T) {
+	tests := []struct {
+		name     string
+		code     string
+		wantPass bool
+		wantMsg  string
+	}{
+		{name: "broken template", wantPass: false, wantMsg: "Test failed: Expected 3 users, got 0"},
+		{name: "promise all", code: fixedFetchUserData, wantPass: true},
+		{
+			name: "await in loop",
+			code: `async function fetchUserData(users) {
+  const userData = [];
+  for (const user of users) {
+    userData.push(await fetchUser(user));
+  }
+  return userData;
+}`,
+			wantPass: true,
+		},
+		{
+			name: "waits with a timer",
+			code: `function fetchUserData(users) {
+  const userData = [];
+  users.forEach(user => fetchUser(user).then(data => userData.push(data)));
+  return new Promise(resolve => setTimeout(() => resolve(userData), 1000));
+}`,
+			wantPass: true,
+		},
+		{
+			name: "mentions Promise.all but never awaits",
+			code: `async function fetchUserData(users) {
+  const userData = [];
+  // TODO: Promise.all
+  users.forEach(user => fetchUser(user).then(data => userData.push(data)));
+  return userData;
+}`,
+			wantPass: false,
+			wantMsg:  "Test failed: Expected 3 users, got 0",
+		},
+		{
+			name:     "never resolves",
+			code:     `function fetchUserData(users) { return new Promise(() => {}); }`,
+			wantPass: false,
+			wantMsg:  "fetchUserData never resolved. Make sure every promise eventually settles.",
+		},
+		{
+			name: "for await",
+			code: `async function fetchUserData(users) {
+  const userData = [];
+  for await (const data of users.map(fetchUser)) userData.push(data);
+  return userData;
+}`,
+			wantPass: true,
+		},
+		{
+			name: "for await with destructuring",
+			code: `async function fetchUserData(users) {
+  const userData = [];
+  for await (const { id, name } of users.map((user) => fetchUser(user))) {
+    userData.push({ id, name });
+  }
+  return userData;
+}`,
+			wantPass: true,
+		},
+		{
+			name:     "builds the users without fetching them",
+			code:     `async function fetchUserData(u) { return u.map(id => ({ id })) }`,
+			wantPass: false,
+			wantMsg:  "Test failed: User 1 was never fetched with fetchUser",
+		},
+		{
+			name: "fetches but makes up the names",
+			code: `async function fetchUserData(users) {
+  await Promise.all(users.map(fetchUser));
+  return users.map(id => ({ id, name: 'Someone' }));
+}`,
+			wantPass: false,
+			wantMsg:  "Test failed: User 1 doesn't have the name fetchUser returned",
+		},
+		{name: "syntax error", code: "async function fetchUserData(users {", wantPass: false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			sm, _, _ := newTestManager(t)
+			s := newAsyncChallenge(t, sm)
+			if tt.code != "" {
+				s.textarea.SetValue(tt.code)
+			}
+
+			step := submitAsync(t, s)
+
+			if step.IsCompleted() != tt.wantPass {
+				t.Fatalf("expected pass=%v, message %q", tt.wantPass, s.errorMsg)
+			}
+			if tt.wantMsg != "" && s.errorMsg != tt.wantMsg {
+				t.Errorf("got message %q, want %q", s.errorMsg, tt.wantMsg)
+			}
+		})
+	}
+}
+
+func TestStep4EvaluatesInCommand(t *testing.T) {
+	sm, _, _ := newTestManager(t)
+	s := newAsyncChallenge(t, sm)
+	s.textarea.SetValue(fixedFetchUserData)
+
+	// Submitting again while the first submission runs does nothing
+	step, cmds := harness.Run(Step(s), harness.Key(tea.KeyCtrlS), harness.Key(tea.KeyCtrlS))
+	if len(cmds) != 1 || step.IsCompleted() || s.errorMsg != "Running your solution..." {
+		t.Fatalf("expected a single evaluation command, got %d commands and message %q", len(cmds), s.errorMsg)
+	}
+	step, _ = harness.Run(step, cmds[0]())
+	if !step.IsCompleted() {
+		t.Fatalf("expected the result to complete the step, got %q", s.errorMsg)
+	}
+}
+
+func TestRewriteForAwait(t *testing.T) {
+	tests := []struct {
+		code string
+		want string
+	}{
+		{
+			code: "for await (const x of xs) f(x)",
+			want: "for (const x  of await __forAwait(xs)) f(x)",
+		},
+		{
+			code: "for await(let [a, b] of pairs.map((p) => g(p, \")\"))) {}",
+			want: "for (let [a, b]  of await __forAwait(pairs.map((p) => g(p, \")\")))) {}",
+		},
+		{code: "for (const x of xs) {}", want: "for (const x of xs) {}"},
+		{code: "// for await (const x of xs)\nfor await (x in xs) {}", want: "// for await (const x of xs)\nfor await (x in xs) {}"},
+		{code: "const s = 'for await (const x of xs)'", want: "const s = 'for await (const x of xs)'"},
+		{code: "before(); platform.forawait (x of xs)", want: "before(); platform.forawait (x of xs)"},
+	}
+	for _, tt := range tests {
+		if got := rewriteForAwait(tt.code); got != tt.want {
+			t.Errorf("rewriteForAwait(%q) = %q, want %q", tt.code, got, tt.want)
+		}
+	}
+}
+
+func TestStep4View(t *testing.T) {
+	sm, _, _ := newTestManager(t)
+	s := newAsyncChallenge(t, sm)
+	harness.Golden(t, "step4_initial", s.View())
+}
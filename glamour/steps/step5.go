@@ -1,15 +1,23 @@
 package steps
 
 import (
+	"encoding/json"
+	"errors"
 	"fmt"
+	"math/rand"
 	"strings"
+	"time"
 
 	"github.com/charmbracelet/bubbles/textarea"
 	tea "github.com/charmbracelet/bubbletea"
 	"github.com/charmbracelet/lipgloss"
 	"github.com/dop251/goja"
+	"github.com/tomaspiaggio/autonoma-hiring-ctf/database"
 )
 
+// errNoHasPath is returned when the candidate code doesn't define hasPath
+var errNoHasPath = errors.New("hasPath is not defined")
+
 // Step5 is the navigation grid challenge
 type Step5 struct {
 	BaseStep
@@ -18,48 +26,176 @@ type Step5 struct {
 	errorMsg string
 	code     string
 	grid     [][]int
+	cases    []gridCase
+
+	// evaluating is set while a submission runs in a command
+	evaluating bool
+}
+
+// Step5Config holds the function template, the grid shown to the candidate
+// and the shapes of the hidden grids the solution is also checked against
+type Step5Config struct {
+	Question    string       `json:"question"`
+	Template    string       `json:"template"`
+	Grid        [][]int      `json:"grid"`
+	HiddenGrids []HiddenGrid `json:"hidden_grids"`
+}
+
+// HiddenGrid is the shape of a grid generated from the session seed. Its cells
+// are never in the pack, so candidates can't read them from the repository.
+type HiddenGrid struct {
+	Width    int  `json:"width"`
+	Height   int  `json:"height"`
+	Solvable bool `json:"solvable"`
+}
+
+// maxHiddenGridSize bounds the width and height of hidden grids
+const maxHiddenGridSize = 20
+
+// gridObstacleRate is the share of the cells of a hidden grid that start as obstacles
+const gridObstacleRate = 0.3
+
+// gridCase is one grid a submission is run against
+type gridCase struct {
+	name     string
+	grid     [][]int
+	solvable bool
+}
+
+func init() {
+	RegisterType("grid", func(sm *StepManager, cfg Step5Config) (Step, error) {
+		step, err := NewStep5(sm, cfg)
+		if err != nil {
+			return nil, err
+		}
+		return step, nil
+	})
 }
 
 // NewStep5 creates a new Step5 instance
-func NewStep5(sm *StepManager) *Step5 {
-	// Initial JavaScript function template
-	jsTemplate := `function hasPath(x, y, grid) {
-    // Your implementation here
-    // Navigate from (0,0) to (5,5) on the grid
-    // You can only move right (R) or down (D)
-    // Some cells are blocked (marked as 1)
-    // Return the path as an array of moves ("R" or "D")
-    // Return undefined if no path is found
-}
-`
+func NewStep5(sm *StepManager, cfg Step5Config) (*Step5, error) {
+	if err := validateGrid(cfg.Grid); err != nil {
+		return nil, err
+	}
+	cases := []gridCase{{name: "Shown grid", grid: cfg.Grid, solvable: gridHasPath(cfg.Grid)}}
+	rng := rand.New(rand.NewSource(sm.StepSeed()))
+	for i, shape := range cfg.HiddenGrids {
+		if err := shape.validate(); err != nil {
+			return nil, fmt.Errorf("hidden grid %d: %w", i+1, err)
+		}
+		cases = append(cases, gridCase{
+			name:     fmt.Sprintf("Hidden grid %d", i+1),
+			grid:     generateGrid(rng, shape),
+			solvable: shape.Solvable,
+		})
+	}
 
 	// Create a textarea for the code
 	ta := textarea.New()
-	ta.SetValue(jsTemplate)
+	ta.SetValue(cfg.Template)
 	ta.Focus()
 	ta.ShowLineNumbers = true
 	ta.Placeholder = "Write your solution here"
 	ta.SetWidth(100) // Increase width significantly
 	ta.SetHeight(20) // Increase height significantly
 
-	// Define the grid with obstacles (1 = obstacle, 0 = free)
-	grid := [][]int{
-		{0, 0, 1, 0, 0, 0},
-		{0, 1, 0, 0, 1, 0},
-		{0, 0, 0, 1, 0, 0},
-		{1, 0, 0, 0, 0, 1},
-		{0, 1, 0, 0, 1, 0},
-		{0, 0, 0, 0, 0, 0},
-	}
-
 	return &Step5{
 		BaseStep: NewBaseStep("Grid Navigation Challenge", sm),
 		textarea: ta,
-		question: "Navigate from (0,0) to (5,5) on a 6x6 grid.\nYou can only move right (R) or down (D).\nAvoid obstacles (marked as 1).\nImplement the hasPath function.",
+		question: cfg.Question,
 		errorMsg: "",
-		code:     jsTemplate,
-		grid:     grid,
+		code:     cfg.Template,
+		grid:     cfg.Grid,
+		cases:    cases,
+	}, nil
+}
+
+// validateGrid checks the grid is a non-empty rectangle of 0s and 1s
+func validateGrid(grid [][]int) error {
+	if len(grid) == 0 || len(grid[0]) == 0 {
+		return fmt.Errorf("grid must not be empty")
+	}
+	for _, row := range grid {
+		if len(row) != len(grid[0]) {
+			return fmt.Errorf("grid rows must all have the same length")
+		}
+		for _, cell := range row {
+			if cell != 0 && cell != 1 {
+				return fmt.Errorf("grid cells must be 0 or 1")
+			}
+		}
+	}
+	return nil
+}
+
+// validate checks the shape fits the limits and, when it must have no path, has a
+// diagonal between the corners to block
+func (h HiddenGrid) validate() error {
+	if h.Width < 1 || h.Height < 1 || h.Width > maxHiddenGridSize || h.Height > maxHiddenGridSize {
+		return fmt.Errorf("width and height must be between 1 and %d", maxHiddenGridSize)
+	}
+	if !h.Solvable && h.Width+h.Height < 4 {
+		return fmt.Errorf("grids without a path must be at least 3x1 or 2x2")
 	}
+	return nil
+}
+
+// generateGrid returns a grid of the shape with random obstacles, drawn from rng.
+// Solvable grids get a random path of right and down moves cleared through them;
+// the others get a diagonal of obstacles, which every such path has to cross.
+func generateGrid(rng *rand.Rand, shape HiddenGrid) [][]int {
+	w, h := shape.Width, shape.Height
+	grid := make([][]int, h)
+	for y := range grid {
+		grid[y] = make([]int, w)
+		for x := range grid[y] {
+			if rng.Float64() < gridObstacleRate {
+				grid[y][x] = 1
+			}
+		}
+	}
+	grid[0][0], grid[h-1][w-1] = 0, 0
+
+	if shape.Solvable {
+		x, y := 0, 0
+		for x < w-1 || y < h-1 {
+			if y == h-1 || (x < w-1 && rng.Intn(2) == 0) {
+				x++
+			} else {
+				y++
+			}
+			grid[y][x] = 0
+		}
+		return grid
+	}
+	if gridHasPath(grid) {
+		diagonal := 1 + rng.Intn(w+h-3)
+		for y := range grid {
+			if x := diagonal - y; x >= 0 && x < w {
+				grid[y][x] = 1
+			}
+		}
+	}
+	return grid
+}
+
+// gridHasPath reports whether the bottom-right corner can be reached from the
+// top-left one moving only right and down
+func gridHasPath(grid [][]int) bool {
+	reachable := make([][]bool, len(grid))
+	for y, row := range grid {
+		reachable[y] = make([]bool, len(row))
+		for x, cell := range row {
+			if cell == 1 {
+				continue
+			}
+			reachable[y][x] = (x == 0 && y == 0) ||
+				(x > 0 && reachable[y][x-1]) ||
+				(y > 0 && reachable[y-1][x])
+		}
+	}
+	last := len(grid) - 1
+	return reachable[last][len(grid[last])-1]
 }
 
 // Init initializes the step
@@ -67,20 +203,39 @@ func (s *Step5) Init() tea.Cmd {
 	return textarea.Blink
 }
 
+// gridResultMsg carries the outcome of a submission evaluated in a command
+type gridResultMsg struct {
+	passed bool
+	report string
+}
+
 // Update handles user input
 func (s *Step5) Update(msg tea.Msg) (Step, tea.Cmd) {
 	switch msg := msg.(type) {
 	case tea.KeyMsg:
 		if msg.String() == "ctrl+s" || msg.String() == "ctrl+d" {
-			// Check the solution
-			code := s.textarea.Value()
-			if s.evaluateCode(code) {
-				s.MarkCompleted()
-				s.errorMsg = "Congratulations! Your solution successfully navigates the grid."
+			if s.evaluating {
 				return s, nil
 			}
+			// Run the solution off the update loop, the grids can take a while
+			s.evaluating = true
+			s.errorMsg = "Running your solution against every grid..."
+			code, cases := s.textarea.Value(), s.cases
+			return s, func() tea.Msg {
+				return evaluateGrids(code, cases)
+			}
+		}
+	case gridResultMsg:
+		s.evaluating = false
+		if msg.passed {
+			s.sm.RecordEvent(database.EventSubmit, map[string]interface{}{"passed": true})
+			s.MarkCompleted()
+			s.errorMsg = fmt.Sprintf("Congratulations! Your solution navigates all %d grids.", len(s.cases))
 			return s, nil
 		}
+		s.errorMsg = msg.report
+		s.sm.RecordEvent(database.EventSubmit, map[string]interface{}{"passed": false, "error": s.errorMsg})
+		return s, nil
 	}
 
 	var cmd tea.Cmd
@@ -88,51 +243,103 @@ func (s *Step5) Update(msg tea.Msg) (Step, tea.Cmd) {
 	return s, cmd
 }
 
-// evaluateCode runs the user's JavaScript solution and checks if it works
-func (s *Step5) evaluateCode(code string) bool {
-	vm := goja.New()
+// evaluateGrids runs the user's JavaScript solution against every grid and
+// reports which cases passed. All the cases share one deadline.
+func evaluateGrids(code string, cases []gridCase) gridResultMsg {
+	deadline := time.Now().Add(evalTimeout)
+	var results []string
+	passed := 0
+	for _, c := range cases {
+		err := runCase(code, c, deadline)
+		// Problems with the code itself fail every case the same way
+		if errors.Is(err, errNoHasPath) {
+			return gridResultMsg{report: "Could not find the hasPath function"}
+		}
+		var syntaxErr *goja.CompilerSyntaxError
+		if errors.As(err, &syntaxErr) {
+			return gridResultMsg{report: describeEvalError(err)}
+		}
 
-	// Execute the user's code
-	_, err := vm.RunString(code)
-	if err != nil {
-		s.errorMsg = fmt.Sprintf("JavaScript error: %v", err)
-		return false
+		size := fmt.Sprintf("%dx%d", len(c.grid[0]), len(c.grid))
+		if err != nil {
+			results = append(results, fmt.Sprintf("✗ %s (%s): %s", c.name, size, err))
+			continue
+		}
+		passed++
+		results = append(results, fmt.Sprintf("✓ %s (%s)", c.name, size))
 	}
 
-	// Get the function
-	hasPathFn, ok := goja.AssertFunction(vm.Get("hasPath"))
-	if !ok {
-		s.errorMsg = "Could not find the hasPath function"
-		return false
+	if passed == len(cases) {
+		return gridResultMsg{passed: true}
 	}
+	return gridResultMsg{report: fmt.Sprintf("Passed %d of %d grids:\n  ", passed, len(cases)) + strings.Join(results, "\n  ")}
+}
+
+// runCase runs the solution on a fresh runtime against one grid, so nothing
+// carries over from the previous case
+func runCase(code string, c gridCase, deadline time.Time) error {
+	var res goja.Value
+	err := runSandboxedUntil(deadline, func(vm *goja.Runtime) error {
+		// Execute the user's code
+		if _, err := vm.RunString(code); err != nil {
+			return err
+		}
 
-	// Call the function with our grid
-	res, err := hasPathFn(goja.Undefined(), vm.ToValue(0), vm.ToValue(0), vm.ToValue(s.grid))
+		// Get the function
+		hasPathFn, ok := goja.AssertFunction(vm.Get("hasPath"))
+		if !ok {
+			return errNoHasPath
+		}
+
+		// Call the function with a copy of the grid, so it can't be changed from JavaScript
+		var err error
+		res, err = hasPathFn(goja.Undefined(), vm.ToValue(0), vm.ToValue(0), vm.ToValue(copyGrid(c.grid)))
+		return err
+	})
+	if errors.Is(err, errNoHasPath) {
+		return err
+	}
+	var syntaxErr *goja.CompilerSyntaxError
+	if errors.As(err, &syntaxErr) {
+		return err
+	}
 	if err != nil {
-		s.errorMsg = fmt.Sprintf("Error executing your function: %v", err)
-		return false
+		return errors.New(describeEvalError(err))
 	}
 
 	// Check if result is undefined (no path)
 	if res == nil || goja.IsUndefined(res) {
-		s.errorMsg = "Your function returned undefined, but there is a valid path through the grid"
-		return false
+		if c.solvable {
+			return errors.New("returned undefined, but there is a valid path through the grid")
+		}
+		return nil
+	}
+	if !c.solvable {
+		return errors.New("returned a path, but this grid has none, expected undefined")
 	}
 
 	// Convert the result to a Go slice
-	pathObj := res.Export()
-	path, ok := pathObj.([]interface{})
+	path, ok := res.Export().([]interface{})
 	if !ok {
-		s.errorMsg = "Your function should return an array of moves (\"R\" or \"D\")"
-		return false
+		return errors.New("should return an array of moves (\"R\" or \"D\")")
 	}
 
 	// Validate the path
-	return s.validatePath(path)
+	return validatePath(c.grid, path)
 }
 
-// validatePath checks if the path is valid
-func (s *Step5) validatePath(path []interface{}) bool {
+// copyGrid returns a deep copy of the grid
+func copyGrid(grid [][]int) [][]int {
+	out := make([][]int, len(grid))
+	for i, row := range grid {
+		out[i] = append([]int(nil), row...)
+	}
+	return out
+}
+
+// validatePath checks the path goes from the top-left to the bottom-right corner
+// of the grid without leaving it or hitting an obstacle
+func validatePath(grid [][]int, path []interface{}) error {
 	x, y := 0, 0
 
 	// Convert path to string array
@@ -140,13 +347,11 @@ func (s *Step5) validatePath(path []interface{}) bool {
 	for i, move := range path {
 		moveStr, ok := move.(string)
 		if !ok {
-			s.errorMsg = "Path should contain only string values (\"R\" or \"D\")"
-			return false
+			return errors.New("path should contain only string values (\"R\" or \"D\")")
 		}
 
 		if moveStr != "R" && moveStr != "D" {
-			s.errorMsg = "Path should contain only \"R\" or \"D\" moves"
-			return false
+			return errors.New("path should contain only \"R\" or \"D\" moves")
 		}
 
 		moves[i] = moveStr
@@ -162,25 +367,42 @@ func (s *Step5) validatePath(path []interface{}) bool {
 		}
 
 		// Check bounds
-		if x >= 6 || y >= 6 {
-			s.errorMsg = "Path goes out of bounds"
-			return false
+		if y >= len(grid) || x >= len(grid[y]) {
+			return errors.New("path goes out of bounds")
 		}
 
 		// Check for obstacles
-		if s.grid[y][x] == 1 {
-			s.errorMsg = fmt.Sprintf("Path hits an obstacle at position (%d,%d)", x, y)
-			return false
+		if grid[y][x] == 1 {
+			return fmt.Errorf("path hits an obstacle at position (%d,%d)", x, y)
 		}
 	}
 
 	// Check if we reached the destination
-	if x == 5 && y == 5 {
-		return true
+	destX, destY := len(grid[0])-1, len(grid)-1
+	if x == destX && y == destY {
+		return nil
 	}
 
-	s.errorMsg = fmt.Sprintf("Path ends at (%d,%d), not the destination (5,5)", x, y)
-	return false
+	return fmt.Errorf("path ends at (%d,%d), not the destination (%d,%d)", x, y, destX, destY)
+}
+
+// SaveState returns the contents of the editor
+func (s *Step5) SaveState() (json.RawMessage, error) {
+	return json.Marshal(editorState{
+		Code:     s.textarea.Value(),
+		ErrorMsg: s.errorMsg,
+	})
+}
+
+// RestoreState restores the contents of the editor
+func (s *Step5) RestoreState(data json.RawMessage) error {
+	var state editorState
+	if err := json.Unmarshal(data, &state); err != nil {
+		return err
+	}
+	s.textarea.SetValue(state.Code)
+	s.errorMsg = state.ErrorMsg
+	return nil
 }
 
 // View returns the view for this step
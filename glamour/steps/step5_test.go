@@ -0,0 +1,205 @@
+package steps
+
+import (
+	"math/rand"
+	"strings"
+	"testing"
+	"time"
+
+	tea "github.com/charmbracelet/bubbletea"
+	"github.com/tomaspiaggio/autonoma-hiring-ctf/harness"
+)
+
+const gridSolution = `function hasPath(x, y, grid) {
+  const rows = grid.length, cols = grid[0].length;
+  if (x >= cols || y >= rows || grid[y][x] === 1) return undefined;
+  if (x === cols - 1 && y === rows - 1) return [];
+  const right = hasPath(x + 1, y, grid);
+  if (right) return ["R"].concat(right);
+  const down = hasPath(x, y + 1, grid);
+  if (down) return ["D"].concat(down);
+  return undefined;
+}`
+
+func newGridChallenge(t *testing.T, sm *StepManager) *Step5 {
+	t.Helper()
+	step, err := sm.Build("grid", defaultParams(t, "grid"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return step.(*Step5)
+}
+
+// submitGrid submits the solution and delivers the result of the evaluation command
+func submitGrid(t *testing.T, s *Step5) Step {
+	t.Helper()
+	step, cmds := harness.Run(Step(s), harness.Key(tea.KeyCtrlS))
+	if len(cmds) != 1 {
+		t.Fatalf("expected the evaluation to run in a command, got %d commands", len(cmds))
+	}
+	step, _ = harness.Run(step, cmds[0]())
+	return step
+}
+
+func TestStep5(t *testing.T) {
+	tests := []struct {
+		name     string
+		code     string
+		wantPass bool
+		wantMsg  string
+	}{
+		{name: "template", wantPass: false, wantMsg: "Passed 2 of 6 grids:\n  ✗ Shown grid (6x6): returned undefined, but there is a valid path through the grid"},
+		{name: "solution", code: gridSolution, wantPass: true},
+		{
+			name:     "hard-coded path",
+			code:     `function hasPath() { return ["D", "D", "R", "R", "D", "R", "D", "D", "R", "R"] }`,
+			wantPass: false,
+			wantMsg:  "✓ Shown grid (6x6)\n  ✗ Hidden grid 1 (7x4): returned a path, but this grid has none, expected undefined",
+		},
+		{name: "hits obstacle", code: `function hasPath() { return ["D", "R"] }`, wantPass: false, wantMsg: "✗ Shown grid (6x6): path hits an obstacle at position (1,1)"},
+		{name: "falls short", code: `function hasPath() { return ["D"] }`, wantPass: false, wantMsg: "✗ Shown grid (6x6): path ends at (0,1), not the destination (5,5)"},
+		{name: "no function", code: `var x = 1`, wantPass: false, wantMsg: "Could not find the hasPath function"},
+		{name: "syntax error", code: `function hasPath( {`, wantPass: false, wantMsg: "JavaScript error: SyntaxError"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			sm, _, _ := newTestManager(t)
+			s := newGridChallenge(t, sm)
+			if tt.code != "" {
+				s.textarea.SetValue(tt.code)
+			}
+
+			step := submitGrid(t, s)
+
+			if step.IsCompleted() != tt.wantPass {
+				t.Fatalf("expected pass=%v, message %q", tt.wantPass, s.errorMsg)
+			}
+			if !strings.Contains(s.errorMsg, tt.wantMsg) {
+				t.Errorf("got message %q, want %q", s.errorMsg, tt.wantMsg)
+			}
+		})
+	}
+}
+
+func TestStep5EvaluatesInCommand(t *testing.T) {
+	sm, _, _ := newTestManager(t)
+	s := newGridChallenge(t, sm)
+	s.textarea.SetValue(gridSolution)
+
+	step, cmds := harness.Run(Step(s), harness.Key(tea.KeyCtrlS), harness.Key(tea.KeyCtrlS))
+	if len(cmds) != 1 || step.IsCompleted() {
+		t.Fatalf("expected a single pending evaluation, got %d commands", len(cmds))
+	}
+	if !strings.Contains(s.View(), "Running your solution") {
+		t.Error("expected the view to show the evaluation is running")
+	}
+
+	step, _ = harness.Run(step, cmds[0]())
+	if !step.IsCompleted() {
+		t.Fatalf("expected the solution to pass, got %q", s.errorMsg)
+	}
+}
+
+func TestStep5SharesOneDeadline(t *testing.T) {
+	tightLimits(t)
+	sm, _, _ := newTestManager(t)
+	s := newGridChallenge(t, sm)
+	// Each grid takes 80ms, so the 200ms budget runs out on the third
+	s.textarea.SetValue(`function hasPath() { const end = Date.now() + 80; while (Date.now() < end) {} }`)
+
+	start := time.Now()
+	submitGrid(t, s)
+	if elapsed := time.Since(start); elapsed > time.Second {
+		t.Errorf("evaluating every grid took %v", elapsed)
+	}
+	if !strings.Contains(s.errorMsg, "✗ Hidden grid 5 (8x8): Your code timed out") {
+		t.Errorf("expected the last grids to time out, got %q", s.errorMsg)
+	}
+}
+
+func TestGenerateGrid(t *testing.T) {
+	shapes := []HiddenGrid{
+		{Width: 1, Height: 1, Solvable: true},
+		{Width: 3, Height: 1, Solvable: false},
+		{Width: 2, Height: 2, Solvable: false},
+		{Width: 7, Height: 4, Solvable: false},
+		{Width: 8, Height: 8, Solvable: true},
+		{Width: 20, Height: 20, Solvable: false},
+	}
+	for seed := int64(0); seed < 200; seed++ {
+		for _, shape := range shapes {
+			grid := generateGrid(rand.New(rand.NewSource(seed)), shape)
+			if err := validateGrid(grid); err != nil || len(grid) != shape.Height || len(grid[0]) != shape.Width {
+				t.Fatalf("seed %d: invalid %dx%d grid %v (%v)", seed, shape.Width, shape.Height, grid, err)
+			}
+			if gridHasPath(grid) != shape.Solvable {
+				t.Fatalf("seed %d: expected solvable=%v, got %v", seed, shape.Solvable, grid)
+			}
+		}
+	}
+}
+
+func TestHiddenGridsFollowTheSeed(t *testing.T) {
+	build := func(seed int64) []gridCase {
+		sm, _, _ := newTestManager(t)
+		sm.Seed = seed
+		return newGridChallenge(t, sm).cases
+	}
+	first, again, other := build(1), build(1), build(2)
+	same, differs := true, false
+	for i := range first {
+		for y := range first[i].grid {
+			for x := range first[i].grid[y] {
+				same = same && first[i].grid[y][x] == again[i].grid[y][x]
+				differs = differs || first[i].grid[y][x] != other[i].grid[y][x]
+			}
+		}
+	}
+	if !same || !differs {
+		t.Errorf("expected the hidden grids to be reproducible from the seed and differ between seeds")
+	}
+}
+
+func TestHiddenGridValidation(t *testing.T) {
+	tests := []struct {
+		shape   HiddenGrid
+		wantErr string
+	}{
+		{shape: HiddenGrid{Width: 0, Height: 3, Solvable: true}, wantErr: "between 1 and 20"},
+		{shape: HiddenGrid{Width: 21, Height: 3, Solvable: true}, wantErr: "between 1 and 20"},
+		{shape: HiddenGrid{Width: 2, Height: 1}, wantErr: "at least 3x1 or 2x2"},
+		{shape: HiddenGrid{Width: 3, Height: 1}},
+	}
+	for _, tt := range tests {
+		err := tt.shape.validate()
+		if tt.wantErr == "" && err != nil || tt.wantErr != "" && (err == nil || !strings.Contains(err.Error(), tt.wantErr)) {
+			t.Errorf("validate(%+v) = %v, want %q", tt.shape, err, tt.wantErr)
+		}
+	}
+}
+
+func TestGridHasPath(t *testing.T) {
+	tests := []struct {
+		grid [][]int
+		want bool
+	}{
+		{grid: [][]int{{0}}, want: true},
+		{grid: [][]int{{1}}, want: false},
+		{grid: [][]int{{0, 0}, {1, 0}}, want: true},
+		{grid: [][]int{{0, 1}, {1, 0}}, want: false},
+		{grid: [][]int{{0, 0, 0}, {0, 1, 0}, {0, 0, 1}}, want: false},
+	}
+
+	for _, tt := range tests {
+		if got := gridHasPath(tt.grid); got != tt.want {
+			t.Errorf("gridHasPath(%v) = %v, want %v", tt.grid, got, tt.want)
+		}
+	}
+}
+
+func TestStep5View(t *testing.T) {
+	sm, _, _ := newTestManager(t)
+	s := newGridChallenge(t, sm)
+	harness.Golden(t, "step5_initial", s.View())
+}
@@ -0,0 +1,81 @@
+package steps
+
+import (
+	"encoding/json"
+	"testing"
+	"time"
+
+	"github.com/tomaspiaggio/autonoma-hiring-ctf/harness"
+	"github.com/tomaspiaggio/autonoma-hiring-ctf/tokens"
+)
+
+const testEmail = "candidate@example.com"
+
+// newTestManager returns a manager backed by a fake clock and store, with a fixed seed
+func newTestManager(t *testing.T) (*StepManager, *harness.FakeClock, *harness.FakeStore) {
+	t.Helper()
+	clock := harness.NewFakeClock()
+	store := harness.NewFakeStore(clock)
+	sm := NewStepManager(nil, clock.Now(), store)
+	sm.Clock = clock
+	sm.Seed = 42
+	sm.SetEmail(testEmail)
+	keys, err := tokens.Generate("test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	sm.Keys = keys
+	return sm, clock, store
+}
+
+// defaultParams returns the params of the first step of the given type in the default pack
+func defaultParams(t *testing.T, stepType string) json.RawMessage {
+	t.Helper()
+	for _, spec := range DefaultPack().Steps {
+		if spec.Type == stepType {
+			return spec.Params
+		}
+	}
+	t.Fatalf("default pack has no %s step", stepType)
+	return nil
+}
+
+func TestUpdateCurrentStepAdvancesOnCompletion(t *testing.T) {
+	sm, _, _ := newTestManager(t)
+	first, err := NewStep1(sm, Step1Config{Words: []WordleWord{{Word: "asado"}}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	second, err := NewStep1(sm, Step1Config{Words: []WordleWord{{Word: "birra"}}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	sm.Steps = []Step{first, second}
+
+	for _, msg := range harness.Type("asado") {
+		sm.UpdateCurrentStep(msg)
+	}
+
+	if sm.CurrentStep != 1 {
+		t.Fatalf("expected to move to step 2, current step is %d", sm.CurrentStep+1)
+	}
+}
+
+func TestSetFailedStepReplacesFlow(t *testing.T) {
+	sm, clock, _ := newTestManager(t)
+	sm.Steps = []Step{NewEndStep(sm, EndConfig{})}
+	clock.Advance(3 * time.Minute)
+
+	sm.SetFailedStep("nope")
+
+	if !sm.StepFailed || sm.FailureMsg != "nope" {
+		t.Fatalf("expected a failure, got failed=%v msg=%q", sm.StepFailed, sm.FailureMsg)
+	}
+	failed, ok := sm.Steps[0].(*FailedStep)
+	if !ok || len(sm.Steps) != 1 {
+		t.Fatalf("expected a single FailedStep, got %#v", sm.Steps)
+	}
+	if failed.timeTaken != 3*time.Minute {
+		t.Errorf("expected 3m taken, got %v", failed.timeTaken)
+	}
+}
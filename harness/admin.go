@@ -0,0 +1,216 @@
+package harness
+
+import (
+	"database/sql"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/tomaspiaggio/autonoma-hiring-ctf/database"
+)
+
+// AdminStore answers the queries of the admin commands and API with canned rows, the queries themselves are tested against Postgres
+// in the database package. Rows are only filtered by the arguments of each call, so tests can check what reaches
+// the output, and every call is recorded in Calls. Set Err to make every call fail.
+type AdminStore struct {
+	Attempts    []database.Attempt
+	Users       []database.User
+	Stats       database.Stats
+	Steps       []database.StepStat
+	Entries     []database.LeaderboardEntry
+	FunnelSteps []database.FunnelStep
+	Exports     []database.ExportRow
+	Emails      []database.OutboxEmail
+	Err         error
+	Calls       []string
+}
+
+func (s *AdminStore) call(format string, args ...interface{}) {
+	s.Calls = append(s.Calls, fmt.Sprintf(format, args...))
+}
+
+// limited returns the first limit rows, or all of them when limit is 0
+func limited[T any](rows []T, limit int) []T {
+	if limit > 0 && len(rows) > limit {
+		return rows[:limit]
+	}
+	return rows
+}
+
+// user returns the user with the email, whatever its case
+func (s *AdminStore) user(email string) (*database.User, error) {
+	for i := range s.Users {
+		if strings.EqualFold(s.Users[i].Email, email) {
+			return &s.Users[i], nil
+		}
+	}
+	return nil, sql.ErrNoRows
+}
+
+func (s *AdminStore) ListAttempts(email string, limit int) ([]database.Attempt, error) {
+	s.call("ListAttempts %s %d", email, limit)
+	if s.Err != nil {
+		return nil, s.Err
+	}
+	var attempts []database.Attempt
+	for _, a := range s.Attempts {
+		if email == "" || strings.EqualFold(a.Email, email) {
+			attempts = append(attempts, a)
+		}
+	}
+	return limited(attempts, limit), nil
+}
+
+func (s *AdminStore) ListUsers(limit int) ([]database.User, error) {
+	s.call("ListUsers %d", limit)
+	if s.Err != nil {
+		return nil, s.Err
+	}
+	return limited(s.Users, limit), nil
+}
+
+func (s *AdminStore) GetUser(email string) (*database.User, error) {
+	s.call("GetUser %s", email)
+	if s.Err != nil {
+		return nil, s.Err
+	}
+	return s.user(email)
+}
+
+func (s *AdminStore) UnlinkPublicKey(email string) error {
+	s.call("UnlinkPublicKey %s", email)
+	if s.Err != nil {
+		return s.Err
+	}
+	_, err := s.user(email)
+	return err
+}
+
+func (s *AdminStore) VoidAttempt(id int) error {
+	s.call("VoidAttempt %d", id)
+	if s.Err != nil {
+		return s.Err
+	}
+	for _, a := range s.Attempts {
+		if a.ID == id {
+			return nil
+		}
+	}
+	return sql.ErrNoRows
+}
+
+func (s *AdminStore) GetStats() (*database.Stats, error) {
+	s.call("GetStats")
+	if s.Err != nil {
+		return nil, s.Err
+	}
+	return &s.Stats, nil
+}
+
+func (s *AdminStore) StepStats() ([]database.StepStat, error) {
+	s.call("StepStats")
+	if s.Err != nil {
+		return nil, s.Err
+	}
+	return s.Steps, nil
+}
+
+func (s *AdminStore) Leaderboard(limit int) ([]database.LeaderboardEntry, error) {
+	s.call("Leaderboard %d", limit)
+	if s.Err != nil {
+		return nil, s.Err
+	}
+	return limited(s.Entries, limit), nil
+}
+
+func (s *AdminStore) SetDisplayHandle(email string, handle string) error {
+	s.call("SetDisplayHandle %s %s", email, handle)
+	if s.Err != nil {
+		return s.Err
+	}
+	if err := database.ValidateHandle(handle); handle != "" && err != nil {
+		return err
+	}
+	user, err := s.user(email)
+	if err != nil {
+		return err
+	}
+	for _, other := range s.Users {
+		if other.ID != user.ID && handle != "" && strings.EqualFold(other.DisplayHandle, handle) {
+			return database.ErrHandleTaken
+		}
+	}
+	return nil
+}
+
+func (s *AdminStore) Funnel(period database.FunnelPeriod, since time.Time) ([]database.FunnelStep, error) {
+	s.call("Funnel %s %s", period, since.Format(time.DateOnly))
+	if s.Err != nil {
+		return nil, s.Err
+	}
+	var steps []database.FunnelStep
+	for _, step := range s.FunnelSteps {
+		if !step.Period.Before(since) {
+			steps = append(steps, step)
+		}
+	}
+	return steps, nil
+}
+
+func (s *AdminStore) ExportAttempts(filter database.ExportFilter, fn func(database.ExportRow) error) error {
+	s.call("ExportAttempts %s %s %s", filter.Outcome, filter.From.Format(time.DateOnly), filter.To.Format(time.DateOnly))
+	if s.Err != nil {
+		return s.Err
+	}
+	for _, row := range s.Exports {
+		if filter.Outcome != "" && !strings.EqualFold(outcome(row.Attempt), filter.Outcome) ||
+			!filter.From.IsZero() && row.SubmittedAt.Before(filter.From) ||
+			!filter.To.IsZero() && !row.SubmittedAt.Before(filter.To) {
+			continue
+		}
+		if err := fn(row); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// outcome returns the outcome an export filter matches the attempt by
+func outcome(a database.Attempt) string {
+	switch {
+	case a.Voided:
+		return "voided"
+	case a.Abandoned:
+		return "abandoned"
+	case a.Failed:
+		return "failed"
+	}
+	return "won"
+}
+
+func (s *AdminStore) ListOutbox(status database.OutboxStatus, limit int) ([]database.OutboxEmail, error) {
+	s.call("ListOutbox %s %d", status, limit)
+	if s.Err != nil {
+		return nil, s.Err
+	}
+	var emails []database.OutboxEmail
+	for _, e := range s.Emails {
+		if status == "" || e.Status == status {
+			emails = append(emails, e)
+		}
+	}
+	return limited(emails, limit), nil
+}
+
+func (s *AdminStore) ResendEmail(id int) error {
+	s.call("ResendEmail %d", id)
+	if s.Err != nil {
+		return s.Err
+	}
+	for _, e := range s.Emails {
+		if e.ID == id {
+			return nil
+		}
+	}
+	return sql.ErrNoRows
+}
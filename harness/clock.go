@@ -0,0 +1,46 @@
+package harness
+
+import (
+	"sync"
+	"time"
+)
+
+// Epoch is the time fake clocks start at
+var Epoch = time.Date(2025, time.April, 1, 12, 0, 0, 0, time.UTC)
+
+// FakeClock is a common.Clock that only moves when told to
+type FakeClock struct {
+	mu  sync.Mutex
+	now time.Time
+}
+
+// NewFakeClock returns a clock set to Epoch
+func NewFakeClock() *FakeClock {
+	return &FakeClock{now: Epoch}
+}
+
+// Now returns the fake current time
+func (c *FakeClock) Now() time.Time {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.now
+}
+
+// Advance moves the clock forward
+func (c *FakeClock) Advance(d time.Duration) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.now = c.now.Add(d)
+}
+
+// Tick returns a script message that advances the clock by d and then
+// delivers a common.TickMsg with the new time
+func (c *FakeClock) Tick(d time.Duration) Advance {
+	return Advance{Clock: c, By: d}
+}
+
+// Advance is a script message handled by Run, see FakeClock.Tick
+type Advance struct {
+	Clock *FakeClock
+	By    time.Duration
+}
@@ -0,0 +1,16 @@
+// Package harness drives the challenge TUI headlessly in tests.
+//
+// Scripts are plain slices of tea.Msg fed to a model or a step in order.
+// Time is controlled with a FakeClock, persistence with a FakeStore, and
+// rendered views are compared against golden files under testdata.
+// Importing the package forces the ASCII color profile so views are stable.
+package harness
+
+import (
+	"github.com/charmbracelet/lipgloss"
+	"github.com/muesli/termenv"
+)
+
+func init() {
+	lipgloss.SetColorProfile(termenv.Ascii)
+}
@@ -0,0 +1,34 @@
+package harness
+
+import (
+	"sync"
+
+	"github.com/tomaspiaggio/autonoma-hiring-ctf/email"
+)
+
+// FakeMailer records the emails it is asked to send. Set Err to make sending fail.
+type FakeMailer struct {
+	Err error
+
+	mu   sync.Mutex
+	sent []email.Message
+}
+
+var _ email.Mailer = (*FakeMailer)(nil)
+
+func (m *FakeMailer) Send(msg email.Message) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if m.Err != nil {
+		return m.Err
+	}
+	m.sent = append(m.sent, msg)
+	return nil
+}
+
+// Sent returns a copy of the emails sent so far
+func (m *FakeMailer) Sent() []email.Message {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return append([]email.Message(nil), m.sent...)
+}
@@ -0,0 +1,109 @@
+package harness
+
+import (
+	"testing"
+	"time"
+
+	tea "github.com/charmbracelet/bubbletea"
+	"github.com/tomaspiaggio/autonoma-hiring-ctf/common"
+)
+
+// Updater is satisfied by tea.Model and steps.Step
+type Updater[M any] interface {
+	Update(msg tea.Msg) (M, tea.Cmd)
+}
+
+// Run feeds the script to the model in order and returns the resulting
+// model with the commands it returned along the way. Commands are not executed.
+func Run[M Updater[M]](m M, script ...tea.Msg) (M, []tea.Cmd) {
+	var cmds []tea.Cmd
+	for _, msg := range script {
+		if adv, ok := msg.(Advance); ok {
+			adv.Clock.Advance(adv.By)
+			msg = common.TickMsg(adv.Clock.Now())
+		}
+
+		var cmd tea.Cmd
+		m, cmd = m.Update(msg)
+		if cmd != nil {
+			cmds = append(cmds, cmd)
+		}
+	}
+	return m, cmds
+}
+
+// Type returns the key presses that type the text. Newlines press enter.
+func Type(text string) []tea.Msg {
+	msgs := make([]tea.Msg, 0, len(text))
+	for _, r := range text {
+		if r == '\n' {
+			msgs = append(msgs, Key(tea.KeyEnter))
+			continue
+		}
+		msgs = append(msgs, tea.KeyMsg{Type: tea.KeyRunes, Runes: []rune{r}})
+	}
+	return msgs
+}
+
+// Key returns a press of a special key such as tea.KeyEnter or tea.KeyCtrlS
+func Key(k tea.KeyType) tea.KeyMsg {
+	return tea.KeyMsg{Type: k}
+}
+
+// Resize returns a terminal resize
+func Resize(width, height int) tea.WindowSizeMsg {
+	return tea.WindowSizeMsg{Width: width, Height: height}
+}
+
+// Script flattens messages and message slices into a single script
+func Script(parts ...interface{}) []tea.Msg {
+	var script []tea.Msg
+	for _, part := range parts {
+		switch p := part.(type) {
+		case []tea.Msg:
+			script = append(script, p...)
+		case tea.Msg:
+			script = append(script, p)
+		}
+	}
+	return script
+}
+
+// Quits reports whether any of the commands quits the program.
+// Commands that don't resolve quickly, like ticks, are treated as not quitting.
+func Quits(cmds ...tea.Cmd) bool {
+	for _, cmd := range cmds {
+		if cmd == nil {
+			continue
+		}
+
+		result := make(chan tea.Msg, 1)
+		go func() { result <- cmd() }()
+
+		select {
+		case msg := <-result:
+			switch msg := msg.(type) {
+			case tea.QuitMsg:
+				return true
+			case tea.BatchMsg:
+				if Quits(msg...) {
+					return true
+				}
+			}
+		case <-time.After(50 * time.Millisecond):
+		}
+	}
+	return false
+}
+
+// Eventually waits up to a second for cond to hold, for effects that happen in goroutines
+func Eventually(t *testing.T, cond func() bool, msg string) {
+	t.Helper()
+	for i := 0; i < 100; i++ {
+		if cond() {
+			return
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	t.Fatalf("condition never held: %s", msg)
+}
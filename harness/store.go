@@ -0,0 +1,569 @@
+package harness
+
+import (
+	"database/sql"
+	"encoding/json"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/tomaspiaggio/autonoma-hiring-ctf/common"
+	"github.com/tomaspiaggio/autonoma-hiring-ctf/database"
+)
+
+// Attempt is an attempt recorded by a FakeStore
+type Attempt struct {
+	ID          int
+	SessionID   string
+	Email       string
+	Failed      bool
+	Abandoned   bool
+	Details     map[string]interface{}
+	SubmittedAt time.Time
+}
+
+// FakeStore is an in-memory database.Store that also answers the verification and outbox worker queries.
+// It only models what the challenge needs, the SQL behind it is tested against Postgres in the database package.
+// Use SetErr to make every call fail.
+type FakeStore struct {
+	mu       sync.Mutex
+	err      error
+	clock    common.Clock
+	attempts []Attempt
+	sessions map[string]*database.Session
+	detached map[string]time.Time
+	keys     map[string]string
+	codes    map[string]*verification
+	sent     map[string][]time.Time
+	events   []database.StepEvent
+	handles  map[string]string
+	outbox   []database.OutboxEmail
+}
+
+// verification is a pending email verification code
+type verification struct {
+	hash      string
+	expiresAt time.Time
+	attempts  int
+}
+
+var _ database.Store = (*FakeStore)(nil)
+
+// NewFakeStore returns an empty store that timestamps records with the given clock
+func NewFakeStore(clock common.Clock) *FakeStore {
+	return &FakeStore{
+		clock:    clock,
+		sessions: map[string]*database.Session{},
+		detached: map[string]time.Time{},
+		keys:     map[string]string{},
+		codes:    map[string]*verification{},
+		sent:     map[string][]time.Time{},
+		handles:  map[string]string{},
+	}
+}
+
+// SetErr makes every call fail with err, or succeed again when it is nil.
+// It is safe to call while steps are writing to the store in the background.
+func (f *FakeStore) SetErr(err error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.err = err
+}
+
+// failure returns the error set with SetErr
+func (f *FakeStore) failure() error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return f.err
+}
+
+// Attempts returns a copy of the recorded attempts
+func (f *FakeStore) Attempts() []Attempt {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return append([]Attempt(nil), f.attempts...)
+}
+
+// Session returns a copy of the stored session with the given id, or nil
+func (f *FakeStore) Session(id string) *database.Session {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	session, ok := f.sessions[id]
+	if !ok {
+		return nil
+	}
+	copied := *session
+	return &copied
+}
+
+// Events returns a copy of the recorded step events
+func (f *FakeStore) Events() []database.StepEvent {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return append([]database.StepEvent(nil), f.events...)
+}
+
+// Outbox returns a copy of the outbox emails, oldest first
+func (f *FakeStore) Outbox() []database.OutboxEmail {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return append([]database.OutboxEmail(nil), f.outbox...)
+}
+
+func (f *FakeStore) FailedAttemptTimes(email string, since time.Time) ([]time.Time, error) {
+	if err := f.failure(); err != nil {
+		return nil, err
+	}
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	var times []time.Time
+	for _, a := range f.attempts {
+		if a.Email == strings.ToLower(email) && a.Failed && a.SubmittedAt.After(since) {
+			times = append(times, a.SubmittedAt)
+		}
+	}
+	return times, nil
+}
+
+func (f *FakeStore) HasUserWon(email string) (bool, error) {
+	if err := f.failure(); err != nil {
+		return false, err
+	}
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	for _, a := range f.attempts {
+		if a.Email == strings.ToLower(email) && !a.Failed {
+			return true, nil
+		}
+	}
+	return false, nil
+}
+
+func (f *FakeStore) CreateAttempt(email string, failed bool, details map[string]interface{}) (int, error) {
+	if err := f.failure(); err != nil {
+		return -1, err
+	}
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	id := len(f.attempts) + 1
+	f.attempts = append(f.attempts, Attempt{
+		ID:          id,
+		Email:       strings.ToLower(email),
+		Failed:      failed,
+		Details:     details,
+		SubmittedAt: f.clock.Now(),
+	})
+	return id, nil
+}
+
+func (f *FakeStore) SaveAttempt(sessionID string, email string, outcome database.Outcome, details map[string]interface{}) (int, error) {
+	if err := f.failure(); err != nil {
+		return -1, err
+	}
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	attempt := Attempt{
+		SessionID:   sessionID,
+		Email:       strings.ToLower(email),
+		Failed:      outcome != database.OutcomeWon,
+		Abandoned:   outcome == database.OutcomeAbandoned,
+		Details:     details,
+		SubmittedAt: f.clock.Now(),
+	}
+	for i, a := range f.attempts {
+		if a.SessionID != sessionID {
+			continue
+		}
+		if !a.Abandoned {
+			return -1, nil
+		}
+		attempt.ID = a.ID
+		f.attempts[i] = attempt
+		return a.ID, nil
+	}
+	attempt.ID = len(f.attempts) + 1
+	f.attempts = append(f.attempts, attempt)
+	return attempt.ID, nil
+}
+
+func (f *FakeStore) BoundPublicKey(email string) (string, error) {
+	if err := f.failure(); err != nil {
+		return "", err
+	}
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return f.keys[strings.ToLower(email)], nil
+}
+
+func (f *FakeStore) BindPublicKey(email string, fingerprint string) (bool, error) {
+	if err := f.failure(); err != nil {
+		return false, err
+	}
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	email = strings.ToLower(email)
+	bound, ok := f.keys[email]
+	if !ok {
+		f.keys[email] = fingerprint
+		return true, nil
+	}
+	return bound == fingerprint, nil
+}
+
+func (f *FakeStore) Leaderboard(limit int) ([]database.LeaderboardEntry, error) {
+	if err := f.failure(); err != nil {
+		return nil, err
+	}
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	best := map[string]Attempt{}
+	for _, a := range f.attempts {
+		took, ok := a.Details["time"].(time.Duration)
+		if a.Failed || !ok || f.handles[a.Email] == "" {
+			continue
+		}
+		if current, seen := best[a.Email]; !seen || took < current.Details["time"].(time.Duration) {
+			best[a.Email] = a
+		}
+	}
+
+	wins := make([]Attempt, 0, len(best))
+	for _, a := range best {
+		wins = append(wins, a)
+	}
+	sort.Slice(wins, func(i, j int) bool {
+		ti, tj := wins[i].Details["time"].(time.Duration), wins[j].Details["time"].(time.Duration)
+		if ti != tj {
+			return ti < tj
+		}
+		return wins[i].SubmittedAt.Before(wins[j].SubmittedAt)
+	})
+	if limit > 0 && len(wins) > limit {
+		wins = wins[:limit]
+	}
+
+	entries := []database.LeaderboardEntry{}
+	for i, a := range wins {
+		entries = append(entries, database.NewLeaderboardEntry(i+1, f.handles[a.Email], a.Details["time"].(time.Duration), a.SubmittedAt))
+	}
+	return entries, nil
+}
+
+func (f *FakeStore) DisplayHandle(email string) (string, error) {
+	if err := f.failure(); err != nil {
+		return "", err
+	}
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return f.handles[strings.ToLower(email)], nil
+}
+
+func (f *FakeStore) SetDisplayHandle(email string, handle string) error {
+	if err := f.failure(); err != nil {
+		return err
+	}
+	if handle != "" {
+		if err := database.ValidateHandle(handle); err != nil {
+			return err
+		}
+	}
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	email = strings.ToLower(email)
+	if !f.hasUser(email) {
+		return sql.ErrNoRows
+	}
+	for other, taken := range f.handles {
+		if other != email && strings.EqualFold(taken, handle) {
+			return database.ErrHandleTaken
+		}
+	}
+	if handle == "" {
+		delete(f.handles, email)
+	} else {
+		f.handles[email] = handle
+	}
+	return nil
+}
+
+// hasUser reports whether the email has an attempt, a session or a bound key. The caller holds the lock.
+func (f *FakeStore) hasUser(email string) bool {
+	if _, ok := f.keys[email]; ok {
+		return true
+	}
+	for _, a := range f.attempts {
+		if a.Email == email {
+			return true
+		}
+	}
+	for _, session := range f.sessions {
+		if session.Email == email {
+			return true
+		}
+	}
+	return false
+}
+
+func (f *FakeStore) CreateSession(id string, email string, startedAt time.Time, seed int64) error {
+	if err := f.failure(); err != nil {
+		return err
+	}
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	f.sessions[id] = &database.Session{
+		ID:        id,
+		Email:     strings.ToLower(email),
+		StartedAt: startedAt,
+		Seed:      seed,
+		UpdatedAt: f.clock.Now(),
+	}
+	return nil
+}
+
+func (f *FakeStore) SaveSession(id string, currentStep int, state []byte) error {
+	if err := f.failure(); err != nil {
+		return err
+	}
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	session, ok := f.sessions[id]
+	if !ok || session.Finished {
+		return nil
+	}
+	session.CurrentStep = currentStep
+	session.State = append([]byte(nil), state...)
+	session.UpdatedAt = f.clock.Now()
+	return nil
+}
+
+func (f *FakeStore) FinishSession(id string) error {
+	if err := f.failure(); err != nil {
+		return err
+	}
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	if session, ok := f.sessions[id]; ok {
+		session.Finished = true
+		session.UpdatedAt = f.clock.Now()
+	}
+	return nil
+}
+
+func (f *FakeStore) DisconnectSession(id string) error {
+	if err := f.failure(); err != nil {
+		return err
+	}
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	if session, ok := f.sessions[id]; ok && !session.Finished {
+		f.detached[id] = f.clock.Now()
+	}
+	return nil
+}
+
+func (f *FakeStore) ClaimResumableSession(email string, grace time.Duration) (*database.Session, error) {
+	if err := f.failure(); err != nil {
+		return nil, err
+	}
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	var latest *database.Session
+	since := f.clock.Now().Add(-grace)
+	for id, at := range f.detached {
+		session := f.sessions[id]
+		if session.Email != strings.ToLower(email) || session.Finished || session.State == nil || !at.After(since) {
+			continue
+		}
+		if latest == nil || at.After(f.detached[latest.ID]) {
+			latest = session
+		}
+	}
+	if latest == nil {
+		return nil, nil
+	}
+	delete(f.detached, latest.ID)
+	latest.UpdatedAt = f.clock.Now()
+	copied := *latest
+	return &copied, nil
+}
+
+func (f *FakeStore) GetSession(id string) (*database.Session, error) {
+	if err := f.failure(); err != nil {
+		return nil, err
+	}
+	session := f.Session(id)
+	if session == nil {
+		return nil, sql.ErrNoRows
+	}
+	return session, nil
+}
+
+func (f *FakeStore) RecordStepEvent(event database.StepEvent) error {
+	if err := f.failure(); err != nil {
+		return err
+	}
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	f.events = append(f.events, event)
+	return nil
+}
+
+func (f *FakeStore) EnqueueEmail(key string, kind string, recipient string, payload interface{}) (bool, error) {
+	if err := f.failure(); err != nil {
+		return false, err
+	}
+	data, err := json.Marshal(payload)
+	if err != nil {
+		return false, err
+	}
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	for _, e := range f.outbox {
+		if e.IdempotencyKey == key {
+			return false, nil
+		}
+	}
+	now := f.clock.Now()
+	f.outbox = append(f.outbox, database.OutboxEmail{
+		ID:             len(f.outbox) + 1,
+		IdempotencyKey: key,
+		Kind:           kind,
+		Recipient:      recipient,
+		Payload:        data,
+		Status:         database.OutboxPending,
+		NextAttemptAt:  now,
+		CreatedAt:      now,
+	})
+	return true, nil
+}
+
+func (f *FakeStore) ClaimEmails(limit int, lease time.Duration) ([]database.OutboxEmail, error) {
+	if err := f.failure(); err != nil {
+		return nil, err
+	}
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	now := f.clock.Now()
+	claimed := []database.OutboxEmail{}
+	for i := range f.outbox {
+		e := &f.outbox[i]
+		if len(claimed) == limit {
+			break
+		}
+		if e.Status != database.OutboxPending || e.NextAttemptAt.After(now) {
+			continue
+		}
+		e.Attempts++
+		e.NextAttemptAt = now.Add(lease)
+		claimed = append(claimed, *e)
+	}
+	return claimed, nil
+}
+
+// updateEmail applies fn to the outbox email with the given id
+func (f *FakeStore) updateEmail(id int, fn func(e *database.OutboxEmail)) error {
+	if err := f.failure(); err != nil {
+		return err
+	}
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	for i := range f.outbox {
+		if f.outbox[i].ID == id {
+			fn(&f.outbox[i])
+			return nil
+		}
+	}
+	return sql.ErrNoRows
+}
+
+func (f *FakeStore) MarkEmailSent(id int) error {
+	return f.updateEmail(id, func(e *database.OutboxEmail) {
+		sentAt := f.clock.Now()
+		e.Status, e.SentAt, e.LastError = database.OutboxSent, &sentAt, ""
+	})
+}
+
+func (f *FakeStore) RetryEmail(id int, reason string, wait time.Duration) error {
+	return f.updateEmail(id, func(e *database.OutboxEmail) {
+		e.LastError, e.NextAttemptAt = reason, f.clock.Now().Add(wait)
+	})
+}
+
+func (f *FakeStore) DeadLetterEmail(id int, reason string) error {
+	return f.updateEmail(id, func(e *database.OutboxEmail) {
+		e.Status, e.LastError = database.OutboxDead, reason
+	})
+}
+
+// ResendEmail requeues an email like an admin would, so tests can check the worker delivers it again
+func (f *FakeStore) ResendEmail(id int) error {
+	return f.updateEmail(id, func(e *database.OutboxEmail) {
+		e.Status, e.Attempts, e.NextAttemptAt, e.SentAt = database.OutboxPending, 0, f.clock.Now(), nil
+	})
+}
+
+func (f *FakeStore) CreateEmailVerification(email string, codeHash string, expiresAt time.Time, maxSends int, since time.Time) error {
+	if err := f.failure(); err != nil {
+		return err
+	}
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	email = strings.ToLower(email)
+	sent := 0
+	for _, at := range f.sent[email] {
+		if at.After(since) {
+			sent++
+		}
+	}
+	if sent >= maxSends {
+		return database.ErrTooManyVerifications
+	}
+	f.sent[email] = append(f.sent[email], f.clock.Now())
+	f.codes[email] = &verification{hash: codeHash, expiresAt: expiresAt}
+	return nil
+}
+
+func (f *FakeStore) CheckEmailVerification(email string, codeHash string, maxAttempts int) (database.VerificationStatus, error) {
+	if err := f.failure(); err != nil {
+		return database.VerificationMissing, err
+	}
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	email = strings.ToLower(email)
+	v, ok := f.codes[email]
+	switch {
+	case !ok:
+		return database.VerificationMissing, nil
+	case !f.clock.Now().Before(v.expiresAt):
+		return database.VerificationExpired, nil
+	case v.attempts >= maxAttempts:
+		return database.VerificationLocked, nil
+	case v.hash != codeHash:
+		v.attempts++
+		if v.attempts >= maxAttempts {
+			return database.VerificationLocked, nil
+		}
+		return database.VerificationWrongCode, nil
+	}
+	delete(f.codes, email)
+	return database.VerificationOK, nil
+}
@@ -2,14 +2,23 @@
 package main
 
 import (
+	"context"
+	"encoding/json"
+	"errors"
+	"flag"
 	"fmt"
+	"io"
 	"log"
 
 	// "log"
+	"net/http"
 	"net/mail"
 	"os"
+	"path/filepath"
+	"strconv"
 	"strings"
 	"sync"
+	"text/tabwriter"
 	"time"
 
 	"github.com/charmbracelet/bubbles/help"
@@ -24,10 +33,19 @@ import (
 	"github.com/charmbracelet/wish/logging"
 	"github.com/gdamore/tcell/v2/terminfo"
 	"github.com/joho/godotenv"
+	"github.com/tomaspiaggio/autonoma-hiring-ctf/admin"
+	"github.com/tomaspiaggio/autonoma-hiring-ctf/api"
 	"github.com/tomaspiaggio/autonoma-hiring-ctf/common"
+	"github.com/tomaspiaggio/autonoma-hiring-ctf/cooldown"
 	"github.com/tomaspiaggio/autonoma-hiring-ctf/database"
+	"github.com/tomaspiaggio/autonoma-hiring-ctf/email"
 	"github.com/tomaspiaggio/autonoma-hiring-ctf/glamour/steps"
+	"github.com/tomaspiaggio/autonoma-hiring-ctf/outbox"
+	"github.com/tomaspiaggio/autonoma-hiring-ctf/tokens"
+	"github.com/tomaspiaggio/autonoma-hiring-ctf/verify"
+	"github.com/tomaspiaggio/autonoma-hiring-ctf/webhook"
 	"github.com/muesli/termenv"
+	gossh "golang.org/x/crypto/ssh"
 )
 
 const (
@@ -36,6 +54,38 @@ const (
 	challengeDuration = 25 * time.Minute
 )
 
+// resumeGrace is how long after a disconnect a candidate can reconnect and resume their session.
+// It can be overridden with RESUME_GRACE.
+var resumeGrace = 10 * time.Minute
+
+// seedSecret keys the per-session content seeds, loaded from SEED_SECRET
+var seedSecret []byte
+
+// cooldownPolicy decides when candidates who failed can try again, set with COOLDOWN_POLICY
+var cooldownPolicy = cooldown.Default
+
+// mailer sends every email, selected with MAILER
+var mailer email.Mailer
+
+// emailTemplates renders every email, overridden by the files in EMAIL_TEMPLATES
+var emailTemplates *email.Templates
+
+// completionKeys sign the tokens winners get, loaded from JWT_KEYS or JWT_KEYS_FILE
+var completionKeys *tokens.Keyring
+
+// emailVerifier sends the codes candidates type to prove they own their email.
+// It is only set when VERIFY_EMAIL is true, and stores the codes keyed with VERIFY_SECRET.
+var emailVerifier *verify.Service
+
+// webhooks notifies WEBHOOK_URLS of how attempts end, nil when none is set
+var webhooks *webhook.Dispatcher
+
+// codeResendAfter is how long a candidate waits before asking for another verification code
+const codeResendAfter = time.Minute
+
+// tooManyCodes is shown once the entered address got all the codes it can for now
+const tooManyCodes = "We sent too many codes to that address. Please try again later."
+
 var (
 	titleStyle = lipgloss.NewStyle().
 			Bold(true).
@@ -94,17 +144,33 @@ type model struct {
 	help         help.Model
 	width        int
 	height       int
-	startTime    time.Time
 	emailInput   textinput.Model
 	emailEntered bool
 	emailError   string
 	stepManager  *steps.StepManager
 	activeTab    int
 	ready        bool
-	db           *database.DB
+	db           database.Store
+
+	// requireKey binds emails to the SSH key they first play with.
+	// It is only set for SSH sessions, fingerprint is empty when the client offered no key.
+	requireKey  bool
+	fingerprint string
+
+	// verifier sends the code that proves the candidate owns the email, nil when verification is off
+	verifier   *verify.Service
+	verifying  bool
+	codeInput  textinput.Model
+	codeError  string
+	codeSentAt time.Time
+
+	// showLeaderboard swaps the rules on the welcome screen for the fastest completions
+	showLeaderboard  bool
+	leaderboard      []database.LeaderboardEntry
+	leaderboardError string
 }
 
-func initialModel(db *database.DB) model {
+func initialModel(db database.Store, clock common.Clock) model {
 	// Initialize email input
 	ti := textinput.New()
 	ti.Placeholder = "you@example.com"
@@ -112,19 +178,31 @@ func initialModel(db *database.DB) model {
 	ti.Width = 40
 	ti.Prompt = promptStyle.Render("Email: ")
 
+	// Initialize verification code input
+	ci := textinput.New()
+	ci.Placeholder = "123456"
+	ci.CharLimit = 6
+	ci.Width = 10
+	ci.Prompt = promptStyle.Render("Code: ")
+
 	// Create all steps
 	allSteps := []steps.Step{}
 
 	// Create step manager
-	startTime := time.Now()
-	sm := steps.NewStepManager(allSteps, startTime, db)
+	sm := steps.NewStepManager(allSteps, clock.Now(), db)
+	sm.Clock = clock
+	sm.TimeLimit = challengeDuration
+	sm.SeedSecret = seedSecret
+	sm.Webhooks = webhooks
+	sm.Mailer = mailer
+	sm.EmailTemplates = emailTemplates
+	sm.Keys = completionKeys
 
 	return model{
 		keys:         keys,
 		help:         help.New(),
 		width:        80,
 		height:       24,
-		startTime:    startTime,
 		emailInput:   ti,
 		emailEntered: false,
 		emailError:   "",
@@ -132,6 +210,8 @@ func initialModel(db *database.DB) model {
 		activeTab:    0,
 		ready:        false,
 		db:           db,
+		verifier:     emailVerifier,
+		codeInput:    ci,
 	}
 }
 
@@ -181,8 +261,15 @@ func (m model) Update(msg tea.Msg) (tea.Model, tea.Cmd) {
 		m.stepManager.UpdateCurrentStep(msg)
 		if m.emailEntered {
 			m.stepManager.UpdateCurrentStep(msg)
+			m.stepManager.Checkpoint()
 		}
-		return m, tickEvery()
+		m.enforceDeadline()
+		if !m.stepManager.StepFailed && !m.stepManager.Done {
+			return m, tickEvery()
+		}
+
+	case deadlineMsg:
+		m.enforceDeadline()
 
 	case tea.KeyMsg:
 		// Global keybindings
@@ -190,23 +277,48 @@ func (m model) Update(msg tea.Msg) (tea.Model, tea.Cmd) {
 			return m, tea.Quit
 		}
 
+		// While verifying the email, keys go to the code input
+		if m.verifying {
+			return m.updateVerification(msg)
+		}
+
 		// If email not entered yet, handle email input
 		if !m.emailEntered {
+			if msg.String() == "ctrl+l" {
+				m.toggleLeaderboard()
+				return m, nil
+			}
 			if msg.String() == "enter" {
 				email := m.emailInput.Value()
 
-				hasWon := false
-				failedToday := false
+				// Emails are bound to the key they first play with, so nobody can use someone else's attempts.
+				// The key is only bound once a session starts, after the email is verified, so here it is just checked.
+				keyMatches, canResume := true, true
 				var dbErr error
+				if m.requireKey && isValidEmail(email) {
+					if m.fingerprint == "" {
+						keyMatches = false
+					} else if bound, err := m.db.BoundPublicKey(email); err != nil {
+						dbErr = fmt.Errorf("checking public key: %w", err)
+					} else {
+						keyMatches = bound == "" || bound == m.fingerprint
+						canResume = bound == m.fingerprint
+					}
+				}
+
+				hasWon := false
+				var nextAttempt time.Time
+				var resumable *database.Session
 				var wg sync.WaitGroup
 				var mu sync.Mutex // Mutex to protect access to shared error variable
 
 				wg.Add(2)
 
-				// Check for failed attempts concurrently
+				// Check for failed attempts concurrently, the cooldown policy says when they can play again
 				go func() {
 					defer wg.Done()
-					res, err := m.db.DoesUserHaveFailedAttemptsToday(email)
+					now := m.stepManager.Now()
+					failures, err := m.db.FailedAttemptTimes(email, now.Add(-cooldownPolicy.Lookback()))
 					if err != nil {
 						mu.Lock()
 						if dbErr == nil { // Store the first error encountered
@@ -215,7 +327,7 @@ func (m model) Update(msg tea.Msg) (tea.Model, tea.Cmd) {
 						mu.Unlock()
 						return
 					}
-					failedToday = res
+					nextAttempt = cooldownPolicy.NextAttempt(failures, now)
 				}()
 
 				// Check for win status concurrently
@@ -233,7 +345,25 @@ func (m model) Update(msg tea.Msg) (tea.Model, tea.Cmd) {
 					hasWon = res
 				}()
 
-				// Wait for both checks to complete
+				// Claim a disconnected session to resume concurrently, only for the key the email is bound to
+				if keyMatches && canResume {
+					wg.Add(1)
+					go func() {
+						defer wg.Done()
+						res, err := m.db.ClaimResumableSession(email, resumeGrace)
+						if err != nil {
+							mu.Lock()
+							if dbErr == nil { // Store the first error encountered
+								dbErr = fmt.Errorf("checking resumable session: %w", err)
+							}
+							mu.Unlock()
+							return
+						}
+						resumable = res
+					}()
+				}
+
+				// Wait for all checks to complete
 				wg.Wait()
 
 				// Handle database errors first
@@ -249,6 +379,16 @@ func (m model) Update(msg tea.Msg) (tea.Model, tea.Cmd) {
 					return m, tea.Batch(cmds...)
 				}
 
+				if !keyMatches {
+					m.emailEntered = true
+					m.emailError = ""
+					m.emailInput.Prompt = emailStyle.Render("Email: ")
+					m.stepManager.SetEmail(email)
+					m.stepManager.Steps = []steps.Step{steps.NewKeyMismatchStep(m.stepManager, m.fingerprint)}
+					cmds = append(cmds, m.stepManager.Init())
+					return m, tea.Batch(cmds...)
+				}
+
 				// Handle specific cases: HasWon or HasFailedToday
 				if hasWon {
 					m.emailEntered = true
@@ -262,27 +402,54 @@ func (m model) Update(msg tea.Msg) (tea.Model, tea.Cmd) {
 					return m, tea.Batch(cmds...)
 				}
 
-				if failedToday {
+				if resumable != nil {
+					m.stepManager.SetEmail(email)
+					if err := m.stepManager.Restore(resumable); err != nil {
+						// Fall through to the regular checks, the stored state is unusable
+						log.Printf("Error restoring session %s for %s: %v", resumable.ID, email, err)
+					} else {
+						log.Printf("Resumed session %s for %s at step %d", resumable.ID, email, m.stepManager.CurrentStep)
+						m.emailEntered = true
+						m.emailError = ""
+						m.emailInput.Prompt = emailStyle.Render("Email: ")
+						cmds = append(cmds, m.stepManager.Init(), m.scheduleDeadline())
+						return m, tea.Batch(cmds...)
+					}
+				}
+
+				if nextAttempt.After(m.stepManager.Now()) {
 					m.emailEntered = true
 					m.emailError = ""
 					m.emailInput.Prompt = emailStyle.Render("Email: ")
 					m.stepManager.SetEmail(email) // Set email for potential logging later if needed
 					// Create and set the "HasFailed" step
-					failTodayStep := steps.NewHasFailedStep(m.stepManager)
+					failTodayStep := steps.NewHasFailedStep(m.stepManager, nextAttempt)
 					m.stepManager.Steps = []steps.Step{failTodayStep}
 					cmds = append(cmds, m.stepManager.Init())
 					return m, tea.Batch(cmds...)
 				}
 
-				// --- Original flow if user is valid and hasn't won/failed today ---
+				// --- Original flow if user is valid and isn't cooling down ---
 				m.stepManager.SetEmail(email)
 				if email != "" && isValidEmail(email) {
-					m.emailEntered = true
-					m.emailError = ""
-					m.emailInput.Prompt = emailStyle.Render("Email: ")
-					m.stepManager.Steps = steps.GenerateSteps(m.stepManager)
-					cmds = append(cmds, m.stepManager.Init())
-					return m, tea.Batch(cmds...)
+					// New challenges only start once the candidate proves they own the email
+					if m.verifier != nil {
+						if err := m.sendCode(); errors.Is(err, database.ErrTooManyVerifications) {
+							m.emailError = tooManyCodes
+							m.emailInput.Prompt = errorStyle.Render("Email: ")
+							return m, nil
+						} else if err != nil {
+							m.emailError = "We couldn't send a code to that address. Please check it and try again."
+							m.emailInput.Prompt = errorStyle.Render("Email: ")
+							return m, nil
+						}
+						m.verifying = true
+						m.emailError = ""
+						m.emailInput.Blur()
+						m.codeInput.Focus()
+						return m, textinput.Blink
+					}
+					return m.startChallenge(email)
 				} else {
 					m.emailError = "Invalid email format. Please try again."
 					m.emailInput.Prompt = errorStyle.Render("Email: ")
@@ -310,41 +477,185 @@ func (m model) Update(msg tea.Msg) (tea.Model, tea.Cmd) {
 		m.height = msg.Height
 		m.help.Width = msg.Width
 		m.ready = true
+
+	default:
+		// Results of commands started by a step, such as an evaluated submission
+		if m.emailEntered {
+			if stepCmd := m.stepManager.UpdateCurrentStep(msg); stepCmd != nil {
+				cmds = append(cmds, stepCmd)
+			}
+		}
 	}
 
+	if m.stepManager.Done {
+		// The win was recorded when the end screen was first shown
+		return m, tea.Quit
+	}
 	if m.stepManager.StepFailed {
-		// Only record attempt if it wasn't a pre-check failure (HasWon/HasFailedToday)
-		// and the failure wasn't due to a DB error during the initial check.
-		if len(m.stepManager.Steps) > 1 || (len(m.stepManager.Steps) == 1 && m.stepManager.FailureMsg != "An error occurred while checking your status. Please try again later.") {
-			go func() {
-				// the json has the last step that was completed, the time it took, and the failure message
-				_, err := m.db.CreateAttempt(m.stepManager.Email, m.stepManager.StepFailed, map[string]interface{}{
-					"step": m.stepManager.CurrentStep,
-					"time": time.Since(m.startTime),
-					"msg":  m.stepManager.FailureMsg,
-				})
-				if err != nil {
-					log.Printf("Error creating attempt for %s: %v\n", m.stepManager.Email, err)
-				}
-			}()
-		}
+		// Pre-check failures (HasWon, cooldown, DB errors) never started a session and record nothing
+		m.stepManager.RecordAttempt(database.OutcomeFailed)
 		return m, tea.Quit
 	}
 
 	return m, tea.Batch(cmds...)
 }
 
+// startChallenge loads the challenge pack and starts a new session for the email
+func (m model) startChallenge(email string) (tea.Model, tea.Cmd) {
+	m.emailEntered = true
+	m.emailError = ""
+	m.emailInput.Prompt = emailStyle.Render("Email: ")
+
+	// The email is verified by now, so bind it to the key. Another key may have been bound since the check.
+	if m.requireKey {
+		ok, err := m.db.BindPublicKey(email, m.fingerprint)
+		if err != nil {
+			log.Printf("Error binding public key for %s: %v", email, err)
+			m.stepManager.Steps = []steps.Step{steps.NewFailedStep(0, 0, "An error occurred while checking your status. Please try again later.", m.stepManager)}
+			return m, m.stepManager.Init()
+		}
+		if !ok {
+			m.stepManager.SetEmail(email)
+			m.stepManager.Steps = []steps.Step{steps.NewKeyMismatchStep(m.stepManager, m.fingerprint)}
+			return m, m.stepManager.Init()
+		}
+	}
+
+	// The pack is read on every session so a new season can ship by replacing the file
+	pack, err := steps.LoadPack(os.Getenv("CHALLENGE_PACK"))
+	if err == nil {
+		err = m.stepManager.StartSession(pack)
+	}
+	if err != nil {
+		log.Printf("Error loading challenge pack for %s: %v", email, err)
+		m.stepManager.Steps = []steps.Step{steps.NewFailedStep(0, 0, "The challenge could not be loaded. Please try again later.", m.stepManager)}
+		return m, m.stepManager.Init()
+	}
+	return m, tea.Batch(m.stepManager.Init(), m.scheduleDeadline())
+}
+
+// deadlineMsg is sent when the challenge time of the session is expected to run out
+type deadlineMsg struct{}
+
+// scheduleDeadline sends a deadlineMsg once the session deadline is reached
+func (m model) scheduleDeadline() tea.Cmd {
+	return tea.Tick(m.stepManager.Deadline().Sub(m.stepManager.Now()), func(time.Time) tea.Msg {
+		return deadlineMsg{}
+	})
+}
+
+// enforceDeadline fails the session once its time is up. It runs on every tick
+// as well as on the deadline message, so a late or lost timer can't extend the challenge.
+func (m model) enforceDeadline() {
+	sm := m.stepManager
+	if sm.SessionID == "" || sm.StepFailed || sm.Finished() {
+		return
+	}
+	if sm.Now().Before(sm.Deadline()) {
+		return
+	}
+	sm.SetTimedOutStep(fmt.Sprintf("Time's up. You run out of time. You had %d minutes to complete the challenge.", int(sm.TimeLimit.Minutes())))
+}
+
+// toggleLeaderboard shows or hides the leaderboard on the welcome screen, loading it when shown
+func (m *model) toggleLeaderboard() {
+	m.showLeaderboard = !m.showLeaderboard
+	if !m.showLeaderboard || m.db == nil {
+		return
+	}
+	entries, err := m.db.Leaderboard(steps.LeaderboardSize)
+	if err != nil {
+		log.Printf("Error loading the leaderboard: %v", err)
+		m.leaderboardError = "The leaderboard is not available right now."
+		return
+	}
+	m.leaderboard = entries
+	m.leaderboardError = ""
+}
+
+// sendCode emails a new verification code to the entered address
+func (m *model) sendCode() error {
+	m.codeSentAt = m.stepManager.Now()
+	if err := m.verifier.Send(m.emailInput.Value()); err != nil {
+		log.Printf("Error sending verification code to %s: %v", m.emailInput.Value(), err)
+		return err
+	}
+	return nil
+}
+
+// updateVerification handles the keys typed while the candidate enters their verification code
+func (m model) updateVerification(msg tea.KeyMsg) (tea.Model, tea.Cmd) {
+	switch msg.String() {
+	case "esc":
+		// Back to the email prompt to fix a typo
+		m.verifying = false
+		m.codeError = ""
+		m.codeInput.Reset()
+		m.codeInput.Blur()
+		m.emailInput.Focus()
+		return m, textinput.Blink
+
+	case "ctrl+r":
+		if wait := codeResendAfter - m.stepManager.Now().Sub(m.codeSentAt); wait > 0 {
+			m.codeError = fmt.Sprintf("You can ask for a new code in %s.", wait.Round(time.Second))
+			return m, nil
+		}
+		m.codeInput.Reset()
+		if err := m.sendCode(); errors.Is(err, database.ErrTooManyVerifications) {
+			m.codeError = tooManyCodes
+			return m, nil
+		} else if err != nil {
+			m.codeError = "We couldn't send you a new code. Please try again later."
+			return m, nil
+		}
+		m.codeError = "We sent you a new code."
+		return m, nil
+
+	case "enter":
+		email := m.emailInput.Value()
+		status, err := m.verifier.Check(email, m.codeInput.Value())
+		m.codeInput.Reset()
+		if err != nil {
+			log.Printf("Error checking verification code for %s: %v", email, err)
+			m.codeError = "We couldn't check your code. Please try again."
+			return m, nil
+		}
+
+		switch status {
+		case database.VerificationOK:
+			m.verifying = false
+			m.codeError = ""
+			return m.startChallenge(email)
+		case database.VerificationWrongCode:
+			m.codeError = "That code is not right. Please try again."
+		case database.VerificationExpired:
+			m.codeError = "That code expired. Press ctrl+r to get a new one."
+		case database.VerificationLocked:
+			m.codeError = "Too many wrong codes. Press ctrl+r to get a new one."
+		default:
+			m.codeError = "There is no code pending. Press ctrl+r to get one."
+		}
+		return m, nil
+	}
+
+	var cmd tea.Cmd
+	m.codeInput, cmd = m.codeInput.Update(msg)
+	return m, cmd
+}
+
 func (m model) View() string {
 	if !m.ready {
 		return "Initializing..."
 	}
 
-	// Calculate time left
-	timeLeft := challengeDuration - time.Since(m.startTime)
+	// Calculate time left, the clock only runs once a session started
+	timeLeft := challengeDuration
+	if m.stepManager.SessionID != "" {
+		timeLeft = m.stepManager.Deadline().Sub(m.stepManager.Now())
+	}
 	var timeLeftStr string
 	if timeLeft <= 0 {
 		timeLeftStr = "Time's up!"
-		m.stepManager.SetFailedStep("Time's up. You run out of time. You had 25 minutes to complete the challenge.")
 	} else {
 		minutes := int(timeLeft.Minutes())
 		seconds := int(timeLeft.Seconds()) % 60
@@ -369,6 +680,14 @@ func (m model) View() string {
 		if m.emailError != "" {
 			emailView += "\n  " + errorStyle.Render(m.emailError)
 		}
+		if m.verifying {
+			emailView += "\n\n  " + helpStyle.Render("We sent a 6-digit code to your email. Type it below to start.") +
+				"\n  " + helpStyle.Render("ctrl+r sends a new code, esc changes the email.") +
+				"\n\n  " + m.codeInput.View()
+			if m.codeError != "" {
+				emailView += "\n  " + errorStyle.Render(m.codeError)
+			}
+		}
 
 		s := fmt.Sprintf("\n\n  %s\t%s\n\n %s\n\n %s\n %s\n\n  %s\n\n",
 			title,
@@ -382,7 +701,7 @@ func (m model) View() string {
 		// Add challenge rules
 		rules := []string{
 			"IMPORTANT:",
-			"- You can only do this test once a day",
+			"- " + cooldownPolicy.Describe(),
 			"- If you exit or run out of time, you're done",
 			"- Challenges become more difficult as you go along",
 			"- Some challenges are time based and require extra concentration",
@@ -391,7 +710,15 @@ func (m model) View() string {
 		}
 
 		rulesText := helpStyle.Render("  " + strings.Join(rules, "\n  "))
+		if m.showLeaderboard {
+			board := steps.RenderLeaderboard(m.leaderboard, "")
+			if m.leaderboardError != "" {
+				board = errorStyle.Render(m.leaderboardError)
+			}
+			rulesText = "  " + titleStyle.Render("Fastest completions") + "\n\n" + lipgloss.NewStyle().PaddingLeft(2).Render(board)
+		}
 		s += "\n" + rulesText + "\n"
+		s += "\n  " + helpStyle.Render("ctrl+l toggles the leaderboard of fastest completions") + "\n"
 
 		return s
 	}
@@ -477,6 +804,146 @@ func (m model) View() string {
 	return doc.String()
 }
 
+// replayModel rebuilds the exact challenge a candidate got in the given session, from its stored seed and pack.
+// Nothing is recorded while replaying.
+func replayModel(db database.Store, sessionID string) (model, error) {
+	session, err := db.GetSession(sessionID)
+	if err != nil {
+		return model{}, fmt.Errorf("loading session %s: %w", sessionID, err)
+	}
+	pack, err := steps.LoadPack(os.Getenv("CHALLENGE_PACK"))
+	if err != nil {
+		return model{}, err
+	}
+
+	m := initialModel(nil, common.SystemClock{})
+	if err := m.stepManager.Replay(session, pack); err != nil {
+		return model{}, err
+	}
+	m.emailInput.SetValue(session.Email)
+	m.emailEntered = true
+	return m, nil
+}
+
+// migrator manages the database schema
+type migrator interface {
+	MigrateUp() ([]database.Migration, error)
+	MigrateDown() (*database.Migration, error)
+	MigrationStatus() ([]database.MigrationStatus, error)
+}
+
+// runMigrate runs the migrate subcommand: up applies pending migrations, down reverts the latest
+// one and status lists every migration
+func runMigrate(db migrator, args []string, out io.Writer) error {
+	if len(args) != 1 {
+		return errors.New("usage: migrate up|down|status")
+	}
+
+	switch args[0] {
+	case "up":
+		applied, err := db.MigrateUp()
+		if err != nil {
+			return err
+		}
+		if len(applied) == 0 {
+			fmt.Fprintln(out, "No pending migrations.")
+		}
+		for _, m := range applied {
+			fmt.Fprintf(out, "Applied %04d_%s\n", m.Version, m.Name)
+		}
+	case "down":
+		reverted, err := db.MigrateDown()
+		if err != nil {
+			return err
+		}
+		if reverted == nil {
+			fmt.Fprintln(out, "No migrations to revert.")
+			return nil
+		}
+		fmt.Fprintf(out, "Reverted %04d_%s\n", reverted.Version, reverted.Name)
+	case "status":
+		statuses, err := db.MigrationStatus()
+		if err != nil {
+			return err
+		}
+		w := tabwriter.NewWriter(out, 0, 0, 2, ' ', 0)
+		fmt.Fprintln(w, "VERSION\tNAME\tAPPLIED")
+		for _, status := range statuses {
+			applied := "pending"
+			if status.AppliedAt != nil {
+				applied = status.AppliedAt.UTC().Format("2006-01-02 15:04:05")
+			}
+			fmt.Fprintf(w, "%04d\t%s\t%s\n", status.Version, status.Name, applied)
+		}
+		return w.Flush()
+	default:
+		return fmt.Errorf("unknown migrate command %q, expected up, down or status", args[0])
+	}
+	return nil
+}
+
+// runPreviewEmail renders an email with sample data and writes its HTML to a file, and its text
+// next to it, so template changes can be checked in a browser before they ship
+func runPreviewEmail(templates *email.Templates, args []string, out io.Writer) error {
+	flags := flag.NewFlagSet("preview-email", flag.ContinueOnError)
+	flags.SetOutput(out)
+	campaign := flags.String("campaign", "", "challenge pack whose template overrides to use")
+	path := flags.String("out", "", "file the HTML is written to (default NAME.html)")
+	if err := flags.Parse(args); err != nil {
+		return err
+	}
+	if flags.NArg() != 1 {
+		return fmt.Errorf("usage: preview-email [--campaign NAME] [--out FILE] %s|%s", email.EndEmailKind, email.VerificationEmail)
+	}
+	name := flags.Arg(0)
+
+	msg, err := templates.Preview(*campaign, name)
+	if err != nil {
+		return err
+	}
+	if *path == "" {
+		*path = name + ".html"
+	}
+	textPath := strings.TrimSuffix(*path, filepath.Ext(*path)) + ".txt"
+	if err := os.WriteFile(*path, []byte(msg.HTML), 0o644); err != nil {
+		return err
+	}
+	if err := os.WriteFile(textPath, []byte(msg.Text+"\n"), 0o644); err != nil {
+		return err
+	}
+	fmt.Fprintf(out, "Subject: %s\nWrote %s and %s\n", msg.Subject, *path, textPath)
+	return nil
+}
+
+// runJWKS writes the JSON Web Key Set of the Ed25519 keys, to publish wherever the tokens are verified
+func runJWKS(keys *tokens.Keyring, out io.Writer) error {
+	set := keys.JWKS()
+	if len(set.Keys) == 0 {
+		return errors.New("no public keys to publish, set JWT_KEYS with EdDSA keys")
+	}
+	encoder := json.NewEncoder(out)
+	encoder.SetIndent("", "  ")
+	return encoder.Encode(set)
+}
+
+// contextKey keys values stored in the ssh session context
+type contextKey string
+
+// stepManagerKey holds the StepManager of the session's challenge
+const stepManagerKey contextKey = "step-manager"
+
+// sessionLifecycle runs once the challenge program stopped, whether the candidate finished, quit
+// or closed their terminal. A session that didn't record its outcome is recorded as abandoned.
+func sessionLifecycle(next ssh.Handler) ssh.Handler {
+	return func(s ssh.Session) {
+		next(s)
+		if sm, ok := s.Context().Value(stepManagerKey).(*steps.StepManager); ok {
+			sm.Abandon()
+			sm.Wait()
+		}
+	}
+}
+
 // teaHandler creates a new bubbletea program for each ssh session
 func teaHandler(s ssh.Session, db *database.DB) (tea.Model, []tea.ProgramOption) {
 	pty, _, active := s.Pty()
@@ -504,7 +971,12 @@ func teaHandler(s ssh.Session, db *database.DB) (tea.Model, []tea.ProgramOption)
 	} else {
 		os.Setenv("TERM", "xterm-256color")
 	}
-	m := initialModel(db)
+	m := initialModel(db, common.SystemClock{})
+	m.requireKey = true
+	if key := s.PublicKey(); key != nil {
+		m.fingerprint = gossh.FingerprintSHA256(key)
+	}
+	s.Context().SetValue(stepManagerKey, m.stepManager)
 
 	return m, []tea.ProgramOption{
 		tea.WithAltScreen(),
@@ -523,6 +995,51 @@ func main() {
 		log.Println("No .env file found, using system environment variables")
 	}
 
+	emailTemplates = email.NewTemplates(os.Getenv("EMAIL_TEMPLATES"))
+
+	completionKeys, err = tokens.FromEnv()
+	if err != nil {
+		log.Fatalf("Invalid JWT keys: %v", err)
+	}
+
+	// JWKS mode, prints the public keys the completion tokens can be verified with
+	if len(os.Args) > 1 && os.Args[1] == "jwks" {
+		if err := runJWKS(completionKeys, os.Stdout); err != nil {
+			log.Fatalln(err)
+		}
+		return
+	}
+
+	if completionKeys == nil {
+		log.Println("JWT_KEYS is not set, completion tokens are signed with a key generated for this run")
+		completionKeys, err = tokens.Generate("ephemeral")
+		if err != nil {
+			log.Fatalln(err)
+		}
+	}
+
+	// Preview mode, renders an email to a file without a database
+	if len(os.Args) > 1 && os.Args[1] == "preview-email" {
+		if err := runPreviewEmail(emailTemplates, os.Args[2:], os.Stdout); err != nil {
+			log.Fatalln(err)
+		}
+		return
+	}
+
+	// Migrate mode, manages the schema without applying pending migrations first
+	if len(os.Args) > 1 && os.Args[1] == "migrate" {
+		db, err := database.Open(os.Getenv("DATABASE_URL"))
+		if err != nil {
+			log.Fatalln(err)
+		}
+		err = runMigrate(db, os.Args[2:], os.Stdout)
+		db.Close()
+		if err != nil {
+			log.Fatalln(err)
+		}
+		return
+	}
+
 	db, err := database.New(os.Getenv("DATABASE_URL"))
 
 	if err != nil {
@@ -532,13 +1049,62 @@ func main() {
 	}
 	defer db.Close()
 
+	if grace := os.Getenv("RESUME_GRACE"); grace != "" {
+		resumeGrace, err = time.ParseDuration(grace)
+		if err != nil {
+			log.Fatalf("Invalid RESUME_GRACE %q: %v", grace, err)
+		}
+	}
+
+	seedSecret = []byte(os.Getenv("SEED_SECRET"))
+	if len(seedSecret) == 0 {
+		log.Println("SEED_SECRET is not set, challenge content can be predicted from the email and attempt ID")
+	}
+
+	cooldownPolicy, err = cooldown.Parse(os.Getenv("COOLDOWN_POLICY"))
+	if err != nil {
+		log.Fatalf("Invalid COOLDOWN_POLICY: %v", err)
+	}
+
+	mailer, err = email.FromEnv()
+	if err != nil {
+		log.Fatalln(err)
+	}
+	log.Printf("Sending emails with %T\n", mailer)
+
+	if verifyEmail, _ := strconv.ParseBool(os.Getenv("VERIFY_EMAIL")); verifyEmail {
+		verifySecret := []byte(os.Getenv("VERIFY_SECRET"))
+		if len(verifySecret) == 0 {
+			log.Fatalln("VERIFY_SECRET is required when VERIFY_EMAIL is true")
+		}
+		emailVerifier = verify.New(verifySecret, db, mailer)
+		emailVerifier.Templates = emailTemplates
+		if ttl := os.Getenv("VERIFY_CODE_TTL"); ttl != "" {
+			emailVerifier.TTL, err = time.ParseDuration(ttl)
+			if err != nil {
+				log.Fatalf("Invalid VERIFY_CODE_TTL %q: %v", ttl, err)
+			}
+		}
+		log.Println("Email verification is enabled")
+	}
+
+	webhooks, err = webhook.FromEnv()
+	if err != nil {
+		log.Fatalln(err)
+	}
+	if webhooks != nil {
+		log.Printf("Sending webhooks to %d URLs\n", len(webhooks.URLs))
+	}
+
 	fmt.Println("TERM", os.Getenv("TERM"))
 	fmt.Println("COLORTERM", os.Getenv("COLORTERM"))
 
 	// Local mode (command line)
 	if len(os.Args) > 1 && os.Args[1] == "local" {
+		go outbox.New(db, outbox.Senders(mailer, emailTemplates)).Run(context.Background())
+		m := initialModel(db, common.SystemClock{})
 		p := tea.NewProgram(
-			initialModel(db),
+			m,
 			tea.WithAltScreen(),
 			tea.WithMouseAllMotion(),
 			tea.WithMouseCellMotion(),
@@ -548,6 +1114,44 @@ func main() {
 			log.Fatal("Error running program:", err)
 			os.Exit(1)
 		}
+		m.stepManager.Abandon()
+		m.stepManager.Wait()
+		webhooks.Wait()
+		return
+	}
+
+	// Admin mode, runs an admin command against the database directly
+	if len(os.Args) > 1 && os.Args[1] == "admin" {
+		if err := admin.Run(db, os.Args[2:], os.Stdout); err != nil {
+			log.Fatalln(err)
+		}
+		return
+	}
+
+	// Report and export modes, print a report such as `report funnel --by day` or export the attempts
+	if len(os.Args) > 1 && (os.Args[1] == "report" || os.Args[1] == "export") {
+		if err := admin.Run(db, os.Args[1:], os.Stdout); err != nil {
+			log.Fatalln(err)
+		}
+		return
+	}
+
+	// Replay mode, regenerates the challenge a candidate got from their session ID
+	if len(os.Args) > 2 && os.Args[1] == "replay" {
+		m, err := replayModel(db, os.Args[2])
+		if err != nil {
+			log.Fatalf("Error replaying session: %v", err)
+		}
+		p := tea.NewProgram(
+			m,
+			tea.WithAltScreen(),
+			tea.WithMouseAllMotion(),
+			tea.WithMouseCellMotion(),
+		)
+
+		if _, err := p.Run(); err != nil {
+			log.Fatal("Error running program:", err)
+		}
 		return
 	}
 
@@ -570,14 +1174,31 @@ func main() {
 		log.Println("SSH host key already exists")
 	}
 
+	// Public keys allowed to run admin commands, in authorized_keys format
+	adminKeys, err := admin.ParseKeys([]byte(os.Getenv("ADMIN_KEYS")))
+	if err != nil {
+		log.Fatalln(err)
+	}
+	log.Printf("Loaded %d admin keys\n", len(adminKeys))
+
 	// Set up ssh server
 	s, err := wish.NewServer(
 		wish.WithAddress(fmt.Sprintf("%s:%d", host, port)),
 		wish.WithHostKeyPath(keyPath),
+		// Anyone can connect, the key is checked against the email once it is entered.
+		// Keyboard interactive lets clients without a key in, to be told they need one.
+		wish.WithPublicKeyAuth(func(ctx ssh.Context, key ssh.PublicKey) bool {
+			return true
+		}),
+		wish.WithKeyboardInteractiveAuth(func(ctx ssh.Context, challenger gossh.KeyboardInteractiveChallenge) bool {
+			return true
+		}),
 		wish.WithMiddleware(
+			sessionLifecycle,
 			bm.Middleware(func(s ssh.Session) (tea.Model, []tea.ProgramOption) {
 				return teaHandler(s, db)
 			}),
+			admin.Middleware(db, adminKeys),
 			logging.Middleware(),
 		),
 	)
@@ -585,9 +1206,35 @@ func main() {
 		log.Fatalln(err)
 	}
 
+	// Deliver the queued emails in the background
+	go outbox.New(db, outbox.Senders(mailer, emailTemplates)).Run(context.Background())
+
+	// Serve the admin API next to the ssh server, only when it has tokens to check
+	if apiTokens := api.ParseTokens(os.Getenv("ADMIN_API_TOKENS")); len(apiTokens) > 0 {
+		addr := os.Getenv("ADMIN_API_ADDR")
+		if addr == "" {
+			addr = ":8080"
+		}
+		apiServer := &http.Server{
+			Addr:              addr,
+			Handler:           api.NewHandler(db, apiTokens),
+			ReadHeaderTimeout: 10 * time.Second,
+		}
+		go func() {
+			log.Printf("Starting admin API on %s with %d tokens\n", addr, len(apiTokens))
+			if err := apiServer.ListenAndServe(); err != nil {
+				log.Fatalf("Admin API stopped: %v", err)
+			}
+		}()
+	}
+
 	// Start ssh server
 	fmt.Printf("Starting Autonoma CTF challenge SSH server on %s:%d...\n", host, port)
 	fmt.Println("Connect with: ssh localhost -p 2222")
 	fmt.Println("Or run in local mode: go run main.go local")
+	fmt.Println("Or replay a candidate's challenge: go run main.go replay <session-id>")
+	fmt.Println("Or run an admin command: go run main.go admin <command>")
+	fmt.Println("Or print the funnel report: go run main.go report funnel")
+	fmt.Println("Or export the attempts: go run main.go export --format csv > attempts.csv")
 	log.Fatalln(s.ListenAndServe())
 }
@@ -0,0 +1,777 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	tea "github.com/charmbracelet/bubbletea"
+	"github.com/charmbracelet/ssh"
+	"github.com/charmbracelet/wish"
+	"github.com/charmbracelet/wish/testsession"
+	"github.com/tomaspiaggio/autonoma-hiring-ctf/common"
+	"github.com/tomaspiaggio/autonoma-hiring-ctf/cooldown"
+	"github.com/tomaspiaggio/autonoma-hiring-ctf/database"
+	"github.com/tomaspiaggio/autonoma-hiring-ctf/email"
+	"github.com/tomaspiaggio/autonoma-hiring-ctf/glamour/steps"
+	"github.com/tomaspiaggio/autonoma-hiring-ctf/harness"
+	"github.com/tomaspiaggio/autonoma-hiring-ctf/tokens"
+	"github.com/tomaspiaggio/autonoma-hiring-ctf/verify"
+	gossh "golang.org/x/crypto/ssh"
+)
+
+const testEmail = "candidate@example.com"
+
+func newTestModel(t *testing.T) (tea.Model, *harness.FakeClock, *harness.FakeStore) {
+	t.Helper()
+	clock := harness.NewFakeClock()
+	store := harness.NewFakeStore(clock)
+	return newModel(store, clock), clock, store
+}
+
+// newModel returns a new connection to the TUI sharing the store and clock
+func newModel(store *harness.FakeStore, clock *harness.FakeClock) tea.Model {
+	m, _ := harness.Run(tea.Model(initialModel(store, clock)), harness.Resize(120, 50))
+	return m
+}
+
+func enterEmail(m tea.Model, email string) tea.Model {
+	m, _ = harness.Run(m, harness.Type(email+"\n")...)
+	return m
+}
+
+func TestWelcomeScreen(t *testing.T) {
+	m, clock, _ := newTestModel(t)
+	harness.Golden(t, "welcome", m.View())
+
+	// The clock only starts with the challenge
+	initial := m.View()
+	m, _ = harness.Run(m, clock.Tick(90*time.Second))
+	if m.View() != initial {
+		t.Error("expected the welcome screen not to count down")
+	}
+}
+
+func TestWelcomeLeaderboard(t *testing.T) {
+	m, _, store := newTestModel(t)
+	store.CreateAttempt("ada@example.com", false, map[string]interface{}{"time": 21 * time.Minute})
+	if err := store.SetDisplayHandle("ada@example.com", "ada"); err != nil {
+		t.Fatal(err)
+	}
+
+	m, _ = harness.Run(m, harness.Key(tea.KeyCtrlL))
+	view := m.View()
+	if !strings.Contains(view, "Fastest completions") || !strings.Contains(view, "ada     21m0s") {
+		t.Fatalf("expected the leaderboard on the welcome screen, got\n%s", view)
+	}
+	if strings.Contains(view, "ada@example.com") {
+		t.Fatal("the leaderboard must not show emails")
+	}
+
+	m, _ = harness.Run(m, harness.Key(tea.KeyCtrlL))
+	if strings.Contains(m.View(), "Fastest completions") {
+		t.Fatal("expected ctrl+l to hide the leaderboard again")
+	}
+}
+
+func TestInvalidEmail(t *testing.T) {
+	m, _, _ := newTestModel(t)
+	m = enterEmail(m, "not-an-email")
+
+	got := m.(model)
+	if got.emailEntered || got.emailError == "" {
+		t.Fatalf("expected an email error, entered=%v error=%q", got.emailEntered, got.emailError)
+	}
+	harness.Golden(t, "invalid_email", m.View())
+}
+
+func TestStartChallenge(t *testing.T) {
+	m, _, store := newTestModel(t)
+	m = enterEmail(m, testEmail)
+
+	got := m.(model)
+	if !got.emailEntered || len(got.stepManager.Steps) != 6 {
+		t.Fatalf("expected the default flow to start, got %d steps", len(got.stepManager.Steps))
+	}
+	if store.Session(got.stepManager.SessionID) == nil {
+		t.Fatal("expected a session to be stored")
+	}
+}
+
+func TestAlreadyWon(t *testing.T) {
+	m, _, store := newTestModel(t)
+	store.CreateAttempt(testEmail, false, nil)
+	m = enterEmail(m, testEmail)
+
+	if _, ok := m.(model).stepManager.Steps[0].(*steps.HasWonStep); !ok {
+		t.Fatalf("expected the already won screen, got %T", m.(model).stepManager.Steps[0])
+	}
+}
+
+func TestCooldown(t *testing.T) {
+	tests := []struct {
+		name     string
+		policy   string
+		failedAt time.Duration
+		want     string
+	}{
+		{name: "rolling", policy: "rolling:24h", failedAt: -3 * time.Hour, want: "21:00:01"},
+		{name: "calendar", policy: "calendar:UTC", failedAt: -3 * time.Hour, want: "12:00:01"},
+		{name: "backoff", policy: "backoff:1h:4h", failedAt: -30 * time.Minute, want: "00:30:01"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			policy, err := cooldown.Parse(tt.policy)
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer func(previous cooldown.Policy) { cooldownPolicy = previous }(cooldownPolicy)
+			cooldownPolicy = policy
+
+			m, clock, store := newTestModel(t)
+			clock.Advance(tt.failedAt)
+			store.CreateAttempt(testEmail, true, nil)
+			clock.Advance(-tt.failedAt)
+			m = enterEmail(m, testEmail)
+
+			step, ok := m.(model).stepManager.Steps[0].(*steps.HasFailedStep)
+			if !ok {
+				t.Fatalf("expected the try again later screen, got %T", m.(model).stepManager.Steps[0])
+			}
+			if view := step.View(); !strings.Contains(view, "Time until next attempt: "+tt.want) {
+				t.Errorf("expected a countdown of %s, got\n%s", tt.want, view)
+			}
+		})
+	}
+}
+
+func TestDatabaseErrorFailsClosed(t *testing.T) {
+	m, _, store := newTestModel(t)
+	store.SetErr(errors.New("connection refused"))
+	m = enterEmail(m, testEmail)
+
+	got := m.(model)
+	if _, ok := got.stepManager.Steps[0].(*steps.FailedStep); !ok || !got.stepManager.StepFailed {
+		t.Fatalf("expected the failed screen, got %T", got.stepManager.Steps[0])
+	}
+}
+
+func TestResumeAfterDisconnect(t *testing.T) {
+	m, clock, store := newTestModel(t)
+	m = enterEmail(m, testEmail)
+	first := m.(model)
+
+	// Solve the wordle, then the tick checkpoints the session
+	wordle := first.stepManager.Steps[0].(*steps.Step1)
+	m, _ = harness.Run(m, harness.Type(wordleAnswer(t, wordle))...)
+	m, _ = harness.Run(m, clock.Tick(3*time.Minute))
+	harness.Eventually(t, func() bool {
+		session := store.Session(first.stepManager.SessionID)
+		return session != nil && session.CurrentStep == 1
+	}, "checkpoint saved")
+
+	// A second terminal can't join the session while it is still being played
+	live := enterEmail(newModel(store, clock), testEmail).(model)
+	if live.stepManager.SessionID == first.stepManager.SessionID {
+		t.Fatal("expected a live session not to be resumed")
+	}
+	live.stepManager.Abandon()
+	live.stepManager.Wait()
+
+	// The connection drops, and the candidate reconnects a few minutes later
+	first.stepManager.Abandon()
+	first.stepManager.Wait()
+	clock.Advance(2 * time.Minute)
+	second := enterEmail(newModel(store, clock), testEmail)
+
+	got := second.(model)
+	if got.stepManager.SessionID != first.stepManager.SessionID || got.stepManager.CurrentStep != 1 {
+		t.Fatalf("expected to resume session %s at step 2, got %s at step %d",
+			first.stepManager.SessionID, got.stepManager.SessionID, got.stepManager.CurrentStep+1)
+	}
+	if !got.stepManager.Deadline().Equal(first.stepManager.Deadline()) {
+		t.Errorf("deadline moved: %v, originally %v", got.stepManager.Deadline(), first.stepManager.Deadline())
+	}
+}
+
+func TestAbandonedSession(t *testing.T) {
+	tests := []struct {
+		name          string
+		play          func(t *testing.T, m tea.Model, clock *harness.FakeClock) tea.Model
+		wantAttempts  int
+		wantFailed    bool
+		wantAbandoned bool
+	}{
+		{
+			name:         "before starting",
+			play:         func(t *testing.T, m tea.Model, clock *harness.FakeClock) tea.Model { return m },
+			wantAttempts: 0,
+		},
+		{
+			name: "mid challenge",
+			play: func(t *testing.T, m tea.Model, clock *harness.FakeClock) tea.Model {
+				m = enterEmail(m, testEmail)
+				wordle := m.(model).stepManager.Steps[0].(*steps.Step1)
+				m, _ = harness.Run(m, harness.Type(wordleAnswer(t, wordle))...)
+				clock.Advance(7 * time.Minute)
+				return m
+			},
+			wantAttempts:  1,
+			wantFailed:    true,
+			wantAbandoned: true,
+		},
+		{
+			name: "after running out of time",
+			play: func(t *testing.T, m tea.Model, clock *harness.FakeClock) tea.Model {
+				m = enterEmail(m, testEmail)
+				clock.Advance(challengeDuration)
+				m, _ = harness.Run(m, deadlineMsg{})
+				return m
+			},
+			wantAttempts: 1,
+			wantFailed:   true,
+		},
+		{
+			name: "on the final screen",
+			play: func(t *testing.T, m tea.Model, clock *harness.FakeClock) tea.Model {
+				m = enterEmail(m, testEmail)
+				sm := m.(model).stepManager
+				sm.CurrentStep = len(sm.Steps) - 1
+				sm.Init()
+				return m
+			},
+			wantAttempts: 1,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			m, clock, store := newTestModel(t)
+			m = tt.play(t, m, clock)
+			sm := m.(model).stepManager
+			sm.Wait()
+
+			// The connection closes
+			sm.Abandon()
+			sm.Wait()
+
+			attempts := store.Attempts()
+			if len(attempts) != tt.wantAttempts {
+				t.Fatalf("expected %d attempts, got %+v", tt.wantAttempts, attempts)
+			}
+			if tt.wantAttempts == 0 {
+				return
+			}
+			if attempts[0].Failed != tt.wantFailed || attempts[0].Abandoned != tt.wantAbandoned {
+				t.Errorf("expected failed=%v abandoned=%v, got %+v", tt.wantFailed, tt.wantAbandoned, attempts[0])
+			}
+			if attempts[0].SessionID != sm.SessionID {
+				t.Errorf("expected the attempt of session %s, got %s", sm.SessionID, attempts[0].SessionID)
+			}
+		})
+	}
+}
+
+func TestAbandonedSessionDetails(t *testing.T) {
+	m, clock, store := newTestModel(t)
+	m = enterEmail(m, testEmail)
+	wordle := m.(model).stepManager.Steps[0].(*steps.Step1)
+	m, _ = harness.Run(m, harness.Type(wordleAnswer(t, wordle))...)
+	clock.Advance(7 * time.Minute)
+
+	sm := m.(model).stepManager
+	sm.Abandon()
+	sm.Wait()
+
+	attempt := store.Attempts()[0]
+	if attempt.Details["step"] != 1 || attempt.Details["time"] != 7*time.Minute {
+		t.Errorf("expected step 2 reached after 7m, got %v", attempt.Details)
+	}
+	if session := store.Session(sm.SessionID); session == nil || session.Finished {
+		t.Error("expected the abandoned session to stay resumable")
+	}
+
+	// Past the resume grace the candidate is cooling down
+	clock.Advance(resumeGrace + time.Minute)
+	next := enterEmail(newModel(store, clock), testEmail)
+	if _, ok := next.(model).stepManager.Steps[0].(*steps.HasFailedStep); !ok {
+		t.Fatalf("expected the try again later screen, got %T", next.(model).stepManager.Steps[0])
+	}
+}
+
+func TestResumedSessionReplacesAbandonedAttempt(t *testing.T) {
+	m, clock, store := newTestModel(t)
+	m = enterEmail(m, testEmail)
+	first := m.(model).stepManager
+	first.Checkpoint()
+	harness.Eventually(t, func() bool {
+		return store.Session(first.SessionID).State != nil
+	}, "checkpoint saved")
+	first.Abandon()
+	first.Wait()
+
+	// Reconnecting within the grace window resumes instead of cooling down
+	clock.Advance(time.Minute)
+	second := enterEmail(newModel(store, clock), testEmail)
+	sm := second.(model).stepManager
+	if sm.SessionID != first.SessionID {
+		t.Fatalf("expected to resume session %s, got %T", first.SessionID, sm.Steps[0])
+	}
+
+	clock.Advance(challengeDuration)
+	harness.Run(second, deadlineMsg{})
+	sm.Wait()
+
+	attempts := store.Attempts()
+	if len(attempts) != 1 || !attempts[0].Failed || attempts[0].Abandoned {
+		t.Fatalf("expected the abandoned attempt to become a failure, got %+v", attempts)
+	}
+}
+
+func TestSessionLifecycleRecordsAbandonedSession(t *testing.T) {
+	m, _, store := newTestModel(t)
+	m = enterEmail(m, testEmail)
+	sm := m.(model).stepManager
+
+	srv, err := wish.NewServer(
+		wish.WithHostKeyPath(filepath.Join(t.TempDir(), "id_ed25519")),
+		wish.WithMiddleware(
+			sessionLifecycle,
+			func(next ssh.Handler) ssh.Handler {
+				return func(s ssh.Session) {
+					s.Context().SetValue(stepManagerKey, sm)
+					next(s)
+				}
+			},
+		),
+	)
+	if err != nil {
+		t.Fatal(err)
+	}
+	sess := testsession.New(t, srv, &gossh.ClientConfig{User: "candidate"})
+	if err := sess.Run(""); err != nil {
+		t.Fatal(err)
+	}
+
+	attempts := store.Attempts()
+	if len(attempts) != 1 || !attempts[0].Abandoned {
+		t.Fatalf("expected an abandoned attempt once the connection closed, got %+v", attempts)
+	}
+}
+
+// wordleAnswer reads the answer from the saved state of the wordle
+func wordleAnswer(t *testing.T, wordle *steps.Step1) string {
+	t.Helper()
+	state, err := wordle.SaveState()
+	if err != nil {
+		t.Fatal(err)
+	}
+	var saved struct {
+		Answer string `json:"answer"`
+	}
+	if err := json.Unmarshal(state, &saved); err != nil {
+		t.Fatal(err)
+	}
+	return saved.Answer
+}
+
+func TestEmailIsBoundToFirstKey(t *testing.T) {
+	tests := []struct {
+		name        string
+		fingerprint string
+		wantDenied  bool
+	}{
+		{name: "same key", fingerprint: "SHA256:first"},
+		{name: "other key", fingerprint: "SHA256:other", wantDenied: true},
+		{name: "no key", wantDenied: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, clock, store := newTestModel(t)
+			if _, err := store.BindPublicKey(testEmail, "SHA256:first"); err != nil {
+				t.Fatal(err)
+			}
+
+			m := initialModel(store, clock)
+			m.requireKey = true
+			m.fingerprint = tt.fingerprint
+			got := enterEmail(m, testEmail).(model)
+
+			_, denied := got.stepManager.Steps[0].(*steps.KeyMismatchStep)
+			if denied != tt.wantDenied {
+				t.Fatalf("expected denied=%v, got %T", tt.wantDenied, got.stepManager.Steps[0])
+			}
+			if denied && got.stepManager.SessionID != "" {
+				t.Error("expected no session to start")
+			}
+		})
+	}
+}
+
+func TestKeyIsBoundOnlyOnceVerified(t *testing.T) {
+	m, _, mailer := newVerifyingModel(t)
+	got := m.(model)
+	got.requireKey = true
+	got.fingerprint = "SHA256:attacker"
+	store := got.db.(*harness.FakeStore)
+
+	// Entering someone's email must not claim it for the key
+	m = enterEmail(got, testEmail)
+	if bound, _ := store.BoundPublicKey(testEmail); bound != "" {
+		t.Fatalf("expected no key to be bound before verifying, got %s", bound)
+	}
+
+	m, _ = harness.Run(m, harness.Type(lastCode(t, mailer)+"\n")...)
+	if !m.(model).emailEntered {
+		t.Fatal("expected the challenge to start")
+	}
+	if bound, _ := store.BoundPublicKey(testEmail); bound != "SHA256:attacker" {
+		t.Errorf("expected the key to be bound once verified, got %q", bound)
+	}
+}
+
+// newVerifyingModel returns a model that asks for a verification code, sent through the returned mailer
+func newVerifyingModel(t *testing.T) (tea.Model, *harness.FakeClock, *harness.FakeMailer) {
+	t.Helper()
+	m, clock, store := newTestModel(t)
+	mailer := &harness.FakeMailer{}
+	got := m.(model)
+	got.verifier = &verify.Service{Secret: []byte("secret"), Store: store, Mailer: mailer, Clock: clock, TTL: 10 * time.Minute, MaxAttempts: 3, MaxSends: 3, SendWindow: time.Hour}
+	return got, clock, mailer
+}
+
+// lastCode returns the code in the last email sent
+func lastCode(t *testing.T, mailer *harness.FakeMailer) string {
+	t.Helper()
+	sent := mailer.Sent()
+	if len(sent) == 0 {
+		t.Fatal("no code was sent")
+	}
+	subject := sent[len(sent)-1].Subject
+	return subject[strings.LastIndex(subject, " ")+1:]
+}
+
+func TestEmailVerification(t *testing.T) {
+	m, clock, mailer := newVerifyingModel(t)
+	m = enterEmail(m, testEmail)
+	if !m.(model).verifying || m.(model).emailEntered {
+		t.Fatal("expected to wait for the verification code")
+	}
+	harness.Golden(t, "verify_code", m.View())
+
+	m, _ = harness.Run(m, harness.Type("000000\n")...)
+	if got := m.(model).codeError; got != "That code is not right. Please try again." {
+		t.Fatalf("unexpected error %q", got)
+	}
+
+	clock.Advance(2 * time.Minute)
+	m, _ = harness.Run(m, harness.Type(lastCode(t, mailer)+"\n")...)
+	got := m.(model)
+	if !got.emailEntered || len(got.stepManager.Steps) != 6 {
+		t.Fatalf("expected the challenge to start, got %d steps", len(got.stepManager.Steps))
+	}
+	if !got.stepManager.StartTime().Equal(clock.Now()) {
+		t.Errorf("expected the clock to start once verified, started at %v", got.stepManager.StartTime())
+	}
+}
+
+func TestEmailVerificationLimits(t *testing.T) {
+	tests := []struct {
+		name    string
+		script  func(clock *harness.FakeClock, code string) []tea.Msg
+		wantErr string
+	}{
+		{
+			name: "expired",
+			script: func(clock *harness.FakeClock, code string) []tea.Msg {
+				clock.Advance(11 * time.Minute)
+				return harness.Type(code + "\n")
+			},
+			wantErr: "That code expired. Press ctrl+r to get a new one.",
+		},
+		{
+			name: "too many attempts",
+			script: func(_ *harness.FakeClock, code string) []tea.Msg {
+				return harness.Type("000000\n000000\n000000\n" + code + "\n")
+			},
+			wantErr: "Too many wrong codes. Press ctrl+r to get a new one.",
+		},
+		{
+			name: "resend too soon",
+			script: func(*harness.FakeClock, string) []tea.Msg {
+				return []tea.Msg{harness.Key(tea.KeyCtrlR)}
+			},
+			wantErr: "You can ask for a new code in 1m0s.",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			m, clock, mailer := newVerifyingModel(t)
+			m = enterEmail(m, testEmail)
+			m, _ = harness.Run(m, tt.script(clock, lastCode(t, mailer))...)
+
+			got := m.(model)
+			if got.emailEntered {
+				t.Fatal("expected the challenge not to start")
+			}
+			if got.codeError != tt.wantErr {
+				t.Errorf("got error %q, want %q", got.codeError, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestVerificationSendLimitSpansSessions(t *testing.T) {
+	first, clock, mailer := newVerifyingModel(t)
+	verifier := first.(model).verifier
+	store := first.(model).db.(*harness.FakeStore)
+	session := func() tea.Model {
+		got := newModel(store, clock).(model)
+		got.verifier = verifier
+		return enterEmail(got, testEmail)
+	}
+
+	// Every new session sends a code, until the email reached the limit
+	m := enterEmail(first, testEmail)
+	clock.Advance(2 * time.Minute)
+	m, _ = harness.Run(m, harness.Key(tea.KeyCtrlR))
+	session()
+	if sent := len(mailer.Sent()); sent != 3 {
+		t.Fatalf("expected 3 codes to be sent, got %d", sent)
+	}
+
+	if got := session().(model); got.verifying || got.emailError != tooManyCodes {
+		t.Errorf("expected the fourth code to be refused, got error %q", got.emailError)
+	}
+	clock.Advance(2 * time.Minute)
+	m, _ = harness.Run(m, harness.Key(tea.KeyCtrlR))
+	if got := m.(model).codeError; got != tooManyCodes {
+		t.Errorf("expected resending to be refused, got error %q", got)
+	}
+	if sent := len(mailer.Sent()); sent != 3 {
+		t.Fatalf("expected no more codes to be sent, got %d", sent)
+	}
+
+	// Codes sent over an hour ago no longer count
+	clock.Advance(time.Hour)
+	if m := session(); !m.(model).verifying {
+		t.Errorf("expected a code to be sent once the window passed, got error %q", m.(model).emailError)
+	}
+}
+
+func TestStepCommandResultsReachTheStep(t *testing.T) {
+	m, _, _ := newTestModel(t)
+	m = enterEmail(m, testEmail)
+	m.(model).stepManager.SetCurrentStep(4)
+
+	// The grid step evaluates submissions in a command
+	m, cmds := harness.Run(m, harness.Key(tea.KeyCtrlS))
+	if len(cmds) != 1 {
+		t.Fatalf("expected the evaluation command, got %d commands", len(cmds))
+	}
+	m, _ = harness.Run(m, cmds[0]())
+	if view := m.View(); !strings.Contains(view, "Passed 2 of 6 grids") {
+		t.Errorf("expected the evaluation result to be shown, got:\n%s", view)
+	}
+}
+
+func TestDeadlineFailsSession(t *testing.T) {
+	tests := []struct {
+		name string
+		msg  tea.Msg
+	}{
+		{name: "on tick", msg: common.TickMsg{}},
+		{name: "on deadline", msg: deadlineMsg{}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			m, clock, store := newTestModel(t)
+			m = enterEmail(m, testEmail)
+			wordle := m.(model).stepManager.Steps[0].(*steps.Step1)
+			m, _ = harness.Run(m, harness.Type(wordleAnswer(t, wordle))...)
+
+			// Rendering after the deadline must not change anything
+			clock.Advance(challengeDuration)
+			m.View()
+			if m.(model).stepManager.StepFailed {
+				t.Fatal("View failed the session")
+			}
+
+			_, cmds := harness.Run(m, tt.msg)
+			if !harness.Quits(cmds...) {
+				t.Fatal("expected the session to end")
+			}
+			harness.Eventually(t, func() bool { return len(store.Attempts()) == 1 }, "attempt recorded")
+			attempt := store.Attempts()[0]
+			if !attempt.Failed || attempt.Details["step"] != 1 {
+				t.Errorf("expected a failed attempt at step 2, got %+v", attempt)
+			}
+		})
+	}
+}
+
+func TestDeadlineSparesFinishedSession(t *testing.T) {
+	m, clock, _ := newTestModel(t)
+	m = enterEmail(m, testEmail)
+	sm := m.(model).stepManager
+	sm.CurrentStep = len(sm.Steps) - 1
+	sm.Init()
+
+	clock.Advance(challengeDuration + time.Minute)
+	m, _ = harness.Run(m, deadlineMsg{})
+	if m.(model).stepManager.StepFailed {
+		t.Fatal("expected a completed challenge to outlive the deadline")
+	}
+}
+
+func TestEndScreenQuitsWithoutFailing(t *testing.T) {
+	m, clock, store := newTestModel(t)
+	m = enterEmail(m, testEmail)
+	sm := m.(model).stepManager
+	sm.CurrentStep = len(sm.Steps) - 1
+	sm.Init()
+
+	m, cmds := harness.Run(m, harness.Type("a")...)
+	if harness.Quits(cmds...) {
+		t.Fatal("expected the end screen to stay up")
+	}
+	_, cmds = harness.Run(m, clock.Tick(5*time.Second))
+	if !harness.Quits(cmds...) {
+		t.Fatal("expected the session to end after the end screen")
+	}
+	harness.Eventually(t, func() bool { return len(store.Attempts()) == 1 }, "attempt recorded")
+	if attempt := store.Attempts()[0]; attempt.Failed {
+		t.Errorf("expected the win to be kept, got %+v", attempt)
+	}
+}
+
+// fakeMigrator has migration 1 applied and migration 2 pending
+type fakeMigrator struct {
+	applied []database.Migration
+}
+
+func (f *fakeMigrator) MigrateUp() ([]database.Migration, error) {
+	pending := []database.Migration{{Version: 2, Name: "sessions"}}
+	if len(f.applied) > 1 {
+		pending = nil
+	}
+	f.applied = append(f.applied, pending...)
+	return pending, nil
+}
+
+func (f *fakeMigrator) MigrateDown() (*database.Migration, error) {
+	if len(f.applied) == 0 {
+		return nil, nil
+	}
+	last := f.applied[len(f.applied)-1]
+	f.applied = f.applied[:len(f.applied)-1]
+	return &last, nil
+}
+
+func (f *fakeMigrator) MigrationStatus() ([]database.MigrationStatus, error) {
+	at := time.Date(2025, 4, 1, 12, 0, 0, 0, time.UTC)
+	return []database.MigrationStatus{
+		{Migration: database.Migration{Version: 1, Name: "users_and_attempts"}, AppliedAt: &at},
+		{Migration: database.Migration{Version: 2, Name: "sessions"}},
+	}, nil
+}
+
+func TestRunMigrate(t *testing.T) {
+	tests := []struct {
+		args    string
+		want    string
+		wantErr string
+	}{
+		{args: "up", want: "Applied 0002_sessions\n"},
+		{args: "down", want: "Reverted 0001_users_and_attempts\n"},
+		{args: "status", want: "VERSION  NAME                APPLIED\n0001     users_and_attempts  2025-04-01 12:00:00\n0002     sessions            pending\n"},
+		{args: "", wantErr: "usage: migrate up|down|status"},
+		{args: "redo", wantErr: `unknown migrate command "redo", expected up, down or status`},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.args, func(t *testing.T) {
+			db := &fakeMigrator{applied: []database.Migration{{Version: 1, Name: "users_and_attempts"}}}
+			var out strings.Builder
+			err := runMigrate(db, strings.Fields(tt.args), &out)
+			if tt.wantErr != "" {
+				if err == nil || err.Error() != tt.wantErr {
+					t.Fatalf("expected error %q, got %v", tt.wantErr, err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if out.String() != tt.want {
+				t.Errorf("expected\n%s\ngot\n%s", tt.want, out.String())
+			}
+		})
+	}
+}
+
+func TestRunPreviewEmail(t *testing.T) {
+	dir := t.TempDir()
+	overrides := filepath.Join(dir, "templates", "spring")
+	if err := os.MkdirAll(overrides, 0o755); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(filepath.Join(overrides, "end.html"), []byte("<p>Spring {{.Token}}</p>"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	templates := email.NewTemplates(filepath.Join(dir, "templates"))
+
+	var out strings.Builder
+	htmlPath := filepath.Join(dir, "preview.html")
+	if err := runPreviewEmail(templates, []string{"--campaign", "spring", "--out", htmlPath, "end"}, &out); err != nil {
+		t.Fatal(err)
+	}
+	html, err := os.ReadFile(htmlPath)
+	if err != nil || !strings.HasPrefix(string(html), "<p>Spring ") {
+		t.Errorf("expected the campaign's HTML, got %q (%v)", html, err)
+	}
+	text, err := os.ReadFile(filepath.Join(dir, "preview.txt"))
+	if err != nil || !strings.Contains(string(text), "This is your token") {
+		t.Errorf("expected the embedded text, got %q (%v)", text, err)
+	}
+	if want := "Subject: Autonoma CTF: one last challenge\n"; !strings.HasPrefix(out.String(), want) {
+		t.Errorf("expected output starting with %q, got %q", want, out.String())
+	}
+
+	for _, args := range [][]string{{}, {"newsletter"}, {"end", "verification"}} {
+		if err := runPreviewEmail(templates, args, io.Discard); err == nil {
+			t.Errorf("expected an error for %q", args)
+		}
+	}
+}
+
+func TestRunJWKS(t *testing.T) {
+	keys, err := tokens.Generate("2025-04")
+	if err != nil {
+		t.Fatal(err)
+	}
+	var out strings.Builder
+	if err := runJWKS(keys, &out); err != nil {
+		t.Fatal(err)
+	}
+	var set tokens.JWKS
+	if err := json.Unmarshal([]byte(out.String()), &set); err != nil {
+		t.Fatal(err)
+	}
+	if len(set.Keys) != 1 || set.Keys[0].ID != "2025-04" || set.Keys[0].X == "" {
+		t.Errorf("unexpected key set %s", out.String())
+	}
+
+	if err := runJWKS(nil, io.Discard); err == nil {
+		t.Error("expected an error without public keys")
+	}
+}
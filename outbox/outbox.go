@@ -0,0 +1,158 @@
+// Package outbox delivers the emails queued in the email_outbox table. Steps only enqueue emails,
+// so an outage of the email provider delays them instead of losing them.
+package outbox
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"time"
+
+	"github.com/tomaspiaggio/autonoma-hiring-ctf/database"
+	"github.com/tomaspiaggio/autonoma-hiring-ctf/email"
+)
+
+const (
+	// DefaultMaxAttempts is how many deliveries are tried before an email is dead-lettered
+	DefaultMaxAttempts = 8
+
+	// DefaultBackoff is the wait before the first retry, doubled on every retry up to DefaultMaxBackoff
+	DefaultBackoff = 30 * time.Second
+
+	// DefaultMaxBackoff caps the wait between retries
+	DefaultMaxBackoff = time.Hour
+)
+
+// Store is the persistence the worker depends on
+type Store interface {
+	ClaimEmails(limit int, lease time.Duration) ([]database.OutboxEmail, error)
+	MarkEmailSent(id int) error
+	RetryEmail(id int, reason string, wait time.Duration) error
+	DeadLetterEmail(id int, reason string) error
+}
+
+var _ Store = (*database.DB)(nil)
+
+// Sender delivers an email of one kind from its payload. The key is the idempotency key of the
+// outbox email, so the provider can drop a retry of an email it already delivered.
+type Sender func(key string, to string, payload json.RawMessage) error
+
+// permanentError is a failure retrying won't fix
+type permanentError struct {
+	err error
+}
+
+func (e permanentError) Error() string { return e.err.Error() }
+func (e permanentError) Unwrap() error { return e.err }
+
+// Permanent marks err as a failure retrying won't fix, such as a payload that can't be decoded,
+// so the email is dead-lettered right away
+func Permanent(err error) error {
+	return permanentError{err}
+}
+
+// Senders returns the senders of every kind of email the challenge enqueues, rendered from the templates
+// and sent through the mailer
+func Senders(mailer email.Mailer, templates *email.Templates) map[string]Sender {
+	return map[string]Sender{
+		email.EndEmailKind: func(key string, to string, payload json.RawMessage) error {
+			var end email.EndEmail
+			if err := json.Unmarshal(payload, &end); err != nil {
+				return Permanent(fmt.Errorf("decoding payload: %w", err))
+			}
+			return email.SendEndEmail(mailer, templates, key, to, end)
+		},
+	}
+}
+
+// Worker delivers due emails, retrying failures with exponential backoff
+type Worker struct {
+	Store       Store
+	Senders     map[string]Sender
+	Interval    time.Duration
+	Lease       time.Duration
+	BatchSize   int
+	MaxAttempts int
+	Backoff     time.Duration
+	MaxBackoff  time.Duration
+}
+
+// New returns a worker polling every 5 seconds with the default retries
+func New(store Store, senders map[string]Sender) *Worker {
+	return &Worker{
+		Store:       store,
+		Senders:     senders,
+		Interval:    5 * time.Second,
+		Lease:       2 * time.Minute,
+		BatchSize:   10,
+		MaxAttempts: DefaultMaxAttempts,
+		Backoff:     DefaultBackoff,
+		MaxBackoff:  DefaultMaxBackoff,
+	}
+}
+
+// Run delivers due emails every interval until the context is done
+func (w *Worker) Run(ctx context.Context) {
+	ticker := time.NewTicker(w.Interval)
+	defer ticker.Stop()
+	for {
+		if _, err := w.Deliver(); err != nil {
+			log.Printf("Error delivering outbox emails: %v\n", err)
+		}
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// Deliver sends the emails that are due and returns how many were sent
+func (w *Worker) Deliver() (int, error) {
+	emails, err := w.Store.ClaimEmails(w.BatchSize, w.Lease)
+	if err != nil {
+		return 0, err
+	}
+
+	sent := 0
+	for _, e := range emails {
+		err := w.send(e)
+		switch {
+		case err == nil:
+			sent++
+			log.Printf("Sent %s email %d to %s\n", e.Kind, e.ID, e.Recipient)
+			err = w.Store.MarkEmailSent(e.ID)
+		case errors.As(err, &permanentError{}) || e.Attempts >= w.MaxAttempts:
+			log.Printf("Giving up on %s email %d to %s after %d attempts: %v\n", e.Kind, e.ID, e.Recipient, e.Attempts, err)
+			err = w.Store.DeadLetterEmail(e.ID, err.Error())
+		default:
+			wait := w.backoff(e.Attempts)
+			log.Printf("Error sending %s email %d to %s, retrying in %s: %v\n", e.Kind, e.ID, e.Recipient, wait, err)
+			err = w.Store.RetryEmail(e.ID, err.Error(), wait)
+		}
+		if err != nil {
+			return sent, err
+		}
+	}
+	return sent, nil
+}
+
+// send delivers a single email with the sender of its kind
+func (w *Worker) send(e database.OutboxEmail) error {
+	sender, ok := w.Senders[e.Kind]
+	if !ok {
+		return Permanent(fmt.Errorf("unknown email kind %q", e.Kind))
+	}
+	return sender(e.IdempotencyKey, e.Recipient, e.Payload)
+}
+
+// backoff returns the wait after the given number of failed attempts
+func (w *Worker) backoff(attempts int) time.Duration {
+	wait := w.Backoff
+	for i := 1; i < attempts && wait < w.MaxBackoff; i++ {
+		wait *= 2
+	}
+	return min(wait, w.MaxBackoff)
+}
@@ -0,0 +1,253 @@
+package outbox
+
+import (
+	"encoding/json"
+	"errors"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/tomaspiaggio/autonoma-hiring-ctf/database"
+	"github.com/tomaspiaggio/autonoma-hiring-ctf/email"
+	"github.com/tomaspiaggio/autonoma-hiring-ctf/harness"
+)
+
+var _ Store = (*harness.FakeStore)(nil)
+
+// flakySender fails the given number of times, then records the emails it sends
+type flakySender struct {
+	failures int
+	keys     []string
+	sent     []string
+}
+
+func (s *flakySender) send(key string, to string, payload json.RawMessage) error {
+	s.keys = append(s.keys, key)
+	if s.failures > 0 {
+		s.failures--
+		return errors.New("resend is down")
+	}
+	s.sent = append(s.sent, to)
+	return nil
+}
+
+func newWorker(t *testing.T, sender *flakySender) (*Worker, *harness.FakeStore, *harness.FakeClock) {
+	t.Helper()
+	clock := harness.NewFakeClock()
+	store := harness.NewFakeStore(clock)
+	w := New(store, map[string]Sender{"end": sender.send})
+	w.MaxAttempts = 3
+	if _, err := store.EnqueueEmail("end:session-1", "end", "ada@example.com", map[string]string{"token": "jwt"}); err != nil {
+		t.Fatal(err)
+	}
+	return w, store, clock
+}
+
+func deliver(t *testing.T, w *Worker) int {
+	t.Helper()
+	sent, err := w.Deliver()
+	if err != nil {
+		t.Fatal(err)
+	}
+	return sent
+}
+
+func TestDeliverSendsOnce(t *testing.T) {
+	sender := &flakySender{}
+	w, store, clock := newWorker(t, sender)
+
+	if deliver(t, w) != 1 {
+		t.Fatal("expected the email to be sent")
+	}
+	clock.Advance(time.Hour)
+	if deliver(t, w) != 0 {
+		t.Fatal("expected a sent email not to be sent again")
+	}
+
+	e := store.Outbox()[0]
+	if e.Status != database.OutboxSent || e.Attempts != 1 || e.SentAt == nil {
+		t.Errorf("unexpected email %+v", e)
+	}
+	if len(sender.sent) != 1 || sender.sent[0] != "ada@example.com" {
+		t.Errorf("sent to %q", sender.sent)
+	}
+}
+
+func TestDeliverRetriesWithBackoff(t *testing.T) {
+	sender := &flakySender{failures: 2}
+	w, store, clock := newWorker(t, sender)
+
+	deliver(t, w)
+	e := store.Outbox()[0]
+	if e.Status != database.OutboxPending || e.LastError != "resend is down" {
+		t.Fatalf("expected a pending retry, got %+v", e)
+	}
+
+	// Not due before the backoff, which doubles after every failure
+	clock.Advance(DefaultBackoff - time.Second)
+	if deliver(t, w) != 0 || len(sender.sent) != 0 {
+		t.Fatal("expected no delivery before the backoff")
+	}
+	clock.Advance(time.Second)
+	deliver(t, w)
+	if next := store.Outbox()[0].NextAttemptAt; !next.Equal(clock.Now().Add(2 * DefaultBackoff)) {
+		t.Errorf("expected the next attempt in %s, got %s", 2*DefaultBackoff, next.Sub(clock.Now()))
+	}
+
+	clock.Advance(2 * DefaultBackoff)
+	if deliver(t, w) != 1 {
+		t.Fatal("expected the third attempt to send it")
+	}
+	if e := store.Outbox()[0]; e.Status != database.OutboxSent || e.Attempts != 3 || e.LastError != "" {
+		t.Errorf("unexpected email %+v", e)
+	}
+
+	// Every retry carries the same key, so the provider can tell it apart from a new email
+	if strings.Join(sender.keys, ",") != "end:session-1,end:session-1,end:session-1" {
+		t.Errorf("expected every attempt to send the outbox key, got %q", sender.keys)
+	}
+}
+
+func TestDeliverDeadLetters(t *testing.T) {
+	tests := []struct {
+		name    string
+		kind    string
+		sender  Sender
+		wantErr string
+		wantTry int
+	}{
+		{
+			name:    "out of attempts",
+			kind:    "end",
+			sender:  func(string, string, json.RawMessage) error { return errors.New("resend is down") },
+			wantErr: "resend is down",
+			wantTry: 3,
+		},
+		{
+			name:    "permanent failure",
+			kind:    "end",
+			sender:  func(string, string, json.RawMessage) error { return Permanent(errors.New("invalid recipient")) },
+			wantErr: "invalid recipient",
+			wantTry: 1,
+		},
+		{
+			name:    "unknown kind",
+			kind:    "newsletter",
+			wantErr: `unknown email kind "newsletter"`,
+			wantTry: 1,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			clock := harness.NewFakeClock()
+			store := harness.NewFakeStore(clock)
+			w := New(store, map[string]Sender{"end": tt.sender})
+			w.MaxAttempts = 3
+			if _, err := store.EnqueueEmail("key", tt.kind, "ada@example.com", nil); err != nil {
+				t.Fatal(err)
+			}
+
+			for i := 0; i < 5; i++ {
+				deliver(t, w)
+				clock.Advance(DefaultMaxBackoff)
+			}
+
+			e := store.Outbox()[0]
+			if e.Status != database.OutboxDead || e.LastError != tt.wantErr || e.Attempts != tt.wantTry {
+				t.Errorf("expected a dead email after %d attempts with %q, got %+v", tt.wantTry, tt.wantErr, e)
+			}
+		})
+	}
+}
+
+func TestResendRequeuesDeadEmail(t *testing.T) {
+	sender := &flakySender{failures: 1}
+	w, store, _ := newWorker(t, sender)
+	w.MaxAttempts = 1
+
+	deliver(t, w)
+	if store.Outbox()[0].Status != database.OutboxDead {
+		t.Fatal("expected the email to be dead")
+	}
+	if err := store.ResendEmail(1); err != nil {
+		t.Fatal(err)
+	}
+	if deliver(t, w) != 1 || store.Outbox()[0].Status != database.OutboxSent {
+		t.Fatalf("expected the resent email to be delivered, got %+v", store.Outbox()[0])
+	}
+}
+
+func TestEnqueueIsIdempotent(t *testing.T) {
+	_, store, _ := newWorker(t, &flakySender{})
+	added, err := store.EnqueueEmail("end:session-1", "end", "ada@example.com", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if added || len(store.Outbox()) != 1 {
+		t.Errorf("expected the second email with the same key to be ignored, got %+v", store.Outbox())
+	}
+}
+
+func TestClaimLeasesEmails(t *testing.T) {
+	_, store, clock := newWorker(t, &flakySender{})
+	claimed, err := store.ClaimEmails(10, time.Minute)
+	if err != nil || len(claimed) != 1 {
+		t.Fatalf("expected to claim the email, got %+v (%v)", claimed, err)
+	}
+
+	// A worker that died mid-delivery leaves it claimed until the lease runs out
+	if again, _ := store.ClaimEmails(10, time.Minute); len(again) != 0 {
+		t.Fatalf("expected the leased email not to be claimed, got %+v", again)
+	}
+	clock.Advance(time.Minute)
+	if again, _ := store.ClaimEmails(10, time.Minute); len(again) != 1 || again[0].Attempts != 2 {
+		t.Fatalf("expected the email to be claimed again after the lease, got %+v", again)
+	}
+}
+
+func TestSendersRenderTheEndEmail(t *testing.T) {
+	clock := harness.NewFakeClock()
+	store := harness.NewFakeStore(clock)
+	mailer := &harness.FakeMailer{}
+	w := New(store, Senders(mailer, nil))
+	if _, err := store.EnqueueEmail("end:session-1", email.EndEmailKind, "ada@example.com", email.EndEmail{Token: "a.b.c"}); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := store.EnqueueEmail("end:session-2", email.EndEmailKind, "bob@example.com", "not a payload"); err != nil {
+		t.Fatal(err)
+	}
+
+	if deliver(t, w) != 1 {
+		t.Fatal("expected the end email to be sent")
+	}
+	sent := mailer.Sent()
+	if len(sent) != 1 || sent[0].To != "ada@example.com" || !strings.Contains(sent[0].Text, "a.b.c") {
+		t.Errorf("unexpected emails %+v", sent)
+	}
+	if sent[0].IdempotencyKey != "end:session-1" {
+		t.Errorf("expected the outbox key to be sent along, got %q", sent[0].IdempotencyKey)
+	}
+	if e := store.Outbox()[1]; e.Status != database.OutboxDead || !strings.Contains(e.LastError, "decoding payload") {
+		t.Errorf("expected the undecodable email to be dead, got %+v", e)
+	}
+}
+
+func TestBackoff(t *testing.T) {
+	w := New(nil, nil)
+	tests := []struct {
+		attempts int
+		want     time.Duration
+	}{
+		{1, 30 * time.Second},
+		{2, time.Minute},
+		{4, 4 * time.Minute},
+		{8, time.Hour},
+		{20, time.Hour},
+	}
+	for _, tt := range tests {
+		if got := w.backoff(tt.attempts); got != tt.want {
+			t.Errorf("backoff(%d) = %s, want %s", tt.attempts, got, tt.want)
+		}
+	}
+}
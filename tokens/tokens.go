@@ -0,0 +1,225 @@
+// Package tokens signs the completion tokens winners get. Keys are identified by the kid in the token header,
+// so a new key can start signing while tokens signed with the previous one keep verifying.
+package tokens
+
+import (
+	"crypto/ed25519"
+	"crypto/rand"
+	"crypto/x509"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"os"
+	"strings"
+
+	"github.com/golang-jwt/jwt/v5"
+)
+
+const (
+	// HS256 keys are shared secrets, so whoever verifies the tokens can also sign them
+	HS256 = "HS256"
+
+	// EdDSA keys are Ed25519 key pairs, so the public key can be published to verify the tokens
+	EdDSA = "EdDSA"
+)
+
+// minSecretLength is the shortest HS256 secret accepted, as long as the hash
+const minSecretLength = 32
+
+// Key signs and verifies tokens. Keys without their private part only verify.
+type Key struct {
+	ID        string
+	Algorithm string
+
+	signing   interface{}
+	verifying interface{}
+}
+
+// CanSign reports whether the key has its private part
+func (k Key) CanSign() bool {
+	return k.signing != nil
+}
+
+// Keyring holds the active keys. The first one signs new tokens and every one verifies them.
+type Keyring struct {
+	Keys []Key
+}
+
+// Parse parses a keyring with a key per line, as "KID ALGORITHM KEY". Blank lines and lines starting with #
+// are skipped. KEY is base64: the secret for HS256, and for EdDSA a DER PKCS #8 private key, or a DER PKIX
+// public key for a retired key that only verifies. The first key must be able to sign.
+func Parse(text string) (*Keyring, error) {
+	keys := &Keyring{}
+	seen := map[string]bool{}
+	for i, line := range strings.Split(text, "\n") {
+		line = strings.TrimSpace(line)
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		key, err := parseKey(line)
+		if err != nil {
+			return nil, fmt.Errorf("line %d: %w", i+1, err)
+		}
+		if seen[key.ID] {
+			return nil, fmt.Errorf("line %d: duplicate key ID %q", i+1, key.ID)
+		}
+		seen[key.ID] = true
+		keys.Keys = append(keys.Keys, key)
+	}
+	if len(keys.Keys) == 0 {
+		return nil, errors.New("no keys")
+	}
+	if !keys.Keys[0].CanSign() {
+		return nil, fmt.Errorf("the first key, %q, signs new tokens but has no private key", keys.Keys[0].ID)
+	}
+	return keys, nil
+}
+
+// parseKey parses a single "KID ALGORITHM KEY" line
+func parseKey(line string) (Key, error) {
+	fields := strings.Fields(line)
+	if len(fields) != 3 {
+		return Key{}, errors.New("expected KID ALGORITHM KEY")
+	}
+	key := Key{ID: fields[0], Algorithm: fields[1]}
+	data, err := base64.StdEncoding.DecodeString(fields[2])
+	if err != nil {
+		return Key{}, fmt.Errorf("key %q isn't base64: %w", key.ID, err)
+	}
+
+	switch key.Algorithm {
+	case HS256:
+		if len(data) < minSecretLength {
+			return Key{}, fmt.Errorf("key %q is %d bytes, HS256 needs at least %d", key.ID, len(data), minSecretLength)
+		}
+		key.signing, key.verifying = data, data
+	case EdDSA:
+		if private, err := x509.ParsePKCS8PrivateKey(data); err == nil {
+			private, ok := private.(ed25519.PrivateKey)
+			if !ok {
+				return Key{}, fmt.Errorf("key %q isn't an Ed25519 key", key.ID)
+			}
+			key.signing, key.verifying = private, private.Public()
+		} else if public, err := x509.ParsePKIXPublicKey(data); err == nil {
+			public, ok := public.(ed25519.PublicKey)
+			if !ok {
+				return Key{}, fmt.Errorf("key %q isn't an Ed25519 key", key.ID)
+			}
+			key.verifying = public
+		} else {
+			return Key{}, fmt.Errorf("key %q is neither a PKCS #8 private key nor a PKIX public key", key.ID)
+		}
+	default:
+		return Key{}, fmt.Errorf("unknown algorithm %q, expected %s or %s", key.Algorithm, HS256, EdDSA)
+	}
+	return key, nil
+}
+
+// FromEnv parses the keyring in JWT_KEYS, or in the file at JWT_KEYS_FILE. It returns nil when neither is set.
+func FromEnv() (*Keyring, error) {
+	if text := os.Getenv("JWT_KEYS"); text != "" {
+		return Parse(text)
+	}
+	path := os.Getenv("JWT_KEYS_FILE")
+	if path == "" {
+		return nil, nil
+	}
+	text, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	keys, err := Parse(string(text))
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", path, err)
+	}
+	return keys, nil
+}
+
+// Generate returns a keyring with a new Ed25519 key, for local runs and tests. Its tokens can't be verified
+// once the process is gone.
+func Generate(id string) (*Keyring, error) {
+	public, private, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		return nil, err
+	}
+	return &Keyring{Keys: []Key{{ID: id, Algorithm: EdDSA, signing: private, verifying: public}}}, nil
+}
+
+// Sign returns the token for the claims, signed with the first key
+func (k *Keyring) Sign(claims jwt.Claims) (string, error) {
+	if k == nil || len(k.Keys) == 0 || !k.Keys[0].CanSign() {
+		return "", errors.New("no signing key")
+	}
+	key := k.Keys[0]
+	token := jwt.NewWithClaims(jwt.GetSigningMethod(key.Algorithm), claims)
+	token.Header["kid"] = key.ID
+	return token.SignedString(key.signing)
+}
+
+// Verify checks the token was signed by one of the keys with the key's algorithm, and decodes its claims.
+// The options are passed on to the parser, such as jwt.WithTimeFunc to check expiry against another clock.
+func (k *Keyring) Verify(token string, claims jwt.Claims, opts ...jwt.ParserOption) error {
+	_, err := jwt.ParseWithClaims(token, claims, func(t *jwt.Token) (interface{}, error) {
+		id, _ := t.Header["kid"].(string)
+		key, ok := k.key(id)
+		if !ok {
+			return nil, fmt.Errorf("unknown key %q", id)
+		}
+		// The algorithm comes from the token, so it is checked against the key's to stop an HS256 token
+		// from being verified with a published Ed25519 key as its secret
+		if t.Method.Alg() != key.Algorithm {
+			return nil, fmt.Errorf("key %q signs with %s, not %s", id, key.Algorithm, t.Method.Alg())
+		}
+		return key.verifying, nil
+	}, append(opts, jwt.WithValidMethods([]string{HS256, EdDSA}))...)
+	return err
+}
+
+// key returns the key with the given ID
+func (k *Keyring) key(id string) (Key, bool) {
+	if k == nil {
+		return Key{}, false
+	}
+	for _, key := range k.Keys {
+		if key.ID == id {
+			return key, true
+		}
+	}
+	return Key{}, false
+}
+
+// JWK is a public key in the JSON Web Key format
+type JWK struct {
+	KeyType   string `json:"kty"`
+	Curve     string `json:"crv"`
+	Algorithm string `json:"alg"`
+	Use       string `json:"use"`
+	ID        string `json:"kid"`
+	X         string `json:"x"`
+}
+
+// JWKS is a JSON Web Key Set, the document verifiers fetch the public keys from
+type JWKS struct {
+	Keys []JWK `json:"keys"`
+}
+
+// JWKS returns the public keys of the EdDSA keys. HS256 secrets are never published.
+func (k *Keyring) JWKS() JWKS {
+	set := JWKS{Keys: []JWK{}}
+	if k == nil {
+		return set
+	}
+	for _, key := range k.Keys {
+		if public, ok := key.verifying.(ed25519.PublicKey); ok {
+			set.Keys = append(set.Keys, JWK{
+				KeyType:   "OKP",
+				Curve:     "Ed25519",
+				Algorithm: EdDSA,
+				Use:       "sig",
+				ID:        key.ID,
+				X:         base64.RawURLEncoding.EncodeToString(public),
+			})
+		}
+	}
+	return set
+}
@@ -0,0 +1,220 @@
+package tokens
+
+import (
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/x509"
+	"encoding/base64"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/golang-jwt/jwt/v5"
+)
+
+// edKeyLines returns the private and public keyring lines of a new Ed25519 key
+func edKeyLines(t *testing.T, id string) (private string, public string) {
+	t.Helper()
+	pub, priv, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	privDER, err := x509.MarshalPKCS8PrivateKey(priv)
+	if err != nil {
+		t.Fatal(err)
+	}
+	pubDER, err := x509.MarshalPKIXPublicKey(pub)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return id + " EdDSA " + base64.StdEncoding.EncodeToString(privDER),
+		id + " EdDSA " + base64.StdEncoding.EncodeToString(pubDER)
+}
+
+var secretLine = "legacy HS256 " + base64.StdEncoding.EncodeToString([]byte(strings.Repeat("s", 32)))
+
+func parse(t *testing.T, lines ...string) *Keyring {
+	t.Helper()
+	keys, err := Parse(strings.Join(lines, "\n"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return keys
+}
+
+func claims() jwt.RegisteredClaims {
+	now := time.Now()
+	return jwt.RegisteredClaims{
+		Subject:   "CandidateCompletion",
+		IssuedAt:  jwt.NewNumericDate(now),
+		ExpiresAt: jwt.NewNumericDate(now.Add(time.Hour)),
+	}
+}
+
+func TestSignAndVerify(t *testing.T) {
+	edPrivate, _ := edKeyLines(t, "2025-04")
+	for _, line := range []string{edPrivate, secretLine} {
+		keys := parse(t, line)
+		token, err := keys.Sign(claims())
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		parsed, _, err := jwt.NewParser().ParseUnverified(token, &jwt.RegisteredClaims{})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if parsed.Header["kid"] != keys.Keys[0].ID || parsed.Method.Alg() != keys.Keys[0].Algorithm {
+			t.Errorf("expected the kid and alg of %s, got %v", keys.Keys[0].ID, parsed.Header)
+		}
+
+		var got jwt.RegisteredClaims
+		if err := keys.Verify(token, &got); err != nil || got.Subject != "CandidateCompletion" {
+			t.Errorf("expected %s token to verify, got %+v (%v)", keys.Keys[0].Algorithm, got, err)
+		}
+	}
+}
+
+func TestRotation(t *testing.T) {
+	oldPrivate, oldPublic := edKeyLines(t, "2025-03")
+	newPrivate, _ := edKeyLines(t, "2025-04")
+
+	old := parse(t, oldPrivate)
+	token, err := old.Sign(claims())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// The new key signs, and the old one keeps verifying with only its public part
+	rotated := parse(t, newPrivate, oldPublic)
+	if err := rotated.Verify(token, &jwt.RegisteredClaims{}); err != nil {
+		t.Errorf("expected the old token to verify after the rotation, got %v", err)
+	}
+	newToken, err := rotated.Sign(claims())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := old.Verify(newToken, &jwt.RegisteredClaims{}); err == nil || !strings.Contains(err.Error(), `unknown key "2025-04"`) {
+		t.Errorf("expected the new token to need the new key, got %v", err)
+	}
+
+	// Once the old key is dropped, its tokens stop verifying
+	if err := parse(t, newPrivate).Verify(token, &jwt.RegisteredClaims{}); err == nil {
+		t.Error("expected the token of a dropped key not to verify")
+	}
+}
+
+func TestVerifyRejectsAlgorithmConfusion(t *testing.T) {
+	private, public := edKeyLines(t, "2025-04")
+	keys := parse(t, private)
+
+	// A forger who knows the published public key signs an HS256 token with it as the secret
+	der, _ := base64.StdEncoding.DecodeString(strings.Fields(public)[2])
+	pub, _ := x509.ParsePKIXPublicKey(der)
+	forged := jwt.NewWithClaims(jwt.SigningMethodHS256, claims())
+	forged.Header["kid"] = "2025-04"
+	token, err := forged.SignedString([]byte(pub.(ed25519.PublicKey)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := keys.Verify(token, &jwt.RegisteredClaims{}); err == nil {
+		t.Error("expected an HS256 token for an EdDSA key to be rejected")
+	}
+
+	unsigned := jwt.NewWithClaims(jwt.SigningMethodNone, claims())
+	unsigned.Header["kid"] = "2025-04"
+	token, _ = unsigned.SignedString(jwt.UnsafeAllowNoneSignatureType)
+	if err := keys.Verify(token, &jwt.RegisteredClaims{}); err == nil {
+		t.Error("expected an unsigned token to be rejected")
+	}
+}
+
+func TestParseErrors(t *testing.T) {
+	private, public := edKeyLines(t, "2025-04")
+	ecKey, _ := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	ecDER, _ := x509.MarshalPKCS8PrivateKey(ecKey)
+
+	tests := []struct {
+		name    string
+		text    string
+		wantErr string
+	}{
+		{name: "empty", text: "\n# no keys yet\n", wantErr: "no keys"},
+		{name: "fields", text: "2025-04 EdDSA", wantErr: "line 1: expected KID ALGORITHM KEY"},
+		{name: "base64", text: "2025-04 EdDSA not-base64!", wantErr: `key "2025-04" isn't base64`},
+		{name: "algorithm", text: "2025-04 RS256 c2VjcmV0", wantErr: `unknown algorithm "RS256"`},
+		{name: "short secret", text: "legacy HS256 c2VjcmV0", wantErr: "HS256 needs at least 32"},
+		{name: "not a key", text: "2025-04 EdDSA " + base64.StdEncoding.EncodeToString([]byte("nope")), wantErr: "neither a PKCS #8"},
+		{name: "not ed25519", text: "2025-04 EdDSA " + base64.StdEncoding.EncodeToString(ecDER), wantErr: "isn't an Ed25519 key"},
+		{name: "duplicate", text: private + "\n" + public, wantErr: `line 2: duplicate key ID "2025-04"`},
+		{name: "public first", text: public, wantErr: `the first key, "2025-04", signs new tokens but has no private key`},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := Parse(tt.text)
+			if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+				t.Errorf("expected an error with %q, got %v", tt.wantErr, err)
+			}
+		})
+	}
+}
+
+func TestJWKS(t *testing.T) {
+	private, public := edKeyLines(t, "2025-04")
+	_, oldPublic := edKeyLines(t, "2025-03")
+	keys := parse(t, private, oldPublic, secretLine)
+
+	set := keys.JWKS()
+	if len(set.Keys) != 2 || set.Keys[0].ID != "2025-04" || set.Keys[1].ID != "2025-03" {
+		t.Fatalf("expected the two Ed25519 keys and no secret, got %+v", set)
+	}
+	der, _ := base64.StdEncoding.DecodeString(strings.Fields(public)[2])
+	pub, _ := x509.ParsePKIXPublicKey(der)
+	if x := base64.RawURLEncoding.EncodeToString(pub.(ed25519.PublicKey)); set.Keys[0].X != x {
+		t.Errorf("x = %q, want %q", set.Keys[0].X, x)
+	}
+	if jwk := set.Keys[0]; jwk.KeyType != "OKP" || jwk.Curve != "Ed25519" || jwk.Algorithm != "EdDSA" || jwk.Use != "sig" {
+		t.Errorf("unexpected JWK %+v", jwk)
+	}
+}
+
+func TestFromEnv(t *testing.T) {
+	private, _ := edKeyLines(t, "from-file")
+	path := filepath.Join(t.TempDir(), "jwt_keys")
+	if err := os.WriteFile(path, []byte("# kid alg key\n"+private+"\n"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+
+	t.Setenv("JWT_KEYS", "")
+	t.Setenv("JWT_KEYS_FILE", "")
+	if keys, err := FromEnv(); keys != nil || err != nil {
+		t.Fatalf("expected no keyring without configuration, got %v (%v)", keys, err)
+	}
+
+	t.Setenv("JWT_KEYS_FILE", path)
+	keys, err := FromEnv()
+	if err != nil || keys.Keys[0].ID != "from-file" {
+		t.Fatalf("expected the keyring from the file, got %+v (%v)", keys, err)
+	}
+
+	t.Setenv("JWT_KEYS", secretLine)
+	keys, err = FromEnv()
+	if err != nil || keys.Keys[0].ID != "legacy" {
+		t.Fatalf("expected JWT_KEYS to take precedence, got %+v (%v)", keys, err)
+	}
+}
+
+func TestNilKeyringCantSign(t *testing.T) {
+	var keys *Keyring
+	if _, err := keys.Sign(claims()); err == nil {
+		t.Error("expected an error without keys")
+	}
+	if err := keys.Verify("a.b.c", &jwt.RegisteredClaims{}); err == nil {
+		t.Error("expected an error without keys")
+	}
+}
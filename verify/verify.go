@@ -0,0 +1,115 @@
+// Package verify checks candidates own the email they play with, by sending them
+// a one-time code they have to type before the challenge starts.
+package verify
+
+import (
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"math/big"
+	"strings"
+	"time"
+
+	"github.com/tomaspiaggio/autonoma-hiring-ctf/common"
+	"github.com/tomaspiaggio/autonoma-hiring-ctf/database"
+	"github.com/tomaspiaggio/autonoma-hiring-ctf/email"
+)
+
+const (
+	// DefaultTTL is how long a code stays valid
+	DefaultTTL = 10 * time.Minute
+
+	// DefaultMaxAttempts is how many wrong codes lock the pending one
+	DefaultMaxAttempts = 5
+
+	// DefaultMaxSends is how many codes an email gets within DefaultSendWindow
+	DefaultMaxSends = 5
+
+	// DefaultSendWindow is how far back sent codes count towards DefaultMaxSends
+	DefaultSendWindow = time.Hour
+
+	// codeDigits is the length of a code
+	codeDigits = 6
+)
+
+// Store keeps the hashes of the codes that were sent
+type Store interface {
+	CreateEmailVerification(email string, codeHash string, expiresAt time.Time, maxSends int, since time.Time) error
+	CheckEmailVerification(email string, codeHash string, maxAttempts int) (database.VerificationStatus, error)
+}
+
+var _ Store = (*database.DB)(nil)
+
+// Service sends and checks verification codes. Each email gets at most MaxSends codes within SendWindow,
+// however many sessions ask for them. Codes are stored keyed with Secret.
+type Service struct {
+	Secret      []byte
+	Store       Store
+	Mailer      email.Mailer
+	Templates   *email.Templates
+	Clock       common.Clock
+	TTL         time.Duration
+	MaxAttempts int
+	MaxSends    int
+	SendWindow  time.Duration
+}
+
+// New returns a service hashing codes with the secret, with the default expiry, attempt and send limits
+func New(secret []byte, store Store, mailer email.Mailer) *Service {
+	return &Service{
+		Secret:      secret,
+		Store:       store,
+		Mailer:      mailer,
+		Clock:       common.SystemClock{},
+		TTL:         DefaultTTL,
+		MaxAttempts: DefaultMaxAttempts,
+		MaxSends:    DefaultMaxSends,
+		SendWindow:  DefaultSendWindow,
+	}
+}
+
+// Send emails a new code to the address, replacing any pending one.
+// It returns database.ErrTooManyVerifications without sending anything once the address reached the send limit.
+func (s *Service) Send(address string) error {
+	code, err := newCode()
+	if err != nil {
+		return err
+	}
+	now := s.Clock.Now()
+	err = s.Store.CreateEmailVerification(address, s.hashCode(address, code), now.Add(s.TTL), s.MaxSends, now.Add(-s.SendWindow))
+	if err != nil {
+		return fmt.Errorf("storing code: %w", err)
+	}
+	if err := email.SendVerificationCode(s.Mailer, s.Templates, address, code, s.TTL); err != nil {
+		return fmt.Errorf("sending code: %w", err)
+	}
+	return nil
+}
+
+// Check compares a typed code with the pending one for the address
+func (s *Service) Check(address string, code string) (database.VerificationStatus, error) {
+	return s.Store.CheckEmailVerification(address, s.hashCode(address, strings.TrimSpace(code)), s.MaxAttempts)
+}
+
+// newCode returns a random code of codeDigits digits
+func newCode() (string, error) {
+	limit := big.NewInt(1)
+	for i := 0; i < codeDigits; i++ {
+		limit.Mul(limit, big.NewInt(10))
+	}
+	n, err := rand.Int(rand.Reader, limit)
+	if err != nil {
+		return "", err
+	}
+	return fmt.Sprintf("%0*d", codeDigits, n), nil
+}
+
+// hashCode returns what is stored instead of the code. It is keyed with the secret, so the
+// million possible codes can't be tried against the table without it.
+func (s *Service) hashCode(address string, code string) string {
+	mac := hmac.New(sha256.New, s.Secret)
+	mac.Write([]byte(strings.ToLower(address) + ":" + code))
+	return hex.EncodeToString(mac.Sum(nil))
+}
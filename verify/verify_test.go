@@ -0,0 +1,114 @@
+package verify
+
+import (
+	"errors"
+	"regexp"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/tomaspiaggio/autonoma-hiring-ctf/database"
+	"github.com/tomaspiaggio/autonoma-hiring-ctf/harness"
+)
+
+var _ Store = (*harness.FakeStore)(nil)
+
+func TestSendAndCheck(t *testing.T) {
+	clock := harness.NewFakeClock()
+	mailer := &harness.FakeMailer{}
+	s := New([]byte("secret"), harness.NewFakeStore(clock), mailer)
+	s.Clock = clock
+
+	if err := s.Send("Candidate@Example.com"); err != nil {
+		t.Fatal(err)
+	}
+	sent := mailer.Sent()
+	if len(sent) != 1 || sent[0].To != "Candidate@Example.com" {
+		t.Fatalf("expected one email to the candidate, got %+v", sent)
+	}
+	code := regexp.MustCompile(`\d{6}$`).FindString(sent[0].Subject)
+	if code == "" || !strings.Contains(sent[0].Text, code) {
+		t.Fatalf("expected a 6-digit code in %q", sent[0].Subject)
+	}
+
+	status, err := s.Check("candidate@example.com", " "+code+" ")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if status != database.VerificationOK {
+		t.Errorf("expected the code to verify the email, got status %d", status)
+	}
+
+	// Codes are single use
+	if status, _ := s.Check("candidate@example.com", code); status != database.VerificationMissing {
+		t.Errorf("expected no pending code after verifying, got status %d", status)
+	}
+}
+
+func TestResendReplacesPendingCode(t *testing.T) {
+	clock := harness.NewFakeClock()
+	mailer := &harness.FakeMailer{}
+	s := New([]byte("secret"), harness.NewFakeStore(clock), mailer)
+	s.Clock = clock
+
+	for i := 0; i < 2; i++ {
+		if err := s.Send("candidate@example.com"); err != nil {
+			t.Fatal(err)
+		}
+		clock.Advance(time.Minute)
+	}
+	sent := mailer.Sent()
+	first := sent[0].Subject[len(sent[0].Subject)-6:]
+	second := sent[1].Subject[len(sent[1].Subject)-6:]
+	if first == second {
+		t.Skip("both codes happen to be equal")
+	}
+
+	if status, _ := s.Check("candidate@example.com", first); status != database.VerificationWrongCode {
+		t.Errorf("expected the first code to be replaced, got status %d", status)
+	}
+	if status, _ := s.Check("candidate@example.com", second); status != database.VerificationOK {
+		t.Errorf("expected the second code to verify, got status %d", status)
+	}
+}
+
+func TestHashCode(t *testing.T) {
+	s := &Service{Secret: []byte("secret")}
+	if s.hashCode("A@example.com", "123456") != s.hashCode("a@example.com", "123456") {
+		t.Error("expected the hash to ignore the email case")
+	}
+	if s.hashCode("a@example.com", "123456") == s.hashCode("b@example.com", "123456") {
+		t.Error("expected the hash to depend on the email")
+	}
+	if strings.Contains(s.hashCode("a@example.com", "123456"), "123456") {
+		t.Error("expected the code not to be stored as is")
+	}
+	other := &Service{Secret: []byte("other")}
+	if s.hashCode("a@example.com", "123456") == other.hashCode("a@example.com", "123456") {
+		t.Error("expected the hash to depend on the secret")
+	}
+}
+
+func TestSendLimit(t *testing.T) {
+	clock := harness.NewFakeClock()
+	mailer := &harness.FakeMailer{}
+	s := New([]byte("secret"), harness.NewFakeStore(clock), mailer)
+	s.Clock = clock
+
+	for i := 0; i < DefaultMaxSends; i++ {
+		if err := s.Send("candidate@example.com"); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := s.Send("Candidate@example.com"); !errors.Is(err, database.ErrTooManyVerifications) {
+		t.Fatalf("expected ErrTooManyVerifications, got %v", err)
+	}
+	if sent := len(mailer.Sent()); sent != DefaultMaxSends {
+		t.Errorf("expected the refused code not to be mailed, got %d emails", sent)
+	}
+
+	clock.Advance(DefaultSendWindow)
+	if err := s.Send("candidate@example.com"); err != nil {
+		t.Errorf("expected codes to be sent again after the window, got %v", err)
+	}
+}
@@ -0,0 +1,207 @@
+// Package webhook notifies other services, such as Slack or the ATS, of how attempts end.
+// Events are POSTed as JSON to every configured URL, signed with HMAC-SHA256 and retried with backoff.
+package webhook
+
+import (
+	"bytes"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// EventType is what happened
+type EventType string
+
+const (
+	// AttemptWon is sent when a candidate reaches the end of the challenge
+	AttemptWon EventType = "attempt.won"
+
+	// AttemptFailed is sent when a candidate fails a step or runs out of time
+	AttemptFailed EventType = "attempt.failed"
+
+	// AttemptAbandoned is sent when a candidate leaves before finishing. If they resume the session,
+	// its outcome is sent again once it ends.
+	AttemptAbandoned EventType = "attempt.abandoned"
+)
+
+const (
+	// SignatureHeader carries the timestamp and signature of a delivery, as t=<unix seconds>,v1=<hex HMAC>
+	SignatureHeader = "X-CTF-Signature"
+
+	// EventHeader carries the event type
+	EventHeader = "X-CTF-Event"
+
+	// DeliveryHeader carries the event ID, the same on every retry
+	DeliveryHeader = "X-CTF-Delivery"
+)
+
+const (
+	// DefaultMaxAttempts is how many times a delivery is tried before giving up
+	DefaultMaxAttempts = 5
+
+	// DefaultBackoff is the wait before the first retry, doubled on every retry
+	DefaultBackoff = 2 * time.Second
+)
+
+// Event is the JSON body of a delivery
+type Event struct {
+	ID        string      `json:"id"`
+	Type      EventType   `json:"type"`
+	CreatedAt time.Time   `json:"created_at"`
+	Data      AttemptData `json:"data"`
+}
+
+// AttemptData describes the attempt an event is about
+type AttemptData struct {
+	Email      string `json:"email"`
+	SessionID  string `json:"session_id"`
+	Step       int    `json:"step"` // the step reached, counted from 1
+	Duration   string `json:"duration"`
+	DurationMs int64  `json:"duration_ms"`
+	Message    string `json:"message,omitempty"`
+}
+
+// NewEvent returns an event with a new ID
+func NewEvent(eventType EventType, at time.Time, data AttemptData) Event {
+	return Event{ID: uuid.NewString(), Type: eventType, CreatedAt: at.UTC(), Data: data}
+}
+
+// Sign returns the signature header value of a body sent at the given time: an HMAC-SHA256 of
+// "<unix seconds>.<body>" keyed with the secret. Receivers recompute it to check the delivery is ours,
+// and check the timestamp is recent to reject replays.
+func Sign(secret []byte, at time.Time, body []byte) string {
+	timestamp := strconv.FormatInt(at.Unix(), 10)
+	mac := hmac.New(sha256.New, secret)
+	mac.Write([]byte(timestamp + "."))
+	mac.Write(body)
+	return "t=" + timestamp + ",v1=" + hex.EncodeToString(mac.Sum(nil))
+}
+
+// Dispatcher delivers events to the configured URLs in the background
+type Dispatcher struct {
+	URLs        []string
+	Secret      []byte
+	Client      *http.Client
+	MaxAttempts int
+	Backoff     time.Duration
+
+	deliveries sync.WaitGroup
+}
+
+// New returns a dispatcher with the default retries and a client that times out after 10 seconds
+func New(urls []string, secret []byte) *Dispatcher {
+	return &Dispatcher{
+		URLs:        urls,
+		Secret:      secret,
+		Client:      &http.Client{Timeout: 10 * time.Second},
+		MaxAttempts: DefaultMaxAttempts,
+		Backoff:     DefaultBackoff,
+	}
+}
+
+// FromEnv returns a dispatcher for the comma separated WEBHOOK_URLS, signing with WEBHOOK_SECRET.
+// It returns nil when no URL is set.
+func FromEnv() (*Dispatcher, error) {
+	var urls []string
+	for _, url := range strings.Split(os.Getenv("WEBHOOK_URLS"), ",") {
+		if url = strings.TrimSpace(url); url != "" {
+			urls = append(urls, url)
+		}
+	}
+	if len(urls) == 0 {
+		return nil, nil
+	}
+	secret := os.Getenv("WEBHOOK_SECRET")
+	if secret == "" {
+		return nil, errors.New("WEBHOOK_SECRET is required to sign the webhooks")
+	}
+	return New(urls, []byte(secret)), nil
+}
+
+// Dispatch sends the event to every URL in the background. A nil dispatcher sends nothing.
+func (d *Dispatcher) Dispatch(event Event) {
+	if d == nil {
+		return
+	}
+	body, err := json.Marshal(event)
+	if err != nil {
+		log.Printf("Error encoding %s webhook %s: %v\n", event.Type, event.ID, err)
+		return
+	}
+	for _, url := range d.URLs {
+		d.deliveries.Add(1)
+		go func() {
+			defer d.deliveries.Done()
+			if err := d.deliver(url, event, body); err != nil {
+				log.Printf("Error delivering %s webhook %s to %s: %v\n", event.Type, event.ID, url, err)
+			}
+		}()
+	}
+}
+
+// Wait blocks until every dispatched event was delivered or given up on
+func (d *Dispatcher) Wait() {
+	if d == nil {
+		return
+	}
+	d.deliveries.Wait()
+}
+
+// deliver posts the body until the receiver accepts it, retrying network errors, timeouts,
+// rate limits and server errors with exponential backoff
+func (d *Dispatcher) deliver(url string, event Event, body []byte) error {
+	backoff := d.Backoff
+	var err error
+	for attempt := 1; attempt <= d.MaxAttempts; attempt++ {
+		if attempt > 1 {
+			time.Sleep(backoff)
+			backoff *= 2
+		}
+
+		var retry bool
+		retry, err = d.post(url, event, body)
+		if err == nil || !retry {
+			return err
+		}
+	}
+	return fmt.Errorf("giving up after %d attempts: %w", d.MaxAttempts, err)
+}
+
+// post makes a single delivery and reports whether a failure is worth retrying
+func (d *Dispatcher) post(url string, event Event, body []byte) (bool, error) {
+	req, err := http.NewRequest(http.MethodPost, url, bytes.NewReader(body))
+	if err != nil {
+		return false, err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("User-Agent", "autonoma-ctf-webhooks")
+	req.Header.Set(EventHeader, string(event.Type))
+	req.Header.Set(DeliveryHeader, event.ID)
+	req.Header.Set(SignatureHeader, Sign(d.Secret, time.Now(), body))
+
+	resp, err := d.Client.Do(req)
+	if err != nil {
+		return true, err
+	}
+	resp.Body.Close()
+
+	switch {
+	case resp.StatusCode >= 200 && resp.StatusCode < 300:
+		return false, nil
+	case resp.StatusCode == http.StatusRequestTimeout, resp.StatusCode == http.StatusTooManyRequests, resp.StatusCode >= 500:
+		return true, fmt.Errorf("receiver responded %s", resp.Status)
+	}
+	return false, fmt.Errorf("receiver rejected the event with %s", resp.Status)
+}
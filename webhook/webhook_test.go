@@ -0,0 +1,176 @@
+package webhook
+
+import (
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// receiver records the deliveries it gets and answers with the given statuses in order, then 200
+type receiver struct {
+	mu         sync.Mutex
+	statuses   []int
+	deliveries []*http.Request
+	bodies     [][]byte
+}
+
+func (r *receiver) ServeHTTP(w http.ResponseWriter, req *http.Request) {
+	body, _ := io.ReadAll(req.Body)
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.deliveries = append(r.deliveries, req)
+	r.bodies = append(r.bodies, body)
+	status := http.StatusOK
+	if len(r.statuses) > 0 {
+		status, r.statuses = r.statuses[0], r.statuses[1:]
+	}
+	w.WriteHeader(status)
+}
+
+func (r *receiver) count() int {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return len(r.deliveries)
+}
+
+func newDispatcher(urls ...string) *Dispatcher {
+	d := New(urls, []byte("shh"))
+	d.Backoff = time.Millisecond
+	d.MaxAttempts = 3
+	return d
+}
+
+var event = NewEvent(AttemptWon, time.Date(2025, 4, 1, 12, 0, 0, 0, time.UTC), AttemptData{
+	Email:      "ada@example.com",
+	SessionID:  "session-1",
+	Step:       6,
+	Duration:   "18m12s",
+	DurationMs: 1_092_000,
+})
+
+func TestDispatchSignsTheEvent(t *testing.T) {
+	rec := &receiver{}
+	server := httptest.NewServer(rec)
+	defer server.Close()
+
+	d := newDispatcher(server.URL)
+	d.Dispatch(event)
+	d.Wait()
+
+	if rec.count() != 1 {
+		t.Fatalf("got %d deliveries, want 1", rec.count())
+	}
+	req, body := rec.deliveries[0], rec.bodies[0]
+	if req.Method != http.MethodPost || req.Header.Get("Content-Type") != "application/json" {
+		t.Errorf("got %s with Content-Type %q", req.Method, req.Header.Get("Content-Type"))
+	}
+	if req.Header.Get(EventHeader) != "attempt.won" || req.Header.Get(DeliveryHeader) != event.ID {
+		t.Errorf("unexpected event headers %v", req.Header)
+	}
+
+	// The receiver can check the signature with the shared secret and the timestamp
+	signature := req.Header.Get(SignatureHeader)
+	timestamp, _, _ := strings.Cut(strings.TrimPrefix(signature, "t="), ",")
+	unix, err := strconv.ParseInt(timestamp, 10, 64)
+	if err != nil {
+		t.Fatalf("invalid signature header %q", signature)
+	}
+	if want := Sign([]byte("shh"), time.Unix(unix, 0), body); signature != want {
+		t.Errorf("signature = %q, want %q", signature, want)
+	}
+	if signature == Sign([]byte("other"), time.Unix(unix, 0), body) {
+		t.Error("expected the signature to depend on the secret")
+	}
+
+	var got Event
+	if err := json.Unmarshal(body, &got); err != nil {
+		t.Fatal(err)
+	}
+	if got != event {
+		t.Errorf("got event %+v, want %+v", got, event)
+	}
+}
+
+func TestDispatchRetries(t *testing.T) {
+	tests := []struct {
+		name           string
+		statuses       []int
+		wantDeliveries int
+	}{
+		{name: "accepted", statuses: nil, wantDeliveries: 1},
+		{name: "server error then accepted", statuses: []int{500, 503}, wantDeliveries: 3},
+		{name: "rate limited then accepted", statuses: []int{429}, wantDeliveries: 2},
+		{name: "gives up", statuses: []int{500, 500, 500, 500}, wantDeliveries: 3},
+		{name: "rejected", statuses: []int{400}, wantDeliveries: 1},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			rec := &receiver{statuses: tt.statuses}
+			server := httptest.NewServer(rec)
+			defer server.Close()
+
+			d := newDispatcher(server.URL)
+			d.Dispatch(event)
+			d.Wait()
+
+			if rec.count() != tt.wantDeliveries {
+				t.Errorf("got %d deliveries, want %d", rec.count(), tt.wantDeliveries)
+			}
+			for _, req := range rec.deliveries {
+				if req.Header.Get(DeliveryHeader) != event.ID {
+					t.Errorf("retries should keep the delivery ID, got %q", req.Header.Get(DeliveryHeader))
+				}
+			}
+		})
+	}
+}
+
+func TestDispatchToEveryURL(t *testing.T) {
+	slack, ats := &receiver{statuses: []int{500}}, &receiver{}
+	slackServer, atsServer := httptest.NewServer(slack), httptest.NewServer(ats)
+	defer slackServer.Close()
+	defer atsServer.Close()
+
+	d := newDispatcher(slackServer.URL, atsServer.URL)
+	d.Dispatch(event)
+	d.Wait()
+
+	if slack.count() != 2 || ats.count() != 1 {
+		t.Errorf("got %d and %d deliveries, want 2 and 1", slack.count(), ats.count())
+	}
+}
+
+func TestNilDispatcher(t *testing.T) {
+	var d *Dispatcher
+	d.Dispatch(event)
+	d.Wait()
+}
+
+func TestFromEnv(t *testing.T) {
+	t.Setenv("WEBHOOK_URLS", "")
+	d, err := FromEnv()
+	if err != nil || d != nil {
+		t.Fatalf("expected no dispatcher without URLs, got %v, %v", d, err)
+	}
+
+	t.Setenv("WEBHOOK_URLS", " https://hooks.slack.com/x, ,https://ats.example.com/hook")
+	if _, err := FromEnv(); err == nil {
+		t.Fatal("expected an error without WEBHOOK_SECRET")
+	}
+
+	t.Setenv("WEBHOOK_SECRET", "shh")
+	d, err = FromEnv()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(d.URLs) != 2 || d.URLs[1] != "https://ats.example.com/hook" {
+		t.Errorf("URLs = %q", d.URLs)
+	}
+}